@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha2
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
 )
@@ -53,8 +54,104 @@ const (
 	// MachineCreated indicates whether the machine has been created or not. If not,
 	// it should include a reason and message for the failure.
 	MachineCreated VSphereMachineProviderConditionType = "MachineCreated"
+
+	// MachineCloneInProgress indicates whether the machine's VM is being
+	// cloned.
+	MachineCloneInProgress VSphereMachineProviderConditionType = "CloneInProgress"
+
+	// MachinePoweringOn indicates whether the machine's VM is being powered
+	// on.
+	MachinePoweringOn VSphereMachineProviderConditionType = "PoweringOn"
+
+	// MachineWaitingForIP indicates whether the machine is waiting for its VM
+	// to report an IP address.
+	MachineWaitingForIP VSphereMachineProviderConditionType = "WaitingForIP"
+
+	// MachineWaitingForGuestTools indicates whether the machine is waiting
+	// for VMware Tools to run in its VM.
+	MachineWaitingForGuestTools VSphereMachineProviderConditionType = "WaitingForGuestTools"
+
+	// MachineJoiningCluster indicates whether the machine's infrastructure is
+	// ready and the machine is waiting for its node to join the cluster.
+	MachineJoiningCluster VSphereMachineProviderConditionType = "JoiningCluster"
+
+	// MachineReady indicates whether the machine's node has joined the
+	// cluster.
+	MachineReady VSphereMachineProviderConditionType = "Ready"
+
+	// MachineControlPlaneHealthy indicates whether the node, kube-apiserver,
+	// and stacked etcd member of a control plane machine became ready after
+	// the machine's node joined the cluster.
+	MachineControlPlaneHealthy VSphereMachineProviderConditionType = "ControlPlaneHealthy"
+
+	// MachineNodeReadyTimedOut indicates whether the machine's node failed to
+	// join the cluster within the node ready timeout after the machine's VM
+	// was powered on. Health check or remediation controllers may replace
+	// machines for which this condition is true.
+	MachineNodeReadyTimedOut VSphereMachineProviderConditionType = "NodeReadyTimedOut"
+
+	// MachineTemplateDrifted indicates whether the template the machine's VM
+	// was cloned from has changed since the VM was cloned.
+	MachineTemplateDrifted VSphereMachineProviderConditionType = "TemplateDrifted"
+
+	// MachineImmutableSpecChanged indicates whether fields of the machine's
+	// spec that are only applied when its VM is created have changed since
+	// the VM was created.
+	MachineImmutableSpecChanged VSphereMachineProviderConditionType = "ImmutableSpecChanged"
 )
 
+// VSphereMachineProviderCondition describes the state of a VSphere machine
+// instance at a certain point.
+type VSphereMachineProviderCondition struct {
+	// Type is the type of the condition.
+	Type VSphereMachineProviderConditionType `json:"type"`
+
+	// Status is the status of the condition.
+	Status corev1.ConditionStatus `json:"status"`
+
+	// LastTransitionTime is the last time the condition transitioned from one
+	// status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// Reason is a unique, one-word, CamelCase reason for the condition's last
+	// transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message is a human-readable message indicating details about the last
+	// transition.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// VSphereMachineProvisioningTimes records when each phase of provisioning a
+// VSphere machine completed. Each time is only recorded once and is retained
+// if provisioning fails, so the time spent in each phase before the failure
+// can be determined.
+type VSphereMachineProvisioningTimes struct {
+	// CloneStarted is the time at which the machine's VM was first cloned.
+	// +optional
+	CloneStarted *metav1.Time `json:"cloneStarted,omitempty"`
+
+	// CloneCompleted is the time at which the machine's VM was created.
+	// +optional
+	CloneCompleted *metav1.Time `json:"cloneCompleted,omitempty"`
+
+	// PoweredOn is the time at which the machine's VM was powered on.
+	// +optional
+	PoweredOn *metav1.Time `json:"poweredOn,omitempty"`
+
+	// IPAddressAssigned is the time at which the machine's VM reported an IP
+	// address.
+	// +optional
+	IPAddressAssigned *metav1.Time `json:"ipAddressAssigned,omitempty"`
+
+	// NodeJoined is the time at which the machine's node joined the cluster.
+	// +optional
+	NodeJoined *metav1.Time `json:"nodeJoined,omitempty"`
+}
+
 // APIEndpoint represents a reachable Kubernetes API endpoint.
 type APIEndpoint struct {
 	// The hostname on which the API server is serving.
@@ -76,6 +173,28 @@ type NetworkSpec struct {
 	// +optional
 	Routes []NetworkRouteSpec `json:"routes,omitempty"`
 
+	// DNSServers is a list of IPv4 and/or IPv6 addresses used as DNS
+	// nameservers by all of the virtual machine's network devices that do
+	// not specify their own Nameservers.
+	// At least one nameserver is required for a device with static IP
+	// addresses and neither DHCP4 nor DHCP6.
+	// +optional
+	DNSServers []string `json:"dnsServers,omitempty"`
+
+	// SearchDomains is a list of search domains used by all of the virtual
+	// machine's network devices that do not specify their own SearchDomains.
+	// +optional
+	SearchDomains []string `json:"searchDomains,omitempty"`
+
+	// NetworkConfig is a cloud-init network configuration version 2 document
+	// that configures the virtual machine's network instead of the
+	// configuration generated from Devices, Routes, DNSServers, and
+	// SearchDomains. It allows configuring topologies such as bonds, VLANs,
+	// and bridges. The NICs of the virtual machine are still created from
+	// Devices, whose other fields are ignored.
+	// +optional
+	NetworkConfig string `json:"networkConfig,omitempty"`
+
 	// PreferredAPIServeCIDR is the preferred CIDR for the Kubernetes API
 	// server endpoint on this machine
 	// +optional
@@ -124,6 +243,9 @@ type NetworkDeviceSpec struct {
 	// MACAddr is the MAC address used by this device.
 	// It is generally a good idea to omit this field and allow a MAC address
 	// to be generated.
+	// When set, the address must be in the range vSphere allows to be
+	// assigned manually, 00:50:56:00:00:00 to 00:50:56:3F:FF:FF, or be a
+	// locally administered unicast address.
 	// Please note that this value must use the VMware OUI to work with the
 	// in-tree vSphere cloud provider.
 	// +optional
@@ -173,6 +295,204 @@ type NetworkStatus struct {
 	NetworkName string `json:"networkName,omitempty"`
 }
 
+// PCIDeviceSpec defines a PCI device passed through to a virtual machine.
+type PCIDeviceSpec struct {
+	// VendorID is the PCI vendor ID of the device, ex. 4318 (0x10DE) for
+	// NVIDIA.
+	VendorID int32 `json:"vendorId"`
+
+	// DeviceID is the PCI device ID of the device.
+	DeviceID int32 `json:"deviceId"`
+}
+
+// DiskSpec defines a disk attached to a virtual machine in addition to the
+// disks of the template from which the virtual machine is cloned.
+type DiskSpec struct {
+	// FileName is the datastore path of an existing virtual disk that is
+	// attached to the VM, ex. "[datastore1] disks/data.vmdk". The disk must
+	// not be attached to another VM that is powered on.
+	// This field is mutually exclusive with SizeGiB.
+	// +optional
+	FileName string `json:"fileName,omitempty"`
+
+	// SizeGiB is the size, in GiB, of a new virtual disk that is created in
+	// the VM's directory.
+	// This field is mutually exclusive with FileName.
+	// +optional
+	SizeGiB int32 `json:"sizeGiB,omitempty"`
+
+	// KeepOnDelete is a flag that controls whether or not the disk is
+	// detached from the VM before the VM is destroyed so that the disk is not
+	// deleted with the VM.
+	// Defaults to false.
+	// +optional
+	KeepOnDelete bool `json:"keepOnDelete,omitempty"`
+}
+
+// FailureDomain is a named placement target of a cluster's VMs. The VM of a
+// machine in a failure domain is created with the failure domain's fields,
+// unless they are overridden by the machine's own fields. Fields that are
+// not set by either default to the cluster's workspace.
+type FailureDomain struct {
+	// Name is the name of the failure domain that machines refer to.
+	Name string `json:"name"`
+
+	// Datacenter is the name or inventory path of the datacenter in which
+	// the VMs are created.
+	// +optional
+	Datacenter string `json:"datacenter,omitempty"`
+
+	// ComputeCluster is the name or inventory path of the compute cluster
+	// in whose root resource pool the VMs are created when ResourcePool is
+	// not set.
+	// +optional
+	ComputeCluster string `json:"computeCluster,omitempty"`
+
+	// ResourcePool is the name or inventory path of the resource pool in
+	// which the VMs are created.
+	// +optional
+	ResourcePool string `json:"resourcePool,omitempty"`
+
+	// Datastore is the name or inventory path of the datastore on which the
+	// VMs are created.
+	// +optional
+	Datastore string `json:"datastore,omitempty"`
+
+	// Folder is the name or inventory path of the folder in which the VMs
+	// are created.
+	// +optional
+	Folder string `json:"folder,omitempty"`
+}
+
+// CloneMode is the type of clone operation used to clone a VM from a template.
+type CloneMode string
+
+const (
+	// FullClone indicates a VM will have no relationship to the source of the
+	// clone operation once the operation is complete. This is the safest clone
+	// mode, but it is not the fastest.
+	FullClone CloneMode = "fullClone"
+
+	// LinkedClone means resulting VMs will be dependent upon the snapshot of
+	// the source VM/template from which the VM was cloned. This is the fastest
+	// clone mode, but it also prevents expanding a VMs disk beyond the size of
+	// the source VM/template.
+	LinkedClone CloneMode = "linkedClone"
+)
+
+// DiskProvisioningType is the provisioning type of a VM's virtual disks.
+type DiskProvisioningType string
+
+const (
+	// ThinDiskProvisioning indicates the space of a disk is allocated and
+	// zeroed on demand, when the disk is first written to.
+	ThinDiskProvisioning DiskProvisioningType = "thin"
+
+	// ThickDiskProvisioning indicates the space of a disk is allocated when
+	// the disk is created, and zeroed on demand (lazy zeroed).
+	ThickDiskProvisioning DiskProvisioningType = "thick"
+
+	// EagerZeroedThickDiskProvisioning indicates the space of a disk is
+	// allocated and zeroed when the disk is created. This type is required
+	// by some clustering and fault tolerance features.
+	EagerZeroedThickDiskProvisioning DiskProvisioningType = "eagerZeroedThick"
+)
+
+// FirmwareType is the firmware of a VM.
+type FirmwareType string
+
+const (
+	// BIOSFirmware indicates a VM boots with legacy BIOS firmware.
+	BIOSFirmware FirmwareType = "bios"
+
+	// EFIFirmware indicates a VM boots with UEFI firmware.
+	EFIFirmware FirmwareType = "efi"
+)
+
+// BootDeviceType is a type of device a VM boots from.
+// +kubebuilder:validation:Enum=cdrom;disk;ethernet;floppy
+type BootDeviceType string
+
+const (
+	// CDROMBootDevice indicates a VM boots from its CD-ROMs.
+	CDROMBootDevice BootDeviceType = "cdrom"
+
+	// DiskBootDevice indicates a VM boots from its disks.
+	DiskBootDevice BootDeviceType = "disk"
+
+	// EthernetBootDevice indicates a VM boots from the network with PXE on
+	// its network devices.
+	EthernetBootDevice BootDeviceType = "ethernet"
+
+	// FloppyBootDevice indicates a VM boots from its floppy drives.
+	FloppyBootDevice BootDeviceType = "floppy"
+)
+
+// SharesLevel is the relative priority of a VM for contended CPU and memory.
+type SharesLevel string
+
+const (
+	// LowSharesLevel indicates a VM has half the priority of a VM with the
+	// normal level.
+	LowSharesLevel SharesLevel = "low"
+
+	// NormalSharesLevel indicates a VM has the default priority.
+	NormalSharesLevel SharesLevel = "normal"
+
+	// HighSharesLevel indicates a VM has twice the priority of a VM with
+	// the normal level.
+	HighSharesLevel SharesLevel = "high"
+)
+
+// LatencySensitivityLevel is how sensitive the workload of a VM is to
+// scheduling latency.
+type LatencySensitivityLevel string
+
+const (
+	// LowLatencySensitivity indicates a VM tolerates more scheduling latency
+	// than a VM with the normal level.
+	LowLatencySensitivity LatencySensitivityLevel = "low"
+
+	// NormalLatencySensitivity indicates a VM is scheduled like other VMs.
+	NormalLatencySensitivity LatencySensitivityLevel = "normal"
+
+	// MediumLatencySensitivity indicates a VM is scheduled with less latency
+	// than a VM with the normal level.
+	MediumLatencySensitivity LatencySensitivityLevel = "medium"
+
+	// HighLatencySensitivity indicates a VM is given exclusive access to
+	// physical CPUs to minimize its scheduling latency. It requires all of
+	// the VM's memory to be reserved.
+	HighLatencySensitivity LatencySensitivityLevel = "high"
+)
+
+// BootstrapFormat is the format of a machine's bootstrap data.
+type BootstrapFormat string
+
+const (
+	// CloudInitBootstrapFormat indicates the bootstrap data is cloud-init
+	// user data. It is provided to the VM with the guestinfo.userdata key.
+	CloudInitBootstrapFormat BootstrapFormat = "cloud-init"
+
+	// IgnitionBootstrapFormat indicates the bootstrap data is an Ignition
+	// config. It is provided to the VM with the
+	// guestinfo.ignition.config.data key.
+	IgnitionBootstrapFormat BootstrapFormat = "ignition"
+)
+
+// BootstrapTransport is how a machine's bootstrap data is provided to its VM.
+type BootstrapTransport string
+
+const (
+	// GuestInfoBootstrapTransport indicates the bootstrap data and metadata
+	// are provided with the VM's guestinfo keys.
+	GuestInfoBootstrapTransport BootstrapTransport = "guestinfo"
+
+	// ISOBootstrapTransport indicates the bootstrap data and metadata are
+	// provided with a cloud-init NoCloud ISO attached to the VM as a CD-ROM.
+	ISOBootstrapTransport BootstrapTransport = "iso"
+)
+
 // VirtualMachineState describes the state of a VM.
 type VirtualMachineState string
 
@@ -31,7 +31,11 @@ const (
 
 // VSphereClusterSpec defines the desired state of VSphereCluster
 type VSphereClusterSpec struct {
-	// Server is the address of the vSphere endpoint.
+	// Server is the address of the vSphere endpoint. It is a host, optionally
+	// with a port, or a URL, ex. https://proxy.local:8443/vcenter/sdk for a
+	// vCenter behind a reverse proxy. The path defaults to /sdk, and the
+	// port defaults to the cloud provider configuration's port for the
+	// server, or 443.
 	Server string `json:"server,omitempty"`
 
 	// Insecure is a flag that controls whether or not to validate the
@@ -39,6 +43,52 @@ type VSphereClusterSpec struct {
 	// +optional
 	Insecure *bool `json:"insecure,omitempty"`
 
+	// Thumbprint is the colon-separated SHA-1 thumbprint of the vSphere
+	// server's certificate, ex. "AB:CD:...". When set, connections to the
+	// vSphere server fail unless the server presents a certificate with this
+	// thumbprint.
+	// +optional
+	Thumbprint string `json:"thumbprint,omitempty"`
+
+	// EnableTagging is a flag that controls whether or not the cluster's VMs
+	// are tagged with the names of the owning cluster and machine, and the
+	// machine's role. Tagging requires the vSphere tagging privileges.
+	// Failing to tag a VM does not fail its provisioning.
+	// +optional
+	EnableTagging bool `json:"enableTagging,omitempty"`
+
+	// VMNameTemplate is a Go template used to name the cluster's VMs. The
+	// template may refer to {{.Cluster}}, {{.Machine}}, and {{.Role}}, the
+	// names of the owning cluster and machine and the machine's role,
+	// either "control-plane" or "worker". Defaults to the machine's name.
+	// +optional
+	VMNameTemplate string `json:"vmNameTemplate,omitempty"`
+
+	// VMNotesTemplate is a Go template used to set the notes of the cluster's
+	// VMs when they are created. The template may refer to {{.Cluster}},
+	// {{.Namespace}}, {{.Machine}}, and {{.Role}}, as well as {{.Created}},
+	// the time at which the VM is created, and {{.Version}}, the version of
+	// the controller that created the VM. The rendered notes may be at most
+	// 1024 characters. Defaults to a note with the machine, cluster,
+	// creation time, and controller version.
+	// +optional
+	VMNotesTemplate string `json:"vmNotesTemplate,omitempty"`
+
+	// HostnameDomain is the DNS domain appended to the hostnames of the
+	// cluster's machines to form their fully qualified domain names, ex.
+	// example.com. The hostname of a machine is the name of its
+	// VSphereMachine, which must be a valid DNS label. Guests typically set
+	// their hostname to the first label of the FQDN, so the machine's node is
+	// still registered with the machine's name. Defaults to no domain.
+	// +optional
+	HostnameDomain string `json:"hostnameDomain,omitempty"`
+
+	// FailureDomains are the placement targets the cluster's machines may be
+	// pinned to with their FailureDomain field, such as one per compute
+	// cluster in a vCenter.
+	// +optional
+	FailureDomains []FailureDomain `json:"failureDomains,omitempty"`
+
 	// CloudProviderConfiguration holds the cluster-wide configuration for the
 	// vSphere cloud provider.
 	CloudProviderConfiguration cloud.Config `json:"cloudProviderConfiguration,omitempty"`
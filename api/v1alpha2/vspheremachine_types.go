@@ -42,18 +42,169 @@ type VSphereMachineSpec struct {
 	// +optional
 	MachineRef string `json:"machineRef,omitempty"`
 
+	// ExistingVMUUID is the BIOS UUID of an existing VM that is adopted as
+	// the machine's VM instead of cloning a new one. Template and
+	// ContentLibraryItem are ignored for adopted VMs.
+	// +optional
+	ExistingVMUUID string `json:"existingVMUUID,omitempty"`
+
+	// RetainVMOnDelete is a flag that indicates whether or not the machine's
+	// VM is left in place, rather than destroyed, when the machine is
+	// deleted. This is typically set for adopted VMs.
+	// +optional
+	RetainVMOnDelete bool `json:"retainVMOnDelete,omitempty"`
+
 	// Template is the name, inventory path, or instance UUID of the template
 	// used to clone new machines.
-	Template string `json:"template"`
+	// This field is mutually exclusive with ContentLibraryItem.
+	// +optional
+	Template string `json:"template,omitempty"`
+
+	// ContentLibraryItem is the path of the OVF content library item, in the
+	// form "library/item", from which new machines are deployed.
+	// This field is mutually exclusive with Template.
+	// +optional
+	ContentLibraryItem string `json:"contentLibraryItem,omitempty"`
+
+	// OVFProperties are the values of the OVF properties, by property ID, set
+	// when machines are deployed from ContentLibraryItem. Deploying fails if
+	// the item does not define one of the properties, or if the item
+	// requires a property without a default value that is not set.
+	// This field may only be set with ContentLibraryItem.
+	// +optional
+	OVFProperties map[string]string `json:"ovfProperties,omitempty"`
+
+	// CloneMode specifies the type of clone operation.
+	// The LinkedClone mode is only supported for templates that have at least
+	// one snapshot. Cloning fails if LinkedClone is requested and the template
+	// has no snapshots.
+	// When LinkedClone mode is enabled the DiskGiB field is ignored as it is
+	// not possible to expand disks of linked clones.
+	// Defaults to FullClone.
+	// +optional
+	CloneMode CloneMode `json:"cloneMode,omitempty"`
+
+	// DiskProvisioning is the provisioning type of the VM's disks, applied to
+	// the disks of a full clone of Template, the disks deployed from
+	// ContentLibraryItem, and the new disks in AdditionalDisks. The thick
+	// types are only supported on VMFS, vSAN, and vVol datastores.
+	// This field may not be set to a thick type with the LinkedClone mode as
+	// the disks of a linked clone are backed by the template's snapshot.
+	// When unset, new disks are thin provisioned and the disks of a clone
+	// keep the provisioning type of the template's disks.
+	// +kubebuilder:validation:Enum=thin;thick;eagerZeroedThick
+	// +optional
+	DiskProvisioning DiskProvisioningType `json:"diskProvisioning,omitempty"`
+
+	// BootstrapFormat is the format of the bootstrap data from the machine's
+	// bootstrap provider. The format determines the guestinfo keys used to
+	// provide the bootstrap data to the VM, and must match the format the
+	// machine image consumes, ex. ignition for Flatcar Container Linux.
+	// Defaults to cloud-init.
+	// +kubebuilder:validation:Enum=cloud-init;ignition
+	// +optional
+	BootstrapFormat BootstrapFormat `json:"bootstrapFormat,omitempty"`
+
+	// BootstrapTransport is how the bootstrap data and cloud-init metadata
+	// are provided to the VM. The iso transport is for images that cannot
+	// read guestinfo. It uploads a cloud-init NoCloud ISO, labeled cidata,
+	// to the VM's directory and attaches it to the VM as a CD-ROM before
+	// the VM is first powered on. The ISO is removed when the VM is
+	// destroyed. The iso transport requires the cloud-init bootstrap format.
+	// Defaults to guestinfo.
+	// +kubebuilder:validation:Enum=guestinfo;iso
+	// +optional
+	BootstrapTransport BootstrapTransport `json:"bootstrapTransport,omitempty"`
+
+	// Server is the address of the vSphere endpoint on which this machine's
+	// VM is created. The credentials and thumbprint for the endpoint are
+	// read from the cluster's cloud provider vCenter configuration for the
+	// server.
+	// Defaults to the cluster's server.
+	// +optional
+	Server string `json:"server,omitempty"`
 
 	// Datacenter is the name or inventory path of the datacenter where this
-	// machine's VM is created/located.
+	// machine's VM is created/located. The template, networks, datastores,
+	// folder, and other objects this spec names are looked up in the
+	// datacenter, unless they are absolute inventory paths. The machine is not
+	// reconciled if the datacenter does not exist.
+	// Defaults to the failure domain's datacenter, or the server's only
+	// datacenter.
 	Datacenter string `json:"datacenter"`
 
+	// ComputeCluster is the name or inventory path of the compute cluster in
+	// whose root resource pool this machine's VM is created when
+	// ResourcePool is not set.
+	// +optional
+	ComputeCluster string `json:"computeCluster,omitempty"`
+
+	// Host is the name or inventory path of the ESXi host on which this
+	// machine's VM is created. The host must be in the compute resource of
+	// the VM's resource pool. A host is required when that compute resource
+	// is a cluster without DRS, and DRS picks the host when it is not set.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// FailureDomain is the name of the failure domain, defined by the
+	// cluster, in which this machine's VM is created. The VM cannot be
+	// created if the cluster does not define the failure domain.
+	// +optional
+	FailureDomain string `json:"failureDomain,omitempty"`
+
+	// Datastore is the name or inventory path of the datastore in which this
+	// machine's VM is created.
+	// Defaults to the datastore from the cluster's cloud provider workspace.
+	// This field is mutually exclusive with Datastores and DatastoreCluster.
+	// +optional
+	Datastore string `json:"datastore,omitempty"`
+
+	// Datastores are the names or inventory paths of the datastores among
+	// which this machine's VM is placed, such as the datastores of a node
+	// pool. The VM is created on the accessible datastore with the most free
+	// space, less the disks of the VMs being cloned to it, preferring the
+	// datastore with the fewest such VMs and then the earlier datastores when
+	// there is a tie. The VM is created later if none of the datastores can
+	// be used. This field is mutually exclusive with Datastore and DatastoreCluster.
+	// +optional
+	Datastores []string `json:"datastores,omitempty"`
+
+	// DatastoreCluster is the name or inventory path of the Storage DRS
+	// datastore cluster in which this machine's VM is created. The VM is placed
+	// on the datastore recommended by Storage DRS.
+	// This field is mutually exclusive with Datastore and Datastores.
+	// +optional
+	DatastoreCluster string `json:"datastoreCluster,omitempty"`
+
+	// StoragePolicy is the name of the storage policy applied to this
+	// machine's VM and its disks. The VM is created on the datastore
+	// compatible with the policy that has the most free space, or on
+	// Datastore if it is set and compatible with the policy. When Datastores
+	// is set, only those datastores are considered.
+	// This field is mutually exclusive with DatastoreCluster.
+	// +optional
+	StoragePolicy string `json:"storagePolicy,omitempty"`
+
+	// ResourcePool is the name or inventory path of the resource pool in which
+	// this machine's VM is created.
+	// Defaults to the resource pool from the cluster's cloud provider
+	// workspace.
+	// +optional
+	ResourcePool string `json:"resourcePool,omitempty"`
+
+	// Folder is the name or inventory path of the folder in which this
+	// machine's VM is created.
+	// Defaults to the folder from the cluster's cloud provider workspace.
+	// +optional
+	Folder string `json:"folder,omitempty"`
+
 	// Network is the network configuration for this machine's VM.
 	Network NetworkSpec `json:"network"`
 
 	// NumCPUs is the number of virtual processors in a virtual machine.
+	// Changing NumCPUs, NumCoresPerSocket, or MemoryMiB resizes an existing
+	// VM. A running VM is resized in place if hot-add allows it, otherwise
+	// it is powered off, resized, and powered back on.
 	// Defaults to the analogue property value in the template from which this
 	// machine is cloned.
 	// +optional
@@ -69,12 +220,145 @@ type VSphereMachineSpec struct {
 	// machine is cloned.
 	// +optional
 	MemoryMiB int64 `json:"memoryMiB,omitempty"`
+	// CPUHotAddEnabled is a flag that controls whether or not virtual
+	// processors may be added to this machine's VM while it is powered on.
+	// Some hosts reject enabling hot-add for VMs with fixed CPU or memory
+	// reservations, or that use vNUMA. If that happens cloning fails and the
+	// rejection is recorded in the CloneInProgress condition.
+	// Defaults to the analogue property value in the template from which this
+	// machine is cloned.
+	// +optional
+	CPUHotAddEnabled *bool `json:"cpuHotAddEnabled,omitempty"`
+	// MemoryHotAddEnabled is a flag that controls whether or not memory may
+	// be added to this machine's VM while it is powered on. Please see
+	// CPUHotAddEnabled for how hot-add interacts with reservations.
+	// Defaults to the analogue property value in the template from which this
+	// machine is cloned.
+	// +optional
+	MemoryHotAddEnabled *bool `json:"memoryHotAddEnabled,omitempty"`
+	// CPUReservationMHz is the CPU capacity, in MHz, guaranteed to this
+	// machine's VM.
+	// Reservations, limits, and shares are applied to existing VMs while they
+	// run. When unset, the VM keeps its current value, which defaults to the
+	// template's.
+	// +optional
+	CPUReservationMHz int64 `json:"cpuReservationMHz,omitempty"`
+	// CPULimitMHz is the maximum CPU capacity, in MHz, this machine's VM may
+	// use. A value of -1 removes the limit.
+	// +optional
+	CPULimitMHz int64 `json:"cpuLimitMHz,omitempty"`
+	// MemoryReservationMiB is the amount of memory, in MiB, guaranteed to
+	// this machine's VM. It may not exceed the VM's memory.
+	// This field may not be set with PCIDevices, which reserve all of the VM's
+	// memory.
+	// +optional
+	MemoryReservationMiB int64 `json:"memoryReservationMiB,omitempty"`
+	// MemoryLimitMiB is the maximum amount of memory, in MiB, this machine's
+	// VM may use. A value of -1 removes the limit.
+	// +optional
+	MemoryLimitMiB int64 `json:"memoryLimitMiB,omitempty"`
+	// Shares is the relative priority of this machine's VM for CPU and
+	// memory when the resources of its host or resource pool are contended.
+	// +kubebuilder:validation:Enum=low;normal;high
+	// +optional
+	Shares SharesLevel `json:"shares,omitempty"`
 	// DiskGiB is the size of a virtual machine's disk, in GiB.
+	// Increasing DiskGiB grows the disk of an existing VM while it runs, but
+	// the guest's filesystem must be expanded by the guest, ex. by
+	// cloud-init. Disks cannot be shrunk.
 	// Defaults to the analogue property value in the template from which this
 	// machine is cloned.
 	// +optional
 	DiskGiB int32 `json:"diskGiB,omitempty"`
 
+	// PCIDevices is a list of PCI devices passed through to this machine's
+	// VM. Each device must be available for passthrough on the host that
+	// runs the VM. The VM's memory is fully reserved when PCI devices are
+	// requested as passthrough requires it.
+	// +optional
+	PCIDevices []PCIDeviceSpec `json:"pciDevices,omitempty"`
+
+	// AdditionalDisks is a list of disks attached to this machine's VM in
+	// addition to the disks of the template from which it is cloned. The
+	// disks are added to the controller of the template's disk when the VM
+	// is created. Changes to this list do not affect existing VMs.
+	// +optional
+	AdditionalDisks []DiskSpec `json:"additionalDisks,omitempty"`
+
+	// HardwareVersion is the virtual hardware version to which this machine's
+	// VM is upgraded after it is cloned, ex. "vmx-15". vSphere does not
+	// support downgrading a VM's hardware version, so the version must not be
+	// older than the template's hardware version.
+	// Defaults to the template's hardware version.
+	// +optional
+	HardwareVersion string `json:"hardwareVersion,omitempty"`
+
+	// Firmware is the firmware of this machine's VM. The template's guest OS
+	// must support the firmware, and the template's disk must be bootable
+	// with it.
+	// Defaults to the template's firmware.
+	// +kubebuilder:validation:Enum=bios;efi
+	// +optional
+	Firmware FirmwareType `json:"firmware,omitempty"`
+
+	// SecureBoot is a flag that controls whether or not UEFI secure boot is
+	// enabled on this machine's VM. Secure boot requires the efi firmware, a
+	// template with hardware version vmx-13 or later, and a guest OS that
+	// supports it.
+	// Defaults to false.
+	// +optional
+	SecureBoot bool `json:"secureBoot,omitempty"`
+
+	// BootDelayMilliseconds is how long this machine's VM waits in its
+	// firmware before it boots, ex. to give an operator time to enter the
+	// firmware's setup when debugging boot problems. It is applied when the
+	// VM is created.
+	// Defaults to the template's boot delay.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	BootDelayMilliseconds int64 `json:"bootDelayMilliseconds,omitempty"`
+
+	// BootOrder is the order of the types of devices this machine's VM boots
+	// from, ex. [ethernet, disk] to boot from the network before the VM's
+	// disk. Each type includes all of the VM's devices of the type, and the
+	// VM must have at least one. Changes to the boot order are applied to
+	// existing VMs and take effect when they are next booted.
+	// Defaults to the template's boot order.
+	// +optional
+	BootOrder []BootDeviceType `json:"bootOrder,omitempty"`
+
+	// LatencySensitivity is how sensitive the workload of this machine's VM
+	// is to scheduling latency. The VM's memory is fully reserved when the
+	// high level is requested as the level requires it, and a
+	// memoryReservationMiB is ignored.
+	// Changes to this field do not affect existing VMs.
+	// Defaults to the template's latency sensitivity.
+	// +kubebuilder:validation:Enum=low;normal;medium;high
+	// +optional
+	LatencySensitivity LatencySensitivityLevel `json:"latencySensitivity,omitempty"`
+
+	// CPUAffinity is the list of the host's logical processors, numbered from
+	// zero, on which this machine's VM may be scheduled. The list must have
+	// at least as many processors as the VM has CPUs, and the processors
+	// must exist on the host. A host is required, and vSphere does not
+	// support CPU affinity for VMs in a compute cluster with DRS fully
+	// automated.
+	// Changes to this field do not affect existing VMs.
+	// +optional
+	CPUAffinity []int32 `json:"cpuAffinity,omitempty"`
+
+	// SnapshotBeforeUpdate is a flag that controls whether or not a snapshot
+	// of this machine's VM is taken before its hardware version is upgraded
+	// or its CPUs or memory are resized. The snapshot is removed if the update
+	// succeeds and is retained for manual recovery if it fails. Only the most
+	// recent of these snapshots are retained. Disk resizes are not preceded by
+	// a snapshot as vSphere does not support growing the disks of a VM that
+	// has snapshots, so growing the disks fails until a snapshot retained
+	// after a failed update is removed.
+	// Defaults to false.
+	// +optional
+	SnapshotBeforeUpdate bool `json:"snapshotBeforeUpdate,omitempty"`
+
 	// TrustedCerts is a list of trusted certificates to add to the machine's VM.
 	// +optional
 	TrustedCerts [][]byte `json:"trustedCerts,omitempty"`
@@ -83,6 +367,41 @@ type VSphereMachineSpec struct {
 	// default NTP server list.
 	// +optional
 	NTPServers []string `json:"ntpServers,omitempty"`
+
+	// SyncTimeWithHost is a flag that controls whether or not VMware Tools
+	// periodically synchronizes the guest's clock with the clock of the ESXi
+	// host. Guests whose clock is kept by NTP, for example with chrony
+	// configured by cloud-init, should typically disable it, as the two
+	// conflict when adjusting the clock. A warning event is emitted when it
+	// is enabled along with NTPServers.
+	// Defaults to the analogue property value in the template from which this
+	// machine is cloned.
+	// +optional
+	SyncTimeWithHost *bool `json:"syncTimeWithHost,omitempty"`
+
+	// ManagePowerState is a flag that controls whether or not this machine's
+	// VM is powered back on if it is powered off after the machine is ready.
+	// Set this to false to stop the VM out-of-band without the controller
+	// powering it on again. The VM is always powered on after it is created.
+	// Defaults to true.
+	// +optional
+	ManagePowerState *bool `json:"managePowerState,omitempty"`
+
+	// SkipGuestToolsWait is a flag that indicates whether or not to skip
+	// waiting for VMware Tools to run in the machine's VM. Set this for images
+	// that do not run VMware Tools, whose IP addresses are then taken from
+	// the status of the machine's node instead of being reported by the VM.
+	// +optional
+	SkipGuestToolsWait bool `json:"skipGuestToolsWait,omitempty"`
+
+	// DetectTemplateDrift is a flag that indicates whether or not to emit a
+	// TemplateDrift event when Template changes after the machine's VM was
+	// cloned from it, such as when the template is updated or replaced by a
+	// different template with the same name. The VM is not changed, but a
+	// rolling replacement of the machine may be warranted. Only machines
+	// cloned from Template are checked.
+	// +optional
+	DetectTemplateDrift bool `json:"detectTemplateDrift,omitempty"`
 }
 
 // VSphereMachineStatus defines the observed state of VSphereMachine
@@ -105,6 +424,65 @@ type VSphereMachineStatus struct {
 	// +optional
 	Network []NetworkStatus `json:"networkStatus,omitempty"`
 
+	// Conditions describe the provisioning progress of the machine.
+	// +optional
+	Conditions []VSphereMachineProviderCondition `json:"conditions,omitempty"`
+
+	// ProvisioningTimes records when each phase of provisioning the machine
+	// completed.
+	// +optional
+	ProvisioningTimes *VSphereMachineProvisioningTimes `json:"provisioningTimes,omitempty"`
+
+	// Resources describes the CPUs, memory, and disk space the machine's VM
+	// is configured with, which may differ from the spec when the spec leaves
+	// them to the template. Resources is informational and is never used as
+	// the desired state of the VM.
+	// +optional
+	Resources *VSphereMachineResources `json:"resources,omitempty"`
+
+	// TemplateVersion identifies the version of the template the machine's
+	// VM was cloned from. It is the template's instance UUID and the time at
+	// which the template's configuration last changed.
+	// +optional
+	TemplateVersion string `json:"templateVersion,omitempty"`
+
+	// FailureDomain is the failure domain in which the machine's VM was
+	// created.
+	// +optional
+	FailureDomain string `json:"failureDomain,omitempty"`
+
+	// Datastore is the name of the datastore on which the machine's VM was
+	// created.
+	// +optional
+	Datastore string `json:"datastore,omitempty"`
+
+	// Host is the name of the ESXi host on which the machine's VM is
+	// running. It is updated when the VM is migrated to another host, ex.
+	// by vMotion.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// InstanceUUID is the instance UUID of the machine's VM. It is recorded
+	// once the VM exists, and the VM is looked up by it afterwards, so the
+	// machine keeps acting on the same VM if the VM is renamed or its
+	// managed object ID changes, ex. when it is re-registered.
+	// +optional
+	InstanceUUID string `json:"instanceUUID,omitempty"`
+
+	// BootstrapISO is the datastore path of the ISO with the machine's
+	// bootstrap data, if the machine uses the iso bootstrap transport. It is
+	// recorded once the ISO is uploaded, so the ISO can be removed when the
+	// machine's VM is destroyed.
+	// +optional
+	BootstrapISO string `json:"bootstrapISO,omitempty"`
+
+	// CreatedSpec records the fields of the spec that are only applied when
+	// the machine's VM is created, as they were when the VM was created.
+	// Changes to these fields are not applied to the VM, which must be
+	// replaced instead.
+	// +optional
+	CreatedSpec *VSphereMachineCreatedSpec `json:"createdSpec,omitempty"`
+
 	// ErrorReason will be set in the event that there is a terminal problem
 	// reconciling the Machine and will contain a succinct value suitable
 	// for machine interpretation.
@@ -144,6 +522,96 @@ type VSphereMachineStatus struct {
 	ErrorMessage *string `json:"errorMessage,omitempty"`
 }
 
+// VSphereMachineResources describes the resources a machine's VM is
+// configured with.
+type VSphereMachineResources struct {
+	// NumCPUs is the number of virtual processors of the VM.
+	// +optional
+	NumCPUs int32 `json:"numCPUs,omitempty"`
+
+	// MemoryMiB is the size of the VM's memory in MiB.
+	// +optional
+	MemoryMiB int64 `json:"memoryMiB,omitempty"`
+
+	// DiskGiB is the total size of the VM's disks in GiB.
+	// +optional
+	DiskGiB int64 `json:"diskGiB,omitempty"`
+}
+
+// VSphereMachineCreatedSpec describes the fields of a VSphereMachineSpec
+// that a machine's VM was created with and that cannot be changed
+// afterwards.
+type VSphereMachineCreatedSpec struct {
+	// Template is the template the VM was cloned from.
+	// +optional
+	Template string `json:"template,omitempty"`
+
+	// ContentLibraryItem is the content library item the VM was deployed
+	// from.
+	// +optional
+	ContentLibraryItem string `json:"contentLibraryItem,omitempty"`
+
+	// CloneMode is the type of clone the VM was created with.
+	// +optional
+	CloneMode CloneMode `json:"cloneMode,omitempty"`
+
+	// Datacenter is the datacenter in which the VM was created.
+	// +optional
+	Datacenter string `json:"datacenter,omitempty"`
+
+	// ComputeCluster is the compute cluster in which the VM was created.
+	// +optional
+	ComputeCluster string `json:"computeCluster,omitempty"`
+
+	// ResourcePool is the resource pool in which the VM was created.
+	// +optional
+	ResourcePool string `json:"resourcePool,omitempty"`
+
+	// Host is the host on which the VM was created.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// Datastore is the datastore on which the VM was created.
+	// +optional
+	Datastore string `json:"datastore,omitempty"`
+
+	// Datastores are the datastores among which the VM was placed.
+	// +optional
+	Datastores []string `json:"datastores,omitempty"`
+
+	// Folder is the folder in which the VM was created.
+	// +optional
+	Folder string `json:"folder,omitempty"`
+
+	// Firmware is the firmware the VM was created with.
+	// +optional
+	Firmware FirmwareType `json:"firmware,omitempty"`
+
+	// SecureBoot is true if the VM was created with secure boot enabled.
+	// +optional
+	SecureBoot bool `json:"secureBoot,omitempty"`
+
+	// BootDelayMilliseconds is the boot delay the VM was created with.
+	// +optional
+	BootDelayMilliseconds int64 `json:"bootDelayMilliseconds,omitempty"`
+
+	// LatencySensitivity is the latency sensitivity the VM was created with.
+	// +optional
+	LatencySensitivity LatencySensitivityLevel `json:"latencySensitivity,omitempty"`
+
+	// CPUAffinity is the CPU affinity the VM was created with.
+	// +optional
+	CPUAffinity []int32 `json:"cpuAffinity,omitempty"`
+
+	// BootstrapTransport is the bootstrap transport the VM was created with.
+	// +optional
+	BootstrapTransport BootstrapTransport `json:"bootstrapTransport,omitempty"`
+
+	// NetworkNames are the networks of the VM's network devices, in order.
+	// +optional
+	NetworkNames []string `json:"networkNames,omitempty"`
+}
+
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:path=vspheremachines,scope=Namespaced,categories=cluster-api
 // +kubebuilder:storageversion
@@ -0,0 +1,209 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// memoryMiBMultiple is the multiple of MiB vSphere requires the memory of a
+// VM to be.
+const memoryMiBMultiple = 4
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-infrastructure-cluster-x-k8s-io-v1alpha2-vspheremachine,mutating=false,failurePolicy=fail,groups=infrastructure.cluster.x-k8s.io,resources=vspheremachines,versions=v1alpha2,name=validation.vspheremachine.infrastructure.cluster.x-k8s.io
+
+var _ webhook.Validator = &VSphereMachine{}
+
+// SetupWebhookWithManager registers the VSphereMachine validating webhook
+// with the manager.
+func (r *VSphereMachine) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(r).Complete()
+}
+
+// ValidateCreate implements webhook.Validator.
+func (r *VSphereMachine) ValidateCreate() error {
+	return r.validate(r.Spec.validate(field.NewPath("spec")))
+}
+
+// ValidateUpdate implements webhook.Validator. Machines whose spec was
+// already invalid are not rejected so the controller can still record its
+// progress and errors on them.
+func (r *VSphereMachine) ValidateUpdate(old runtime.Object) error {
+	if oldMachine, ok := old.(*VSphereMachine); ok && len(oldMachine.Spec.validate(field.NewPath("spec"))) > 0 {
+		return nil
+	}
+	return r.ValidateCreate()
+}
+
+// ValidateDelete implements webhook.Validator.
+func (r *VSphereMachine) ValidateDelete() error {
+	return nil
+}
+
+func (r *VSphereMachine) validate(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("VSphereMachine").GroupKind(), r.Name, allErrs)
+}
+
+// validate returns the errors in the spec that prevent a VM from being
+// created as specified. Errors that depend on the contents of vSphere are
+// reported when the machine is reconciled instead.
+func (s *VSphereMachineSpec) validate(path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	switch {
+	case s.ExistingVMUUID != "":
+	case s.Template != "" && s.ContentLibraryItem != "":
+		allErrs = append(allErrs, field.Invalid(path.Child("contentLibraryItem"), s.ContentLibraryItem, "template and contentLibraryItem are mutually exclusive"))
+	case s.Template == "" && s.ContentLibraryItem == "":
+		allErrs = append(allErrs, field.Required(path.Child("template"), "one of template, contentLibraryItem, or existingVMUUID is required"))
+	}
+	if len(s.OVFProperties) > 0 && s.ContentLibraryItem == "" {
+		allErrs = append(allErrs, field.Invalid(path.Child("ovfProperties"), s.OVFProperties, "ovfProperties require a contentLibraryItem"))
+	}
+	if s.Datastore != "" && s.DatastoreCluster != "" {
+		allErrs = append(allErrs, field.Invalid(path.Child("datastoreCluster"), s.DatastoreCluster, "datastore and datastoreCluster are mutually exclusive"))
+	}
+	if s.Datastore != "" && len(s.Datastores) > 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("datastores"), s.Datastores, "datastore and datastores are mutually exclusive"))
+	}
+	if len(s.Datastores) > 0 && s.DatastoreCluster != "" {
+		allErrs = append(allErrs, field.Invalid(path.Child("datastoreCluster"), s.DatastoreCluster, "datastores and datastoreCluster are mutually exclusive"))
+	}
+	if s.StoragePolicy != "" && s.DatastoreCluster != "" {
+		allErrs = append(allErrs, field.Invalid(path.Child("datastoreCluster"), s.DatastoreCluster, "storagePolicy and datastoreCluster are mutually exclusive"))
+	}
+
+	if s.ResourcePool != "" && s.ComputeCluster != "" {
+		allErrs = append(allErrs, field.Invalid(path.Child("computeCluster"), s.ComputeCluster, "resourcePool and computeCluster are mutually exclusive"))
+	}
+	if s.Host != "" && len(s.PCIDevices) > 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("host"), s.Host, "host and pciDevices are mutually exclusive"))
+	}
+
+	if s.CloneMode == LinkedClone && s.DiskProvisioning != "" && s.DiskProvisioning != ThinDiskProvisioning {
+		allErrs = append(allErrs, field.Invalid(path.Child("diskProvisioning"), s.DiskProvisioning, "thick disk provisioning types are not supported by linkedClone"))
+	}
+
+	if s.SecureBoot && s.Firmware != EFIFirmware {
+		allErrs = append(allErrs, field.Invalid(path.Child("secureBoot"), s.SecureBoot, "secureBoot requires the efi firmware"))
+	}
+
+	if s.BootstrapTransport == ISOBootstrapTransport && s.BootstrapFormat == IgnitionBootstrapFormat {
+		allErrs = append(allErrs, field.Invalid(path.Child("bootstrapTransport"), s.BootstrapTransport, "the iso bootstrap transport requires the cloud-init bootstrap format"))
+	}
+
+	if s.BootDelayMilliseconds < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("bootDelayMilliseconds"), s.BootDelayMilliseconds, "must not be negative"))
+	}
+	bootDevices := map[BootDeviceType]bool{}
+	for i, device := range s.BootOrder {
+		switch device {
+		case CDROMBootDevice, DiskBootDevice, EthernetBootDevice, FloppyBootDevice:
+		default:
+			allErrs = append(allErrs, field.NotSupported(path.Child("bootOrder").Index(i), device, []string{
+				string(CDROMBootDevice), string(DiskBootDevice), string(EthernetBootDevice), string(FloppyBootDevice)}))
+		}
+		if bootDevices[device] {
+			allErrs = append(allErrs, field.Duplicate(path.Child("bootOrder").Index(i), device))
+		}
+		bootDevices[device] = true
+	}
+	if bootDevices[EthernetBootDevice] && len(s.Network.Devices) == 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("bootOrder"), s.BootOrder, "booting from ethernet requires a network device"))
+	}
+
+	if len(s.CPUAffinity) > 0 && s.Host == "" {
+		allErrs = append(allErrs, field.Required(path.Child("host"), "cpuAffinity requires a host"))
+	}
+	cpus := map[int32]bool{}
+	for i, cpu := range s.CPUAffinity {
+		switch {
+		case cpu < 0:
+			allErrs = append(allErrs, field.Invalid(path.Child("cpuAffinity").Index(i), cpu, "must not be negative"))
+		case cpus[cpu]:
+			allErrs = append(allErrs, field.Duplicate(path.Child("cpuAffinity").Index(i), cpu))
+		}
+		cpus[cpu] = true
+	}
+	if s.NumCPUs > 0 && len(s.CPUAffinity) > 0 && int32(len(s.CPUAffinity)) < s.NumCPUs {
+		allErrs = append(allErrs, field.Invalid(path.Child("cpuAffinity"), s.CPUAffinity, "must have at least numCPUs processors"))
+	}
+
+	if s.NumCPUs < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("numCPUs"), s.NumCPUs, "must not be negative"))
+	}
+	if s.NumCoresPerSocket < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("numCoresPerSocket"), s.NumCoresPerSocket, "must not be negative"))
+	}
+	if s.NumCPUs > 0 && s.NumCoresPerSocket > 0 && s.NumCPUs%s.NumCoresPerSocket != 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("numCPUs"), s.NumCPUs, "must be a multiple of numCoresPerSocket"))
+	}
+	if s.MemoryMiB < 0 || s.MemoryMiB%memoryMiBMultiple != 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("memoryMiB"), s.MemoryMiB, "must be a non-negative multiple of 4"))
+	}
+	for _, alloc := range []struct {
+		reservationField, limitField string
+		reservation, limit           int64
+	}{
+		{"cpuReservationMHz", "cpuLimitMHz", s.CPUReservationMHz, s.CPULimitMHz},
+		{"memoryReservationMiB", "memoryLimitMiB", s.MemoryReservationMiB, s.MemoryLimitMiB},
+	} {
+		if alloc.reservation < 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child(alloc.reservationField), alloc.reservation, "must not be negative"))
+		}
+		if alloc.limit < -1 {
+			allErrs = append(allErrs, field.Invalid(path.Child(alloc.limitField), alloc.limit, "must be -1 or a non-negative limit"))
+		}
+		if alloc.limit > 0 && alloc.reservation > alloc.limit {
+			allErrs = append(allErrs, field.Invalid(path.Child(alloc.reservationField), alloc.reservation, "must not exceed "+alloc.limitField))
+		}
+	}
+	if s.MemoryMiB > 0 && s.MemoryReservationMiB > s.MemoryMiB {
+		allErrs = append(allErrs, field.Invalid(path.Child("memoryReservationMiB"), s.MemoryReservationMiB, "must not exceed memoryMiB"))
+	}
+	if s.MemoryReservationMiB > 0 && len(s.PCIDevices) > 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("memoryReservationMiB"), s.MemoryReservationMiB, "memoryReservationMiB and pciDevices are mutually exclusive"))
+	}
+	if s.DiskGiB < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("diskGiB"), s.DiskGiB, "must not be negative"))
+	}
+
+	for i, disk := range s.AdditionalDisks {
+		diskPath := path.Child("additionalDisks").Index(i)
+		switch {
+		case disk.FileName != "" && disk.SizeGiB != 0:
+			allErrs = append(allErrs, field.Invalid(diskPath.Child("sizeGiB"), disk.SizeGiB, "fileName and sizeGiB are mutually exclusive"))
+		case disk.FileName == "" && disk.SizeGiB <= 0:
+			allErrs = append(allErrs, field.Required(diskPath.Child("sizeGiB"), "one of fileName or a positive sizeGiB is required"))
+		}
+	}
+
+	for i, device := range s.Network.Devices {
+		if device.NetworkName == "" {
+			allErrs = append(allErrs, field.Required(path.Child("network", "devices").Index(i).Child("networkName"), ""))
+		}
+	}
+
+	return allErrs
+}
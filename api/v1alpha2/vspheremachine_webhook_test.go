@@ -0,0 +1,350 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVSphereMachine_ValidateCreate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		modifySpec func(*VSphereMachineSpec)
+		expectErr  bool
+	}{
+		{
+			name:       "valid",
+			modifySpec: func(*VSphereMachineSpec) {},
+		},
+		{
+			name: "existing vm without template",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Template = ""
+				spec.ExistingVMUUID = "42000000-0000-0000-0000-000000000000"
+			},
+		},
+		{
+			name: "missing source",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Template = ""
+			},
+			expectErr: true,
+		},
+		{
+			name: "template and content library item",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.ContentLibraryItem = "library/item"
+			},
+			expectErr: true,
+		},
+		{
+			name: "ovf properties without content library item",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.OVFProperties = map[string]string{"key": "value"}
+			},
+			expectErr: true,
+		},
+		{
+			name: "datastore and datastore cluster",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Datastore = "ds"
+				spec.DatastoreCluster = "pod"
+			},
+			expectErr: true,
+		},
+		{
+			name: "datastore and datastores",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Datastore = "ds"
+				spec.Datastores = []string{"ds1", "ds2"}
+			},
+			expectErr: true,
+		},
+		{
+			name: "datastores and datastore cluster",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Datastores = []string{"ds1", "ds2"}
+				spec.DatastoreCluster = "pod"
+			},
+			expectErr: true,
+		},
+		{
+			name: "storage policy and datastore cluster",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.StoragePolicy = "gold"
+				spec.DatastoreCluster = "pod"
+			},
+			expectErr: true,
+		},
+		{
+			name: "resource pool and compute cluster",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.ResourcePool = "pool"
+				spec.ComputeCluster = "cluster"
+			},
+			expectErr: true,
+		},
+		{
+			name: "thick disks with linked clone",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.CloneMode = LinkedClone
+				spec.DiskProvisioning = EagerZeroedThickDiskProvisioning
+			},
+			expectErr: true,
+		},
+		{
+			name: "efi firmware with secure boot",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Firmware = EFIFirmware
+				spec.SecureBoot = true
+			},
+		},
+		{
+			name: "secure boot without efi firmware",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Firmware = BIOSFirmware
+				spec.SecureBoot = true
+			},
+			expectErr: true,
+		},
+		{
+			name: "iso bootstrap transport",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.BootstrapTransport = ISOBootstrapTransport
+			},
+		},
+		{
+			name: "iso bootstrap transport with ignition",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.BootstrapFormat = IgnitionBootstrapFormat
+				spec.BootstrapTransport = ISOBootstrapTransport
+			},
+			expectErr: true,
+		},
+		{
+			name: "boot options",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.BootDelayMilliseconds = 5000
+				spec.BootOrder = []BootDeviceType{EthernetBootDevice, DiskBootDevice}
+			},
+		},
+		{
+			name: "negative boot delay",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.BootDelayMilliseconds = -1
+			},
+			expectErr: true,
+		},
+		{
+			name: "unsupported boot device",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.BootOrder = []BootDeviceType{"usb"}
+			},
+			expectErr: true,
+		},
+		{
+			name: "duplicate boot device",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.BootOrder = []BootDeviceType{DiskBootDevice, DiskBootDevice}
+			},
+			expectErr: true,
+		},
+		{
+			name: "ethernet boot without network devices",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Network.Devices = nil
+				spec.BootOrder = []BootDeviceType{EthernetBootDevice}
+			},
+			expectErr: true,
+		},
+		{
+			name: "cpu affinity",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Host = "esx1"
+				spec.LatencySensitivity = HighLatencySensitivity
+				spec.CPUAffinity = []int32{0, 1, 2, 3}
+			},
+		},
+		{
+			name: "cpu affinity without host",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.CPUAffinity = []int32{0, 1, 2, 3}
+			},
+			expectErr: true,
+		},
+		{
+			name: "duplicate cpu affinity",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Host = "esx1"
+				spec.CPUAffinity = []int32{0, 1, 2, 2}
+			},
+			expectErr: true,
+		},
+		{
+			name: "cpu affinity smaller than cpus",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Host = "esx1"
+				spec.CPUAffinity = []int32{0, 1}
+			},
+			expectErr: true,
+		},
+		{
+			name: "negative cpus",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.NumCPUs = -1
+			},
+			expectErr: true,
+		},
+		{
+			name: "cpus not a multiple of cores",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.NumCPUs = 3
+				spec.NumCoresPerSocket = 2
+			},
+			expectErr: true,
+		},
+		{
+			name: "memory not a multiple of 4",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.MemoryMiB = 2050
+			},
+			expectErr: true,
+		},
+		{
+			name: "resource allocation",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.CPUReservationMHz = 1000
+				spec.CPULimitMHz = 2000
+				spec.MemoryReservationMiB = 2048
+				spec.MemoryLimitMiB = -1
+				spec.Shares = HighSharesLevel
+			},
+		},
+		{
+			name: "memory reservation exceeds memory",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.MemoryReservationMiB = 8192
+			},
+			expectErr: true,
+		},
+		{
+			name: "cpu reservation exceeds limit",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.CPUReservationMHz = 2000
+				spec.CPULimitMHz = 1000
+			},
+			expectErr: true,
+		},
+		{
+			name: "invalid memory limit",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.MemoryLimitMiB = -2
+			},
+			expectErr: true,
+		},
+		{
+			name: "memory reservation with pci devices",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.MemoryReservationMiB = 1024
+				spec.PCIDevices = []PCIDeviceSpec{{DeviceID: 0x1eb8, VendorID: 0x10de}}
+			},
+			expectErr: true,
+		},
+		{
+			name: "negative disk size",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.DiskGiB = -1
+			},
+			expectErr: true,
+		},
+		{
+			name: "additional disk with file name and size",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.AdditionalDisks = []DiskSpec{{FileName: "[ds] data.vmdk", SizeGiB: 10}}
+			},
+			expectErr: true,
+		},
+		{
+			name: "network device without network name",
+			modifySpec: func(spec *VSphereMachineSpec) {
+				spec.Network.Devices[0].NetworkName = ""
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &VSphereMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				Spec: VSphereMachineSpec{
+					Template:  "ubuntu",
+					NumCPUs:   4,
+					MemoryMiB: 4096,
+					DiskGiB:   20,
+					Network: NetworkSpec{
+						Devices: []NetworkDeviceSpec{{NetworkName: "VM Network", DHCP4: true}},
+					},
+				},
+			}
+			tc.modifySpec(&machine.Spec)
+
+			err := machine.ValidateCreate()
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+
+			template := &VSphereMachineTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-template", Namespace: "test-namespace"},
+				Spec: VSphereMachineTemplateSpec{
+					Template: VSphereMachineTemplateResource{Spec: machine.Spec},
+				},
+			}
+			if err := template.ValidateCreate(); (err != nil) != tc.expectErr {
+				t.Fatalf("expected template error %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestVSphereMachine_ValidateUpdate(t *testing.T) {
+	valid := VSphereMachineSpec{Template: "ubuntu"}
+	invalid := VSphereMachineSpec{Template: "ubuntu", NumCPUs: -1}
+
+	testCases := []struct {
+		name      string
+		old       VSphereMachineSpec
+		new       VSphereMachineSpec
+		expectErr bool
+	}{
+		{name: "valid update", old: valid, new: valid},
+		{name: "invalid update", old: valid, new: invalid, expectErr: true},
+		{name: "already invalid", old: invalid, new: invalid},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			oldMachine := &VSphereMachine{ObjectMeta: metav1.ObjectMeta{Name: "test-machine"}, Spec: tc.old}
+			newMachine := &VSphereMachine{ObjectMeta: metav1.ObjectMeta{Name: "test-machine"}, Spec: tc.new}
+			if err := newMachine.ValidateUpdate(oldMachine); (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
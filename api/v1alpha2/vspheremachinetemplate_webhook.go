@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-infrastructure-cluster-x-k8s-io-v1alpha2-vspheremachinetemplate,mutating=false,failurePolicy=fail,groups=infrastructure.cluster.x-k8s.io,resources=vspheremachinetemplates,versions=v1alpha2,name=validation.vspheremachinetemplate.infrastructure.cluster.x-k8s.io
+
+var _ webhook.Validator = &VSphereMachineTemplate{}
+
+// SetupWebhookWithManager registers the VSphereMachineTemplate validating
+// webhook with the manager.
+func (r *VSphereMachineTemplate) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(r).Complete()
+}
+
+// ValidateCreate implements webhook.Validator.
+func (r *VSphereMachineTemplate) ValidateCreate() error {
+	allErrs := r.Spec.Template.Spec.validate(field.NewPath("spec", "template", "spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("VSphereMachineTemplate").GroupKind(), r.Name, allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator.
+func (r *VSphereMachineTemplate) ValidateUpdate(old runtime.Object) error {
+	return r.ValidateCreate()
+}
+
+// ValidateDelete implements webhook.Validator.
+func (r *VSphereMachineTemplate) ValidateDelete() error {
+	return nil
+}
@@ -41,6 +41,36 @@ func (in *APIEndpoint) DeepCopy() *APIEndpoint {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiskSpec) DeepCopyInto(out *DiskSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiskSpec.
+func (in *DiskSpec) DeepCopy() *DiskSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DiskSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailureDomain) DeepCopyInto(out *FailureDomain) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureDomain.
+func (in *FailureDomain) DeepCopy() *FailureDomain {
+	if in == nil {
+		return nil
+	}
+	out := new(FailureDomain)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NetworkDeviceSpec) DeepCopyInto(out *NetworkDeviceSpec) {
 	*out = *in
@@ -111,6 +141,16 @@ func (in *NetworkSpec) DeepCopyInto(out *NetworkSpec) {
 		*out = make([]NetworkRouteSpec, len(*in))
 		copy(*out, *in)
 	}
+	if in.DNSServers != nil {
+		in, out := &in.DNSServers, &out.DNSServers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SearchDomains != nil {
+		in, out := &in.SearchDomains, &out.SearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkSpec.
@@ -143,6 +183,21 @@ func (in *NetworkStatus) DeepCopy() *NetworkStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDeviceSpec) DeepCopyInto(out *PCIDeviceSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDeviceSpec.
+func (in *PCIDeviceSpec) DeepCopy() *PCIDeviceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDeviceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VSphereCluster) DeepCopyInto(out *VSphereCluster) {
 	*out = *in
@@ -210,6 +265,11 @@ func (in *VSphereClusterSpec) DeepCopyInto(out *VSphereClusterSpec) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.FailureDomains != nil {
+		in, out := &in.FailureDomains, &out.FailureDomains
+		*out = make([]FailureDomain, len(*in))
+		copy(*out, *in)
+	}
 	in.CloudProviderConfiguration.DeepCopyInto(&out.CloudProviderConfiguration)
 }
 
@@ -270,6 +330,36 @@ func (in *VSphereMachine) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VSphereMachineCreatedSpec) DeepCopyInto(out *VSphereMachineCreatedSpec) {
+	*out = *in
+	if in.Datastores != nil {
+		in, out := &in.Datastores, &out.Datastores
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CPUAffinity != nil {
+		in, out := &in.CPUAffinity, &out.CPUAffinity
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.NetworkNames != nil {
+		in, out := &in.NetworkNames, &out.NetworkNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VSphereMachineCreatedSpec.
+func (in *VSphereMachineCreatedSpec) DeepCopy() *VSphereMachineCreatedSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VSphereMachineCreatedSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VSphereMachineList) DeepCopyInto(out *VSphereMachineList) {
 	*out = *in
@@ -302,6 +392,72 @@ func (in *VSphereMachineList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VSphereMachineProviderCondition) DeepCopyInto(out *VSphereMachineProviderCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VSphereMachineProviderCondition.
+func (in *VSphereMachineProviderCondition) DeepCopy() *VSphereMachineProviderCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(VSphereMachineProviderCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VSphereMachineProvisioningTimes) DeepCopyInto(out *VSphereMachineProvisioningTimes) {
+	*out = *in
+	if in.CloneStarted != nil {
+		in, out := &in.CloneStarted, &out.CloneStarted
+		*out = (*in).DeepCopy()
+	}
+	if in.CloneCompleted != nil {
+		in, out := &in.CloneCompleted, &out.CloneCompleted
+		*out = (*in).DeepCopy()
+	}
+	if in.PoweredOn != nil {
+		in, out := &in.PoweredOn, &out.PoweredOn
+		*out = (*in).DeepCopy()
+	}
+	if in.IPAddressAssigned != nil {
+		in, out := &in.IPAddressAssigned, &out.IPAddressAssigned
+		*out = (*in).DeepCopy()
+	}
+	if in.NodeJoined != nil {
+		in, out := &in.NodeJoined, &out.NodeJoined
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VSphereMachineProvisioningTimes.
+func (in *VSphereMachineProvisioningTimes) DeepCopy() *VSphereMachineProvisioningTimes {
+	if in == nil {
+		return nil
+	}
+	out := new(VSphereMachineProvisioningTimes)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VSphereMachineResources) DeepCopyInto(out *VSphereMachineResources) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VSphereMachineResources.
+func (in *VSphereMachineResources) DeepCopy() *VSphereMachineResources {
+	if in == nil {
+		return nil
+	}
+	out := new(VSphereMachineResources)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VSphereMachineSpec) DeepCopyInto(out *VSphereMachineSpec) {
 	*out = *in
@@ -310,7 +466,49 @@ func (in *VSphereMachineSpec) DeepCopyInto(out *VSphereMachineSpec) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.OVFProperties != nil {
+		in, out := &in.OVFProperties, &out.OVFProperties
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Datastores != nil {
+		in, out := &in.Datastores, &out.Datastores
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	in.Network.DeepCopyInto(&out.Network)
+	if in.CPUHotAddEnabled != nil {
+		in, out := &in.CPUHotAddEnabled, &out.CPUHotAddEnabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.MemoryHotAddEnabled != nil {
+		in, out := &in.MemoryHotAddEnabled, &out.MemoryHotAddEnabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.PCIDevices != nil {
+		in, out := &in.PCIDevices, &out.PCIDevices
+		*out = make([]PCIDeviceSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.AdditionalDisks != nil {
+		in, out := &in.AdditionalDisks, &out.AdditionalDisks
+		*out = make([]DiskSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.BootOrder != nil {
+		in, out := &in.BootOrder, &out.BootOrder
+		*out = make([]BootDeviceType, len(*in))
+		copy(*out, *in)
+	}
+	if in.CPUAffinity != nil {
+		in, out := &in.CPUAffinity, &out.CPUAffinity
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
 	if in.TrustedCerts != nil {
 		in, out := &in.TrustedCerts, &out.TrustedCerts
 		*out = make([][]byte, len(*in))
@@ -327,6 +525,16 @@ func (in *VSphereMachineSpec) DeepCopyInto(out *VSphereMachineSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.SyncTimeWithHost != nil {
+		in, out := &in.SyncTimeWithHost, &out.SyncTimeWithHost
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ManagePowerState != nil {
+		in, out := &in.ManagePowerState, &out.ManagePowerState
+		*out = new(bool)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VSphereMachineSpec.
@@ -354,6 +562,28 @@ func (in *VSphereMachineStatus) DeepCopyInto(out *VSphereMachineStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]VSphereMachineProviderCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ProvisioningTimes != nil {
+		in, out := &in.ProvisioningTimes, &out.ProvisioningTimes
+		*out = new(VSphereMachineProvisioningTimes)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(VSphereMachineResources)
+		**out = **in
+	}
+	if in.CreatedSpec != nil {
+		in, out := &in.CreatedSpec, &out.CreatedSpec
+		*out = new(VSphereMachineCreatedSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ErrorReason != nil {
 		in, out := &in.ErrorReason, &out.ErrorReason
 		*out = new(errors.MachineStatusError)
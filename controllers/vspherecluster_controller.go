@@ -242,8 +242,8 @@ func (r *VSphereClusterReconciler) reconcileCloudConfigSecret(ctx *context.Clust
 
 	credentials := map[string]string{}
 	for server := range ctx.VSphereCluster.Spec.CloudProviderConfiguration.VCenter {
-		credentials[fmt.Sprintf("%s.username", server)] = ctx.User()
-		credentials[fmt.Sprintf("%s.password", server)] = ctx.Pass()
+		credentials[fmt.Sprintf("%s.username", server)] = ctx.UserFor(server)
+		credentials[fmt.Sprintf("%s.password", server)] = ctx.PassFor(server)
 	}
 	// Define the kubeconfig secret for the target cluster.
 	secret := &apiv1.Secret{
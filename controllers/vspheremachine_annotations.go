@@ -41,6 +41,9 @@ func (r *VSphereMachineReconciler) updateMachineAnnotationJSON(machine *infrav1.
 func (r *VSphereMachineReconciler) updateMachineAnnotation(machine *infrav1.VSphereMachine, annotation string, content string) {
 	// Get the annotations
 	annotations := machine.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
 
 	// Set our annotation to the given content.
 	annotations[annotation] = content
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+)
+
+// bootstrapDataBackoff increases the time machines wait between checks for
+// their bootstrap data, which is not generated for joining machines until
+// the control plane is online.
+var bootstrapDataBackoff = newRequeueBackoff()
+
+// requeueBackoff tracks the number of successive times each machine was
+// requeued while waiting for the same condition.
+type requeueBackoff struct {
+	sync.Mutex
+
+	// attempts maps a machine to the number of times it was requeued.
+	attempts map[string]int
+}
+
+func newRequeueBackoff() *requeueBackoff {
+	return &requeueBackoff{
+		attempts: map[string]int{},
+	}
+}
+
+// next returns the time the machine waits before it is requeued again. The
+// first wait is initial, and each successive wait doubles until it reaches
+// max. A max lower than initial disables the backoff.
+func (b *requeueBackoff) next(machine string, initial, max time.Duration) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	attempt := b.attempts[machine]
+	b.attempts[machine] = attempt + 1
+
+	delay := initial
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max && max >= initial {
+		delay = max
+	}
+	return delay
+}
+
+// reset forgets the machine's requeues, so the next wait is the initial one.
+func (b *requeueBackoff) reset(machine string) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.attempts, machine)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequeueBackoff(t *testing.T) {
+	b := newRequeueBackoff()
+
+	steps := []struct {
+		name     string
+		machine  string
+		reset    bool
+		initial  time.Duration
+		max      time.Duration
+		expected time.Duration
+	}{
+		{name: "first requeue", machine: "m1", initial: 20 * time.Second, max: time.Minute, expected: 20 * time.Second},
+		{name: "second requeue", machine: "m1", initial: 20 * time.Second, max: time.Minute, expected: 40 * time.Second},
+		{name: "capped requeue", machine: "m1", initial: 20 * time.Second, max: time.Minute, expected: time.Minute},
+		{name: "still capped", machine: "m1", initial: 20 * time.Second, max: time.Minute, expected: time.Minute},
+		{name: "other machine", machine: "m2", initial: 20 * time.Second, max: time.Minute, expected: 20 * time.Second},
+		{name: "reset", machine: "m1", reset: true},
+		{name: "requeue after reset", machine: "m1", initial: 20 * time.Second, max: time.Minute, expected: 20 * time.Second},
+		{name: "backoff disabled", machine: "m2", initial: 20 * time.Second, max: 0, expected: 20 * time.Second},
+	}
+
+	for _, step := range steps {
+		if step.reset {
+			b.reset(step.machine)
+			continue
+		}
+		if actual := b.next(step.machine, step.initial, step.max); actual != step.expected {
+			t.Fatalf("%s: expected requeue after %v, got %v", step.name, step.expected, actual)
+		}
+	}
+}
@@ -19,14 +19,19 @@ package controllers
 import (
 	goctx "context"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/tools/record"
+	kuberecord "k8s.io/client-go/tools/record"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
 	clusterutilv1 "sigs.k8s.io/cluster-api/util"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -36,16 +41,25 @@ import (
 
 	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi"
+	infrautilv1 "sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/metrics"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
 )
 
 // VSphereMachineReconciler reconciles a VSphereMachine object
 type VSphereMachineReconciler struct {
 	client.Client
 	Log      logr.Logger
-	Recorder record.EventRecorder
+	Recorder kuberecord.EventRecorder
+
+	// Context is the parent context of each reconcile. Cancelling it aborts
+	// in-flight waits for vSphere tasks, such as when the manager stops.
+	// Defaults to a context that is never cancelled.
+	Context goctx.Context
 }
 
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=vspheremachines,verbs=get;list;watch;create;update;patch;delete
@@ -55,7 +69,10 @@ type VSphereMachineReconciler struct {
 
 // Reconcile ensures the back-end state reflects the Kubernetes resource state intent.
 func (r *VSphereMachineReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
-	parentContext := goctx.Background()
+	parentContext := r.Context
+	if parentContext == nil {
+		parentContext = goctx.Background()
+	}
 
 	logger := r.Log.
 		WithName(controllerName).
@@ -85,6 +102,20 @@ func (r *VSphereMachineReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, r
 
 	logger = logger.WithName(fmt.Sprintf("machine=%s", machine.Name))
 
+	// Paused machines are left alone, before a vSphere session is created,
+	// until the paused annotation is removed.
+	pausedKey := vsphereMachine.Namespace + "/" + vsphereMachine.Name
+	if isPaused(machine, vsphereMachine) {
+		logger.Info("Machine is paused, skipping reconciliation")
+		if pausedMachines.pause(pausedKey) {
+			record.Eventf(vsphereMachine, "Paused", "reconciliation of machine %q is paused", machine.Name)
+		}
+		return reconcile.Result{}, nil
+	}
+	if pausedMachines.resume(pausedKey) {
+		record.Eventf(vsphereMachine, "Resumed", "reconciliation of machine %q is resumed", machine.Name)
+	}
+
 	// Fetch the Cluster.
 	cluster, err := clusterutilv1.GetClusterFromMetadata(parentContext, r.Client, machine.ObjectMeta)
 	if err != nil {
@@ -125,6 +156,11 @@ func (r *VSphereMachineReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, r
 		machine,
 		vsphereMachine)
 	if err != nil {
+		// The machine's objects are looked up in its datacenter, so a
+		// datacenter that does not exist is reported on the machine.
+		if services.IsNotFoundError(err) {
+			record.Warnf(vsphereMachine, "DatacenterNotFound", "%v", err)
+		}
 		return reconcile.Result{}, errors.Wrapf(err, "failed to create machine context")
 	}
 
@@ -162,11 +198,37 @@ func (r *VSphereMachineReconciler) SetupWithManager(mgr ctrl.Manager) error {
 func (r *VSphereMachineReconciler) reconcileDelete(ctx *context.MachineContext) (reconcile.Result, error) {
 	ctx.Logger.Info("Handling deleted VSphereMachine")
 
-	// TODO(akutz) Implement selection of VM service based on vSphere version
-	var vmService services.VirtualMachineService = &govmomi.VMService{}
+	// Drain the machine's node before its VM is destroyed.
+	if ok, err := r.reconcileNodeDrain(ctx); !ok {
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		ctx.Logger.V(6).Info("requeuing operation until node is drained")
+		return reconcile.Result{RequeueAfter: config.DefaultRequeue}, nil
+	}
+
+	// Wait for the machine's pre-delete hooks before its VM is destroyed.
+	if !r.reconcilePreDeleteHooks(ctx) {
+		ctx.Logger.V(6).Info("requeuing operation until pre-delete hooks are removed")
+		return reconcile.Result{RequeueAfter: config.DefaultRequeue}, nil
+	}
+
+	vmService := newVMService()
 
 	vm, err := vmService.DestroyVM(ctx)
 	if err != nil {
+		// A machine error indicates the VM cannot be destroyed until the
+		// problem is fixed in vSphere, so record it instead of requeuing.
+		if machineErr, ok := errors.Cause(err).(*capierrors.MachineError); ok {
+			ctx.VSphereMachine.Status.ErrorReason = &machineErr.Reason
+			ctx.VSphereMachine.Status.ErrorMessage = &machineErr.Message
+			ctx.Logger.Error(err, "terminal error destroying VM")
+			return reconcile.Result{}, nil
+		}
+		if services.IsTransientError(err) {
+			ctx.Logger.V(2).Info("requeuing operation after transient error", "reason", err.Error())
+			return reconcile.Result{RequeueAfter: config.DefaultRequeue}, nil
+		}
 		return reconcile.Result{}, errors.Wrapf(err, "failed to destroy VM")
 	}
 
@@ -178,10 +240,115 @@ func (r *VSphereMachineReconciler) reconcileDelete(ctx *context.MachineContext)
 
 	// The VM is deleted so remove the finalizer.
 	ctx.VSphereMachine.Finalizers = clusterutilv1.Filter(ctx.VSphereMachine.Finalizers, infrav1.MachineFinalizer)
+	bootstrapDataBackoff.reset(bootstrapDataBackoffKey(ctx))
 
 	return reconcile.Result{}, nil
 }
 
+// newKubeClient returns a client for the target cluster. It is a variable so
+// tests can replace the target cluster's API server.
+var newKubeClient = infrautilv1.NewKubeClient
+
+// reconcileNodeDrain cordons the machine's node and evicts its pods. A false
+// value is returned until all of the pods have been evicted or the drain
+// timeout has elapsed. The drain is skipped if the target cluster is being
+// deleted or its API server cannot be reached.
+func (r *VSphereMachineReconciler) reconcileNodeDrain(ctx *context.MachineContext) (bool, error) {
+	nodeRef := ctx.Machine.Status.NodeRef
+	if nodeRef == nil {
+		return true, nil
+	}
+	if !ctx.Cluster.DeletionTimestamp.IsZero() {
+		ctx.Logger.V(4).Info("skipping node drain, cluster is being deleted", "node-name", nodeRef.Name)
+		return true, nil
+	}
+
+	targetClusterClient, err := newKubeClient(ctx, ctx.Client, ctx.Cluster)
+	if err != nil {
+		ctx.Logger.Info("skipping node drain, unable to get client for target cluster", "node-name", nodeRef.Name, "reason", err.Error())
+		return true, nil
+	}
+
+	// The drain timeout starts before the node is first cordoned, so a node
+	// that cannot be cordoned does not block the machine's deletion forever.
+	startedAt, err := time.Parse(time.RFC3339, r.machineAnnotation(ctx.VSphereMachine, constants.DrainStartedAnnotationLabel))
+	if err != nil {
+		startedAt = time.Now().UTC()
+		r.updateMachineAnnotation(ctx.VSphereMachine, constants.DrainStartedAnnotationLabel, startedAt.Format(time.RFC3339))
+		record.Eventf(ctx.VSphereMachine, "DrainStarted", "started draining node %q", nodeRef.Name)
+	}
+
+	if err := infrautilv1.CordonNode(targetClusterClient, nodeRef.Name); err != nil {
+		cause := errors.Cause(err)
+		if apierrors.IsNotFound(cause) {
+			return true, nil
+		}
+		if _, ok := cause.(apierrors.APIStatus); !ok {
+			ctx.Logger.Info("skipping node drain, target cluster is unreachable", "node-name", nodeRef.Name, "reason", err.Error())
+			return true, nil
+		}
+		if time.Since(startedAt) < config.DefaultNodeDrainTimeout {
+			return false, err
+		}
+		record.Warnf(ctx.VSphereMachine, "DrainTimeout", "timed out after %s cordoning node %q: %v", config.DefaultNodeDrainTimeout, nodeRef.Name, err)
+		return true, nil
+	}
+
+	remaining, err := infrautilv1.EvictNodePods(targetClusterClient, nodeRef.Name)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to drain node %q", nodeRef.Name)
+	}
+	if remaining > 0 {
+		if time.Since(startedAt) < config.DefaultNodeDrainTimeout {
+			ctx.Logger.V(4).Info("waiting for pods to be evicted", "node-name", nodeRef.Name, "remaining-pods", remaining)
+			return false, nil
+		}
+		record.Warnf(ctx.VSphereMachine, "DrainTimeout", "timed out after %s draining node %q with %d pods remaining", config.DefaultNodeDrainTimeout, nodeRef.Name, remaining)
+		return true, nil
+	}
+
+	ctx.Logger.V(4).Info("node drained", "node-name", nodeRef.Name)
+	return true, nil
+}
+
+// reconcilePreDeleteHooks returns false while the Machine or VSphereMachine
+// carries an annotation with the pre-delete hook prefix, which allows
+// operators to clean up after a machine, such as by releasing its licenses
+// or IP leases, before its VM is destroyed. True is returned once the hooks
+// are removed or the pre-delete hook timeout has elapsed.
+func (r *VSphereMachineReconciler) reconcilePreDeleteHooks(ctx *context.MachineContext) bool {
+	hooks := append(preDeleteHooks(ctx.Machine.Annotations), preDeleteHooks(ctx.VSphereMachine.Annotations)...)
+	if len(hooks) == 0 {
+		return true
+	}
+
+	startedAt, err := time.Parse(time.RFC3339, r.machineAnnotation(ctx.VSphereMachine, constants.PreDeleteHookStartedAnnotationLabel))
+	if err != nil {
+		startedAt = time.Now().UTC()
+		r.updateMachineAnnotation(ctx.VSphereMachine, constants.PreDeleteHookStartedAnnotationLabel, startedAt.Format(time.RFC3339))
+		record.Eventf(ctx.VSphereMachine, "PreDeleteHookPending", "waiting for pre-delete hooks %s to be removed before destroying vm", strings.Join(hooks, ", "))
+	}
+
+	if time.Since(startedAt) < config.DefaultPreDeleteHookTimeout {
+		ctx.Logger.V(4).Info("waiting for pre-delete hooks", "hooks", hooks)
+		return false
+	}
+	record.Warnf(ctx.VSphereMachine, "PreDeleteHookTimeout", "timed out after %s waiting for pre-delete hooks %s to be removed", config.DefaultPreDeleteHookTimeout, strings.Join(hooks, ", "))
+	return true
+}
+
+// preDeleteHooks returns the names of the pre-delete hooks in annotations.
+func preDeleteHooks(annotations map[string]string) []string {
+	var hooks []string
+	for k := range annotations {
+		if strings.HasPrefix(k, constants.PreDeleteHookAnnotationPrefix) {
+			hooks = append(hooks, strings.TrimPrefix(k, constants.PreDeleteHookAnnotationPrefix))
+		}
+	}
+	sort.Strings(hooks)
+	return hooks
+}
+
 func (r *VSphereMachineReconciler) reconcileNormal(ctx *context.MachineContext) (reconcile.Result, error) {
 	// If the VSphereMachine is in an error state, return early.
 	if ctx.VSphereMachine.Status.ErrorReason != nil || ctx.VSphereMachine.Status.ErrorMessage != nil {
@@ -189,31 +356,67 @@ func (r *VSphereMachineReconciler) reconcileNormal(ctx *context.MachineContext)
 		return reconcile.Result{}, nil
 	}
 
-	// If the VSphereMachine doesn't have our finalizer, add it.
+	// If the VSphereMachine doesn't have our finalizer, add it and requeue so
+	// the finalizer is persisted before a VM is created. Otherwise a machine
+	// deleted while its VM is being cloned could be removed without the VM.
 	if !clusterutilv1.Contains(ctx.VSphereMachine.Finalizers, infrav1.MachineFinalizer) {
 		ctx.VSphereMachine.Finalizers = append(ctx.VSphereMachine.Finalizers, infrav1.MachineFinalizer)
+		return reconcile.Result{Requeue: true}, nil
 	}
 
 	if !ctx.Cluster.Status.InfrastructureReady {
 		ctx.Logger.Info("Cluster infrastructure is not ready yet, requeuing machine")
+		record.Eventf(ctx.VSphereMachine, "WaitingForClusterInfrastructure", "waiting for infrastructure of cluster %q to be ready", ctx.Cluster.Name)
 		return reconcile.Result{RequeueAfter: config.DefaultRequeue}, nil
 	}
 
 	// Make sure bootstrap data is available and populated.
 	if ctx.Machine.Spec.Bootstrap.Data == nil {
-		ctx.Logger.Info("Waiting for bootstrap data to be available")
-		return reconcile.Result{RequeueAfter: config.DefaultRequeue}, nil
+		// Bootstrap data for joining machines is not generated until the
+		// control plane is online, so this is usually the longer wait. The
+		// Machine is watched, so backing off does not delay a machine whose
+		// bootstrap data becomes available.
+		requeueAfter := bootstrapDataBackoff.next(bootstrapDataBackoffKey(ctx), config.DefaultRequeue, config.MaxBootstrapDataRequeue)
+		ctx.Logger.Info("Waiting for bootstrap data to be available", "requeue-after", requeueAfter)
+		record.Eventf(ctx.VSphereMachine, "WaitingForBootstrapData", "waiting for bootstrap data for machine %q", ctx.Machine.Name)
+		return reconcile.Result{RequeueAfter: requeueAfter}, nil
 	}
+	bootstrapDataBackoff.reset(bootstrapDataBackoffKey(ctx))
 
-	// TODO(akutz) Implement selection of VM service based on vSphere version
-	var vmService services.VirtualMachineService = &govmomi.VMService{}
+	vmService := newVMService()
 
 	// Get or create the VM.
 	vm, err := vmService.ReconcileVM(ctx)
 	if err != nil {
+		// A machine error indicates the machine cannot be reconciled as
+		// specified, so record it as terminal instead of requeuing.
+		if machineErr, ok := errors.Cause(err).(*capierrors.MachineError); ok {
+			ctx.VSphereMachine.Status.ErrorReason = &machineErr.Reason
+			ctx.VSphereMachine.Status.ErrorMessage = &machineErr.Message
+			record.Warnf(ctx.VSphereMachine, "ReconcileFailed", "%s", machineErr.Message)
+			ctx.Logger.Error(err, "terminal error reconciling VM")
+			return reconcile.Result{}, nil
+		}
+		if requeueErr, ok := errors.Cause(err).(*services.RequeueAfterError); ok {
+			ctx.Logger.V(4).Info("requeuing operation", "reason", requeueErr.Reason, "requeue-after", requeueErr.RequeueAfter)
+			return reconcile.Result{RequeueAfter: requeueErr.RequeueAfter}, nil
+		}
+		// Transient errors are expected to clear on their own, so the
+		// operation is retried without being reported as a failure.
+		if services.IsTransientError(err) {
+			ctx.Logger.V(2).Info("requeuing operation after transient error", "reason", err.Error())
+			return reconcile.Result{RequeueAfter: config.DefaultRequeue}, nil
+		}
 		return reconcile.Result{}, errors.Wrapf(err, "failed to reconcile VM")
 	}
 
+	// The timeout is checked before the VM's network, as a VM that never
+	// boots may also never report its IP addresses.
+	if !reconcileNodeReadyTimeout(ctx) {
+		ctx.Logger.Info("node did not join the cluster in time, marking machine as failed", "timeout", config.DefaultNodeReadyTimeout)
+		return reconcile.Result{}, nil
+	}
+
 	if vm.State != infrav1.VirtualMachineStateReady {
 		ctx.Logger.V(6).Info("requeuing operation until vm state is reconciled", "expected-vm-state", infrav1.VirtualMachineStateReady, "actual-vm-state", vm.State)
 		return reconcile.Result{RequeueAfter: config.DefaultRequeue}, nil
@@ -235,9 +438,94 @@ func (r *VSphereMachineReconciler) reconcileNormal(ctx *context.MachineContext)
 	ctx.VSphereMachine.Status.Ready = true
 	ctx.Logger.V(6).Info("VSphereMachine is infrastructure-ready")
 
+	// The Machine's NodeRef is set once its node has joined the cluster.
+	if ctx.Machine.Status.NodeRef == nil {
+		infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineReady, corev1.ConditionFalse, "WaitingForNodeRef", "")
+
+		// The node is only matched to the machine once the node has a
+		// provider ID, so it is set as soon as the node registers instead
+		// of waiting for the cloud provider to initialize the node. Once the
+		// node has the provider ID, only the NodeRef is waited for.
+		if condition := infrautilv1.GetMachineCondition(ctx.VSphereMachine, infrav1.MachineJoiningCluster); condition == nil || condition.Reason != nodeProviderIDSetReason {
+			infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineJoiningCluster, corev1.ConditionTrue, "WaitingForNodeRef", "")
+			if ctx.Cluster.Status.ControlPlaneInitialized && !r.reconcileNodeProviderID(ctx) {
+				ctx.Logger.V(6).Info("requeuing operation until node is registered")
+				return reconcile.Result{RequeueAfter: config.DefaultRequeue}, nil
+			}
+		}
+	} else {
+		infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineJoiningCluster, corev1.ConditionFalse, "NodeJoined", "")
+		infrautilv1.MarkProvisioningPhaseCompleted(ctx.VSphereMachine, infrautilv1.ProvisioningPhaseNodeJoin)
+		infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineReady, corev1.ConditionTrue, "NodeJoined",
+			fmt.Sprintf("node %q has joined the cluster", ctx.Machine.Status.NodeRef.Name))
+
+		if infrautilv1.IsControlPlaneMachine(ctx.Machine) {
+			if !r.reconcileControlPlaneHealth(ctx) {
+				ctx.Logger.V(6).Info("requeuing operation until control plane member is healthy")
+				return reconcile.Result{RequeueAfter: config.DefaultRequeue}, nil
+			}
+		}
+	}
+
 	return reconcile.Result{}, nil
 }
 
+// reconcileControlPlaneHealth returns false while the node, kube-apiserver,
+// or stacked etcd member of a control plane machine that joined the cluster
+// is not ready. A warning is emitted if the member is not healthy within the
+// control plane health timeout, as the member may have failed to join
+// silently. The member is only checked until it is healthy once. Failing to
+// reach the target cluster is logged and does not fail the reconcile, as the
+// member is checked again once the machine is requeued.
+func (r *VSphereMachineReconciler) reconcileControlPlaneHealth(ctx *context.MachineContext) bool {
+	if infrautilv1.IsMachineConditionTrue(ctx.VSphereMachine, infrav1.MachineControlPlaneHealthy) {
+		return true
+	}
+
+	targetClusterClient, err := newKubeClient(ctx, ctx.Client, ctx.Cluster)
+	if err != nil {
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			ctx.Logger.V(2).Info("unable to check health of control plane member", "reason", err.Error())
+		} else {
+			ctx.Logger.Error(err, "unable to check health of control plane member")
+		}
+		return false
+	}
+	nodeName := ctx.Machine.Status.NodeRef.Name
+	reason, err := infrautilv1.GetControlPlaneHealth(targetClusterClient, nodeName)
+	if err != nil {
+		ctx.Logger.Error(err, "unable to check health of control plane member")
+		return false
+	}
+	if reason == "" {
+		infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineControlPlaneHealthy, corev1.ConditionTrue, "ControlPlaneHealthy", "")
+		return true
+	}
+
+	if times := ctx.VSphereMachine.Status.ProvisioningTimes; times != nil && times.NodeJoined != nil &&
+		time.Since(times.NodeJoined.Time) >= config.DefaultControlPlaneHealthTimeout {
+		// The warning is only emitted the first time the wait times out.
+		if cond := infrautilv1.GetMachineCondition(ctx.VSphereMachine, infrav1.MachineControlPlaneHealthy); cond == nil || cond.Reason != "ControlPlaneHealthTimeout" {
+			record.Warnf(ctx.VSphereMachine, "ControlPlaneUnhealthy", "control plane member %q is not healthy %s after joining the cluster: %s",
+				nodeName, config.DefaultControlPlaneHealthTimeout, reason)
+		}
+		infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineControlPlaneHealthy, corev1.ConditionFalse, "ControlPlaneHealthTimeout", reason)
+		return false
+	}
+
+	infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineControlPlaneHealthy, corev1.ConditionFalse, "WaitingForControlPlane", reason)
+	return false
+}
+
+// newVMService returns the service used to reconcile VMs.
+func newVMService() services.VirtualMachineService {
+	if config.DryRun {
+		return metrics.InstrumentVMService(&govmomi.DryRunVMService{})
+	}
+	// TODO(akutz) Implement selection of VM service based on vSphere version
+	return metrics.InstrumentVMService(&govmomi.VMService{})
+}
+
 func (r *VSphereMachineReconciler) reconcileNetwork(ctx *context.MachineContext, vm infrav1.VirtualMachine, vmService services.VirtualMachineService) (bool, error) {
 	expNetCount, actNetCount := len(ctx.VSphereMachine.Spec.Network.Devices), len(vm.Network)
 	if expNetCount != actNetCount {
@@ -258,10 +546,24 @@ func (r *VSphereMachineReconciler) reconcileNetwork(ctx *context.MachineContext,
 		}
 	}
 
+	// VMs that do not run VMware Tools never report their IP addresses, so
+	// the addresses are taken from the machine's node once it exists.
+	if len(ipAddrs) == 0 && ctx.VSphereMachine.Spec.SkipGuestToolsWait {
+		ipAddrs = r.getNodeAddresses(ctx)
+		if len(ipAddrs) == 0 {
+			infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineWaitingForIP, corev1.ConditionFalse, "GuestToolsWaitSkipped", "IP addresses are taken from the node once it joins the cluster")
+			ctx.Logger.V(6).Info("skipping wait on IP addresses reported by the vm")
+			return true, nil
+		}
+	}
+
 	if len(ipAddrs) == 0 {
+		infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineWaitingForIP, corev1.ConditionTrue, "WaitingForIPAddress", "")
 		ctx.Logger.V(6).Info("requeuing to wait on IP addresses")
 		return false, nil
 	}
+	infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineWaitingForIP, corev1.ConditionFalse, "IPAddressAssigned", "")
+	infrautilv1.MarkProvisioningPhaseCompleted(ctx.VSphereMachine, infrautilv1.ProvisioningPhaseIPAddress)
 
 	// Use the collected IP addresses to assign the Machine's addresses.
 	ctx.VSphereMachine.Status.Addresses = ipAddrs
@@ -269,6 +571,38 @@ func (r *VSphereMachineReconciler) reconcileNetwork(ctx *context.MachineContext,
 	return true, nil
 }
 
+// getNodeAddresses returns the internal and external IP addresses in the
+// status of the machine's node, or nil if the machine has no node yet. Failing
+// to get the node is logged and nil is returned, as the addresses are taken
+// from the node once it can be reached.
+func (r *VSphereMachineReconciler) getNodeAddresses(ctx *context.MachineContext) []corev1.NodeAddress {
+	nodeRef := ctx.Machine.Status.NodeRef
+	if nodeRef == nil {
+		return nil
+	}
+	targetClusterClient, err := newKubeClient(ctx, ctx.Client, ctx.Cluster)
+	if err != nil {
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			ctx.Logger.V(2).Info("unable to get addresses of node", "reason", err.Error())
+		} else {
+			ctx.Logger.Error(err, "unable to get addresses of node")
+		}
+		return nil
+	}
+	node, err := targetClusterClient.Nodes().Get(nodeRef.Name, metav1.GetOptions{})
+	if err != nil {
+		ctx.Logger.Error(errors.Wrapf(err, "failed to get node %q", nodeRef.Name), "unable to get addresses of node")
+		return nil
+	}
+	var addrs []corev1.NodeAddress
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP || addr.Type == corev1.NodeExternalIP {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 func (r *VSphereMachineReconciler) reconcileProviderID(ctx *context.MachineContext, vm infrav1.VirtualMachine, vmService services.VirtualMachineService) error {
 	providerID := fmt.Sprintf("vsphere://%s", vm.BiosUUID)
 	if ctx.VSphereMachine.Spec.ProviderID == nil || *ctx.VSphereMachine.Spec.ProviderID != providerID {
@@ -277,3 +611,49 @@ func (r *VSphereMachineReconciler) reconcileProviderID(ctx *context.MachineConte
 	}
 	return nil
 }
+
+// nodeProviderIDSetReason is the reason of the machine's JoiningCluster
+// condition once its node has the machine's provider ID.
+const nodeProviderIDSetReason = "NodeProviderIDSet"
+
+// reconcileNodeProviderID sets the provider ID of the machine's node if the
+// node registered without one. False is returned if the node has not
+// registered yet. Failing to set the provider ID is logged and does not fail
+// the reconcile, as the cloud provider sets it as well. Once the node has the
+// provider ID, it is recorded in the machine's JoiningCluster condition.
+func (r *VSphereMachineReconciler) reconcileNodeProviderID(ctx *context.MachineContext) bool {
+	// The kubelet registers the node with the guest's hostname, which may or
+	// may not be qualified with the cluster's domain.
+	nodeNames := []string{ctx.VSphereMachine.Name}
+	if hostname, err := infrautilv1.GetMachineHostname(*ctx.VSphereMachine, ctx.VSphereCluster.Spec.HostnameDomain); err == nil && hostname != ctx.VSphereMachine.Name {
+		nodeNames = append([]string{strings.ToLower(hostname)}, nodeNames...)
+	}
+
+	targetClusterClient, err := newKubeClient(ctx, ctx.Client, ctx.Cluster)
+	if err != nil {
+		// The cluster's kubeconfig may not exist yet.
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			ctx.Logger.V(2).Info("unable to set provider ID of node", "reason", err.Error())
+		} else {
+			ctx.Logger.Error(err, "unable to set provider ID of node")
+		}
+		return false
+	}
+	nodeName, err := infrautilv1.SetNodeProviderID(targetClusterClient, nodeNames, *ctx.VSphereMachine.Spec.ProviderID)
+	if err != nil {
+		ctx.Logger.Error(err, "unable to set provider ID of node")
+		return nodeName != ""
+	}
+	if nodeName == "" {
+		ctx.Logger.V(2).Info("node is not registered yet", "node-names", nodeNames)
+		return false
+	}
+	ctx.Logger.V(4).Info("node has provider ID", "node", nodeName, "provider-id", *ctx.VSphereMachine.Spec.ProviderID)
+	infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineJoiningCluster, corev1.ConditionTrue, nodeProviderIDSetReason,
+		fmt.Sprintf("node %q has provider ID %q", nodeName, *ctx.VSphereMachine.Spec.ProviderID))
+	return true
+}
+
+func bootstrapDataBackoffKey(ctx *context.MachineContext) string {
+	return ctx.VSphereMachine.Namespace + "/" + ctx.VSphereMachine.Name
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	goctx "context"
+	"testing"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	clienttesting "k8s.io/client-go/testing"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	infrautilv1 "sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+func TestReconcileControlPlaneHealth(t *testing.T) {
+	defer func(f func(goctx.Context, client.Client, *clusterv1.Cluster) (corev1client.CoreV1Interface, error)) {
+		newKubeClient = f
+	}(newKubeClient)
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-node"},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	apiServer := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: metav1.NamespaceSystem,
+			Name:      "kube-apiserver-test-node",
+			Labels:    map[string]string{"component": "kube-apiserver"},
+		},
+		Spec: corev1.PodSpec{NodeName: "test-node"},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		objects        []runtime.Object
+		kubeClientErr  error
+		nodeErr        error
+		expected       bool
+		expectedReason string
+	}{
+		{
+			name:           "healthy",
+			objects:        []runtime.Object{node, apiServer},
+			expected:       true,
+			expectedReason: "ControlPlaneHealthy",
+		},
+		{
+			name:           "kube-apiserver not running",
+			objects:        []runtime.Object{node},
+			expectedReason: "WaitingForControlPlane",
+		},
+		{
+			name:          "kubeconfig not found",
+			kubeClientErr: errors.Wrap(apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "test-cluster-kubeconfig"), "failed to get kubeconfig"),
+		},
+		{
+			name:    "target cluster unreachable",
+			objects: []runtime.Object{node, apiServer},
+			nodeErr: apierrors.NewServiceUnavailable("connection refused"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			targetClient := fake.NewSimpleClientset(tc.objects...)
+			if tc.nodeErr != nil {
+				targetClient.PrependReactor("get", "nodes", func(action clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tc.nodeErr
+				})
+			}
+			newKubeClient = func(goctx.Context, client.Client, *clusterv1.Cluster) (corev1client.CoreV1Interface, error) {
+				if tc.kubeClientErr != nil {
+					return nil, tc.kubeClientErr
+				}
+				return targetClient.CoreV1(), nil
+			}
+
+			vsphereMachine := &infrav1.VSphereMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+			}
+			ctx := newDrainMachineContext(t, vsphereMachine)
+
+			r := &VSphereMachineReconciler{}
+			if ok := r.reconcileControlPlaneHealth(ctx); ok != tc.expected {
+				t.Fatalf("expected %t, got %t", tc.expected, ok)
+			}
+			var reason string
+			if condition := infrautilv1.GetMachineCondition(vsphereMachine, infrav1.MachineControlPlaneHealthy); condition != nil {
+				reason = condition.Reason
+			}
+			if reason != tc.expectedReason {
+				t.Fatalf("expected reason %q, got %q", tc.expectedReason, reason)
+			}
+		})
+	}
+}
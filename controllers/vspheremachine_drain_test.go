@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	goctx "context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	clienttesting "k8s.io/client-go/testing"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func TestReconcileNodeDrain(t *testing.T) {
+	defer func(f func(goctx.Context, client.Client, *clusterv1.Cluster) (corev1client.CoreV1Interface, error)) {
+		newKubeClient = f
+	}(newKubeClient)
+
+	nodeResource := schema.GroupResource{Resource: "nodes"}
+
+	testCases := []struct {
+		name          string
+		verb          string
+		err           error
+		drainStarted  time.Duration
+		expected      bool
+		expectErr     bool
+		expectCordon  bool
+		expectStarted bool
+	}{
+		{
+			name:          "drained",
+			expected:      true,
+			expectCordon:  true,
+			expectStarted: true,
+		},
+		{
+			name:          "unreachable",
+			verb:          "get",
+			err:           errors.New("dial tcp: connection refused"),
+			expected:      true,
+			expectStarted: true,
+		},
+		{
+			name:          "not found",
+			verb:          "get",
+			err:           apierrors.NewNotFound(nodeResource, "test-node"),
+			expected:      true,
+			expectStarted: true,
+		},
+		{
+			name:          "conflict",
+			verb:          "update",
+			err:           apierrors.NewConflict(nodeResource, "test-node", errors.New("object was modified")),
+			expectErr:     true,
+			expectStarted: true,
+		},
+		{
+			name:          "forbidden",
+			verb:          "get",
+			err:           apierrors.NewForbidden(nodeResource, "test-node", errors.New("not allowed")),
+			expectErr:     true,
+			expectStarted: true,
+		},
+		{
+			name:          "forbidden after drain timeout",
+			verb:          "get",
+			err:           apierrors.NewForbidden(nodeResource, "test-node", errors.New("not allowed")),
+			drainStarted:  time.Hour,
+			expected:      true,
+			expectStarted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			targetClient := fake.NewSimpleClientset(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node"},
+			})
+			if tc.err != nil {
+				targetClient.PrependReactor(tc.verb, "nodes", func(action clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tc.err
+				})
+			}
+			newKubeClient = func(goctx.Context, client.Client, *clusterv1.Cluster) (corev1client.CoreV1Interface, error) {
+				return targetClient.CoreV1(), nil
+			}
+
+			vsphereMachine := &infrav1.VSphereMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+			}
+			if tc.drainStarted > 0 {
+				vsphereMachine.Annotations = map[string]string{
+					constants.DrainStartedAnnotationLabel: time.Now().Add(-tc.drainStarted).UTC().Format(time.RFC3339),
+				}
+			}
+			ctx := newDrainMachineContext(t, vsphereMachine)
+
+			r := &VSphereMachineReconciler{}
+			ok, err := r.reconcileNodeDrain(ctx)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if ok != tc.expected {
+				t.Fatalf("expected %t, got %t", tc.expected, ok)
+			}
+			if _, started := vsphereMachine.Annotations[constants.DrainStartedAnnotationLabel]; started != tc.expectStarted {
+				t.Fatalf("expected drain started %t, got %t", tc.expectStarted, started)
+			}
+			if tc.err == nil {
+				node, err := targetClient.CoreV1().Nodes().Get("test-node", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if node.Spec.Unschedulable != tc.expectCordon {
+					t.Fatalf("expected node cordoned %t, got %t", tc.expectCordon, node.Spec.Unschedulable)
+				}
+			}
+		})
+	}
+}
+
+func newDrainMachineContext(t *testing.T, vsphereMachine *infrav1.VSphereMachine) *context.MachineContext {
+	clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+		Cluster: &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+		},
+		VSphereCluster: &infrav1.VSphereCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+		Status: clusterv1.MachineStatus{
+			NodeRef: &corev1.ObjectReference{Name: "test-node"},
+		},
+	}
+	machineContext, err := context.NewMachineContextFromClusterContext(clusterContext, machine, vsphereMachine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return machineContext
+}
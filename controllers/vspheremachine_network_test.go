@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	goctx "context"
+	"testing"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	infrautilv1 "sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+func TestReconcileNetwork_SkipGuestToolsWait(t *testing.T) {
+	defer func(f func(goctx.Context, client.Client, *clusterv1.Cluster) (corev1client.CoreV1Interface, error)) {
+		newKubeClient = f
+	}(newKubeClient)
+
+	testCases := []struct {
+		name              string
+		objects           []runtime.Object
+		kubeClientErr     error
+		expectedReason    string
+		expectedAddresses []corev1.NodeAddress
+	}{
+		{
+			name: "node has addresses",
+			objects: []runtime.Object{&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node"},
+				Status: corev1.NodeStatus{
+					Addresses: []corev1.NodeAddress{
+						{Type: corev1.NodeHostName, Address: "test-node"},
+						{Type: corev1.NodeInternalIP, Address: "192.168.0.10"},
+					},
+				},
+			}},
+			expectedReason:    "IPAddressAssigned",
+			expectedAddresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "192.168.0.10"}},
+		},
+		{
+			name:           "node not registered",
+			expectedReason: "GuestToolsWaitSkipped",
+		},
+		{
+			name:           "kubeconfig not found",
+			kubeClientErr:  errors.Wrap(apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "test-cluster-kubeconfig"), "failed to get kubeconfig"),
+			expectedReason: "GuestToolsWaitSkipped",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			targetClient := fake.NewSimpleClientset(tc.objects...)
+			newKubeClient = func(goctx.Context, client.Client, *clusterv1.Cluster) (corev1client.CoreV1Interface, error) {
+				if tc.kubeClientErr != nil {
+					return nil, tc.kubeClientErr
+				}
+				return targetClient.CoreV1(), nil
+			}
+
+			vsphereMachine := &infrav1.VSphereMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				Spec: infrav1.VSphereMachineSpec{
+					Network:            infrav1.NetworkSpec{Devices: []infrav1.NetworkDeviceSpec{{NetworkName: "VM Network"}}},
+					SkipGuestToolsWait: true,
+				},
+			}
+			ctx := newDrainMachineContext(t, vsphereMachine)
+			vm := infrav1.VirtualMachine{
+				Name:    "test-machine",
+				Network: []infrav1.NetworkStatus{{NetworkName: "VM Network", MACAddr: "00:50:56:00:00:01"}},
+			}
+
+			r := &VSphereMachineReconciler{}
+			ok, err := r.reconcileNetwork(ctx, vm, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatal("expected network to be reconciled")
+			}
+			var reason string
+			if condition := infrautilv1.GetMachineCondition(vsphereMachine, infrav1.MachineWaitingForIP); condition != nil {
+				reason = condition.Reason
+			}
+			if reason != tc.expectedReason {
+				t.Fatalf("expected reason %q, got %q", tc.expectedReason, reason)
+			}
+			if len(vsphereMachine.Status.Addresses) != len(tc.expectedAddresses) {
+				t.Fatalf("expected addresses %v, got %v", tc.expectedAddresses, vsphereMachine.Status.Addresses)
+			}
+			for i := range tc.expectedAddresses {
+				if vsphereMachine.Status.Addresses[i] != tc.expectedAddresses[i] {
+					t.Fatalf("expected addresses %v, got %v", tc.expectedAddresses, vsphereMachine.Status.Addresses)
+				}
+			}
+		})
+	}
+}
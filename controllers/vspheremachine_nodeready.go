@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	infrautilv1 "sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// reconcileNodeReadyTimeout reports a machine whose node has not joined the
+// cluster within the node ready timeout after the machine's VM was powered
+// on, ex. because the VM's image is broken or its bootstrap failed. Such a
+// machine would otherwise wait for its node forever. The NodeReadyTimedOut
+// condition is set and a warning is emitted the first time the timeout
+// elapses, so a health check or remediation controller can replace the
+// machine. False is returned if the machine was marked as failed instead.
+func reconcileNodeReadyTimeout(ctx *context.MachineContext) bool {
+	cond := infrautilv1.GetMachineCondition(ctx.VSphereMachine, infrav1.MachineNodeReadyTimedOut)
+	if ctx.Machine.Status.NodeRef != nil {
+		// The condition is only cleared on machines that timed out, as
+		// the node of a machine may join after the timeout elapsed.
+		if cond != nil {
+			infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineNodeReadyTimedOut, corev1.ConditionFalse, "NodeJoined", "")
+		}
+		return true
+	}
+
+	times := ctx.VSphereMachine.Status.ProvisioningTimes
+	if config.DefaultNodeReadyTimeout <= 0 || times == nil || times.PoweredOn == nil ||
+		time.Since(times.PoweredOn.Time) < config.DefaultNodeReadyTimeout {
+		return true
+	}
+
+	message := fmt.Sprintf("node did not join the cluster within %s of vm %q being powered on", config.DefaultNodeReadyTimeout, ctx.VSphereMachine.Name)
+	if cond == nil || cond.Status != corev1.ConditionTrue {
+		record.Warnf(ctx.VSphereMachine, "NodeReadyTimeout", "%s", message)
+	}
+	infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineNodeReadyTimedOut, corev1.ConditionTrue, "NodeReadyTimeout", message)
+
+	if !config.FailOnNodeReadyTimeout {
+		return true
+	}
+	reason := capierrors.CreateMachineError
+	ctx.VSphereMachine.Status.ErrorReason = &reason
+	ctx.VSphereMachine.Status.ErrorMessage = &message
+	return false
+}
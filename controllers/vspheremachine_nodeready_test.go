@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	infrautilv1 "sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+func TestReconcileNodeReadyTimeout(t *testing.T) {
+	defer func(timeout time.Duration, fail bool) {
+		config.DefaultNodeReadyTimeout, config.FailOnNodeReadyTimeout = timeout, fail
+	}(config.DefaultNodeReadyTimeout, config.FailOnNodeReadyTimeout)
+
+	testCases := []struct {
+		name            string
+		poweredOn       time.Duration
+		nodeJoined      bool
+		timedOut        bool
+		disabled        bool
+		fail            bool
+		expected        bool
+		expectCondition corev1.ConditionStatus
+		expectFailed    bool
+	}{
+		{name: "not powered on", expected: true},
+		{name: "within timeout", poweredOn: time.Minute, expected: true},
+		{name: "timed out", poweredOn: time.Hour, expected: true, expectCondition: corev1.ConditionTrue},
+		{name: "timed out and failed", poweredOn: time.Hour, fail: true, expectCondition: corev1.ConditionTrue, expectFailed: true},
+		{name: "timeout disabled", poweredOn: time.Hour, disabled: true, expected: true},
+		{name: "node joined", poweredOn: time.Hour, nodeJoined: true, expected: true},
+		{name: "node joined after timeout", poweredOn: time.Hour, nodeJoined: true, timedOut: true, expected: true, expectCondition: corev1.ConditionFalse},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config.FailOnNodeReadyTimeout = tc.fail
+			config.DefaultNodeReadyTimeout = 10 * time.Minute
+			if tc.disabled {
+				config.DefaultNodeReadyTimeout = 0
+			}
+
+			ctx := &context.MachineContext{
+				Machine:        &clusterv1.Machine{},
+				VSphereMachine: &infrav1.VSphereMachine{ObjectMeta: metav1.ObjectMeta{Name: "test-machine"}},
+			}
+			if tc.poweredOn > 0 {
+				poweredOn := metav1.NewTime(time.Now().Add(-tc.poweredOn))
+				ctx.VSphereMachine.Status.ProvisioningTimes = &infrav1.VSphereMachineProvisioningTimes{PoweredOn: &poweredOn}
+			}
+			if tc.nodeJoined {
+				ctx.Machine.Status.NodeRef = &corev1.ObjectReference{Name: "test-node"}
+			}
+			if tc.timedOut {
+				infrautilv1.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineNodeReadyTimedOut, corev1.ConditionTrue, "NodeReadyTimeout", "")
+			}
+
+			if actual := reconcileNodeReadyTimeout(ctx); actual != tc.expected {
+				t.Fatalf("expected %t, got %t", tc.expected, actual)
+			}
+			cond := infrautilv1.GetMachineCondition(ctx.VSphereMachine, infrav1.MachineNodeReadyTimedOut)
+			switch {
+			case tc.expectCondition == "" && cond != nil:
+				t.Fatalf("expected no condition, got %+v", cond)
+			case tc.expectCondition != "" && (cond == nil || cond.Status != tc.expectCondition):
+				t.Fatalf("expected condition status %q, got %+v", tc.expectCondition, cond)
+			}
+			if failed := ctx.VSphereMachine.Status.ErrorReason != nil; failed != tc.expectFailed {
+				t.Fatalf("expected failed %t, got %t", tc.expectFailed, failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+)
+
+// pausedMachines records the machines whose reconciliation is paused, so the
+// event announcing a pause is only emitted once.
+var pausedMachines = newPausedSet()
+
+// pausedSet tracks the machines that are paused.
+type pausedSet struct {
+	sync.Mutex
+
+	machines map[string]struct{}
+}
+
+func newPausedSet() *pausedSet {
+	return &pausedSet{
+		machines: map[string]struct{}{},
+	}
+}
+
+// pause records the machine as paused. True is returned if the machine was
+// not already paused.
+func (s *pausedSet) pause(machine string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.machines[machine]; ok {
+		return false
+	}
+	s.machines[machine] = struct{}{}
+	return true
+}
+
+// resume forgets the machine is paused. True is returned if the machine was
+// paused.
+func (s *pausedSet) resume(machine string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.machines[machine]; !ok {
+		return false
+	}
+	delete(s.machines, machine)
+	return true
+}
+
+// isPaused returns true if any of the objects has a paused annotation.
+func isPaused(objects ...metav1.Object) bool {
+	for _, obj := range objects {
+		annotations := obj.GetAnnotations()
+		if _, ok := annotations[constants.PausedAnnotationLabel]; ok {
+			return true
+		}
+		if _, ok := annotations[constants.ClusterPausedAnnotationLabel]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+)
+
+func TestIsPaused(t *testing.T) {
+	testCases := []struct {
+		name                      string
+		machineAnnotations        map[string]string
+		vsphereMachineAnnotations map[string]string
+		expected                  bool
+	}{
+		{name: "not paused"},
+		{name: "unrelated annotation", machineAnnotations: map[string]string{"foo": "bar"}},
+		{name: "cluster api annotation on machine", machineAnnotations: map[string]string{constants.ClusterPausedAnnotationLabel: ""}, expected: true},
+		{name: "capv annotation on machine", machineAnnotations: map[string]string{constants.PausedAnnotationLabel: "true"}, expected: true},
+		{name: "annotation on vsphere machine", vsphereMachineAnnotations: map[string]string{constants.PausedAnnotationLabel: ""}, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Annotations: tc.machineAnnotations}}
+			vsphereMachine := &infrav1.VSphereMachine{ObjectMeta: metav1.ObjectMeta{Annotations: tc.vsphereMachineAnnotations}}
+			if actual := isPaused(machine, vsphereMachine); actual != tc.expected {
+				t.Fatalf("expected paused %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPausedSet(t *testing.T) {
+	s := newPausedSet()
+
+	if !s.pause("m1") {
+		t.Fatal("expected first pause to be reported")
+	}
+	if s.pause("m1") {
+		t.Fatal("expected repeated pause not to be reported")
+	}
+	if s.resume("m2") {
+		t.Fatal("expected resume of machine that is not paused not to be reported")
+	}
+	if !s.resume("m1") {
+		t.Fatal("expected resume of paused machine to be reported")
+	}
+	if !s.pause("m1") {
+		t.Fatal("expected pause after resume to be reported")
+	}
+}
@@ -110,6 +110,7 @@ function build_images() {
   docker build \
     -f Dockerfile \
     -t "${MANAGER_IMAGE_NAME}":"${VERSION}" \
+    --build-arg "VERSION=${VERSION}" \
     .
   if [ "${LATEST}" ]; then
     echo "tagging image ${MANAGER_IMAGE_NAME}:${VERSION} as latest"
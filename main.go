@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	goctx "context"
 	"flag"
 	"net/http"
 	"net/http/pprof"
@@ -36,6 +37,7 @@ import (
 	"sigs.k8s.io/cluster-api-provider-vsphere/controllers"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/version"
 )
 
 const (
@@ -72,6 +74,7 @@ func main() {
 
 	var metricsAddr string
 	var enableLeaderElection bool
+	var webhookPort int
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
@@ -85,6 +88,32 @@ func main() {
 		"The interval at which cluster-api objects are synchronized")
 	flag.DurationVar(&config.DefaultRequeue, "requeue-period", defaultRequeuePeriod,
 		"The default amount of time to wait before an operation is requeued.")
+	flag.DurationVar(&config.MaxBootstrapDataRequeue, "max-bootstrap-data-requeue-period", config.MaxBootstrapDataRequeue,
+		"The maximum amount of time to wait before requeueing a machine whose bootstrap data is not available yet. Values lower than the requeue period disable the backoff.")
+	flag.DurationVar(&config.DefaultNodeDrainTimeout, "node-drain-timeout", config.DefaultNodeDrainTimeout,
+		"The amount of time to wait for a deleted machine's node to be drained before its VM is destroyed.")
+	flag.DurationVar(&config.DefaultPreDeleteHookTimeout, "pre-delete-hook-timeout", config.DefaultPreDeleteHookTimeout,
+		"The amount of time to wait for a deleted machine's pre-delete hook annotations to be removed before its VM is destroyed.")
+	flag.DurationVar(&config.DefaultGuestToolsTimeout, "guest-tools-timeout", config.DefaultGuestToolsTimeout,
+		"The amount of time to wait for VMware Tools to run in a powered on VM before a warning is emitted.")
+	flag.DurationVar(&config.DefaultControlPlaneHealthTimeout, "control-plane-health-timeout", config.DefaultControlPlaneHealthTimeout,
+		"The amount of time to wait for a control plane machine to become healthy after its node joins the cluster before a warning is emitted.")
+	flag.DurationVar(&config.DefaultNodeReadyTimeout, "node-ready-timeout", config.DefaultNodeReadyTimeout,
+		"The amount of time to wait for a machine's node to join the cluster after its VM is powered on before the machine is reported as timed out. Zero disables the timeout.")
+	flag.BoolVar(&config.FailOnNodeReadyTimeout, "fail-on-node-ready-timeout", false,
+		"Mark machines whose node does not join the cluster within the node ready timeout as failed instead of only reporting them.")
+	flag.DurationVar(&config.DefaultCloneTimeout, "clone-timeout", config.DefaultCloneTimeout,
+		"The amount of time a VM's clone task may run before it is cancelled and retried. Zero disables the timeout.")
+	flag.DurationVar(&config.DefaultPowerOffGracePeriod, "power-off-grace-period", config.DefaultPowerOffGracePeriod,
+		"The amount of time the guest of a deleted or resized machine's VM is given to shut down before the VM is powered off. Zero disables the guest shutdown.")
+	flag.DurationVar(&config.DefaultSessionKeepAlive, "session-keepalive", config.DefaultSessionKeepAlive,
+		"The interval at which cached vSphere sessions are kept alive. Zero disables the keepalive.")
+	flag.IntVar(&config.MaxConcurrentClones, "max-concurrent-clones", config.MaxConcurrentClones,
+		"The maximum number of VMs that are cloned at the same time on each vSphere server. Zero or less disables the limit.")
+	flag.IntVar(&webhookPort, "webhook-port", 0,
+		"The port the validating webhook server binds to. The webhooks are disabled when zero, which is the default.")
+	flag.BoolVar(&config.DryRun, "dry-run", false,
+		"Validate machines and log the VMs that would be created instead of creating or destroying VMs. No connections are made to vSphere.")
 	flag.Parse()
 
 	if *watchNamespace != "" {
@@ -96,6 +125,10 @@ func main() {
 		go runProfiler(*profilerAddress)
 	}
 
+	if config.DryRun {
+		setupLog.Info("WARNING: dry-run mode is enabled, VMs will not be created or destroyed")
+	}
+
 	ctrl.SetLogger(klogr.New())
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
@@ -104,6 +137,7 @@ func main() {
 		LeaderElection:     enableLeaderElection,
 		SyncPeriod:         syncPeriod,
 		Namespace:          *watchNamespace,
+		Port:               webhookPort,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -113,10 +147,21 @@ func main() {
 	// Initialize event recorder.
 	record.InitFromRecorder(mgr.GetEventRecorderFor("vsphere-controller"))
 
+	// Cancel in-flight reconciles when the manager is signalled to stop so
+	// waits for vSphere tasks do not block the shutdown.
+	stopCh := ctrl.SetupSignalHandler()
+	ctx, cancel := goctx.WithCancel(goctx.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
 	if err = (&controllers.VSphereMachineReconciler{
 		Client:   mgr.GetClient(),
 		Log:      ctrl.Log.WithName("controllers").WithName("VSphereMachine"),
 		Recorder: mgr.GetEventRecorderFor("vspheremachine-controller"),
+		Context:  ctx,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "VSphereMachine")
 		os.Exit(1)
@@ -129,10 +174,20 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "VSphereCluster")
 		os.Exit(1)
 	}
+	if webhookPort != 0 {
+		if err = (&infrav1.VSphereMachine{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "VSphereMachine")
+			os.Exit(1)
+		}
+		if err = (&infrav1.VSphereMachineTemplate{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "VSphereMachineTemplate")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
-	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	setupLog.Info("starting manager", "version", version.Version)
+	if err := mgr.Start(stopCh); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
@@ -24,4 +24,70 @@ var (
 	// DefaultRequeue is the default time for how long to wait when
 	// requeueing a CAPI operation.
 	DefaultRequeue = 20 * time.Second
+
+	// MaxBootstrapDataRequeue is the maximum time for how long to wait when
+	// requeueing a machine whose bootstrap data is not available yet. The
+	// wait starts at DefaultRequeue and doubles each time the machine is
+	// requeued. A value lower than DefaultRequeue disables the backoff.
+	MaxBootstrapDataRequeue = 5 * time.Minute
+
+	// DefaultNodeDrainTimeout is the default time for how long to wait for
+	// the pods on a machine's node to be evicted before the machine's VM is
+	// destroyed anyway.
+	DefaultNodeDrainTimeout = 5 * time.Minute
+
+	// DefaultPreDeleteHookTimeout is the default time for how long to wait
+	// for the pre-delete hooks of a deleted machine to be removed before the
+	// machine's VM is destroyed anyway.
+	DefaultPreDeleteHookTimeout = 30 * time.Minute
+
+	// DefaultGuestToolsTimeout is the default time for how long to wait for
+	// VMware Tools to run in a powered on VM before the VM's IP addresses are
+	// waited for anyway.
+	DefaultGuestToolsTimeout = 10 * time.Minute
+
+	// DefaultControlPlaneHealthTimeout is the default time for how long to
+	// wait for a control plane machine to become healthy after its node joins
+	// the cluster before a warning is emitted.
+	DefaultControlPlaneHealthTimeout = 10 * time.Minute
+
+	// DefaultNodeReadyTimeout is the default time for how long to wait for a
+	// machine's node to join the cluster after the machine's VM was powered
+	// on before the machine is reported as timed out. It is distinct from
+	// DefaultControlPlaneHealthTimeout, which starts once the node joined. A
+	// value of zero disables the timeout.
+	DefaultNodeReadyTimeout = 30 * time.Minute
+
+	// FailOnNodeReadyTimeout is a flag that indicates whether or not machines
+	// whose node did not join the cluster within DefaultNodeReadyTimeout are
+	// marked as failed instead of only being reported.
+	FailOnNodeReadyTimeout bool
+
+	// DefaultCloneTimeout is the default time for how long a VM's clone task
+	// may run before it is cancelled and the clone is retried. A value of
+	// zero disables the timeout.
+	DefaultCloneTimeout = 30 * time.Minute
+
+	// DefaultPowerOffGracePeriod is the default time for how long the guest
+	// of a VM that is powered off because its machine is deleted or resized
+	// is given to shut down before the VM is powered off. The guest is only asked to shut down if VMware Tools is
+	// running. A value of zero powers off VMs without a guest shutdown.
+	DefaultPowerOffGracePeriod = 30 * time.Second
+
+	// DefaultSessionKeepAlive is the default interval at which cached vSphere
+	// sessions are used to keep vCenter from expiring them while the
+	// controller is idle. It is below vCenter's default session timeout of
+	// 30 minutes. A value of zero disables the keepalive.
+	DefaultSessionKeepAlive = 5 * time.Minute
+
+	// MaxConcurrentClones is the maximum number of VMs that are cloned or
+	// deployed at the same time on each vSphere server. Machines whose VMs
+	// would exceed the limit are requeued until a clone completes. A value
+	// of zero or less disables the limit.
+	MaxConcurrentClones = 5
+
+	// DryRun is a flag that indicates whether or not VMs are only validated
+	// and logged instead of being created or destroyed. No vSphere sessions
+	// are created in dry-run mode.
+	DryRun bool
 )
@@ -17,6 +17,8 @@ limitations under the License.
 package constants
 
 import (
+	"time"
+
 	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
 )
 
@@ -48,4 +50,66 @@ const (
 	// MaintenanceAnnotationLabel is the annotation used to indicate a machine and/or
 	// cluster are in maintenance mode.
 	MaintenanceAnnotationLabel = "capv." + v1alpha2.GroupName + "/maintenance"
+
+	// DrainStartedAnnotationLabel is the annotation used to record the time at
+	// which the drain of a deleted machine's node was started.
+	DrainStartedAnnotationLabel = "capv." + v1alpha2.GroupName + "/drain-started"
+
+	// PreDeleteHookAnnotationPrefix is the prefix of the annotations that
+	// pause the destruction of a deleted machine's VM until they are removed,
+	// such as "pre-delete.hook.capv.infrastructure.cluster.x-k8s.io/license".
+	PreDeleteHookAnnotationPrefix = "pre-delete.hook.capv." + v1alpha2.GroupName + "/"
+
+	// PreDeleteHookStartedAnnotationLabel is the annotation used to record
+	// the time at which a deleted machine started waiting for its pre-delete
+	// hooks.
+	PreDeleteHookStartedAnnotationLabel = "capv." + v1alpha2.GroupName + "/pre-delete-hook-started"
+
+	// RebootAnnotationLabel is the annotation used to request the reboot of a
+	// machine's VM. A value of "soft" reboots the VM's guest OS with VMware
+	// Tools and a value of "hard" resets the VM. The annotation is removed
+	// once the request is handled.
+	RebootAnnotationLabel = "capv." + v1alpha2.GroupName + "/reboot"
+
+	// LastRebootAnnotationLabel is the annotation used to record the time at
+	// which a machine's VM was last rebooted by request.
+	LastRebootAnnotationLabel = "capv." + v1alpha2.GroupName + "/last-reboot"
+
+	// ResizeAnnotationLabel is the annotation used to record the progress of
+	// a resize that powers off a machine's VM, so the VM is powered back on
+	// once the resize is applied or has failed.
+	ResizeAnnotationLabel = "capv." + v1alpha2.GroupName + "/resize"
+
+	// KeptDisksAnnotationLabel is the annotation used to record the
+	// comma-separated datastore paths of a deleted machine's disks that are
+	// detached from its VM to be kept on delete.
+	KeptDisksAnnotationLabel = "capv." + v1alpha2.GroupName + "/kept-disks"
+
+	// PausedAnnotationLabel is the annotation used to pause the reconciliation
+	// of a machine. A paused machine's VM is not created, updated, or deleted
+	// until the annotation is removed.
+	PausedAnnotationLabel = "capv." + v1alpha2.GroupName + "/paused"
+
+	// ClusterPausedAnnotationLabel is the Cluster API annotation used to pause
+	// the reconciliation of a machine. It is honored the same way as
+	// PausedAnnotationLabel.
+	ClusterPausedAnnotationLabel = "cluster.x-k8s.io/paused"
+)
+
+const (
+	// CloneRetryInitialInterval is the amount of time to wait before the first
+	// retry of a clone operation that failed with a transient vCenter error.
+	CloneRetryInitialInterval = 2 * time.Second
+
+	// CloneRetryFactor is the factor by which the interval between retries of
+	// a clone operation is multiplied after each attempt.
+	CloneRetryFactor = 2.0
+
+	// CloneRetryJitter is the maximum fraction of the retry interval that is
+	// added to each interval to avoid many machines retrying in lockstep.
+	CloneRetryJitter = 0.1
+
+	// CloneRetryMaxAttempts is the maximum number of times a clone operation is
+	// attempted before the transient error is returned to the caller.
+	CloneRetryMaxAttempts = 4
 )
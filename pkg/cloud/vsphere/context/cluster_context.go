@@ -127,6 +127,45 @@ func (c *ClusterContext) Pass() string {
 	return os.Getenv("VSPHERE_PASSWORD")
 }
 
+// UserFor returns the username used to access the provided vSphere endpoint.
+// The username from the endpoint's cloud provider vCenter configuration takes
+// precedence over the default username.
+func (c *ClusterContext) UserFor(server string) string {
+	if vcenter, ok := c.VSphereCluster.Spec.CloudProviderConfiguration.VCenter[server]; ok && vcenter.Username != "" {
+		return vcenter.Username
+	}
+	return c.User()
+}
+
+// PassFor returns the password used to access the provided vSphere endpoint.
+// The password from the endpoint's cloud provider vCenter configuration takes
+// precedence over the default password.
+func (c *ClusterContext) PassFor(server string) string {
+	if vcenter, ok := c.VSphereCluster.Spec.CloudProviderConfiguration.VCenter[server]; ok && vcenter.Password != "" {
+		return vcenter.Password
+	}
+	return c.Pass()
+}
+
+// PortFor returns the port of the provided vSphere endpoint from its cloud
+// provider vCenter configuration, or from the global cloud provider
+// configuration, or an empty string if no port is configured.
+func (c *ClusterContext) PortFor(server string) string {
+	if vcenter, ok := c.VSphereCluster.Spec.CloudProviderConfiguration.VCenter[server]; ok && vcenter.Port != "" {
+		return vcenter.Port
+	}
+	return c.VSphereCluster.Spec.CloudProviderConfiguration.Global.Port
+}
+
+// ThumbprintFor returns the thumbprint of the provided vSphere endpoint's
+// certificate, or an empty string if no thumbprint is configured.
+func (c *ClusterContext) ThumbprintFor(server string) string {
+	if server == c.VSphereCluster.Spec.Server && c.VSphereCluster.Spec.Thumbprint != "" {
+		return c.VSphereCluster.Spec.Thumbprint
+	}
+	return c.VSphereCluster.Spec.CloudProviderConfiguration.VCenter[server].Thumbprint
+}
+
 // CanLogin returns a flag indicating whether the cluster config has
 // enough information to login to the vSphere endpoint.
 func (c *ClusterContext) CanLogin() bool {
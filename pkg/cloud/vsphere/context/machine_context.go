@@ -17,6 +17,7 @@ limitations under the License.
 package context
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -25,6 +26,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
 )
 
 // MachineContextParams are the parameters needed to create a MachineContext.
@@ -60,7 +62,15 @@ func NewMachineContextFromClusterContext(
 		vsphereMachinePatch: client.MergeFrom(vsphereMachine.DeepCopyObject()),
 	}
 
-	if machineCtx.CanLogin() {
+	// Only the cluster's server and its configured vCenters are trusted with
+	// the cluster's credentials.
+	if server := vsphereMachine.Spec.Server; server != "" && server != clusterCtx.VSphereCluster.Spec.Server {
+		if _, ok := clusterCtx.VSphereCluster.Spec.CloudProviderConfiguration.VCenter[server]; !ok {
+			return nil, errors.Errorf("unknown vSphere server %q for machine %q, the server must be configured as a cloud provider vCenter", server, machineCtx)
+		}
+	}
+
+	if machineCtx.CanLogin() && !config.DryRun {
 		session, err := getOrCreateCachedSession(machineCtx)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to create vSphere session for machine %q", machineCtx)
@@ -100,6 +110,59 @@ func (c *MachineContext) String() string {
 	return fmt.Sprintf("%s/%s/%s", c.Cluster.Namespace, c.Cluster.Name, c.Machine.Name)
 }
 
+// Server returns the address of the vSphere endpoint on which the machine's
+// VM is located.
+func (c *MachineContext) Server() string {
+	if server := c.VSphereMachine.Spec.Server; server != "" {
+		return server
+	}
+	return c.VSphereCluster.Spec.Server
+}
+
+// User returns the username used to access the machine's vSphere endpoint.
+func (c *MachineContext) User() string {
+	return c.UserFor(c.Server())
+}
+
+// Pass returns the password used to access the machine's vSphere endpoint.
+func (c *MachineContext) Pass() string {
+	return c.PassFor(c.Server())
+}
+
+// FailureDomain returns the cluster's definition of the machine's failure
+// domain, or nil if the machine has no failure domain or the cluster does
+// not define it.
+func (c *MachineContext) FailureDomain() *v1alpha2.FailureDomain {
+	name := c.VSphereMachine.Spec.FailureDomain
+	if name == "" {
+		return nil
+	}
+	for i := range c.VSphereCluster.Spec.FailureDomains {
+		if fd := &c.VSphereCluster.Spec.FailureDomains[i]; fd.Name == name {
+			return fd
+		}
+	}
+	return nil
+}
+
+// Datacenter returns the datacenter in which the machine's VM is created.
+// The machine's datacenter takes precedence over its failure domain's.
+func (c *MachineContext) Datacenter() string {
+	if c.VSphereMachine.Spec.Datacenter != "" {
+		return c.VSphereMachine.Spec.Datacenter
+	}
+	if fd := c.FailureDomain(); fd != nil {
+		return fd.Datacenter
+	}
+	return ""
+}
+
+// CanLogin returns a flag indicating whether there is enough information to
+// login to the machine's vSphere endpoint.
+func (c *MachineContext) CanLogin() bool {
+	return c.Server() != "" && c.User() != ""
+}
+
 // GetObject returns the Machine object.
 func (c *MachineContext) GetObject() runtime.Object {
 	return c.Machine
@@ -110,16 +173,19 @@ func (c *MachineContext) GetSession() *Session {
 	return c.Session
 }
 
-// Patch updates the object and its status on the API server.
+// Patch updates the object and its status on the API server. The patch is
+// not bound to the context's cancellation so changes made before the context
+// was cancelled, such as the reference of a started clone task, are not lost.
 func (c *MachineContext) Patch() error {
+	ctx := context.Background()
 
 	// Patch Machine object.
-	if err := c.Client.Patch(c, c.VSphereMachine, c.vsphereMachinePatch); err != nil {
+	if err := c.Client.Patch(ctx, c.VSphereMachine, c.vsphereMachinePatch); err != nil {
 		return errors.Wrapf(err, "error patching VSphereMachine %s/%s", c.Machine.Namespace, c.Machine.Name)
 	}
 
 	// Patch Machine status.
-	if err := c.Client.Status().Patch(c, c.VSphereMachine, c.vsphereMachinePatch); err != nil {
+	if err := c.Client.Status().Patch(ctx, c.VSphereMachine, c.vsphereMachinePatch); err != nil {
 		return errors.Wrapf(err, "error patching VSphereMachine %s/%s status", c.Machine.Namespace, c.Machine.Name)
 	}
 
@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/simulator"
+	"k8s.io/klog/klogr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2/cloud"
+)
+
+func TestMachineContext_Server(t *testing.T) {
+	os.Setenv("VSPHERE_USERNAME", "default-user")
+	os.Setenv("VSPHERE_PASSWORD", "default-pass")
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	vsphereCluster := &v1alpha2.VSphereCluster{
+		Spec: v1alpha2.VSphereClusterSpec{
+			Server:     "vc1.local",
+			Thumbprint: "AA:BB",
+			CloudProviderConfiguration: cloud.Config{
+				VCenter: map[string]cloud.VCenterConfig{
+					"vc1.local": {},
+					"vc2.local": {Username: "vc2-user", Password: "vc2-pass", Thumbprint: "CC:DD"},
+				},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name               string
+		server             string
+		expectedServer     string
+		expectedUser       string
+		expectedPass       string
+		expectedThumbprint string
+	}{
+		{
+			name:               "defaults to cluster server",
+			expectedServer:     "vc1.local",
+			expectedUser:       "default-user",
+			expectedPass:       "default-pass",
+			expectedThumbprint: "AA:BB",
+		},
+		{
+			name:               "configured vcenter",
+			server:             "vc2.local",
+			expectedServer:     "vc2.local",
+			expectedUser:       "vc2-user",
+			expectedPass:       "vc2-pass",
+			expectedThumbprint: "CC:DD",
+		},
+		{
+			name:           "unconfigured vcenter",
+			server:         "vc3.local",
+			expectedServer: "vc3.local",
+			expectedUser:   "default-user",
+			expectedPass:   "default-pass",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &MachineContext{
+				ClusterContext: &ClusterContext{VSphereCluster: vsphereCluster},
+				VSphereMachine: &v1alpha2.VSphereMachine{
+					Spec: v1alpha2.VSphereMachineSpec{Server: tc.server},
+				},
+			}
+			if actual := ctx.Server(); actual != tc.expectedServer {
+				t.Errorf("expected server %q, got %q", tc.expectedServer, actual)
+			}
+			if actual := ctx.User(); actual != tc.expectedUser {
+				t.Errorf("expected user %q, got %q", tc.expectedUser, actual)
+			}
+			if actual := ctx.Pass(); actual != tc.expectedPass {
+				t.Errorf("expected pass %q, got %q", tc.expectedPass, actual)
+			}
+			if actual := ctx.ThumbprintFor(ctx.Server()); actual != tc.expectedThumbprint {
+				t.Errorf("expected thumbprint %q, got %q", tc.expectedThumbprint, actual)
+			}
+		})
+	}
+}
+
+func TestNewMachineContextFromClusterContext_UnknownServer(t *testing.T) {
+	clusterCtx := &ClusterContext{
+		Cluster: &clusterv1.Cluster{},
+		VSphereCluster: &v1alpha2.VSphereCluster{
+			Spec: v1alpha2.VSphereClusterSpec{Server: "vc1.local"},
+		},
+		Logger: klogr.New(),
+	}
+	_, err := NewMachineContextFromClusterContext(
+		clusterCtx,
+		&clusterv1.Machine{},
+		&v1alpha2.VSphereMachine{
+			Spec: v1alpha2.VSphereMachineSpec{Server: "unknown.local"},
+		})
+	if err == nil {
+		t.Fatal("expected error for unknown server, got nil")
+	}
+}
+
+func TestNewMachineContextFromClusterContext_Datacenter(t *testing.T) {
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	testCases := []struct {
+		name          string
+		datacenter    string
+		expectedError bool
+	}{
+		{name: "default datacenter"},
+		{name: "datacenter", datacenter: "DC0"},
+		{name: "missing datacenter", datacenter: "missing-dc", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterCtx, err := NewClusterContext(&ClusterContextParams{
+				Cluster: &clusterv1.Cluster{},
+				VSphereCluster: &v1alpha2.VSphereCluster{
+					Spec: v1alpha2.VSphereClusterSpec{Server: s.URL.Host},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessions := GetSessionCacheStats().Sessions
+
+			ctx, err := NewMachineContextFromClusterContext(
+				clusterCtx,
+				&clusterv1.Machine{},
+				&v1alpha2.VSphereMachine{
+					Spec: v1alpha2.VSphereMachineSpec{Datacenter: tc.datacenter},
+				})
+			if !tc.expectedError {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if ctx.Session == nil {
+					t.Fatal("expected a session")
+				}
+				return
+			}
+			if _, ok := errors.Cause(err).(*find.NotFoundError); !ok {
+				t.Fatalf("expected not found error, got %T: %v", err, err)
+			}
+			if actual := GetSessionCacheStats().Sessions; actual != sessions {
+				t.Fatalf("expected %d cached sessions, got %d", sessions, actual)
+			}
+		})
+	}
+}
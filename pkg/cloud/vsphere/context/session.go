@@ -18,60 +18,113 @@ package context
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/soap"
 
 	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
 )
 
 var sessionCache = map[string]Session{}
 var sessionMU sync.Mutex
 
+// sessionCacheHits and sessionCacheMisses count the sessions reused from and
+// added to the session cache. They are guarded by sessionMU.
+var sessionCacheHits, sessionCacheMisses uint64
+
+// SessionCacheStats describes the use of the session cache.
+type SessionCacheStats struct {
+	// Sessions is the number of cached sessions.
+	Sessions int
+
+	// Hits is the number of times a cached session was reused.
+	Hits uint64
+
+	// Misses is the number of times a session was created because no
+	// usable session was cached.
+	Misses uint64
+}
+
+// GetSessionCacheStats returns the current use of the session cache.
+func GetSessionCacheStats() SessionCacheStats {
+	sessionMU.Lock()
+	defer sessionMU.Unlock()
+	return SessionCacheStats{
+		Sessions: len(sessionCache),
+		Hits:     sessionCacheHits,
+		Misses:   sessionCacheMisses,
+	}
+}
+
 // Session is a vSphere session with a configured Finder.
 type Session struct {
 	*govmomi.Client
 	Finder     *find.Finder
 	datacenter *object.Datacenter
+
+	// credentials is a digest of the credentials used to create the session.
+	credentials string
+
+	// stopKeepAlive is closed to stop keeping the session alive.
+	stopKeepAlive chan struct{}
 }
 
 func getOrCreateCachedSession(ctx *MachineContext) (*Session, error) {
-	sessionMU.Lock()
-	defer sessionMU.Unlock()
-
-	server := ctx.VSphereCluster.Spec.Server
-	datacenter := ctx.VSphereMachine.Spec.Datacenter
+	server := ctx.Server()
+	datacenter := ctx.Datacenter()
 	sessionKey := server + ctx.User() + datacenter
+	credentials := credentialsDigest(ctx.User(), ctx.Pass())
 
-	if session, ok := sessionCache[sessionKey]; ok {
-		if ok, _ := session.SessionManager.SessionIsActive(ctx); ok {
-			return &session, nil
+	// sessionMU is not held while talking to vCenter, so a slow or
+	// unreachable server does not block the reconciles of other servers.
+	sessionMU.Lock()
+	cached, ok := sessionCache[sessionKey]
+	sessionMU.Unlock()
+	if ok {
+		// A session is only reused if it was created with the current
+		// credentials and vCenter still considers it to be authenticated.
+		if cached.credentials == credentials && isSessionValid(ctx, cached) {
+			sessionMU.Lock()
+			sessionCacheHits++
+			sessionMU.Unlock()
+			return &cached, nil
 		}
+		evictSession(ctx, sessionKey, cached)
 	}
 
-	soapURL, err := soap.ParseURL(server)
+	soapURL, err := getSDKURL(ctx, server)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error parsing vSphere URL %q", server)
-	}
-	if soapURL == nil {
-		return nil, errors.Errorf("error parsing vSphere URL %q", server)
+		return nil, err
 	}
 
-	soapURL.User = url.UserPassword(ctx.User(), ctx.Pass())
+	thumbprint := ctx.ThumbprintFor(server)
+	if thumbprint == "" {
+		ctx.Logger.Info("WARNING: no thumbprint configured, the vSphere server's certificate will not be verified", "server", server)
+	}
 
-	// Temporarily setting the insecure flag True
-	// TODO(ssurana): handle the certs better
-	client, err := govmomi.NewClient(ctx, soapURL, true)
+	client, err := newClient(ctx, soapURL, url.UserPassword(ctx.User(), ctx.Pass()), thumbprint)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error setting up new vSphere SOAP client")
 	}
 
-	session := Session{Client: client}
+	session := Session{Client: client, credentials: credentials}
 
 	session.UserAgent = v1alpha2.GroupVersion.String()
 
@@ -81,18 +134,194 @@ func getOrCreateCachedSession(ctx *MachineContext) (*Session, error) {
 	// Assign the datacenter if one was specified.
 	dc, err := session.Finder.DatacenterOrDefault(ctx, datacenter)
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to find datacenter %q", datacenter)
+		// The session is not cached, so log out of it rather than leaving a
+		// session behind on every retry.
+		logoutSession(ctx, session)
+		if datacenter == "" {
+			return nil, errors.Wrapf(err, "unable to find default datacenter on %q", server)
+		}
+		return nil, errors.Wrapf(err, "unable to find datacenter %q on %q", datacenter, server)
 	}
 	session.datacenter = dc
 	session.Finder.SetDatacenter(dc)
 
-	// Cache the session.
+	sessionMU.Lock()
+	sessionCacheMisses++
+	// Another reconcile may have cached a session for the server while this
+	// one was created, in which case that session is used instead.
+	cached, ok = sessionCache[sessionKey]
+	if ok && cached.credentials == credentials {
+		sessionMU.Unlock()
+		logoutSession(ctx, session)
+		return &cached, nil
+	}
+	// Keep the session alive until it is evicted from the cache.
+	if config.DefaultSessionKeepAlive > 0 {
+		session.stopKeepAlive = make(chan struct{})
+		go keepAlive(ctx.Logger, client, config.DefaultSessionKeepAlive, session.stopKeepAlive)
+	}
 	sessionCache[sessionKey] = session
+	sessionMU.Unlock()
+	if ok {
+		logoutSession(ctx, cached)
+	}
 	ctx.Logger.V(2).Info("cached vSphere client session", "server", server, "datacenter", datacenter)
 
 	return &session, nil
 }
 
+// isSessionValid returns true if vCenter still considers the session to be
+// authenticated. UserSession is used as, unlike SessionIsActive, it does not
+// require any privileges beyond a valid login.
+func isSessionValid(ctx context.Context, session Session) bool {
+	userSession, err := session.SessionManager.UserSession(ctx)
+	return err == nil && userSession != nil
+}
+
+// evictSession removes the session from the cache and logs out of it,
+// unless it was already replaced by another reconcile.
+func evictSession(ctx *MachineContext, sessionKey string, session Session) {
+	sessionMU.Lock()
+	cached, ok := sessionCache[sessionKey]
+	evicted := ok && cached.Client == session.Client
+	if evicted {
+		delete(sessionCache, sessionKey)
+	}
+	sessionMU.Unlock()
+	if evicted {
+		logoutSession(ctx, session)
+		ctx.Logger.V(2).Info("evicted vSphere client session", "server", ctx.Server(), "datacenter", ctx.Datacenter())
+	}
+}
+
+// getSDKURL returns the URL of the provided server's SDK endpoint. The server
+// is a host, optionally with a port, or a URL whose path is the SDK endpoint,
+// ex. https://proxy.local:8443/vcenter/sdk for a vCenter behind a reverse
+// proxy. The path defaults to /sdk, and a server without a port uses the
+// port from the cloud provider configuration, if any.
+func getSDKURL(ctx *MachineContext, server string) (*url.URL, error) {
+	soapURL, err := soap.ParseURL(server)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing vSphere URL %q", server)
+	}
+	if soapURL == nil || soapURL.Hostname() == "" {
+		return nil, errors.Errorf("error parsing vSphere URL %q: no host", server)
+	}
+	if soapURL.Scheme != "https" && soapURL.Scheme != "http" {
+		return nil, errors.Errorf("error parsing vSphere URL %q: unsupported scheme %q", server, soapURL.Scheme)
+	}
+	if port := ctx.PortFor(server); soapURL.Port() == "" && port != "" {
+		soapURL.Host = net.JoinHostPort(soapURL.Hostname(), port)
+	}
+	return soapURL, nil
+}
+
+// newClient returns a new, authenticated vSphere client. If a thumbprint is
+// provided the server's certificate must match it, otherwise the server's
+// certificate is not verified.
+func newClient(ctx context.Context, soapURL *url.URL, user *url.Userinfo, thumbprint string) (*govmomi.Client, error) {
+	// Temporarily setting the insecure flag True
+	// TODO(ssurana): handle the certs better
+	soapClient := soap.NewClient(soapURL, true)
+	if thumbprint != "" {
+		// The insecure flag skips verification of the certificate chain, so
+		// pin the server's certificate to the expected thumbprint instead.
+		transport := soapClient.Client.Transport.(*http.Transport)
+		transport.TLSClientConfig.VerifyPeerCertificate = verifyThumbprint(soapURL.Host, thumbprint)
+	}
+
+	// Creating the client queries the endpoint's service content, which
+	// includes its version, so an endpoint that is not a vSphere SDK fails
+	// here rather than at login.
+	vimClient, err := vim25.NewClient(ctx, soapClient)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to query vSphere SDK endpoint %s://%s%s", soapURL.Scheme, soapURL.Host, soapURL.Path)
+	}
+
+	client := &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: session.NewManager(vimClient),
+	}
+	if err := client.Login(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// verifyThumbprint returns a function that verifies the SHA-1 thumbprint of
+// a server's leaf certificate matches the expected thumbprint.
+func verifyThumbprint(host, thumbprint string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.Errorf("security error: vSphere server %q did not present a certificate", host)
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return errors.Wrapf(err, "security error: unable to parse certificate of vSphere server %q", host)
+		}
+		if actual := soap.ThumbprintSHA1(cert); !strings.EqualFold(actual, thumbprint) {
+			return errors.Errorf("security error: vSphere server %q has certificate thumbprint %q, expected %q", host, actual, thumbprint)
+		}
+		return nil
+	}
+}
+
+// NewRestClient returns a new vAPI client that is logged in with the provided
+// credentials. The caller is responsible for logging out of the client.
+func (s *Session) NewRestClient(ctx context.Context, user *url.Userinfo) (*rest.Client, error) {
+	if s.Client == nil {
+		return nil, errors.New("vSphere client is not initialized")
+	}
+	restClient := rest.NewClient(s.Client.Client)
+	// The vAPI endpoint of a vCenter behind a reverse proxy shares the path
+	// prefix of its SDK endpoint.
+	if sdkPath := s.Client.URL().Path; strings.HasSuffix(sdkPath, "/sdk") && sdkPath != "/sdk" {
+		restClient = &rest.Client{Client: s.Client.Client.NewServiceClient(strings.TrimSuffix(sdkPath, "/sdk")+"/rest", "")}
+	}
+	if err := restClient.Login(ctx, user); err != nil {
+		return nil, errors.Wrap(err, "unable to create vAPI session")
+	}
+	return restClient, nil
+}
+
+// keepAlive asks the client's session manager for the client's session every
+// interval until stop is closed, so vCenter does not expire the session while
+// the controller is idle.
+func keepAlive(logger logr.Logger, client *govmomi.Client, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if _, err := client.SessionManager.UserSession(ctx); err != nil {
+				logger.V(4).Info("unable to keep vSphere client session alive", "reason", err.Error())
+			}
+			cancel()
+		}
+	}
+}
+
+// logoutSession makes a best-effort attempt to log out of a session that is
+// no longer needed so it does not count against vCenter's session limit. The
+// session is no longer kept alive.
+func logoutSession(ctx context.Context, session Session) {
+	if session.stopKeepAlive != nil {
+		close(session.stopKeepAlive)
+	}
+	if session.Client != nil {
+		_ = session.Logout(ctx)
+	}
+}
+
+func credentialsDigest(user, pass string) string {
+	digest := sha256.Sum256([]byte(user + ":" + pass))
+	return hex.EncodeToString(digest[:])
+}
+
 // FindByInstanceUUID finds an object by its instance UUID.
 func (s *Session) FindByInstanceUUID(ctx context.Context, uuid string) (object.Reference, error) {
 	if s.Client == nil {
@@ -120,3 +349,17 @@ func (s *Session) FindByUUID(ctx context.Context, uuid string) (object.Reference
 	}
 	return ref, nil
 }
+
+// DeleteDatastoreFile starts deleting a file, given by its datastore path,
+// from a datastore in the session's datacenter. The task that deletes the
+// file is returned without waiting for it.
+func (s *Session) DeleteDatastoreFile(ctx context.Context, name string) (*object.Task, error) {
+	if s.Client == nil {
+		return nil, errors.New("vSphere client is not initialized")
+	}
+	task, err := object.NewFileManager(s.Client.Client).DeleteDatastoreFile(ctx, name, s.datacenter)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error deleting datastore file %q", name)
+	}
+	return task, nil
+}
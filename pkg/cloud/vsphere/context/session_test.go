@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/klog/klogr"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2/cloud"
+)
+
+func Test_newClient_Thumbprint(t *testing.T) {
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	testCases := []struct {
+		name        string
+		thumbprint  string
+		expectedErr bool
+	}{
+		{
+			name: "no thumbprint",
+		},
+		{
+			name:       "matching thumbprint",
+			thumbprint: soap.ThumbprintSHA1(s.Certificate()),
+		},
+		{
+			name:       "matching lower-case thumbprint",
+			thumbprint: strings.ToLower(soap.ThumbprintSHA1(s.Certificate())),
+		},
+		{
+			name:        "mismatched thumbprint",
+			thumbprint:  "00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF:00:11:22:33",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := newClient(context.Background(), s.URL, s.URL.User, tc.thumbprint)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if !strings.Contains(err.Error(), "security error") {
+					t.Fatalf("expected security error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_ = client.Logout(context.Background())
+		})
+	}
+}
+
+func Test_keepAlive(t *testing.T) {
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	client, err := newClient(context.Background(), s.URL, s.URL.User, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = client.Logout(context.Background()) }()
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		keepAlive(klogr.New(), client, 10*time.Millisecond, stop)
+		close(done)
+	}()
+
+	// Let the session be kept alive a few times before stopping.
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected keepalive to stop")
+	}
+
+	if userSession, err := client.SessionManager.UserSession(context.Background()); err != nil || userSession == nil {
+		t.Fatalf("expected session to be active, got %v", err)
+	}
+}
+
+func Test_getSDKURL(t *testing.T) {
+	testCases := []struct {
+		name        string
+		server      string
+		port        string
+		expectedURL string
+		expectedErr bool
+	}{
+		{name: "host", server: "vc.local", expectedURL: "https://vc.local/sdk"},
+		{name: "host and port", server: "vc.local:8443", expectedURL: "https://vc.local:8443/sdk"},
+		{name: "configured port", server: "vc.local", port: "8443", expectedURL: "https://vc.local:8443/sdk"},
+		{name: "port overrides configured port", server: "vc.local:9443", port: "8443", expectedURL: "https://vc.local:9443/sdk"},
+		{name: "url with path", server: "https://proxy.local:8443/vcenter/sdk", expectedURL: "https://proxy.local:8443/vcenter/sdk"},
+		{name: "unsupported scheme", server: "ftp://vc.local", expectedErr: true},
+		{name: "no host", server: "https:///sdk", expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &MachineContext{
+				ClusterContext: &ClusterContext{
+					VSphereCluster: &v1alpha2.VSphereCluster{
+						Spec: v1alpha2.VSphereClusterSpec{
+							Server: tc.server,
+							CloudProviderConfiguration: cloud.Config{
+								Global: cloud.GlobalConfig{Port: tc.port},
+							},
+						},
+					},
+				},
+			}
+			u, err := getSDKURL(ctx, tc.server)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", u)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			u.User = nil
+			if actual := u.String(); actual != tc.expectedURL {
+				t.Fatalf("expected URL %q, got %q", tc.expectedURL, actual)
+			}
+		})
+	}
+}
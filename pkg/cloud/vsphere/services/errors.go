@@ -0,0 +1,201 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+)
+
+// RequeueAfterError is returned by a service when an operation cannot be
+// performed yet and should be retried after RequeueAfter, rather than being
+// treated as a failure.
+type RequeueAfterError struct {
+	RequeueAfter time.Duration
+	Reason       string
+}
+
+// Error implements the error interface.
+func (e *RequeueAfterError) Error() string {
+	return fmt.Sprintf("%s, requeue after %s", e.Reason, e.RequeueAfter)
+}
+
+// ErrorKind classifies the errors returned by services, so callers can
+// decide how to handle an error without inspecting the vSphere fault that
+// caused it.
+type ErrorKind string
+
+const (
+	// UnknownErrorKind is the kind of errors that are not classified.
+	UnknownErrorKind ErrorKind = ""
+
+	// NotFoundErrorKind is the kind of errors caused by a missing vSphere
+	// object.
+	NotFoundErrorKind ErrorKind = "NotFound"
+
+	// PermissionDeniedErrorKind is the kind of errors caused by the vSphere
+	// user lacking a privilege.
+	PermissionDeniedErrorKind ErrorKind = "PermissionDenied"
+
+	// TransientErrorKind is the kind of errors caused by a vSphere fault that
+	// is expected to clear on its own, such as an object being busy or a
+	// host being briefly unreachable.
+	TransientErrorKind ErrorKind = "Transient"
+
+	// InvalidSpecErrorKind is the kind of errors caused by a machine spec
+	// that cannot be reconciled as specified.
+	InvalidSpecErrorKind ErrorKind = "InvalidSpec"
+)
+
+// KindError is an error of a known kind. Its message is the message of the
+// error it wraps.
+type KindError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *KindError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the wrapped error, so errors.Cause returns the error that
+// caused a *KindError.
+func (e *KindError) Cause() error {
+	return e.Err
+}
+
+// WithKind returns the provided error as an error of the provided kind, or
+// nil if the error is nil.
+func WithKind(err error, kind ErrorKind) error {
+	if err == nil {
+		return nil
+	}
+	return &KindError{Kind: kind, Err: err}
+}
+
+// GetErrorKind returns the kind of the provided error. The errors wrapped
+// with github.com/pkg/errors are inspected from the outermost to the
+// innermost, and the kind is that of the first *KindError, vSphere fault,
+// govmomi not found error, or *capierrors.MachineError that is found. A
+// *KindError of UnknownErrorKind hides the kind of the errors it wraps.
+func GetErrorKind(err error) ErrorKind {
+	for err != nil {
+		if kindErr, ok := err.(*KindError); ok {
+			return kindErr.Kind
+		}
+		if kind := getErrorKind(err); kind != UnknownErrorKind {
+			return kind
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return UnknownErrorKind
+}
+
+// IsNotFoundError returns true if the provided error was caused by a missing
+// vSphere object.
+func IsNotFoundError(err error) bool {
+	return GetErrorKind(err) == NotFoundErrorKind
+}
+
+// IsPermissionDeniedError returns true if the provided error was caused by
+// the vSphere user lacking a privilege.
+func IsPermissionDeniedError(err error) bool {
+	return GetErrorKind(err) == PermissionDeniedErrorKind
+}
+
+// IsTransientError returns true if the provided error was caused by a
+// vSphere fault that is expected to clear on its own.
+func IsTransientError(err error) bool {
+	return GetErrorKind(err) == TransientErrorKind
+}
+
+// IsInvalidSpecError returns true if the provided error was caused by a
+// machine spec that cannot be reconciled as specified.
+func IsInvalidSpecError(err error) bool {
+	return GetErrorKind(err) == InvalidSpecErrorKind
+}
+
+// getErrorKind returns the kind of the provided error, without inspecting
+// the errors it wraps.
+func getErrorKind(err error) ErrorKind {
+	switch err := err.(type) {
+	case *KindError:
+		return err.Kind
+	case *capierrors.MachineError:
+		if err.Reason == capierrors.InvalidConfigurationMachineError {
+			return InvalidSpecErrorKind
+		}
+		return UnknownErrorKind
+	case *find.NotFoundError, *find.DefaultNotFoundError:
+		return NotFoundErrorKind
+	}
+	return getFaultKind(fault(err))
+}
+
+// getFaultKind returns the kind of errors caused by the provided vSphere
+// fault.
+func getFaultKind(f interface{}) ErrorKind {
+	switch f.(type) {
+	case types.ManagedObjectNotFound, *types.ManagedObjectNotFound,
+		types.NotFound, *types.NotFound:
+		return NotFoundErrorKind
+	case types.NoPermission, *types.NoPermission:
+		return PermissionDeniedErrorKind
+	case types.InvalidState, *types.InvalidState,
+		types.TaskInProgress, *types.TaskInProgress,
+		types.ConcurrentAccess, *types.ConcurrentAccess,
+		types.HostCommunication, *types.HostCommunication,
+		types.HostNotConnected, *types.HostNotConnected,
+		types.HostNotReachable, *types.HostNotReachable:
+		return TransientErrorKind
+	}
+	return UnknownErrorKind
+}
+
+// Fault returns the vSphere fault that caused the provided error, or nil if
+// the error was not caused by a vSphere fault.
+func Fault(err error) interface{} {
+	return fault(errors.Cause(err))
+}
+
+// fault is like Fault, but without inspecting the errors the provided error
+// wraps.
+func fault(err error) interface{} {
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case interface{ Fault() types.BaseMethodFault }:
+		// Handles errors returned by tasks as well as wrapped vim faults.
+		return err.Fault()
+	default:
+		if soap.IsSoapFault(err) {
+			return soap.ToSoapFault(err).VimFault()
+		}
+	}
+	return nil
+}
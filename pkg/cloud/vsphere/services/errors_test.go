@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+)
+
+func TestGetErrorKind(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected ErrorKind
+	}{
+		{
+			name:     "nil",
+			expected: UnknownErrorKind,
+		},
+		{
+			name:     "plain error",
+			err:      errors.New("invalid template"),
+			expected: UnknownErrorKind,
+		},
+		{
+			name:     "find not found",
+			err:      errors.Wrap(&find.NotFoundError{}, "unable to find template"),
+			expected: NotFoundErrorKind,
+		},
+		{
+			name:     "managed object not found",
+			err:      errors.Wrap(soap.WrapVimFault(&types.ManagedObjectNotFound{}), "unable to get vm"),
+			expected: NotFoundErrorKind,
+		},
+		{
+			name:     "no permission",
+			err:      soap.WrapVimFault(&types.NoPermission{}),
+			expected: PermissionDeniedErrorKind,
+		},
+		{
+			name:     "task in progress",
+			err:      errors.Wrap(soap.WrapVimFault(&types.TaskInProgress{}), "clone failed"),
+			expected: TransientErrorKind,
+		},
+		{
+			name:     "invalid configuration",
+			err:      errors.Wrap(capierrors.InvalidMachineConfiguration("invalid spec"), "unable to create vm"),
+			expected: InvalidSpecErrorKind,
+		},
+		{
+			name:     "other machine error",
+			err:      capierrors.CreateMachine("unable to create vm"),
+			expected: UnknownErrorKind,
+		},
+		{
+			name:     "outermost kind",
+			err:      errors.Wrap(WithKind(soap.WrapVimFault(&types.TaskInProgress{}), PermissionDeniedErrorKind), "clone failed"),
+			expected: PermissionDeniedErrorKind,
+		},
+		{
+			name:     "unknown kind",
+			err:      errors.Wrap(WithKind(soap.WrapVimFault(&types.TaskInProgress{}), UnknownErrorKind), "clone failed"),
+			expected: UnknownErrorKind,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := GetErrorKind(tc.err); actual != tc.expected {
+				t.Fatalf("expected error kind %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestKindErrorCause(t *testing.T) {
+	machineErr := capierrors.CreateMachine("permission denied")
+	err := errors.Wrap(WithKind(machineErr, PermissionDeniedErrorKind), "unable to create vm")
+
+	if !IsPermissionDeniedError(err) {
+		t.Fatalf("expected permission denied error, got %q", GetErrorKind(err))
+	}
+	if cause := errors.Cause(err); cause != machineErr {
+		t.Fatalf("expected cause to be the machine error, got %v", cause)
+	}
+	if actual := err.Error(); actual != "unable to create vm: permission denied" {
+		t.Fatalf("unexpected error message %q", actual)
+	}
+}
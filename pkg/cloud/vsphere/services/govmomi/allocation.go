@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/vcenter"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// reconcileResourceAllocation applies the machine's CPU and memory
+// reservations, limits, and shares to the VM when they differ from the VM's.
+// vSphere applies them while the VM runs, so the VM is not powered off. The
+// reconfigure op is recorded in the machine's task reference and false is
+// returned while it is started. A *capierrors.MachineError is returned if the
+// memory reservation exceeds the VM's memory.
+func (vms *VMService) reconcileResourceAllocation(ctx *context.MachineContext) (bool, error) {
+	cpu, memory := vcenter.GetResourceAllocation(ctx.VSphereMachine.Spec)
+	if cpu == nil && memory == nil {
+		return true, nil
+	}
+
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, *getMoRef(ctx), []string{"config"}, &obj); err != nil {
+		return false, errors.Wrapf(err, "unable to get resource allocation of vm %q", ctx)
+	}
+	if obj.Config == nil {
+		return true, nil
+	}
+	if reservation := ctx.VSphereMachine.Spec.MemoryReservationMiB; reservation > int64(obj.Config.Hardware.MemoryMB) {
+		return false, capierrors.InvalidMachineConfiguration("invalid memory reservation for vm %q: reservation of %dMiB exceeds the vm's %dMiB of memory",
+			ctx, reservation, obj.Config.Hardware.MemoryMB)
+	}
+
+	cpu = getResourceAllocationChange(obj.Config.CpuAllocation, cpu)
+	memory = getResourceAllocationChange(obj.Config.MemoryAllocation, memory)
+	if cpu == nil && memory == nil {
+		return true, nil
+	}
+
+	vm, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		return false, err
+	}
+	ctx.Logger.V(4).Info("updating resource allocation", "cpu-allocation", cpu, "memory-allocation", memory)
+	task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+		CpuAllocation:    cpu,
+		MemoryAllocation: memory,
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to trigger resource allocation op for vm %q", ctx)
+	}
+	record.Eventf(ctx.VSphereMachine, "ResourceAllocationUpdating", "updating cpu and memory reservations, limits, and shares of vm %q", ctx.VSphereMachine.Name)
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for resource allocation op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return false, nil
+}
+
+// getResourceAllocationChange returns the fields of the desired allocation
+// that differ from the current allocation, or nil if none differ.
+func getResourceAllocationChange(current *types.ResourceAllocationInfo, desired *types.ResourceAllocationInfo) *types.ResourceAllocationInfo {
+	if desired == nil {
+		return nil
+	}
+	if current == nil {
+		return desired
+	}
+
+	var (
+		change  types.ResourceAllocationInfo
+		changed bool
+	)
+	if desired.Reservation != nil && (current.Reservation == nil || *current.Reservation != *desired.Reservation) {
+		change.Reservation, changed = desired.Reservation, true
+	}
+	if desired.Limit != nil && (current.Limit == nil || *current.Limit != *desired.Limit) {
+		change.Limit, changed = desired.Limit, true
+	}
+	if desired.Shares != nil && (current.Shares == nil || current.Shares.Level != desired.Shares.Level) {
+		change.Shares, changed = desired.Shares, true
+	}
+	if !changed {
+		return nil
+	}
+	return &change
+}
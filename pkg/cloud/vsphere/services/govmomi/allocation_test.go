@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/vcenter"
+)
+
+func TestGetResourceAllocationChange(t *testing.T) {
+	int64Ptr := func(i int64) *int64 { return &i }
+
+	current := &types.ResourceAllocationInfo{
+		Reservation: int64Ptr(0),
+		Limit:       int64Ptr(-1),
+		Shares:      &types.SharesInfo{Level: types.SharesLevelNormal, Shares: 1000},
+	}
+
+	testCases := []struct {
+		name     string
+		current  *types.ResourceAllocationInfo
+		spec     infrav1.VSphereMachineSpec
+		expected *types.ResourceAllocationInfo
+	}{
+		{
+			name:    "unset",
+			current: current,
+		},
+		{
+			name:     "no current allocation",
+			spec:     infrav1.VSphereMachineSpec{CPUReservationMHz: 1000},
+			expected: &types.ResourceAllocationInfo{Reservation: int64Ptr(1000)},
+		},
+		{
+			name:    "unchanged",
+			current: current,
+			spec:    infrav1.VSphereMachineSpec{CPULimitMHz: -1, Shares: infrav1.NormalSharesLevel},
+		},
+		{
+			name:     "reservation",
+			current:  current,
+			spec:     infrav1.VSphereMachineSpec{CPUReservationMHz: 1000, CPULimitMHz: -1},
+			expected: &types.ResourceAllocationInfo{Reservation: int64Ptr(1000)},
+		},
+		{
+			name:     "limit",
+			current:  current,
+			spec:     infrav1.VSphereMachineSpec{CPULimitMHz: 2000},
+			expected: &types.ResourceAllocationInfo{Limit: int64Ptr(2000)},
+		},
+		{
+			name:     "shares",
+			current:  current,
+			spec:     infrav1.VSphereMachineSpec{Shares: infrav1.HighSharesLevel},
+			expected: &types.ResourceAllocationInfo{Shares: &types.SharesInfo{Level: types.SharesLevelHigh}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, _ := vcenter.GetResourceAllocation(tc.spec)
+			actual := getResourceAllocationChange(tc.current, cpu)
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected allocation change %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestReconcileResourceAllocation(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	simVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{
+			MachineRef:        simVM.Reference().Value,
+			CPUReservationMHz: 1000,
+		},
+	})
+
+	// The reconfigure is started by a reconcile and waited for by the next.
+	var vms VMService
+	ok, err := vms.reconcileResourceAllocation(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || machineContext.VSphereMachine.Status.TaskRef == "" {
+		t.Fatal("expected reconfigure task to be recorded")
+	}
+	if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+		t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+	}
+	if reservation := simVM.Config.CpuAllocation.Reservation; reservation == nil || *reservation != 1000 {
+		t.Fatalf("expected cpu reservation of 1000MHz, got %v", reservation)
+	}
+
+	ok, err = vms.reconcileResourceAllocation(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || machineContext.VSphereMachine.Status.TaskRef != "" {
+		t.Fatal("expected updated vm to be reconciled")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// reconcileBootOrder applies the machine's boot order to its VM. The boot
+// order is applied once the VM exists rather than when it is cloned, since
+// it refers to the keys of the VM's disks and network devices, which are
+// only known once the VM is created. A *capierrors.MachineError is returned
+// if the VM has no device of one of the types in the boot order.
+func (vms *VMService) reconcileBootOrder(ctx *context.MachineContext) error {
+	if len(ctx.VSphereMachine.Spec.BootOrder) == 0 {
+		return nil
+	}
+
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, *getMoRef(ctx), []string{"config.hardware.device", "config.bootOptions"}, &obj); err != nil {
+		return errors.Wrapf(err, "unable to get boot options for vm %q", ctx)
+	}
+	bootOrder, err := getBootOrder(ctx, object.VirtualDeviceList(obj.Config.Hardware.Device))
+	if err != nil {
+		return err
+	}
+
+	var options types.VirtualMachineBootOptions
+	if obj.Config.BootOptions != nil {
+		if reflect.DeepEqual(obj.Config.BootOptions.BootOrder, bootOrder) {
+			return nil
+		}
+		options = *obj.Config.BootOptions
+	}
+	options.BootOrder = bootOrder
+
+	vm, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Logger.V(4).Info("setting boot order", "boot-order", ctx.VSphereMachine.Spec.BootOrder)
+	if err := vm.SetBootOptions(ctx, &options); err != nil {
+		return errors.Wrapf(err, "failed to set boot order of vm %q", ctx)
+	}
+	record.Eventf(ctx.VSphereMachine, "BootOrderChanged", "set boot order of vm %q to %v", ctx.VSphereMachine.Name, ctx.VSphereMachine.Spec.BootOrder)
+	return nil
+}
+
+// getBootOrder returns the VM's bootable devices in the machine's boot
+// order.
+func getBootOrder(ctx *context.MachineContext, devices object.VirtualDeviceList) ([]types.BaseVirtualMachineBootOptionsBootableDevice, error) {
+	var bootOrder []types.BaseVirtualMachineBootOptionsBootableDevice
+	for _, deviceType := range ctx.VSphereMachine.Spec.BootOrder {
+		bootable := devices.BootOrder([]string{string(deviceType)})
+		if len(bootable) == 0 {
+			return nil, capierrors.InvalidMachineConfiguration("invalid boot order for %q: vm has no %s device to boot from", ctx, deviceType)
+		}
+		bootOrder = append(bootOrder, bootable...)
+	}
+	return bootOrder, nil
+}
+
+// hasBootDevice returns true if the boot order includes the device type.
+func hasBootDevice(bootOrder []infrav1.BootDeviceType, deviceType infrav1.BootDeviceType) bool {
+	for _, t := range bootOrder {
+		if t == deviceType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/simulator"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+)
+
+func TestReconcileBootOrder(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	testCases := []struct {
+		name          string
+		bootOrder     []infrav1.BootDeviceType
+		expectedTypes []string
+		expectedError bool
+	}{
+		{
+			name: "no boot order",
+		},
+		{
+			name:          "ethernet then disk",
+			bootOrder:     []infrav1.BootDeviceType{infrav1.EthernetBootDevice, infrav1.DiskBootDevice},
+			expectedTypes: []string{"ethernet", "disk"},
+		},
+		{
+			name:          "missing device type",
+			bootOrder:     []infrav1.BootDeviceType{infrav1.FloppyBootDevice},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: infrav1.VSphereMachineSpec{
+					MachineRef: vm.Reference().Value,
+					BootOrder:  tc.bootOrder,
+				},
+			})
+
+			var vms VMService
+			err := vms.reconcileBootOrder(machineContext)
+			if tc.expectedError {
+				if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %T: %v", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tc.expectedTypes) == 0 {
+				return
+			}
+
+			var actualTypes []string
+			for _, device := range vm.Config.BootOptions.BootOrder {
+				switch device.(type) {
+				case *vimtypes.VirtualMachineBootOptionsBootableEthernetDevice:
+					actualTypes = append(actualTypes, "ethernet")
+				case *vimtypes.VirtualMachineBootOptionsBootableDiskDevice:
+					actualTypes = append(actualTypes, "disk")
+				}
+			}
+			if len(actualTypes) < len(tc.expectedTypes) || actualTypes[0] != tc.expectedTypes[0] || actualTypes[len(actualTypes)-1] != tc.expectedTypes[len(tc.expectedTypes)-1] {
+				t.Fatalf("expected boot order %v, got %v", tc.expectedTypes, actualTypes)
+			}
+
+			// Reconciling an applied boot order is a no-op.
+			if err := vms.reconcileBootOrder(machineContext); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"bytes"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/extra"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/iso"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// reconcileBootstrapISO provides the bootstrap data and metadata of a machine
+// with the iso bootstrap transport to its VM. A cloud-init NoCloud ISO is
+// uploaded to the VM's directory and inserted into the VM's first CD-ROM,
+// which is added if the VM has none. The ISO is only attached while the VM
+// has not been powered on, since cloud-init reads it on first boot. The
+// attach op is recorded in the machine's task reference and false is returned
+// while it is started, so the VM is not powered on before the ISO is attached.
+func (vms *VMService) reconcileBootstrapISO(ctx *context.MachineContext, vm infrav1.VirtualMachine) (bool, error) {
+	if ctx.VSphereMachine.Spec.BootstrapTransport != infrav1.ISOBootstrapTransport {
+		return true, nil
+	}
+
+	powerState, err := vms.getPowerState(ctx)
+	if err != nil {
+		return false, err
+	}
+	if powerState != infrav1.VirtualMachinePowerStatePoweredOff {
+		return true, nil
+	}
+
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, *getMoRef(ctx), []string{"config.files.vmPathName", "config.hardware.device"}, &obj); err != nil {
+		return false, errors.Wrapf(err, "unable to get devices for vm %q", ctx)
+	}
+	isoPath, err := getBootstrapISOPath(obj.Config.Files.VmPathName)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to get bootstrap iso path for vm %q", ctx)
+	}
+	devices := object.VirtualDeviceList(obj.Config.Hardware.Device)
+	if hasBootstrapISO(devices, isoPath) {
+		return true, nil
+	}
+
+	data, err := newBootstrapISO(ctx, vm)
+	if err != nil {
+		return false, err
+	}
+	if err := uploadBootstrapISO(ctx, isoPath, data); err != nil {
+		return false, err
+	}
+	ctx.VSphereMachine.Status.BootstrapISO = isoPath.String()
+
+	op := types.VirtualDeviceConfigSpecOperationEdit
+	cdrom, err := devices.FindCdrom("")
+	if err != nil {
+		ide, err := devices.FindIDEController("")
+		if err != nil {
+			return false, errors.Wrapf(err, "unable to add cd-rom for bootstrap iso to vm %q", ctx)
+		}
+		if cdrom, err = devices.CreateCdrom(ide); err != nil {
+			return false, errors.Wrapf(err, "unable to add cd-rom for bootstrap iso to vm %q", ctx)
+		}
+		op = types.VirtualDeviceConfigSpecOperationAdd
+	}
+	cdrom = devices.InsertIso(cdrom, isoPath.String())
+	cdrom.Connectable = &types.VirtualDeviceConnectInfo{
+		AllowGuestControl: true,
+		Connected:         true,
+		StartConnected:    true,
+	}
+
+	vmObj, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		return false, err
+	}
+	ctx.Logger.V(4).Info("attaching bootstrap iso", "path", isoPath.String())
+	reconfigureTask, err := vmObj.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+		DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+			&types.VirtualDeviceConfigSpec{
+				Operation: op,
+				Device:    cdrom,
+			},
+		},
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to trigger bootstrap iso attach op for vm %q", ctx)
+	}
+	record.Eventf(ctx.VSphereMachine, "BootstrapISOAttaching", "attaching bootstrap iso %q to vm %q", isoPath.String(), ctx.VSphereMachine.Name)
+	ctx.VSphereMachine.Status.TaskRef = reconfigureTask.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for bootstrap iso attach op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return false, nil
+}
+
+// newBootstrapISO returns the cloud-init NoCloud ISO with the machine's
+// bootstrap data, metadata, and network configuration.
+func newBootstrapISO(ctx *context.MachineContext, vm infrav1.VirtualMachine) ([]byte, error) {
+	metadata, err := util.GetMachineMetadata(*ctx.VSphereMachine, ctx.VSphereCluster.Spec.HostnameDomain, vm.Network...)
+	if err != nil {
+		return nil, err
+	}
+	networkConfig, err := util.GetMachineNetworkConfig(metadata)
+	if err != nil {
+		return nil, err
+	}
+	var userData []byte
+	if data := ctx.Machine.Spec.Bootstrap.Data; data != nil {
+		userData = extra.Decode([]byte(*data))
+	}
+	data, err := iso.New(bootstrapISOLabel,
+		iso.File{Name: "user-data", Data: userData},
+		iso.File{Name: "meta-data", Data: metadata},
+		iso.File{Name: "network-config", Data: networkConfig},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create bootstrap iso for vm %q", ctx)
+	}
+	return data, nil
+}
+
+// getBootstrapISOPath returns the datastore path of the bootstrap ISO in the
+// directory of the VM with the provided configuration file.
+func getBootstrapISOPath(vmPathName string) (object.DatastorePath, error) {
+	var p object.DatastorePath
+	if !p.FromString(vmPathName) {
+		return p, errors.Errorf("invalid datastore path %q", vmPathName)
+	}
+	p.Path = path.Join(path.Dir(p.Path), bootstrapISOFileName)
+	return p, nil
+}
+
+// hasBootstrapISO returns true if one of the devices is a CD-ROM that has
+// the bootstrap ISO inserted and is connected when the VM is powered on.
+func hasBootstrapISO(devices object.VirtualDeviceList, isoPath object.DatastorePath) bool {
+	for _, device := range devices.SelectByType((*types.VirtualCdrom)(nil)) {
+		cdrom := device.(*types.VirtualCdrom)
+		backing, ok := cdrom.Backing.(*types.VirtualCdromIsoBackingInfo)
+		if ok && backing.FileName == isoPath.String() && cdrom.Connectable != nil && cdrom.Connectable.StartConnected {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadBootstrapISO uploads the bootstrap ISO to the datastore path. An ISO
+// that was partially uploaded before the upload failed is removed.
+func uploadBootstrapISO(ctx *context.MachineContext, isoPath object.DatastorePath, data []byte) error {
+	ds, err := ctx.Session.Finder.Datastore(ctx, isoPath.Datastore)
+	if err != nil {
+		return errors.Wrapf(err, "unable to find datastore %q for bootstrap iso of vm %q", isoPath.Datastore, ctx)
+	}
+	upload := soap.DefaultUpload
+	upload.ContentLength = int64(len(data))
+	if err := ds.Upload(ctx, bytes.NewReader(data), isoPath.Path, &upload); err != nil {
+		// The upload is retried once the partially uploaded ISO is removed.
+		if task, err := ctx.Session.DeleteDatastoreFile(ctx, isoPath.String()); err != nil {
+			ctx.Logger.Error(err, "unable to remove partially uploaded bootstrap iso", "path", isoPath.String())
+		} else {
+			ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+		}
+		return errors.Wrapf(err, "unable to upload bootstrap iso %q for vm %q", isoPath.String(), ctx)
+	}
+	return nil
+}
+
+// deleteBootstrapISO removes the machine's bootstrap ISO, if any, from its
+// datastore once the machine's VM is destroyed. False is returned while the
+// ISO is being deleted. The ISO is forgotten once the task that deletes it
+// completes.
+func deleteBootstrapISO(ctx *context.MachineContext) (bool, error) {
+	isoPath := ctx.VSphereMachine.Status.BootstrapISO
+	if isoPath == "" {
+		return true, nil
+	}
+	task, err := ctx.Session.DeleteDatastoreFile(ctx, isoPath)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to delete bootstrap iso %q of vm %q", isoPath, ctx)
+	}
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for bootstrap iso delete op")
+	return false, nil
+}
+
+// forgetDeletedBootstrapISO forgets the machine's bootstrap ISO once the
+// task that deleted it completes. A file that does not exist, such as one
+// removed along with its VM's directory, is deleted as well. True is returned
+// if the task deleted the file or found it already removed.
+func forgetDeletedBootstrapISO(ctx *context.MachineContext, info types.TaskInfo) bool {
+	if info.DescriptionId != deleteDatastoreFileTaskDescriptionID {
+		return false
+	}
+	if info.State == types.TaskInfoStateError {
+		if info.Error == nil {
+			return false
+		}
+		if _, ok := info.Error.Fault.(*types.FileNotFound); !ok {
+			return false
+		}
+	}
+	if isoPath := ctx.VSphereMachine.Status.BootstrapISO; isoPath != "" {
+		ctx.Logger.V(4).Info("deleted bootstrap iso", "path", isoPath)
+		ctx.VSphereMachine.Status.BootstrapISO = ""
+	}
+	return true
+}
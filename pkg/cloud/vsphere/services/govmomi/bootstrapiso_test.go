@@ -0,0 +1,126 @@
+package govmomi
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+)
+
+func TestReconcileBootstrapISO(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vm.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOff
+	vm.Summary.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOff
+
+	// isoFile returns the local path of the VM's bootstrap ISO, which the
+	// simulator keeps in a local directory for each datastore.
+	isoFile := func() string {
+		p, err := getBootstrapISOPath(vm.Config.Files.VmPathName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range simulator.Map.All("Datastore") {
+			if ds := obj.(*simulator.Datastore); ds.Name == p.Datastore {
+				return path.Join(ds.Info.GetDatastoreInfo().Url, p.Path)
+			}
+		}
+		t.Fatalf("datastore %q not found", p.Datastore)
+		return ""
+	}
+
+	bootstrapData := "#cloud-config\n"
+	machineContext := sim.newMachineContext(t, &clusterv1.Machine{
+		Spec: clusterv1.MachineSpec{
+			Bootstrap: clusterv1.Bootstrap{Data: &bootstrapData},
+		},
+	}, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{
+			MachineRef: vm.Reference().Value,
+			Network: infrav1.NetworkSpec{
+				Devices: []infrav1.NetworkDeviceSpec{{NetworkName: "VM Network", DHCP4: true}},
+			},
+		},
+	})
+	network := []infrav1.NetworkStatus{{NetworkName: "VM Network", MACAddr: "00:50:56:00:00:01"}}
+
+	var vms VMService
+	// The guestinfo transport does not attach an ISO.
+	if ok, err := vms.reconcileBootstrapISO(machineContext, infrav1.VirtualMachine{Network: network}); err != nil || !ok {
+		t.Fatalf("expected no bootstrap iso, got %t, %v", ok, err)
+	}
+	if machineContext.VSphereMachine.Status.BootstrapISO != "" {
+		t.Fatalf("expected no bootstrap iso, got %q", machineContext.VSphereMachine.Status.BootstrapISO)
+	}
+
+	// The attach is started by a reconcile and waited for by the next.
+	machineContext.VSphereMachine.Spec.BootstrapTransport = infrav1.ISOBootstrapTransport
+	ok, err := vms.reconcileBootstrapISO(machineContext, infrav1.VirtualMachine{Network: network})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || machineContext.VSphereMachine.Status.TaskRef == "" {
+		t.Fatal("expected attach task to be recorded")
+	}
+	if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+		t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+	}
+	if ok, err := vms.reconcileBootstrapISO(machineContext, infrav1.VirtualMachine{Network: network}); err != nil || !ok {
+		t.Fatalf("expected attached bootstrap iso to be reconciled, got %t, %v", ok, err)
+	}
+	isoPath := machineContext.VSphereMachine.Status.BootstrapISO
+	if isoPath == "" {
+		t.Fatal("expected bootstrap iso to be recorded")
+	}
+	if _, err := os.Stat(isoFile()); err != nil {
+		t.Fatalf("expected bootstrap iso to be uploaded: %v", err)
+	}
+	p, _ := getBootstrapISOPath(vm.Config.Files.VmPathName)
+	devices := object.VirtualDeviceList(vm.Config.Hardware.Device)
+	if !hasBootstrapISO(devices, p) {
+		t.Fatalf("expected bootstrap iso %q to be attached", isoPath)
+	}
+	if cdroms := devices.SelectByType((*vimtypes.VirtualCdrom)(nil)); len(cdroms) != 1 {
+		t.Fatalf("expected 1 cd-rom, got %d", len(cdroms))
+	}
+
+	// The delete is started by a reconcile and waited for by the next.
+	if ok, err := deleteBootstrapISO(machineContext); err != nil || ok {
+		t.Fatalf("expected delete task to be started, got %t, %v", ok, err)
+	}
+	if machineContext.VSphereMachine.Status.BootstrapISO != isoPath {
+		t.Fatal("expected bootstrap iso to be remembered until its delete completes")
+	}
+	if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+		t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+	}
+	if machineContext.VSphereMachine.Status.BootstrapISO != "" {
+		t.Fatal("expected deleted bootstrap iso to be forgotten")
+	}
+	if _, err := os.Stat(isoFile()); !os.IsNotExist(err) {
+		t.Fatalf("expected bootstrap iso to be deleted, got %v", err)
+	}
+	if ok, err := deleteBootstrapISO(machineContext); err != nil || !ok {
+		t.Fatalf("expected forgotten bootstrap iso to be reconciled, got %t, %v", ok, err)
+	}
+
+	// An ISO that no longer exists is not an error.
+	machineContext.VSphereMachine.Status.BootstrapISO = isoPath
+	if ok, err := deleteBootstrapISO(machineContext); err != nil || ok {
+		t.Fatalf("expected delete task to be started, got %t, %v", ok, err)
+	}
+	if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+		t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+	}
+	if machineContext.VSphereMachine.Status.BootstrapISO != "" {
+		t.Fatal("expected missing bootstrap iso to be forgotten")
+	}
+}
@@ -16,8 +16,111 @@ limitations under the License.
 
 package govmomi
 
+import (
+	"time"
+)
+
 const (
 	morefTypeTask = "Task"
+
+	// cloneTaskDescriptionID identifies the tasks that clone VMs.
+	cloneTaskDescriptionID = "VirtualMachine.clone"
+
+	// reconfigureTaskDescriptionID identifies the tasks that reconfigure
+	// VMs, such as those deployed from a content library.
+	reconfigureTaskDescriptionID = "VirtualMachine.reconfigure"
+
+	// destroyTaskDescriptionID identifies the tasks that destroy VMs.
+	destroyTaskDescriptionID = "VirtualMachine.destroy"
+
+	// hardwareUpgradeTaskDescriptionID identifies the tasks that upgrade the
+	// virtual hardware of VMs.
+	hardwareUpgradeTaskDescriptionID = "VirtualMachine.upgradeVirtualHardware"
+
+	// deleteDatastoreFileTaskDescriptionID identifies the tasks that delete
+	// datastore files.
+	deleteDatastoreFileTaskDescriptionID = "FileManager.deleteDatastoreFile"
+)
+
+const (
+	// updateSnapshotPrefix is the prefix of the names of the snapshots taken
+	// before a VM is updated.
+	updateSnapshotPrefix = "capv-pre-update-"
+
+	// maxUpdateSnapshots is the maximum number of snapshots taken before a VM
+	// is updated that are retained.
+	maxUpdateSnapshots = 3
+
+	// hardwareUpgradeUpdate names the update that upgrades the virtual
+	// hardware of a VM in the names of the snapshots taken before it.
+	hardwareUpgradeUpdate = "hardware-upgrade"
+
+	// resizeUpdate names the update that changes the CPUs and memory of a
+	// VM in the names of the snapshots taken before it.
+	resizeUpdate = "resize"
+)
+
+const (
+	// minNumCPUs is the minimum number of CPUs of a VM, which matches the
+	// minimum VMs are cloned with.
+	minNumCPUs = 2
+
+	// memoryMiBMultiple is the multiple of MiB vSphere requires the memory
+	// of a VM to be.
+	memoryMiBMultiple = 4
+)
+
+const (
+	// resizePoweringOff and resizeReconfiguring are the values of the resize
+	// annotation while a VM is powered off to be resized and while the
+	// powered off VM is resized.
+	resizePoweringOff   = "powering-off"
+	resizeReconfiguring = "reconfiguring"
+
+	// resizeFailedPrefix prefixes the value of the resize annotation after a
+	// resize that powered off a VM failed. The rest of the value describes
+	// the resize, which is not tried again until the machine's CPUs or
+	// memory change.
+	resizeFailedPrefix = "failed: "
+)
+
+const (
+	// cloneQueueRequeue is how long to wait before retrying the clone of a
+	// VM that was queued because too many clones are in flight.
+	cloneQueueRequeue = 10 * time.Second
+
+	// cloneSlotTimeout is how long a clone holds its slot before the slot is
+	// freed anyway, so a clone that is never seen to complete, such as that
+	// of a machine deleted out of band, cannot block other clones forever.
+	cloneSlotTimeout = 30 * time.Minute
+
+	// noDiskSpaceRequeue is how long to wait before retrying the clone of a
+	// VM that failed because its datastore is out of space, which does not
+	// clear until an operator frees space or changes the datastore.
+	noDiskSpaceRequeue = 5 * time.Minute
+)
+
+const (
+	// rebootSoft and rebootHard are the values of the reboot annotation that
+	// request a reboot of the guest OS and a reset of the VM.
+	rebootSoft = "soft"
+	rebootHard = "hard"
+
+	// rebootMinInterval is how long after a requested reboot of a VM further
+	// requests are ignored, so a request that is applied again, such as by a
+	// tool that manages the machine's annotations, cannot keep the VM in a
+	// reboot loop.
+	rebootMinInterval = 10 * time.Minute
+)
+
+const (
+	// bootstrapISOLabel is the label of the ISO with a machine's bootstrap
+	// data, which cloud-init's NoCloud datasource looks for.
+	bootstrapISOLabel = "cidata"
+
+	// bootstrapISOFileName is the name of the ISO with a machine's bootstrap
+	// data in the directory of the machine's VM.
+	bootstrapISOFileName = "cidata.iso"
 )
 
 // nolint
@@ -17,14 +17,165 @@ limitations under the License.
 package govmomi
 
 import (
+	"time"
+
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/esxi"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/vcenter"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
 )
 
+// validateMachine verifies the machine's VM can be created as specified. A
+// *capierrors.MachineError is returned if the machine's spec is invalid or
+// refers to vSphere objects that do not exist.
+func validateMachine(ctx *context.MachineContext) error {
+	if err := validateMachineSpec(ctx); err != nil {
+		return err
+	}
+	if ctx.Session.IsVC() {
+		return vcenter.Validate(ctx)
+	}
+	return nil
+}
+
+// validateMachineSpec verifies the machine's spec without connecting to
+// vSphere. A *capierrors.MachineError is returned if the spec is invalid.
+func validateMachineSpec(ctx *context.MachineContext) error {
+	spec := ctx.VSphereMachine.Spec
+	switch {
+	case spec.Template != "" && spec.ContentLibraryItem != "":
+		return capierrors.InvalidMachineConfiguration("invalid source for %q: template %q and content library item %q are mutually exclusive", ctx, spec.Template, spec.ContentLibraryItem)
+	case spec.Template == "" && spec.ContentLibraryItem == "" && spec.ExistingVMUUID == "":
+		return capierrors.InvalidMachineConfiguration("invalid source for %q: one of template, content library item, or existing vm UUID is required", ctx)
+	case len(spec.OVFProperties) > 0 && spec.ContentLibraryItem == "":
+		return capierrors.InvalidMachineConfiguration("invalid source for %q: ovf properties require a content library item", ctx)
+	case spec.Datastore != "" && spec.DatastoreCluster != "":
+		return capierrors.InvalidMachineConfiguration("invalid storage placement for %q: datastore %q and datastore cluster %q are mutually exclusive", ctx, spec.Datastore, spec.DatastoreCluster)
+	case spec.Datastore != "" && len(spec.Datastores) > 0:
+		return capierrors.InvalidMachineConfiguration("invalid storage placement for %q: datastore %q and datastores are mutually exclusive", ctx, spec.Datastore)
+	case len(spec.Datastores) > 0 && spec.DatastoreCluster != "":
+		return capierrors.InvalidMachineConfiguration("invalid storage placement for %q: datastores and datastore cluster %q are mutually exclusive", ctx, spec.DatastoreCluster)
+	case spec.StoragePolicy != "" && spec.DatastoreCluster != "":
+		return capierrors.InvalidMachineConfiguration("invalid storage placement for %q: storage policy %q and datastore cluster %q are mutually exclusive", ctx, spec.StoragePolicy, spec.DatastoreCluster)
+	case spec.CloneMode == infrav1.LinkedClone && spec.DiskProvisioning != "" && spec.DiskProvisioning != infrav1.ThinDiskProvisioning:
+		return capierrors.InvalidMachineConfiguration("invalid disk provisioning for %q: disk provisioning type %q is not supported by linked clones", ctx, spec.DiskProvisioning)
+	case spec.ResourcePool != "" && spec.ComputeCluster != "":
+		return capierrors.InvalidMachineConfiguration("invalid placement for %q: resource pool %q and compute cluster %q are mutually exclusive", ctx, spec.ResourcePool, spec.ComputeCluster)
+	case spec.Host != "" && len(spec.PCIDevices) > 0:
+		return capierrors.InvalidMachineConfiguration("invalid placement for %q: host %q and pci devices are mutually exclusive as the host is picked by its pci devices", ctx, spec.Host)
+	case spec.MemoryMiB > 0 && spec.MemoryReservationMiB > spec.MemoryMiB:
+		return capierrors.InvalidMachineConfiguration("invalid memory reservation for %q: reservation of %dMiB exceeds the vm's %dMiB of memory", ctx, spec.MemoryReservationMiB, spec.MemoryMiB)
+	case spec.MemoryLimitMiB > 0 && spec.MemoryReservationMiB > spec.MemoryLimitMiB:
+		return capierrors.InvalidMachineConfiguration("invalid memory reservation for %q: reservation of %dMiB exceeds the limit of %dMiB", ctx, spec.MemoryReservationMiB, spec.MemoryLimitMiB)
+	case spec.CPULimitMHz > 0 && spec.CPUReservationMHz > spec.CPULimitMHz:
+		return capierrors.InvalidMachineConfiguration("invalid cpu reservation for %q: reservation of %dMHz exceeds the limit of %dMHz", ctx, spec.CPUReservationMHz, spec.CPULimitMHz)
+	case spec.MemoryReservationMiB > 0 && len(spec.PCIDevices) > 0:
+		return capierrors.InvalidMachineConfiguration("invalid memory reservation for %q: memory reservation and pci devices are mutually exclusive as pci devices reserve all of the vm's memory", ctx)
+	case spec.SecureBoot && spec.Firmware != infrav1.EFIFirmware:
+		return capierrors.InvalidMachineConfiguration("invalid firmware for %q: secure boot requires the %q firmware", ctx, infrav1.EFIFirmware)
+	case spec.BootstrapTransport == infrav1.ISOBootstrapTransport && spec.BootstrapFormat == infrav1.IgnitionBootstrapFormat:
+		return capierrors.InvalidMachineConfiguration("invalid bootstrap transport for %q: the %q transport requires the %q bootstrap format", ctx, spec.BootstrapTransport, infrav1.CloudInitBootstrapFormat)
+	case hasBootDevice(spec.BootOrder, infrav1.EthernetBootDevice) && len(spec.Network.Devices) == 0:
+		return capierrors.InvalidMachineConfiguration("invalid boot order for %q: booting from ethernet requires a network device", ctx)
+	case len(spec.CPUAffinity) > 0 && spec.Host == "":
+		return capierrors.InvalidMachineConfiguration("invalid cpu affinity for %q: cpu affinity requires a host", ctx)
+	case spec.FailureDomain != "" && ctx.FailureDomain() == nil:
+		return capierrors.InvalidMachineConfiguration("invalid failure domain for %q: failure domain %q is not defined by cluster %q", ctx, spec.FailureDomain, ctx.VSphereCluster.Name)
+	}
+
+	if err := util.ValidateMachineNetwork(ctx.VSphereMachine); err != nil {
+		return capierrors.InvalidMachineConfiguration("%v", err)
+	}
+
+	if _, err := util.GetMachineVMName(ctx.VSphereCluster.Spec.VMNameTemplate, ctx.Cluster.Name, ctx.Machine); err != nil {
+		return capierrors.InvalidMachineConfiguration("%v", err)
+	}
+
+	if _, err := util.GetMachineHostname(*ctx.VSphereMachine, ctx.VSphereCluster.Spec.HostnameDomain); err != nil {
+		return capierrors.InvalidMachineConfiguration("%v", err)
+	}
+
+	if _, err := util.GetMachineVMNotes(ctx.VSphereCluster.Spec.VMNotesTemplate, ctx.Cluster.Name, ctx.Machine, time.Now()); err != nil {
+		return capierrors.InvalidMachineConfiguration("%v", err)
+	}
+
+	fileNames := map[string]bool{}
+	for i, disk := range spec.AdditionalDisks {
+		switch {
+		case disk.FileName != "" && disk.SizeGiB != 0:
+			return capierrors.InvalidMachineConfiguration("invalid additional disk %d for %q: file name %q and size are mutually exclusive", i, ctx, disk.FileName)
+		case disk.FileName == "" && disk.SizeGiB <= 0:
+			return capierrors.InvalidMachineConfiguration("invalid additional disk %d for %q: one of file name or a positive size is required", i, ctx)
+		case fileNames[disk.FileName]:
+			return capierrors.InvalidMachineConfiguration("invalid additional disk %d for %q: file name %q is used by more than one disk", i, ctx, disk.FileName)
+		}
+		if disk.FileName != "" {
+			fileNames[disk.FileName] = true
+		}
+	}
+
+	if spec.HardwareVersion != "" {
+		if _, err := util.ParseHardwareVersion(spec.HardwareVersion); err != nil {
+			return capierrors.InvalidMachineConfiguration("%v", err)
+		}
+	}
+	return nil
+}
+
 func createVM(ctx *context.MachineContext, bootstrapData []byte) error {
+	if spec := ctx.VSphereMachine.Spec; spec.SyncTimeWithHost != nil && *spec.SyncTimeWithHost && len(spec.NTPServers) > 0 {
+		record.Warnf(ctx.VSphereMachine, "TimeSyncConflict", "VMware Tools time synchronization and NTP servers are both enabled for machine %q, which may make its clock unstable", ctx.Machine.Name)
+	}
+	if ctx.VSphereMachine.Spec.LatencySensitivity == infrav1.HighLatencySensitivity {
+		record.Warnf(ctx.VSphereMachine, "MemoryReserved", "all of the memory of machine %q is reserved as high latency sensitivity requires it", ctx.Machine.Name)
+	}
+	if err := cloneVM(ctx, bootstrapData); err != nil {
+		return err
+	}
+	ctx.VSphereMachine.Status.FailureDomain = ctx.VSphereMachine.Spec.FailureDomain
+	// The new VM's instance UUID is recorded once the clone completes.
+	ctx.VSphereMachine.Status.InstanceUUID = ""
+	ctx.VSphereMachine.Status.CreatedSpec = getCreatedSpec(ctx.VSphereMachine.Spec)
+	return nil
+}
+
+func cloneVM(ctx *context.MachineContext, bootstrapData []byte) error {
 	if ctx.Session.IsVC() {
+		if ctx.VSphereMachine.Spec.ContentLibraryItem != "" {
+			return vcenter.DeployFromLibrary(ctx, bootstrapData)
+		}
 		return vcenter.Clone(ctx, bootstrapData)
 	}
 	return esxi.Clone(ctx, bootstrapData)
 }
+
+// getCreatedSpec returns the fields of the provided spec that are only
+// applied when a machine's VM is created.
+func getCreatedSpec(spec infrav1.VSphereMachineSpec) *infrav1.VSphereMachineCreatedSpec {
+	created := &infrav1.VSphereMachineCreatedSpec{
+		Template:              spec.Template,
+		ContentLibraryItem:    spec.ContentLibraryItem,
+		CloneMode:             spec.CloneMode,
+		Datacenter:            spec.Datacenter,
+		ComputeCluster:        spec.ComputeCluster,
+		ResourcePool:          spec.ResourcePool,
+		Host:                  spec.Host,
+		Datastore:             spec.Datastore,
+		Datastores:            spec.Datastores,
+		Folder:                spec.Folder,
+		Firmware:              spec.Firmware,
+		SecureBoot:            spec.SecureBoot,
+		BootDelayMilliseconds: spec.BootDelayMilliseconds,
+		LatencySensitivity:    spec.LatencySensitivity,
+		CPUAffinity:           spec.CPUAffinity,
+		BootstrapTransport:    spec.BootstrapTransport,
+	}
+	for _, device := range spec.Network.Devices {
+		created.NetworkNames = append(created.NetworkNames, device.NetworkName)
+	}
+	return created
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// getMachineDisks returns the disks cloned from the machine's template and
+// the disks attached for the machine's additional disks, in the order of the
+// machine's AdditionalDisks. Existing disks are matched by their file name.
+// New disks are added after the template's disks on the template's disk
+// controller, so they are matched to the last disks by controller and unit
+// number. An additional disk is nil if it is not attached to the VM.
+func getMachineDisks(ctx *context.MachineContext, devices object.VirtualDeviceList) ([]*types.VirtualDisk, []*types.VirtualDisk) {
+	var remaining []*types.VirtualDisk
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		remaining = append(remaining, device.(*types.VirtualDisk))
+	}
+	sort.Slice(remaining, func(i, j int) bool {
+		a, b := remaining[i], remaining[j]
+		if a.ControllerKey != b.ControllerKey {
+			return a.ControllerKey < b.ControllerKey
+		}
+		return a.UnitNumber != nil && b.UnitNumber != nil && *a.UnitNumber < *b.UnitNumber
+	})
+
+	additional := make([]*types.VirtualDisk, len(ctx.VSphereMachine.Spec.AdditionalDisks))
+	var newDisks []int
+	for i, diskSpec := range ctx.VSphereMachine.Spec.AdditionalDisks {
+		if diskSpec.FileName == "" {
+			newDisks = append(newDisks, i)
+			continue
+		}
+		for j, disk := range remaining {
+			if getDiskFileName(disk) == diskSpec.FileName {
+				additional[i] = disk
+				remaining = append(remaining[:j], remaining[j+1:]...)
+				break
+			}
+		}
+	}
+
+	// Assign the last remaining disks to the new disks.
+	offset := len(remaining) - len(newDisks)
+	for i, index := range newDisks {
+		if offset+i >= 0 {
+			additional[index] = remaining[offset+i]
+		}
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return remaining[:offset], additional
+}
+
+// detachKeptDisks detaches the machine's additional disks that are kept on
+// delete from the machine's VM so they are not deleted with the VM. The kept
+// disks are recorded in the machine's kept disks annotation, so they are
+// still found by their file names once they are detached and the remaining
+// disks have moved. The detach op is recorded in the machine's task reference
+// and false is returned while it is started, so the VM is not destroyed
+// before its disks are detached.
+func detachKeptDisks(ctx *context.MachineContext) (bool, error) {
+	var keep bool
+	for _, diskSpec := range ctx.VSphereMachine.Spec.AdditionalDisks {
+		keep = keep || diskSpec.KeepOnDelete
+	}
+	if !keep {
+		return true, nil
+	}
+
+	vm, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		return false, err
+	}
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to get devices for vm %q", ctx)
+	}
+
+	keptDisks, ok := ctx.VSphereMachine.Annotations[constants.KeptDisksAnnotationLabel]
+	if !ok {
+		var fileNames []string
+		_, additional := getMachineDisks(ctx, devices)
+		for i, disk := range additional {
+			if disk != nil && ctx.VSphereMachine.Spec.AdditionalDisks[i].KeepOnDelete {
+				fileNames = append(fileNames, getDiskFileName(disk))
+			}
+		}
+		keptDisks = strings.Join(fileNames, ",")
+		if ctx.VSphereMachine.Annotations == nil {
+			ctx.VSphereMachine.Annotations = map[string]string{}
+		}
+		ctx.VSphereMachine.Annotations[constants.KeptDisksAnnotationLabel] = keptDisks
+	}
+
+	var (
+		deviceSpecs []types.BaseVirtualDeviceConfigSpec
+		fileNames   []string
+	)
+	for _, fileName := range strings.Split(keptDisks, ",") {
+		for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+			disk := device.(*types.VirtualDisk)
+			if fileName == "" || getDiskFileName(disk) != fileName {
+				continue
+			}
+			// Removing a device without a file operation detaches the disk
+			// without deleting its files.
+			deviceSpecs = append(deviceSpecs, &types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationRemove,
+				Device:    disk,
+			})
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	if len(deviceSpecs) == 0 {
+		return true, nil
+	}
+
+	ctx.Logger.V(4).Info("detaching disks kept on delete", "file-names", fileNames)
+	task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{DeviceChange: deviceSpecs})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to trigger disk detach op for vm %q", ctx)
+	}
+	record.Eventf(ctx.VSphereMachine, "DisksDetaching", "detaching disks kept on delete from vm %q: %s",
+		ctx.VSphereMachine.Name, strings.Join(fileNames, ", "))
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for disk detach op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return false, nil
+}
+
+// getDiskFileName returns the datastore path of the provided disk's file.
+func getDiskFileName(disk *types.VirtualDisk) string {
+	if backing, ok := disk.Backing.(types.BaseVirtualDeviceFileBackingInfo); ok {
+		return backing.GetVirtualDeviceFileBackingInfo().FileName
+	}
+	return ""
+}
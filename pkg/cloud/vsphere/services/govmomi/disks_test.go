@@ -0,0 +1,205 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func newTestDisk(controllerKey, unitNumber int32, fileName string) *vimtypes.VirtualDisk {
+	return &vimtypes.VirtualDisk{
+		VirtualDevice: vimtypes.VirtualDevice{
+			Key:           2000 + unitNumber,
+			ControllerKey: controllerKey,
+			UnitNumber:    vimtypes.NewInt32(unitNumber),
+			Backing: &vimtypes.VirtualDiskFlatVer2BackingInfo{
+				VirtualDeviceFileBackingInfo: vimtypes.VirtualDeviceFileBackingInfo{FileName: fileName},
+			},
+		},
+	}
+}
+
+func TestGetMachineDisks(t *testing.T) {
+	devices := object.VirtualDeviceList{
+		newTestDisk(1000, 3, "[ds] vm/vm_2.vmdk"),
+		newTestDisk(1000, 0, "[ds] vm/vm.vmdk"),
+		newTestDisk(1000, 2, "[ds] disks/data.vmdk"),
+		newTestDisk(1000, 1, "[ds] vm/vm_1.vmdk"),
+	}
+
+	testCases := []struct {
+		name               string
+		disks              []infrav1.DiskSpec
+		expectedPrimary    []string
+		expectedAdditional []string
+	}{
+		{
+			name:            "no additional disks",
+			expectedPrimary: []string{"[ds] vm/vm.vmdk", "[ds] vm/vm_1.vmdk", "[ds] disks/data.vmdk", "[ds] vm/vm_2.vmdk"},
+		},
+		{
+			name: "new and existing disks",
+			disks: []infrav1.DiskSpec{
+				{SizeGiB: 10},
+				{FileName: "[ds] disks/data.vmdk"},
+				{SizeGiB: 20},
+			},
+			expectedPrimary:    []string{"[ds] vm/vm.vmdk"},
+			expectedAdditional: []string{"[ds] vm/vm_1.vmdk", "[ds] disks/data.vmdk", "[ds] vm/vm_2.vmdk"},
+		},
+		{
+			name: "missing disks",
+			disks: []infrav1.DiskSpec{
+				{FileName: "[ds] disks/missing.vmdk"},
+				{SizeGiB: 10},
+			},
+			expectedPrimary:    []string{"[ds] vm/vm.vmdk", "[ds] vm/vm_1.vmdk", "[ds] disks/data.vmdk"},
+			expectedAdditional: []string{"", "[ds] vm/vm_2.vmdk"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &context.MachineContext{
+				VSphereMachine: &infrav1.VSphereMachine{
+					Spec: infrav1.VSphereMachineSpec{AdditionalDisks: tc.disks},
+				},
+			}
+			primary, additional := getMachineDisks(ctx, devices)
+			if len(primary) != len(tc.expectedPrimary) {
+				t.Fatalf("expected %d primary disks, got %d", len(tc.expectedPrimary), len(primary))
+			}
+			for i, disk := range primary {
+				if actual := getDiskFileName(disk); actual != tc.expectedPrimary[i] {
+					t.Errorf("expected primary disk %d %q, got %q", i, tc.expectedPrimary[i], actual)
+				}
+			}
+			if len(additional) != len(tc.expectedAdditional) {
+				t.Fatalf("expected %d additional disks, got %d", len(tc.expectedAdditional), len(additional))
+			}
+			for i, disk := range additional {
+				actual := ""
+				if disk != nil {
+					actual = getDiskFileName(disk)
+				}
+				if actual != tc.expectedAdditional[i] {
+					t.Errorf("expected additional disk %d %q, got %q", i, tc.expectedAdditional[i], actual)
+				}
+			}
+		})
+	}
+}
+
+func TestDetachKeptDisks(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	simVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{
+			MachineRef: simVM.Reference().Value,
+			AdditionalDisks: []infrav1.DiskSpec{
+				{SizeGiB: 1},
+				{SizeGiB: 1, KeepOnDelete: true},
+			},
+		},
+	})
+	vm, err := getVMfromMachineRef(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getDisks := func() []*vimtypes.VirtualDisk {
+		devices, err := vm.Device(machineContext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var disks []*vimtypes.VirtualDisk
+		for _, device := range devices.SelectByType((*vimtypes.VirtualDisk)(nil)) {
+			disks = append(disks, device.(*vimtypes.VirtualDisk))
+		}
+		return disks
+	}
+
+	// Add the machine's new disks after the VM's disk.
+	primary := getDisks()[0]
+	var deviceSpecs []vimtypes.BaseVirtualDeviceConfigSpec
+	for i := range machineContext.VSphereMachine.Spec.AdditionalDisks {
+		deviceSpecs = append(deviceSpecs, &vimtypes.VirtualDeviceConfigSpec{
+			Operation:     vimtypes.VirtualDeviceConfigSpecOperationAdd,
+			FileOperation: vimtypes.VirtualDeviceConfigSpecFileOperationCreate,
+			Device: &vimtypes.VirtualDisk{
+				VirtualDevice: vimtypes.VirtualDevice{
+					Key:           int32(-200 - i),
+					ControllerKey: primary.ControllerKey,
+					UnitNumber:    vimtypes.NewInt32(*primary.UnitNumber + 1 + int32(i)),
+					Backing: &vimtypes.VirtualDiskFlatVer2BackingInfo{
+						DiskMode: string(vimtypes.VirtualDiskModePersistent),
+					},
+				},
+				CapacityInKB: 1024 * 1024,
+			},
+		})
+	}
+	task, err := vm.Reconfigure(machineContext, vimtypes.VirtualMachineConfigSpec{DeviceChange: deviceSpecs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(machineContext); err != nil {
+		t.Fatal(err)
+	}
+	disks := getDisks()
+	if len(disks) != 3 {
+		t.Fatalf("expected 3 disks, got %d", len(disks))
+	}
+	kept := getDiskFileName(disks[2])
+
+	// The detach is started by a reconcile and waited for by the next.
+	ok, err := detachKeptDisks(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || machineContext.VSphereMachine.Status.TaskRef == "" {
+		t.Fatal("expected detach task to be recorded")
+	}
+	if actual := machineContext.VSphereMachine.Annotations[constants.KeptDisksAnnotationLabel]; actual != kept {
+		t.Fatalf("expected kept disks %q, got %q", kept, actual)
+	}
+	if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+		t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+	}
+	if ok, err := detachKeptDisks(machineContext); err != nil || !ok {
+		t.Fatalf("expected detached disks to be reconciled, got %t, %v", ok, err)
+	}
+	disks = getDisks()
+	if len(disks) != 2 {
+		t.Fatalf("expected 2 disks after detach, got %d", len(disks))
+	}
+	for _, disk := range disks {
+		if getDiskFileName(disk) == kept {
+			t.Fatalf("expected disk %q to be detached", kept)
+		}
+	}
+}
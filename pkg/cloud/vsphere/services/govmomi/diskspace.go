@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/vcenter"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// taskNoDiskSpaceError returns a *services.RequeueAfterError if the provided
+// task failed because its datastore is out of space. Retrying the task does
+// not help until space is freed or the machine's datastore is changed, so
+// the clone is retried after noDiskSpaceRequeue and an event describing the
+// space that is required and free is emitted. Nil is returned if the task
+// did not fail due to a lack of space.
+func taskNoDiskSpaceError(ctx *context.MachineContext, task *types.TaskInfo) error {
+	if task.Error == nil {
+		return nil
+	}
+	datastoreName, ok := getNoDiskSpaceFault(task.Error.Fault)
+	if !ok {
+		return nil
+	}
+
+	if name, freeGiB, requiredGiB, err := vcenter.GetDiskSpace(ctx, datastoreName); err != nil {
+		ctx.Logger.Error(err, "unable to get disk space of datastore")
+		record.Warnf(ctx.VSphereMachine, "DatastoreFull", "insufficient datastore space to create vm for machine %q: %s", ctx.Machine.Name, task.Error.LocalizedMessage)
+	} else {
+		record.Warnf(ctx.VSphereMachine, "DatastoreFull", "insufficient space on datastore %q to create vm for machine %q: required=%dGiB free=%dGiB",
+			name, ctx.Machine.Name, requiredGiB, freeGiB)
+	}
+
+	return &services.RequeueAfterError{
+		RequeueAfter: noDiskSpaceRequeue,
+		Reason:       fmt.Sprintf("datastore is out of space for %q", ctx),
+	}
+}
+
+// getNoDiskSpaceFault returns true if the provided fault was caused by a lack
+// of space on a datastore, along with the name of the datastore if the fault
+// names it.
+func getNoDiskSpaceFault(f interface{}) (string, bool) {
+	switch f := f.(type) {
+	case types.NoDiskSpace:
+		return f.Datastore, true
+	case *types.NoDiskSpace:
+		return f.Datastore, true
+	case types.InsufficientStorageSpace, *types.InsufficientStorageSpace:
+		return "", true
+	}
+	return "", false
+}
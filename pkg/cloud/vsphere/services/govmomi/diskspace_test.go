@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
+)
+
+func TestTaskNoDiskSpaceError(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	datastore := simulator.Map.Any("Datastore").(*simulator.Datastore)
+
+	testCases := []struct {
+		name            string
+		fault           types.BaseMethodFault
+		expectedRequeue bool
+	}{
+		{
+			name: "no fault",
+		},
+		{
+			name:  "other fault",
+			fault: &types.InvalidState{},
+		},
+		{
+			name:            "no disk space on datastore",
+			fault:           &types.NoDiskSpace{Datastore: datastore.Name},
+			expectedRequeue: true,
+		},
+		{
+			name:            "no disk space on missing datastore",
+			fault:           &types.NoDiskSpace{Datastore: "missing-datastore"},
+			expectedRequeue: true,
+		},
+		{
+			name:            "insufficient storage space",
+			fault:           &types.InsufficientStorageSpace{},
+			expectedRequeue: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: infrav1.VSphereMachineSpec{Template: vm.Name},
+			})
+
+			info := types.TaskInfo{State: types.TaskInfoStateError}
+			if tc.fault != nil {
+				info.Error = &types.LocalizedMethodFault{Fault: tc.fault}
+			}
+
+			err := taskNoDiskSpaceError(machineContext, &info)
+			if !tc.expectedRequeue {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			requeueErr, ok := err.(*services.RequeueAfterError)
+			if !ok {
+				t.Fatalf("expected requeue error, got %T: %v", err, err)
+			}
+			if requeueErr.RequeueAfter != noDiskSpaceRequeue {
+				t.Errorf("expected requeue after %v, got %v", noDiskSpaceRequeue, requeueErr.RequeueAfter)
+			}
+		})
+	}
+}
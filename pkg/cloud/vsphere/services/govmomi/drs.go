@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+const morefTypeClusterComputeResource = "ClusterComputeResource"
+
+// controlPlaneAntiAffinityRuleName returns the name of the DRS rule that
+// keeps the cluster's control plane VMs on separate hosts.
+func controlPlaneAntiAffinityRuleName(ctx *context.MachineContext) string {
+	return fmt.Sprintf("%s-%s-control-plane-anti-affinity", ctx.Cluster.Namespace, ctx.Cluster.Name)
+}
+
+// reconcileControlPlaneAntiAffinity adds the provided control plane VM to the
+// DRS anti-affinity rule for the cluster's control plane VMs, creating the
+// rule if needed. DRS rules require at least two VMs, so the rule is created
+// along with the second control plane VM. Nothing is done if the VM is not
+// in a DRS-enabled cluster. The reconfigure op is recorded in the machine's
+// task reference and false is returned while it is started.
+func reconcileControlPlaneAntiAffinity(ctx *context.MachineContext, vm types.ManagedObjectReference) (bool, error) {
+	var vmObj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, vm, []string{"resourcePool"}, &vmObj); err != nil {
+		return false, errors.Wrapf(err, "unable to get resource pool of vm for %q", ctx)
+	}
+	if vmObj.ResourcePool == nil {
+		return false, errors.Errorf("unable to get resource pool of vm for %q", ctx)
+	}
+	var poolObj mo.ResourcePool
+	if err := ctx.Session.RetrieveOne(ctx, *vmObj.ResourcePool, []string{"owner"}, &poolObj); err != nil {
+		return false, errors.Wrapf(err, "unable to get owner of resource pool for %q", ctx)
+	}
+	if poolObj.Owner.Type != morefTypeClusterComputeResource {
+		ctx.Logger.V(4).Info("vm is not in a cluster, skipping control plane anti-affinity rule", "compute-resource", poolObj.Owner.Value)
+		return true, nil
+	}
+
+	var clusterObj mo.ClusterComputeResource
+	if err := ctx.Session.RetrieveOne(ctx, poolObj.Owner, []string{"configurationEx"}, &clusterObj); err != nil {
+		return false, errors.Wrapf(err, "unable to get configuration of compute cluster for %q", ctx)
+	}
+	config, ok := clusterObj.ConfigurationEx.(*types.ClusterConfigInfoEx)
+	if !ok || config.DrsConfig.Enabled == nil || !*config.DrsConfig.Enabled {
+		ctx.Logger.V(4).Info("DRS is disabled, skipping control plane anti-affinity rule", "compute-resource", poolObj.Owner.Value)
+		return true, nil
+	}
+
+	ruleName := controlPlaneAntiAffinityRuleName(ctx)
+	var existing *types.ClusterAntiAffinityRuleSpec
+	for _, rule := range config.Rule {
+		if r, ok := rule.(*types.ClusterAntiAffinityRuleSpec); ok && r.Name == ruleName {
+			existing = r
+			break
+		}
+	}
+
+	vms, err := getControlPlaneVMs(ctx, vm, existing)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil && len(vms) == len(existing.Vm) {
+		return true, nil
+	}
+	if len(vms) < 2 {
+		ctx.Logger.V(4).Info("waiting for another control plane vm to create anti-affinity rule", "rule", ruleName)
+		return true, nil
+	}
+
+	enabled := true
+	ruleSpec := types.ClusterRuleSpec{
+		ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationAdd},
+		Info: &types.ClusterAntiAffinityRuleSpec{
+			ClusterRuleInfo: types.ClusterRuleInfo{
+				Name:    ruleName,
+				Enabled: &enabled,
+			},
+			Vm: vms,
+		},
+	}
+	if existing != nil {
+		ruleSpec.Operation = types.ArrayUpdateOperationEdit
+		ruleSpec.Info.GetClusterRuleInfo().Key = existing.Key
+	}
+
+	ctx.Logger.V(4).Info("reconciling control plane anti-affinity rule", "rule", ruleName, "operation", ruleSpec.Operation, "vm-count", len(vms))
+	cluster := object.NewClusterComputeResource(ctx.Session.Client.Client, poolObj.Owner)
+	task, err := cluster.Reconfigure(ctx, &types.ClusterConfigSpecEx{
+		RulesSpec: []types.ClusterRuleSpec{ruleSpec},
+	}, true)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to trigger reconfigure op for anti-affinity rule %q", ruleName)
+	}
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for anti-affinity rule reconfigure op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return false, nil
+}
+
+// getControlPlaneVMs returns the provided VM, the VMs already in the
+// anti-affinity rule, and the VMs of the cluster's other control plane
+// machines.
+func getControlPlaneVMs(
+	ctx *context.MachineContext,
+	vm types.ManagedObjectReference,
+	existing *types.ClusterAntiAffinityRuleSpec) ([]types.ManagedObjectReference, error) {
+
+	var vms []types.ManagedObjectReference
+	seen := map[types.ManagedObjectReference]bool{}
+	add := func(ref types.ManagedObjectReference) {
+		if !seen[ref] {
+			seen[ref] = true
+			vms = append(vms, ref)
+		}
+	}
+
+	if existing != nil {
+		for _, ref := range existing.Vm {
+			add(ref)
+		}
+	}
+	add(vm)
+
+	if ctx.Client == nil {
+		return vms, nil
+	}
+	machines, err := util.GetMachinesInCluster(ctx, ctx.Client, ctx.Cluster.Namespace, ctx.Cluster.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, machine := range machines {
+		if !util.IsControlPlaneMachine(machine) || machine.Name == ctx.Machine.Name {
+			continue
+		}
+		vsphereMachine, err := util.GetVSphereMachine(ctx, ctx.Client, machine.Namespace, machine.Spec.InfrastructureRef.Name)
+		if err != nil {
+			// The machine may not have a VSphereMachine yet.
+			ctx.Logger.V(6).Info("skipping control plane machine without vspheremachine", "machine", machine.Name, "reason", err.Error())
+			continue
+		}
+		if vsphereMachine.Spec.MachineRef != "" && vsphereMachine.DeletionTimestamp.IsZero() {
+			add(util.GetMachineManagedObjectReference(vsphereMachine))
+		}
+	}
+	return vms, nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+)
+
+func TestReconcileControlPlaneAntiAffinity(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+	model.Machine = 3
+	sim := newVCSimWithModel(t, model)
+	defer sim.destroy()
+
+	cluster := simulator.Map.Any("ClusterComputeResource").(*simulator.ClusterComputeResource)
+	vms := simulator.Map.All("VirtualMachine")
+	if len(vms) < 3 {
+		t.Fatalf("expected at least 3 vms, got %d", len(vms))
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clusterv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := infrav1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	newMachines := func(name string, vm types.ManagedObjectReference) (*clusterv1.Machine, *infrav1.VSphereMachine) {
+		labels := map[string]string{
+			clusterv1.MachineClusterLabelName:      "test-cluster",
+			clusterv1.MachineControlPlaneLabelName: "true",
+		}
+		machine := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-namespace", Labels: labels},
+			Spec: clusterv1.MachineSpec{
+				InfrastructureRef: corev1.ObjectReference{Name: name},
+			},
+		}
+		vsphereMachine := &infrav1.VSphereMachine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-namespace", Labels: labels},
+			Spec:       infrav1.VSphereMachineSpec{MachineRef: vm.Value},
+		}
+		return machine, vsphereMachine
+	}
+
+	getRule := func() *types.ClusterAntiAffinityRuleSpec {
+		for _, rule := range cluster.ConfigurationEx.(*types.ClusterConfigInfoEx).Rule {
+			if r, ok := rule.(*types.ClusterAntiAffinityRuleSpec); ok && r.Name == "test-namespace-test-cluster-control-plane-anti-affinity" {
+				return r
+			}
+		}
+		return nil
+	}
+
+	reconcile := func(vm types.ManagedObjectReference, others ...types.ManagedObjectReference) {
+		var objects []runtime.Object
+		for i, other := range others {
+			machine, vsphereMachine := newMachines(string(rune('a'+i)), other)
+			objects = append(objects, machine, vsphereMachine)
+		}
+
+		machine, vsphereMachine := newMachines("test-machine", vm)
+		machineContext := sim.newMachineContext(t, machine, vsphereMachine)
+		machineContext.Client = fake.NewFakeClientWithScheme(scheme, objects...)
+		ok, err := reconcileControlPlaneAntiAffinity(machineContext, vm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			return
+		}
+		// The reconfigure is started by a reconcile and waited for by the
+		// next.
+		if machineContext.VSphereMachine.Status.TaskRef == "" {
+			t.Fatal("expected reconfigure task to be recorded")
+		}
+		if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+			t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+		}
+		if ok, err := reconcileControlPlaneAntiAffinity(machineContext, vm); err != nil || !ok {
+			t.Fatalf("expected anti-affinity rule to be reconciled, got %t, %v", ok, err)
+		}
+	}
+
+	vm0, vm1, vm2 := vms[0].Reference(), vms[1].Reference(), vms[2].Reference()
+
+	// A single control plane VM does not need a rule.
+	reconcile(vm0)
+	if rule := getRule(); rule != nil {
+		t.Fatalf("expected no rule, got %+v", rule)
+	}
+
+	// The second control plane VM creates the rule.
+	reconcile(vm1, vm0)
+	rule := getRule()
+	if rule == nil {
+		t.Fatal("expected rule to be created")
+	}
+	if len(rule.Vm) != 2 {
+		t.Fatalf("expected 2 vms in rule, got %v", rule.Vm)
+	}
+
+	// The third control plane VM is added to the existing rule.
+	reconcile(vm2, vm0)
+	rule = getRule()
+	if len(rule.Vm) != 3 {
+		t.Fatalf("expected 3 vms in rule, got %v", rule.Vm)
+	}
+
+	// No rule is created when DRS is disabled.
+	cluster.ConfigurationEx.(*types.ClusterConfigInfoEx).Rule = nil
+	cluster.ConfigurationEx.(*types.ClusterConfigInfoEx).DrsConfig.Enabled = types.NewBool(false)
+	reconcile(vm1, vm0)
+	if rule := getRule(); rule != nil {
+		t.Fatalf("expected no rule with drs disabled, got %+v", rule)
+	}
+}
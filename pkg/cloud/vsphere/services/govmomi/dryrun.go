@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+// DryRunVMService validates machines and logs the VMs that would be created
+// without connecting to vSphere. VMs are never reported as existing.
+type DryRunVMService struct{}
+
+// ReconcileVM validates the machine's spec and logs the VM that would be
+// created. The returned VM is always pending.
+func (vms *DryRunVMService) ReconcileVM(ctx *context.MachineContext) (infrav1.VirtualMachine, error) {
+	vm := infrav1.VirtualMachine{
+		Name:  ctx.VSphereMachine.Name,
+		State: infrav1.VirtualMachineStatePending,
+	}
+
+	if err := validateMachineSpec(ctx); err != nil {
+		return vm, err
+	}
+
+	vmName, err := util.GetMachineVMName(ctx.VSphereCluster.Spec.VMNameTemplate, ctx.Cluster.Name, ctx.Machine)
+	if err != nil {
+		return vm, err
+	}
+
+	spec := ctx.VSphereMachine.Spec
+	ctx.Logger.Info("dry-run: would create vm",
+		"server", ctx.Server(),
+		"vm-name", vmName,
+		"datacenter", spec.Datacenter,
+		"template", spec.Template,
+		"content-library-item", spec.ContentLibraryItem,
+		"clone-mode", spec.CloneMode,
+		"resource-pool", spec.ResourcePool,
+		"folder", spec.Folder,
+		"datastore", spec.Datastore,
+		"datastores", spec.Datastores,
+		"datastore-cluster", spec.DatastoreCluster,
+		"storage-policy", spec.StoragePolicy,
+		"num-cpus", spec.NumCPUs,
+		"memory-mib", spec.MemoryMiB,
+		"disk-gib", spec.DiskGiB,
+		"network-devices", len(spec.Network.Devices))
+
+	return vm, nil
+}
+
+// DestroyVM logs the VM that would be destroyed. The returned VM is always
+// not found.
+func (vms *DryRunVMService) DestroyVM(ctx *context.MachineContext) (infrav1.VirtualMachine, error) {
+	ctx.Logger.Info("dry-run: would destroy vm", "server", ctx.Server(), "moref-id", ctx.VSphereMachine.Spec.MachineRef)
+	return infrav1.VirtualMachine{
+		Name:  ctx.VSphereMachine.Name,
+		State: infrav1.VirtualMachineStateNotFound,
+	}, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func TestDryRunVMService(t *testing.T) {
+	config.DryRun = true
+	defer func() { config.DryRun = false }()
+
+	testCases := []struct {
+		name          string
+		spec          infrav1.VSphereMachineSpec
+		expectedError bool
+	}{
+		{
+			name: "valid",
+			spec: infrav1.VSphereMachineSpec{Template: "template"},
+		},
+		{
+			name:          "invalid",
+			spec:          infrav1.VSphereMachineSpec{Template: "template", ContentLibraryItem: "library/item"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+				Cluster: &clusterv1.Cluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+				},
+				VSphereCluster: &infrav1.VSphereCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					Spec:       infrav1.VSphereClusterSpec{Server: "unreachable.local"},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			machineContext, err := context.NewMachineContextFromClusterContext(
+				clusterContext,
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				&infrav1.VSphereMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+					Spec:       tc.spec,
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if machineContext.Session != nil {
+				t.Fatal("expected no vSphere session in dry-run mode")
+			}
+
+			var vms DryRunVMService
+			vm, err := vms.ReconcileVM(machineContext)
+			if tc.expectedError {
+				if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %T: %v", err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if vm.State != infrav1.VirtualMachineStatePending {
+				t.Fatalf("expected vm state %q, got %q", infrav1.VirtualMachineStatePending, vm.State)
+			}
+
+			vm, err = vms.DestroyVM(machineContext)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if vm.State != infrav1.VirtualMachineStateNotFound {
+				t.Fatalf("expected vm state %q, got %q", infrav1.VirtualMachineStateNotFound, vm.State)
+			}
+		})
+	}
+}
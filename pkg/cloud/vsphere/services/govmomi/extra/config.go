@@ -41,6 +41,22 @@ func (e *Config) SetCloudInitUserData(data []byte) error {
 	return nil
 }
 
+// SetIgnitionUserData sets the Ignition config at the key
+// "guestinfo.ignition.config.data" as a base64-encoded string.
+func (e *Config) SetIgnitionUserData(data []byte) error {
+	*e = append(*e,
+		&types.OptionValue{
+			Key:   "guestinfo.ignition.config.data",
+			Value: e.encode(data),
+		},
+		&types.OptionValue{
+			Key:   "guestinfo.ignition.config.data.encoding",
+			Value: "base64",
+		},
+	)
+	return nil
+}
+
 // SetCloudInitMetadata sets the cloud init user data at the key
 // "guestinfo.metadata" as a base64-encoded string.
 func (e *Config) SetCloudInitMetadata(data []byte) error {
@@ -65,12 +81,18 @@ func (e *Config) encode(data []byte) string {
 	if len(data) == 0 {
 		return ""
 	}
-	for {
+	return base64.StdEncoding.EncodeToString(Decode(data))
+}
+
+// Decode returns the plain-text data by decoding the data as base64 as many
+// times as necessary, since bootstrap data may be encoded more than once.
+func Decode(data []byte) []byte {
+	for len(data) > 0 {
 		decoded, err := base64.StdEncoding.DecodeString(string(data))
 		if err != nil {
-			break
+			return data
 		}
 		data = decoded
 	}
-	return base64.StdEncoding.EncodeToString(data)
+	return data
 }
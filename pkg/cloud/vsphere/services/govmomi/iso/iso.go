@@ -0,0 +1,284 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package iso writes ISO 9660 images with a single directory, such as the
+// cloud-init NoCloud ISOs used to provide bootstrap data to VMs that cannot
+// read guestinfo.
+package iso
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sectorSize = 2048
+
+	// The sectors of an image. The first 16 sectors are the system area,
+	// which is unused. Each path table only describes the root directory,
+	// so every table and directory fits in a single sector.
+	primaryVolumeSector  = 16
+	jolietVolumeSector   = 17
+	terminatorSector     = 18
+	primaryLPathSector   = 19
+	primaryMPathSector   = 20
+	jolietLPathSector    = 21
+	jolietMPathSector    = 22
+	primaryRootDirSector = 23
+	jolietRootDirSector  = 24
+	firstFileSector      = 25
+
+	pathTableSize = 10
+
+	// maxLabelLength is the maximum length of a volume label, which is
+	// limited by the 32 bytes of a Joliet volume identifier.
+	maxLabelLength = 16
+
+	// maxNameLength is the maximum length of a Joliet file name.
+	maxNameLength = 64
+)
+
+// File is a file in the root directory of an image.
+type File struct {
+	// Name is the name of the file.
+	Name string
+
+	// Data is the contents of the file.
+	Data []byte
+}
+
+// New returns an ISO 9660 image labeled with the provided label, with the
+// provided files in its root directory. Joliet extensions preserve the case
+// of the file names. The file names of the primary volume are only
+// upper-cased, so readers without Joliet support that map names to lower
+// case, such as Linux, find the files by their names as well.
+func New(label string, files ...File) ([]byte, error) {
+	if label == "" || len(label) > maxLabelLength {
+		return nil, errors.Errorf("invalid iso label %q: must be 1 to %d characters", label, maxLabelLength)
+	}
+
+	image := make([]byte, firstFileSector*sectorSize)
+	primaryRecords := map[string][]byte{}
+	jolietRecords := map[string][]byte{}
+	seen := map[string]bool{}
+	for _, file := range files {
+		if file.Name == "" || len(file.Name) > maxNameLength || strings.ContainsAny(file.Name, "/;") {
+			return nil, errors.Errorf("invalid iso file name %q", file.Name)
+		}
+		if seen[strings.ToUpper(file.Name)] {
+			return nil, errors.Errorf("duplicate iso file name %q", file.Name)
+		}
+		seen[strings.ToUpper(file.Name)] = true
+
+		extent, size := uint32(0), uint32(len(file.Data))
+		if size > 0 {
+			extent = uint32(len(image) / sectorSize)
+			image = append(image, file.Data...)
+			image = append(image, make([]byte, padding(len(image)))...)
+		}
+		primaryName := primaryFileName(file.Name)
+		jolietName := string(ucs2(file.Name))
+		primaryRecords[primaryName] = directoryRecord([]byte(primaryName), extent, size, false)
+		jolietRecords[jolietName] = directoryRecord([]byte(jolietName), extent, size, false)
+	}
+
+	if err := writeDirectory(image[primaryRootDirSector*sectorSize:], primaryRootDirSector, primaryRecords); err != nil {
+		return nil, err
+	}
+	if err := writeDirectory(image[jolietRootDirSector*sectorSize:], jolietRootDirSector, jolietRecords); err != nil {
+		return nil, err
+	}
+	writePathTables(image[primaryLPathSector*sectorSize:], image[primaryMPathSector*sectorSize:], primaryRootDirSector)
+	writePathTables(image[jolietLPathSector*sectorSize:], image[jolietMPathSector*sectorSize:], jolietRootDirSector)
+
+	volumeSectors := uint32(len(image) / sectorSize)
+	writeVolumeDescriptor(image[primaryVolumeSector*sectorSize:], volumeDescriptor{
+		typ:           1,
+		label:         padded([]byte(label), 32, ' '),
+		pad:           func(b []byte, n int) []byte { return padded(b, n, ' ') },
+		volumeSectors: volumeSectors,
+		lPathTable:    primaryLPathSector,
+		mPathTable:    primaryMPathSector,
+		rootDirectory: primaryRootDirSector,
+	})
+	writeVolumeDescriptor(image[jolietVolumeSector*sectorSize:], volumeDescriptor{
+		typ:           2,
+		label:         ucs2Padded(ucs2(label), 32),
+		pad:           ucs2Padded,
+		volumeSectors: volumeSectors,
+		lPathTable:    jolietLPathSector,
+		mPathTable:    jolietMPathSector,
+		rootDirectory: jolietRootDirSector,
+		// UCS-2 level 3.
+		escapeSequences: []byte("%/E"),
+	})
+	terminator := image[terminatorSector*sectorSize:]
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	return image, nil
+}
+
+// volumeDescriptor describes a primary or supplementary volume descriptor.
+type volumeDescriptor struct {
+	typ             byte
+	label           []byte
+	pad             func([]byte, int) []byte
+	volumeSectors   uint32
+	lPathTable      uint32
+	mPathTable      uint32
+	rootDirectory   uint32
+	escapeSequences []byte
+}
+
+func writeVolumeDescriptor(b []byte, vd volumeDescriptor) {
+	b[0] = vd.typ
+	copy(b[1:], "CD001")
+	b[6] = 1
+	copy(b[8:40], vd.pad(nil, 32))
+	copy(b[40:72], vd.label)
+	putBothUint32(b[80:], vd.volumeSectors)
+	copy(b[88:120], vd.escapeSequences)
+	putBothUint16(b[120:], 1)
+	putBothUint16(b[124:], 1)
+	putBothUint16(b[128:], sectorSize)
+	putBothUint32(b[132:], pathTableSize)
+	binary.LittleEndian.PutUint32(b[140:], vd.lPathTable)
+	binary.BigEndian.PutUint32(b[148:], vd.mPathTable)
+	copy(b[156:190], directoryRecord([]byte{0}, vd.rootDirectory, sectorSize, true))
+	// The volume set, publisher, data preparer, and application
+	// identifiers, followed by the copyright, abstract, and bibliographic
+	// file identifiers.
+	offset := 190
+	for _, n := range []int{128, 128, 128, 128, 37, 37, 37} {
+		copy(b[offset:offset+n], vd.pad(nil, n))
+		offset += n
+	}
+	// The creation, modification, expiration, and effective dates are
+	// unspecified.
+	for i := 0; i < 4; i++ {
+		copy(b[offset:], strings.Repeat("0", 16))
+		offset += 17
+	}
+	b[offset] = 1
+}
+
+// writeDirectory writes the records of a root directory to its sector,
+// which must hold all of the records.
+func writeDirectory(b []byte, sector uint32, records map[string][]byte) error {
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dir := append(directoryRecord([]byte{0}, sector, sectorSize, true), directoryRecord([]byte{1}, sector, sectorSize, true)...)
+	for _, name := range names {
+		dir = append(dir, records[name]...)
+	}
+	if len(dir) > sectorSize {
+		return errors.Errorf("too many iso files: the root directory exceeds %d bytes", sectorSize)
+	}
+	copy(b, dir)
+	return nil
+}
+
+// writePathTables writes the little-endian and big-endian path tables of an
+// image whose only directory is the root directory.
+func writePathTables(l, m []byte, rootDirectory uint32) {
+	for _, table := range []struct {
+		b     []byte
+		order binary.ByteOrder
+	}{{l, binary.LittleEndian}, {m, binary.BigEndian}} {
+		table.b[0] = 1
+		table.order.PutUint32(table.b[2:], rootDirectory)
+		// The root directory is its own parent.
+		table.order.PutUint16(table.b[6:], 1)
+	}
+}
+
+// directoryRecord returns the record of a file or directory.
+func directoryRecord(name []byte, extent, size uint32, dir bool) []byte {
+	n := 33 + len(name)
+	n += n % 2
+	r := make([]byte, n)
+	r[0] = byte(n)
+	putBothUint32(r[2:], extent)
+	putBothUint32(r[10:], size)
+	if dir {
+		r[25] = 2
+	}
+	putBothUint16(r[28:], 1)
+	r[32] = byte(len(name))
+	copy(r[33:], name)
+	return r
+}
+
+// primaryFileName returns the name of a file in the primary volume, which
+// ends with a period if the name has no extension, and file version 1.
+func primaryFileName(name string) string {
+	name = strings.ToUpper(name)
+	if !strings.Contains(name, ".") {
+		name += "."
+	}
+	return name + ";1"
+}
+
+func ucs2(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
+
+func padded(b []byte, n int, pad byte) []byte {
+	for len(b) < n {
+		b = append(b, pad)
+	}
+	return b
+}
+
+func ucs2Padded(b []byte, n int) []byte {
+	for len(b)+1 < n {
+		b = append(b, 0, ' ')
+	}
+	return b
+}
+
+func padding(n int) int {
+	if n%sectorSize == 0 {
+		return 0
+	}
+	return sectorSize - n%sectorSize
+}
+
+// putBothUint32 writes v in the both-byte orders format, little-endian
+// followed by big-endian.
+func putBothUint32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func putBothUint16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
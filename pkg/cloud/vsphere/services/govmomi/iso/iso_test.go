@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iso
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// readRootDirectory returns the files in the root directory described by
+// the volume descriptor in the sector, by their names.
+func readRootDirectory(t *testing.T, image []byte, sector int) (string, map[string]string) {
+	vd := image[sector*sectorSize:]
+	if string(vd[1:6]) != "CD001" {
+		t.Fatalf("sector %d is not a volume descriptor", sector)
+	}
+	root := vd[156:]
+	extent := binary.LittleEndian.Uint32(root[2:])
+	if extent != binary.BigEndian.Uint32(root[6:]) {
+		t.Fatalf("root directory extent of sector %d differs by byte order", sector)
+	}
+
+	files := map[string]string{}
+	dir := image[int(extent)*sectorSize : int(extent+1)*sectorSize]
+	for offset := 0; offset < len(dir) && dir[offset] > 0; offset += int(dir[offset]) {
+		r := dir[offset:]
+		name := string(r[33 : 33+int(r[32])])
+		if r[25]&2 != 0 {
+			continue
+		}
+		start, size := binary.LittleEndian.Uint32(r[2:]), binary.LittleEndian.Uint32(r[10:])
+		files[name] = string(image[int(start)*sectorSize : int(start)*sectorSize+int(size)])
+	}
+	return string(vd[40:72]), files
+}
+
+func TestNew(t *testing.T) {
+	image, err := New("cidata",
+		File{Name: "user-data", Data: []byte("#cloud-config\n")},
+		File{Name: "meta-data", Data: []byte("instance-id: test\n")},
+		File{Name: "empty"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image)%sectorSize != 0 {
+		t.Fatalf("expected image size to be a multiple of %d, got %d", sectorSize, len(image))
+	}
+	if volumeSectors := binary.LittleEndian.Uint32(image[primaryVolumeSector*sectorSize+80:]); int(volumeSectors) != len(image)/sectorSize {
+		t.Fatalf("expected %d sectors, got %d", len(image)/sectorSize, volumeSectors)
+	}
+	if image[terminatorSector*sectorSize] != 255 {
+		t.Fatal("expected volume descriptor set terminator")
+	}
+
+	label, files := readRootDirectory(t, image, primaryVolumeSector)
+	if expected := "cidata" + string(padded(nil, 26, ' ')); label != expected {
+		t.Fatalf("expected primary label %q, got %q", expected, label)
+	}
+	expected := map[string]string{
+		"USER-DATA.;1": "#cloud-config\n",
+		"META-DATA.;1": "instance-id: test\n",
+		"EMPTY.;1":     "",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected primary files %v, got %v", expected, files)
+	}
+
+	label, files = readRootDirectory(t, image, jolietVolumeSector)
+	if expected := string(ucs2Padded(ucs2("cidata"), 32)); label != expected {
+		t.Fatalf("expected joliet label %q, got %q", expected, label)
+	}
+	expected = map[string]string{
+		string(ucs2("user-data")): "#cloud-config\n",
+		string(ucs2("meta-data")): "instance-id: test\n",
+		string(ucs2("empty")):     "",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected joliet files %v, got %v", expected, files)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		label string
+		files []File
+	}{
+		{name: "missing label"},
+		{name: "long label", label: "a-label-longer-than-16"},
+		{name: "missing file name", label: "cidata", files: []File{{Data: []byte("data")}}},
+		{name: "file name with separator", label: "cidata", files: []File{{Name: "dir/file"}}},
+		{name: "duplicate file name", label: "cidata", files: []File{{Name: "meta-data"}, {Name: "META-DATA"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := New(tc.label, tc.files...); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"sync"
+	"time"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+// clones limits the number of VMs this process clones at the same time on
+// each vSphere server.
+var clones = newCloneLimiter()
+
+// cloneLimiter tracks the machines whose VMs are being cloned on each
+// vSphere server. A machine holds a slot from the time its clone is started
+// until the clone completes or fails.
+type cloneLimiter struct {
+	sync.Mutex
+
+	// slots maps a server to the machines holding a slot on the server and
+	// the time each slot was acquired.
+	slots map[string]map[string]time.Time
+
+	now func() time.Time
+}
+
+func newCloneLimiter() *cloneLimiter {
+	return &cloneLimiter{
+		slots: map[string]map[string]time.Time{},
+		now:   time.Now,
+	}
+}
+
+// acquire returns true if the machine holds, or was given, one of the
+// server's slots. False is returned if limit slots are held by other
+// machines. A limit of zero or less does not limit the number of slots.
+func (l *cloneLimiter) acquire(server, machine string, limit int) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	held, ok := l.slots[server]
+	if !ok {
+		held = map[string]time.Time{}
+		l.slots[server] = held
+	}
+	for m, acquired := range held {
+		if now.Sub(acquired) > cloneSlotTimeout {
+			delete(held, m)
+		}
+	}
+	if _, ok := held[machine]; ok {
+		return true
+	}
+	if limit > 0 && len(held) >= limit {
+		return false
+	}
+	held[machine] = now
+	return true
+}
+
+// release frees the machine's slot on the server, if it holds one.
+func (l *cloneLimiter) release(server, machine string) {
+	l.Lock()
+	defer l.Unlock()
+
+	if held, ok := l.slots[server]; ok {
+		delete(held, machine)
+		if len(held) == 0 {
+			delete(l.slots, server)
+		}
+	}
+}
+
+// acquireCloneSlot returns true if the machine may clone its VM without
+// exceeding config.MaxConcurrentClones on the machine's server.
+func acquireCloneSlot(ctx *context.MachineContext) bool {
+	return clones.acquire(ctx.Server(), cloneSlotKey(ctx), config.MaxConcurrentClones)
+}
+
+// releaseCloneSlot frees the machine's slot on its server once its clone
+// is no longer in flight.
+func releaseCloneSlot(ctx *context.MachineContext) {
+	clones.release(ctx.Server(), cloneSlotKey(ctx))
+}
+
+func cloneSlotKey(ctx *context.MachineContext) string {
+	return ctx.VSphereMachine.Namespace + "/" + ctx.VSphereMachine.Name
+}
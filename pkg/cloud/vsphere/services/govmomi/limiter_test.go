@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCloneLimiter(t *testing.T) {
+	now := time.Now()
+	l := newCloneLimiter()
+	l.now = func() time.Time { return now }
+
+	steps := []struct {
+		name     string
+		server   string
+		machine  string
+		release  bool
+		advance  time.Duration
+		limit    int
+		expected bool
+	}{
+		{name: "first slot", server: "vc1", machine: "m1", limit: 2, expected: true},
+		{name: "second slot", server: "vc1", machine: "m2", limit: 2, expected: true},
+		{name: "limit reached", server: "vc1", machine: "m3", limit: 2, expected: false},
+		{name: "slot already held", server: "vc1", machine: "m1", limit: 2, expected: true},
+		{name: "other server", server: "vc2", machine: "m3", limit: 2, expected: true},
+		{name: "no limit", server: "vc1", machine: "m3", limit: 0, expected: true},
+		{name: "release", server: "vc1", machine: "m3", release: true},
+		{name: "limit still reached", server: "vc1", machine: "m4", limit: 2, expected: false},
+		{name: "release held slot", server: "vc1", machine: "m2", release: true},
+		{name: "released slot", server: "vc1", machine: "m4", limit: 2, expected: true},
+		{name: "timed out slots", server: "vc1", machine: "m5", advance: cloneSlotTimeout + time.Second, limit: 1, expected: true},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.release {
+			l.release(step.server, step.machine)
+			continue
+		}
+		if actual := l.acquire(step.server, step.machine, step.limit); actual != step.expected {
+			t.Fatalf("%s: expected acquire to return %t, got %t", step.name, step.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+const (
+	taskResultSuccess = "success"
+	taskResultError   = "error"
+)
+
+var (
+	sessionsDesc = prometheus.NewDesc(
+		"capv_vsphere_sessions",
+		"The number of cached vSphere sessions.",
+		nil, nil,
+	)
+
+	sessionCacheHitsDesc = prometheus.NewDesc(
+		"capv_vsphere_session_cache_hits_total",
+		"The number of times a cached vSphere session was reused.",
+		nil, nil,
+	)
+
+	sessionCacheMissesDesc = prometheus.NewDesc(
+		"capv_vsphere_session_cache_misses_total",
+		"The number of times a vSphere session was created because no usable session was cached.",
+		nil, nil,
+	)
+
+	taskDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "capv_vsphere_task_duration_seconds",
+			Help:    "The duration of vSphere tasks by task type, ex. VirtualMachine.clone, and result.",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800},
+		},
+		[]string{"task", "result"},
+	)
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(sessionCollector{}, taskDuration)
+}
+
+// sessionCollector collects the metrics of the session cache when the
+// metrics are scraped, as the cache is owned by the context package.
+type sessionCollector struct{}
+
+func (sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionsDesc
+	ch <- sessionCacheHitsDesc
+	ch <- sessionCacheMissesDesc
+}
+
+func (sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := context.GetSessionCacheStats()
+	ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(stats.Sessions))
+	ch <- prometheus.MustNewConstMetric(sessionCacheHitsDesc, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(sessionCacheMissesDesc, prometheus.CounterValue, float64(stats.Misses))
+}
+
+// observeTask records the duration of a completed task. Tasks are observed
+// when a reconcile finds they completed, so the duration is the one vCenter
+// reports rather than the time between reconciles.
+func observeTask(info types.TaskInfo) {
+	if info.StartTime == nil || info.CompleteTime == nil {
+		return
+	}
+	result := taskResultSuccess
+	if info.State == types.TaskInfoStateError {
+		result = taskResultError
+	}
+	taskDuration.WithLabelValues(info.DescriptionId, result).Observe(info.CompleteTime.Sub(*info.StartTime).Seconds())
+}
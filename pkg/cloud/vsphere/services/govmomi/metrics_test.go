@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestObserveTask(t *testing.T) {
+	start := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	complete := start.Add(20 * time.Second)
+
+	taskDuration.Reset()
+	observeTask(types.TaskInfo{DescriptionId: "VirtualMachine.clone", State: types.TaskInfoStateSuccess, StartTime: &start, CompleteTime: &complete})
+	observeTask(types.TaskInfo{DescriptionId: "VirtualMachine.powerOn", State: types.TaskInfoStateError, StartTime: &start, CompleteTime: &start})
+	// Tasks that have not completed are not observed.
+	observeTask(types.TaskInfo{DescriptionId: "VirtualMachine.reconfigure", State: types.TaskInfoStateRunning, StartTime: &start})
+
+	expected := `
+# HELP capv_vsphere_task_duration_seconds The duration of vSphere tasks by task type, ex. VirtualMachine.clone, and result.
+# TYPE capv_vsphere_task_duration_seconds histogram
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="1"} 1
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="5"} 1
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="10"} 1
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="30"} 1
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="60"} 1
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="120"} 1
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="300"} 1
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="600"} 1
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="1800"} 1
+capv_vsphere_task_duration_seconds_bucket{result="error",task="VirtualMachine.powerOn",le="+Inf"} 1
+capv_vsphere_task_duration_seconds_sum{result="error",task="VirtualMachine.powerOn"} 0
+capv_vsphere_task_duration_seconds_count{result="error",task="VirtualMachine.powerOn"} 1
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="1"} 0
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="5"} 0
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="10"} 0
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="30"} 1
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="60"} 1
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="120"} 1
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="300"} 1
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="600"} 1
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="1800"} 1
+capv_vsphere_task_duration_seconds_bucket{result="success",task="VirtualMachine.clone",le="+Inf"} 1
+capv_vsphere_task_duration_seconds_sum{result="success",task="VirtualMachine.clone"} 20
+capv_vsphere_task_duration_seconds_count{result="success",task="VirtualMachine.clone"} 1
+`
+	if err := testutil.CollectAndCompare(taskDuration, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
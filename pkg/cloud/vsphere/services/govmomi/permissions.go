@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// machineErrorFunc returns a *capierrors.MachineError with a reason specific
+// to the failed operation, ex. capierrors.CreateMachine.
+type machineErrorFunc func(msg string, args ...interface{}) *capierrors.MachineError
+
+// permissionError returns a *capierrors.MachineError if the provided error was
+// caused by the vCenter user lacking a privilege required by the operation.
+// Retrying the operation does not help until the user's privileges are
+// fixed, so an event naming the denied operation is emitted as well. Any
+// other error is returned as-is.
+func permissionError(ctx *context.MachineContext, op string, err error, newErr machineErrorFunc) error {
+	noPermission := getNoPermissionFault(services.Fault(err))
+	if noPermission == nil {
+		return err
+	}
+	return newPermissionError(ctx, op, noPermission, newErr)
+}
+
+// taskPermissionError is like permissionError, but for the fault of a
+// failed task. Nil is returned if the task did not fail due to a missing
+// privilege.
+func taskPermissionError(ctx *context.MachineContext, op string, task *types.TaskInfo, newErr machineErrorFunc) error {
+	if task.Error == nil {
+		return nil
+	}
+	noPermission := getNoPermissionFault(task.Error.Fault)
+	if noPermission == nil {
+		return nil
+	}
+	return newPermissionError(ctx, op, noPermission, newErr)
+}
+
+func newPermissionError(ctx *context.MachineContext, op string, noPermission *types.NoPermission, newErr machineErrorFunc) error {
+	record.Warnf(ctx.VSphereMachine, "PermissionDenied",
+		"permission to %s was denied for user %q: missing privilege %q on %s %q",
+		op, ctx.User(), noPermission.PrivilegeId, noPermission.Object.Type, noPermission.Object.Value)
+	return services.WithKind(newErr("permission to %s for %q was denied for user %q: missing privilege %q on %s %q",
+		op, ctx, ctx.User(), noPermission.PrivilegeId, noPermission.Object.Type, noPermission.Object.Value),
+		services.PermissionDeniedErrorKind)
+}
+
+// getNoPermissionFault returns the provided fault if it is a NoPermission
+// fault, otherwise nil.
+func getNoPermissionFault(f interface{}) *types.NoPermission {
+	switch f := f.(type) {
+	case types.NoPermission:
+		return &f
+	case *types.NoPermission:
+		return f
+	}
+	return nil
+}
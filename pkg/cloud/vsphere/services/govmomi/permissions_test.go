@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
+)
+
+func TestPermissionError(t *testing.T) {
+	noPermission := &types.NoPermission{
+		Object:      types.ManagedObjectReference{Type: "Folder", Value: "group-v3"},
+		PrivilegeId: "VirtualMachine.Inventory.CreateFromExisting",
+	}
+
+	testCases := []struct {
+		name          string
+		err           error
+		expectedError bool
+	}{
+		{
+			name: "other error",
+			err:  errors.New("invalid template"),
+		},
+		{
+			name:          "no-permission",
+			err:           soap.WrapVimFault(noPermission),
+			expectedError: true,
+		},
+		{
+			name:          "wrapped-no-permission",
+			err:           errors.Wrap(soap.WrapVimFault(noPermission), "clone failed"),
+			expectedError: true,
+		},
+		{
+			name:          "soap-no-permission",
+			err:           newSoapFaultError(*noPermission),
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := newPermissionTestContext()
+			err := permissionError(ctx, "create vm", tc.err, capierrors.CreateMachine)
+			machineErr, ok := errors.Cause(err).(*capierrors.MachineError)
+			if !tc.expectedError {
+				if err != tc.err {
+					t.Fatalf("expected error %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expected machine error, got %v", err)
+			}
+			if machineErr.Reason != capierrors.CreateMachineError {
+				t.Errorf("expected reason %q, got %q", capierrors.CreateMachineError, machineErr.Reason)
+			}
+		})
+	}
+}
+
+func TestTaskPermissionError(t *testing.T) {
+	testCases := []struct {
+		name          string
+		info          types.TaskInfo
+		expectedError bool
+	}{
+		{
+			name: "no fault",
+			info: types.TaskInfo{State: types.TaskInfoStateError},
+		},
+		{
+			name: "other fault",
+			info: types.TaskInfo{
+				State: types.TaskInfoStateError,
+				Error: &types.LocalizedMethodFault{Fault: &types.InvalidState{}},
+			},
+		},
+		{
+			name: "no-permission",
+			info: types.TaskInfo{
+				State: types.TaskInfoStateError,
+				Error: &types.LocalizedMethodFault{Fault: &types.NoPermission{PrivilegeId: "Datastore.AllocateSpace"}},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := newPermissionTestContext()
+			err := taskPermissionError(ctx, "create vm", &tc.info, capierrors.CreateMachine)
+			if _, ok := errors.Cause(err).(*capierrors.MachineError); ok != tc.expectedError {
+				t.Fatalf("expected machine error=%v, got %v", tc.expectedError, err)
+			}
+			if actual := services.IsPermissionDeniedError(err); actual != tc.expectedError {
+				t.Fatalf("expected permission denied error=%v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func newPermissionTestContext() *context.MachineContext {
+	return &context.MachineContext{
+		ClusterContext: &context.ClusterContext{
+			Cluster: &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+			},
+			VSphereCluster: &infrav1.VSphereCluster{},
+		},
+		Machine: &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+		},
+		VSphereMachine: &infrav1.VSphereMachine{},
+	}
+}
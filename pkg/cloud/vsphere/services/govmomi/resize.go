@@ -0,0 +1,293 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// vmResize describes the CPUs and memory a VM is resized to.
+type vmResize struct {
+	numCPUs           int32
+	numCoresPerSocket int32
+	memoryMiB         int64
+
+	// requiresPowerOff is true if the VM must be powered off to be resized.
+	requiresPowerOff bool
+}
+
+func (r vmResize) String() string {
+	return fmt.Sprintf("%d CPUs with %d cores per socket and %dMiB of memory", r.numCPUs, r.numCoresPerSocket, r.memoryMiB)
+}
+
+// reconcileCPUAndMemory resizes the VM when its CPUs or memory differ from
+// the machine's NumCPUs, NumCoresPerSocket, or MemoryMiB. A powered on VM is
+// resized in place when hot-add allows it. Otherwise its guest is shut down,
+// the VM is powered off and resized, and the VM is powered back on, which
+// the resize annotation tracks across reconciles. The VM is powered back on
+// as well if the resize fails, and a failed resize is not tried again until
+// the machine's CPUs or memory change, so the VM is not power cycled by
+// every reconcile. Each step is a task recorded in the machine's task
+// reference, so false is returned while a step is started.
+func (vms *VMService) reconcileCPUAndMemory(ctx *context.MachineContext) (bool, error) {
+	spec := ctx.VSphereMachine.Spec
+	phase, resizing := ctx.VSphereMachine.Annotations[constants.ResizeAnnotationLabel]
+	if spec.NumCPUs <= 0 && spec.NumCoresPerSocket <= 0 && spec.MemoryMiB <= 0 && !resizing {
+		return true, nil
+	}
+
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, *getMoRef(ctx), []string{"config", "runtime.powerState"}, &obj); err != nil {
+		return false, errors.Wrapf(err, "unable to get cpu and memory of vm %q", ctx)
+	}
+	if obj.Config == nil {
+		return true, nil
+	}
+	powerState := obj.Runtime.PowerState
+	vm, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	resize, err := getVMResize(ctx, obj.Config, powerState)
+	if err != nil {
+		// A VM powered off for a resize is not left powered off because the
+		// machine's CPUs or memory were changed to values vSphere refuses.
+		if ok, powerOnErr := vms.finishResize(ctx, powerState); powerOnErr != nil || !ok {
+			return false, powerOnErr
+		}
+		return false, err
+	}
+	if resize == nil {
+		// The snapshot taken before a resize is removed once the resize is
+		// seen to have been applied.
+		if spec.SnapshotBeforeUpdate {
+			if ok, err := removeUpdateSnapshots(ctx, vm, resizeUpdate); err != nil || !ok {
+				return false, err
+			}
+		}
+		return vms.finishResize(ctx, powerState)
+	}
+
+	hw := obj.Config.Hardware
+	switch {
+	case phase == resizeFailedPrefix+resize.String():
+		ctx.Logger.V(4).Info("skipping resize that failed", "resize", resize.String())
+		return true, nil
+	case phase == resizeReconfiguring:
+		// The resize task completed, yet the VM does not match the machine.
+		message := fmt.Sprintf("failed to resize vm %q to %s, the resize is not retried until the machine's CPUs or memory change",
+			ctx.VSphereMachine.Name, resize)
+		if spec.SnapshotBeforeUpdate {
+			snapshot, err := getRetainedUpdateSnapshot(ctx, vm, resizeUpdate)
+			if err != nil {
+				return false, err
+			}
+			if snapshot != "" {
+				message += fmt.Sprintf(", snapshot %q was retained for recovery", snapshot)
+			}
+		}
+		record.Warnf(ctx.VSphereMachine, "ResizeFailed", "%s", message)
+		setResizePhase(ctx, resizeFailedPrefix+resize.String())
+		return vms.powerOnAfterResize(ctx, powerState)
+	}
+
+	if spec.SnapshotBeforeUpdate {
+		if ok, err := takeUpdateSnapshot(ctx, vm, resizeUpdate); err != nil || !ok {
+			return false, err
+		}
+	}
+
+	if resize.requiresPowerOff {
+		if phase != resizePoweringOff {
+			ctx.Logger.V(4).Info("powering off vm to resize it", "resize", resize.String())
+			if util.IsControlPlaneMachine(ctx.Machine) {
+				record.Warnf(ctx.VSphereMachine, "DisruptiveResize", "powering off control plane vm %q to resize it, the control plane is degraded until it is powered back on",
+					ctx.VSphereMachine.Name)
+			}
+			setResizePhase(ctx, resizePoweringOff)
+		}
+		if ok, err := vms.reconcileGuestShutdown(ctx); err != nil || !ok {
+			return false, err
+		}
+		task, err := vms.powerOffVM(ctx)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to trigger power off op for vm %q", ctx)
+		}
+		ctx.VSphereMachine.Status.TaskRef = task
+		ctx.Logger.V(6).Info("reenqueue to wait for power off before resize", "task", task)
+		return false, nil
+	}
+
+	// A VM that was powered off for the resize is resized now.
+	if phase == resizePoweringOff {
+		forgetGuestShutdown(ctx)
+		setResizePhase(ctx, resizeReconfiguring)
+	}
+	ctx.Logger.V(4).Info("resizing vm",
+		"current-num-cpus", hw.NumCPU, "requested-num-cpus", resize.numCPUs,
+		"current-num-cores-per-socket", hw.NumCoresPerSocket, "requested-num-cores-per-socket", resize.numCoresPerSocket,
+		"current-memory-mib", hw.MemoryMB, "requested-memory-mib", resize.memoryMiB)
+	task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+		NumCPUs:           resize.numCPUs,
+		NumCoresPerSocket: resize.numCoresPerSocket,
+		MemoryMB:          resize.memoryMiB,
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to trigger resize op for vm %q", ctx)
+	}
+	record.Eventf(ctx.VSphereMachine, "Resizing", "resizing vm %q from %d CPUs and %dMiB of memory to %d CPUs and %dMiB of memory",
+		ctx.VSphereMachine.Name, hw.NumCPU, hw.MemoryMB, resize.numCPUs, resize.memoryMiB)
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for resize op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return false, nil
+}
+
+// finishResize removes the resize annotation once the VM matches the
+// machine, and powers the VM back on if it was powered off for the resize.
+// False is returned if the VM is being powered on.
+func (vms *VMService) finishResize(ctx *context.MachineContext, powerState types.VirtualMachinePowerState) (bool, error) {
+	phase, resizing := ctx.VSphereMachine.Annotations[constants.ResizeAnnotationLabel]
+	if resizing && !strings.HasPrefix(phase, resizeFailedPrefix) {
+		ok, err := vms.powerOnAfterResize(ctx, powerState)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			delete(ctx.VSphereMachine.Annotations, constants.ResizeAnnotationLabel)
+			return false, nil
+		}
+	}
+	delete(ctx.VSphereMachine.Annotations, constants.ResizeAnnotationLabel)
+	return true, nil
+}
+
+// powerOnAfterResize powers on the VM if it is powered off, which is the
+// case for a VM that was powered off for a resize. False is returned if the
+// VM is being powered on.
+func (vms *VMService) powerOnAfterResize(ctx *context.MachineContext, powerState types.VirtualMachinePowerState) (bool, error) {
+	forgetGuestShutdown(ctx)
+	if powerState != types.VirtualMachinePowerStatePoweredOff {
+		return true, nil
+	}
+	task, err := vms.powerOnVM(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to trigger power on op for vm %q", ctx)
+	}
+	ctx.VSphereMachine.Status.TaskRef = task
+	ctx.Logger.V(6).Info("reenqueue to wait for power on after resize", "task", task)
+	return false, nil
+}
+
+// setResizePhase records the progress of a resize that powers off the VM
+// in the machine's resize annotation.
+func setResizePhase(ctx *context.MachineContext, phase string) {
+	if ctx.VSphereMachine.Annotations == nil {
+		ctx.VSphereMachine.Annotations = map[string]string{}
+	}
+	ctx.VSphereMachine.Annotations[constants.ResizeAnnotationLabel] = phase
+}
+
+// getVMResize returns how a VM with the provided configuration and power
+// state is resized to the machine's CPUs and memory, or nil if the VM already
+// matches the machine. A *capierrors.MachineError is returned if vSphere
+// would refuse the machine's CPUs or memory.
+func getVMResize(
+	ctx *context.MachineContext,
+	config *types.VirtualMachineConfigInfo,
+	powerState types.VirtualMachinePowerState) (*vmResize, error) {
+
+	spec := ctx.VSphereMachine.Spec
+	hw := config.Hardware
+	// VMs that do not report their cores per socket have one.
+	if hw.NumCoresPerSocket <= 0 {
+		hw.NumCoresPerSocket = 1
+	}
+
+	resize := &vmResize{
+		numCPUs:           hw.NumCPU,
+		numCoresPerSocket: hw.NumCoresPerSocket,
+		memoryMiB:         int64(hw.MemoryMB),
+	}
+	if spec.NumCPUs > 0 {
+		// VMs are cloned with no fewer than the minimum number of CPUs.
+		resize.numCPUs = spec.NumCPUs
+		if resize.numCPUs < minNumCPUs {
+			resize.numCPUs = minNumCPUs
+		}
+	}
+	switch {
+	case spec.NumCoresPerSocket > 0:
+		resize.numCoresPerSocket = spec.NumCoresPerSocket
+	case resize.numCPUs%resize.numCoresPerSocket != 0:
+		// VMs are cloned with a single socket unless the machine sets its
+		// cores per socket, so a VM whose cores per socket do not divide its
+		// new number of CPUs, such as a VM cloned with 2 CPUs that is
+		// resized to 3, is resized to a single socket as well. Other VMs
+		// keep their cores per socket so CPUs can still be hot-added.
+		resize.numCoresPerSocket = resize.numCPUs
+	}
+	if spec.MemoryMiB > 0 {
+		resize.memoryMiB = spec.MemoryMiB
+	}
+
+	if resize.numCPUs == hw.NumCPU && resize.numCoresPerSocket == hw.NumCoresPerSocket && resize.memoryMiB == int64(hw.MemoryMB) {
+		return nil, nil
+	}
+
+	if resize.numCPUs%resize.numCoresPerSocket != 0 {
+		return nil, capierrors.InvalidMachineConfiguration("unable to resize vm %q to %d CPUs: the number of CPUs must be a multiple of the %d cores per socket",
+			ctx, resize.numCPUs, resize.numCoresPerSocket)
+	}
+	if resize.memoryMiB%memoryMiBMultiple != 0 {
+		return nil, capierrors.InvalidMachineConfiguration("unable to resize vm %q to %dMiB of memory: memory must be a multiple of %dMiB",
+			ctx, resize.memoryMiB, memoryMiBMultiple)
+	}
+
+	if powerState != types.VirtualMachinePowerStatePoweredOn {
+		return resize, nil
+	}
+
+	// vSphere can add CPUs and memory to a powered on VM that enables
+	// hot-add, but never remove them or change the VM's cores per socket.
+	cpuHotAdd := config.CpuHotAddEnabled != nil && *config.CpuHotAddEnabled
+	memoryHotAdd := config.MemoryHotAddEnabled != nil && *config.MemoryHotAddEnabled
+	switch {
+	case resize.numCoresPerSocket != hw.NumCoresPerSocket:
+		resize.requiresPowerOff = true
+	case resize.numCPUs < hw.NumCPU, resize.numCPUs > hw.NumCPU && !cpuHotAdd:
+		resize.requiresPowerOff = true
+	case resize.memoryMiB < int64(hw.MemoryMB):
+		resize.requiresPowerOff = true
+	case resize.memoryMiB > int64(hw.MemoryMB):
+		// Memory cannot be hot-added beyond the VM's hot-plug limit.
+		resize.requiresPowerOff = !memoryHotAdd ||
+			(config.HotPlugMemoryLimit > 0 && resize.memoryMiB > config.HotPlugMemoryLimit)
+	}
+	return resize, nil
+}
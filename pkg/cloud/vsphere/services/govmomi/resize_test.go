@@ -0,0 +1,296 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func TestGetVMResize(t *testing.T) {
+	enabled := true
+	testCases := []struct {
+		name               string
+		spec               infrav1.VSphereMachineSpec
+		numCPUs            int32
+		numCoresPerSocket  int32
+		hotAdd             bool
+		powerState         vimtypes.VirtualMachinePowerState
+		expected           *vmResize
+		expectMachineError bool
+	}{
+		{
+			name:       "unchanged",
+			spec:       infrav1.VSphereMachineSpec{NumCPUs: 2, MemoryMiB: 2048},
+			powerState: vimtypes.VirtualMachinePowerStatePoweredOn,
+		},
+		{
+			name:       "below minimum cpus",
+			spec:       infrav1.VSphereMachineSpec{NumCPUs: 1},
+			powerState: vimtypes.VirtualMachinePowerStatePoweredOn,
+		},
+		{
+			name:       "grow powered off",
+			spec:       infrav1.VSphereMachineSpec{NumCPUs: 4, MemoryMiB: 4096},
+			powerState: vimtypes.VirtualMachinePowerStatePoweredOff,
+			expected:   &vmResize{numCPUs: 4, numCoresPerSocket: 1, memoryMiB: 4096},
+		},
+		{
+			name:       "grow without hot-add",
+			spec:       infrav1.VSphereMachineSpec{NumCPUs: 4, MemoryMiB: 4096},
+			powerState: vimtypes.VirtualMachinePowerStatePoweredOn,
+			expected:   &vmResize{numCPUs: 4, numCoresPerSocket: 1, memoryMiB: 4096, requiresPowerOff: true},
+		},
+		{
+			name:       "grow with hot-add",
+			spec:       infrav1.VSphereMachineSpec{NumCPUs: 4, MemoryMiB: 4096},
+			hotAdd:     true,
+			powerState: vimtypes.VirtualMachinePowerStatePoweredOn,
+			expected:   &vmResize{numCPUs: 4, numCoresPerSocket: 1, memoryMiB: 4096},
+		},
+		{
+			name:       "grow beyond hot-plug limit",
+			spec:       infrav1.VSphereMachineSpec{MemoryMiB: 16384},
+			hotAdd:     true,
+			powerState: vimtypes.VirtualMachinePowerStatePoweredOn,
+			expected:   &vmResize{numCPUs: 2, numCoresPerSocket: 1, memoryMiB: 16384, requiresPowerOff: true},
+		},
+		{
+			name:       "shrink with hot-add",
+			spec:       infrav1.VSphereMachineSpec{MemoryMiB: 1024},
+			hotAdd:     true,
+			powerState: vimtypes.VirtualMachinePowerStatePoweredOn,
+			expected:   &vmResize{numCPUs: 2, numCoresPerSocket: 1, memoryMiB: 1024, requiresPowerOff: true},
+		},
+		{
+			name:       "change cores per socket with hot-add",
+			spec:       infrav1.VSphereMachineSpec{NumCoresPerSocket: 2},
+			hotAdd:     true,
+			powerState: vimtypes.VirtualMachinePowerStatePoweredOn,
+			expected:   &vmResize{numCPUs: 2, numCoresPerSocket: 2, memoryMiB: 2048, requiresPowerOff: true},
+		},
+		{
+			name:              "cloned with default cores per socket",
+			spec:              infrav1.VSphereMachineSpec{NumCPUs: 3},
+			numCoresPerSocket: 2,
+			powerState:        vimtypes.VirtualMachinePowerStatePoweredOff,
+			expected:          &vmResize{numCPUs: 3, numCoresPerSocket: 3, memoryMiB: 2048},
+		},
+		{
+			name:              "cloned with default cores per socket while powered on",
+			spec:              infrav1.VSphereMachineSpec{NumCPUs: 6},
+			numCPUs:           4,
+			numCoresPerSocket: 4,
+			powerState:        vimtypes.VirtualMachinePowerStatePoweredOn,
+			expected:          &vmResize{numCPUs: 6, numCoresPerSocket: 6, memoryMiB: 2048, requiresPowerOff: true},
+		},
+		{
+			name:              "cloned with default cores per socket with hot-add",
+			spec:              infrav1.VSphereMachineSpec{NumCPUs: 4},
+			numCoresPerSocket: 2,
+			hotAdd:            true,
+			powerState:        vimtypes.VirtualMachinePowerStatePoweredOn,
+			expected:          &vmResize{numCPUs: 4, numCoresPerSocket: 2, memoryMiB: 2048},
+		},
+		{
+			name:               "cpus not a multiple of cores per socket",
+			spec:               infrav1.VSphereMachineSpec{NumCPUs: 3, NumCoresPerSocket: 2},
+			powerState:         vimtypes.VirtualMachinePowerStatePoweredOff,
+			expectMachineError: true,
+		},
+		{
+			name:               "memory not a multiple of 4MiB",
+			spec:               infrav1.VSphereMachineSpec{MemoryMiB: 2050},
+			powerState:         vimtypes.VirtualMachinePowerStatePoweredOff,
+			expectMachineError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &context.MachineContext{
+				ClusterContext: &context.ClusterContext{
+					Cluster: &clusterv1.Cluster{
+						ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					},
+				},
+				Machine: &clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				VSphereMachine: &infrav1.VSphereMachine{Spec: tc.spec},
+			}
+			config := &vimtypes.VirtualMachineConfigInfo{
+				Hardware: vimtypes.VirtualHardware{
+					NumCPU:            2,
+					NumCoresPerSocket: 1,
+					MemoryMB:          2048,
+				},
+				HotPlugMemoryLimit: 8192,
+			}
+			if tc.numCPUs > 0 {
+				config.Hardware.NumCPU = tc.numCPUs
+			}
+			if tc.numCoresPerSocket > 0 {
+				config.Hardware.NumCoresPerSocket = tc.numCoresPerSocket
+			}
+			if tc.hotAdd {
+				config.CpuHotAddEnabled = &enabled
+				config.MemoryHotAddEnabled = &enabled
+			}
+
+			actual, err := getVMResize(ctx, config, tc.powerState)
+			if tc.expectMachineError {
+				if _, ok := err.(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tc.expected == nil && actual != nil:
+				t.Fatalf("expected no resize, got %+v", *actual)
+			case tc.expected != nil && actual == nil:
+				t.Fatalf("expected resize %+v, got none", *tc.expected)
+			case tc.expected != nil && *tc.expected != *actual:
+				t.Fatalf("expected resize %+v, got %+v", *tc.expected, *actual)
+			}
+		})
+	}
+}
+
+func TestReconcileCPUAndMemory(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	defer func(grace time.Duration) { config.DefaultPowerOffGracePeriod = grace }(config.DefaultPowerOffGracePeriod)
+	config.DefaultPowerOffGracePeriod = time.Minute
+
+	simVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	simVM.Guest.ToolsRunningStatus = string(vimtypes.VirtualMachineToolsRunningStatusGuestToolsRunning)
+
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{
+			MachineRef: simVM.Reference().Value,
+			NumCPUs:    4,
+			MemoryMiB:  4096,
+		},
+	})
+	forgetGuestShutdown(machineContext)
+	defer forgetGuestShutdown(machineContext)
+
+	// Each step is started by a reconcile and waited for by the next one.
+	var vms VMService
+	reconcileStep := func() {
+		t.Helper()
+		ok, err := vms.reconcileCPUAndMemory(machineContext)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok {
+			t.Fatal("expected resize to be in progress")
+		}
+		if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+			t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+		}
+	}
+	resizePhase := func() string {
+		return machineContext.VSphereMachine.Annotations[constants.ResizeAnnotationLabel]
+	}
+
+	// The simulator's VM is powered on and does not enable hot-add, so its
+	// guest is shut down before it is resized.
+	reconcileStep()
+	if simVM.Runtime.PowerState != vimtypes.VirtualMachinePowerStatePoweredOff {
+		t.Fatalf("expected guest to be shut down, got power state %q", simVM.Runtime.PowerState)
+	}
+	if phase := resizePhase(); phase != resizePoweringOff {
+		t.Fatalf("expected resize phase %q, got %q", resizePoweringOff, phase)
+	}
+
+	reconcileStep()
+	if actual := simVM.Config.Hardware.NumCPU; actual != 4 {
+		t.Errorf("expected 4 CPUs, got %d", actual)
+	}
+	if actual := simVM.Config.Hardware.MemoryMB; actual != 4096 {
+		t.Errorf("expected 4096MiB of memory, got %d", actual)
+	}
+
+	// The resized VM is powered back on.
+	reconcileStep()
+	if simVM.Runtime.PowerState != vimtypes.VirtualMachinePowerStatePoweredOn {
+		t.Fatalf("expected vm to be powered on, got power state %q", simVM.Runtime.PowerState)
+	}
+	if phase, ok := machineContext.VSphereMachine.Annotations[constants.ResizeAnnotationLabel]; ok {
+		t.Fatalf("expected resize annotation to be removed, got %q", phase)
+	}
+	if ok, err := vms.reconcileCPUAndMemory(machineContext); err != nil || !ok {
+		t.Fatalf("expected no resize, got %v, %v", ok, err)
+	}
+
+	// A VM that does not match the machine once it is reconfigured is powered
+	// back on, the snapshot taken before the resize is reported as retained,
+	// and the failed resize is not tried again.
+	vm, err := getVMfromMachineRef(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := takeUpdateSnapshot(machineContext, vm, resizeUpdate); err != nil {
+		t.Fatal(err)
+	}
+	if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+		t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+	}
+	snapshot, err := getRetainedUpdateSnapshot(machineContext, vm, resizeUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machineContext.VSphereMachine.Spec.SnapshotBeforeUpdate = true
+	simVM.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOff
+	simVM.Summary.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOff
+	machineContext.VSphereMachine.Spec.NumCPUs = 8
+	machineContext.VSphereMachine.Annotations[constants.ResizeAnnotationLabel] = resizeReconfiguring
+	reconcileStep()
+	if simVM.Runtime.PowerState != vimtypes.VirtualMachinePowerStatePoweredOn {
+		t.Fatalf("expected vm to be powered on, got power state %q", simVM.Runtime.PowerState)
+	}
+	if phase := resizePhase(); !strings.HasPrefix(phase, resizeFailedPrefix) {
+		t.Fatalf("expected failed resize phase, got %q", phase)
+	}
+	if snapshot == "" || !testEvents.has("ResizeFailed", snapshot) {
+		t.Fatalf("expected ResizeFailed event naming snapshot %q", snapshot)
+	}
+	if ok, err := vms.reconcileCPUAndMemory(machineContext); err != nil || !ok {
+		t.Fatalf("expected failed resize to be skipped, got %v, %v", ok, err)
+	}
+	if simVM.Runtime.PowerState != vimtypes.VirtualMachinePowerStatePoweredOn {
+		t.Fatalf("expected vm to stay powered on, got power state %q", simVM.Runtime.PowerState)
+	}
+}
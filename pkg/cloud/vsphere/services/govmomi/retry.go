@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// cloneRetries tracks the clones of each machine that failed with a
+// transient error, so the clone is retried with backoff across reconciles.
+var cloneRetries = newRetryTracker()
+
+// retryTracker counts the consecutive attempts of an operation that failed
+// with a transient error for each machine.
+type retryTracker struct {
+	sync.Mutex
+
+	attempts map[string]int
+}
+
+func newRetryTracker() *retryTracker {
+	return &retryTracker{attempts: map[string]int{}}
+}
+
+// next records a failed attempt of the machine's operation and returns how
+// long to wait before the operation is attempted again. False is returned,
+// and the attempts are forgotten, once the operation has been attempted
+// CloneRetryMaxAttempts times.
+func (r *retryTracker) next(machine string) (time.Duration, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.attempts[machine]++
+	attempt := r.attempts[machine]
+	if attempt >= constants.CloneRetryMaxAttempts {
+		delete(r.attempts, machine)
+		return 0, false
+	}
+	interval := float64(constants.CloneRetryInitialInterval) * math.Pow(constants.CloneRetryFactor, float64(attempt-1))
+	return wait.Jitter(time.Duration(interval), constants.CloneRetryJitter), true
+}
+
+// reset forgets the failed attempts of the machine's operation.
+func (r *retryTracker) reset(machine string) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.attempts, machine)
+}
+
+// transientCloneError returns a *services.RequeueAfterError if the
+// machine's clone failed with the provided transient error, so the clone is
+// retried with backoff. Nil is returned if the error is not transient. Once
+// the clone has failed with transient errors CloneRetryMaxAttempts times, the
+// error is returned without its transient kind, so it is reported like any
+// other error instead of being requeued as transient again.
+func transientCloneError(ctx *context.MachineContext, err error) error {
+	if !isTransientError(err) {
+		resetCloneRetries(ctx)
+		return nil
+	}
+	backoff, ok := cloneRetries.next(cloneSlotKey(ctx))
+	if !ok {
+		record.Warnf(ctx.VSphereMachine, "CloneRetriesExhausted", "giving up retrying vm clone after %d attempts: %v", constants.CloneRetryMaxAttempts, err)
+		return services.WithKind(err, services.UnknownErrorKind)
+	}
+	ctx.Logger.V(2).Info("retrying clone after transient error", "requeue-after", backoff, "reason", err.Error())
+	record.Warnf(ctx.VSphereMachine, "CloneRetry", "retrying vm clone in %s after transient error: %v", backoff, err)
+	return &services.RequeueAfterError{
+		RequeueAfter: backoff,
+		Reason:       fmt.Sprintf("transient error cloning vm for %q", ctx),
+	}
+}
+
+// resetCloneRetries forgets the machine's clones that failed with a
+// transient error once the machine's VM exists or is no longer wanted.
+func resetCloneRetries(ctx *context.MachineContext) {
+	cloneRetries.reset(cloneSlotKey(ctx))
+}
+
+// isTransientError returns a flag indicating whether or not the provided error
+// is the result of a vCenter fault that is expected to clear on its own, such
+// as an object being busy or a host being briefly unreachable.
+func isTransientError(err error) bool {
+	return services.IsTransientError(err)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+)
+
+func TestIsTransientError(t *testing.T) {
+	testCases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{
+			name:      "nil",
+			err:       nil,
+			transient: false,
+		},
+		{
+			name:      "plain-error",
+			err:       errors.New("invalid template"),
+			transient: false,
+		},
+		{
+			name:      "invalid-state",
+			err:       soap.WrapVimFault(&types.InvalidState{}),
+			transient: true,
+		},
+		{
+			name:      "wrapped-task-in-progress",
+			err:       errors.Wrap(soap.WrapVimFault(&types.TaskInProgress{}), "clone failed"),
+			transient: true,
+		},
+		{
+			name:      "soap-concurrent-access",
+			err:       newSoapFaultError(types.ConcurrentAccess{}),
+			transient: true,
+		},
+		{
+			name:      "no-permission",
+			err:       soap.WrapVimFault(&types.NoPermission{}),
+			transient: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isTransientError(tc.err); actual != tc.transient {
+				t.Fatalf("expected transient=%v, got %v", tc.transient, actual)
+			}
+		})
+	}
+}
+
+func TestRetryTracker(t *testing.T) {
+	r := newRetryTracker()
+
+	var last time.Duration
+	for attempt := 1; attempt < constants.CloneRetryMaxAttempts; attempt++ {
+		backoff, ok := r.next("m1")
+		if !ok {
+			t.Fatalf("expected attempt %d to be retried", attempt)
+		}
+		if backoff <= last {
+			t.Fatalf("expected attempt %d to back off for longer than %s, got %s", attempt, last, backoff)
+		}
+		last = backoff
+	}
+	if _, ok := r.next("m1"); ok {
+		t.Fatal("expected attempts to be exhausted")
+	}
+
+	// Exhausted and reset attempts start over.
+	if backoff, ok := r.next("m1"); !ok || backoff > last {
+		t.Fatalf("expected exhausted attempts to start over, got %s, %t", backoff, ok)
+	}
+	r.reset("m1")
+	if backoff, ok := r.next("m1"); !ok || backoff > last {
+		t.Fatalf("expected reset attempts to start over, got %s, %t", backoff, ok)
+	}
+	if _, ok := r.next("m2"); !ok {
+		t.Fatal("expected attempts of other machines to be tracked separately")
+	}
+}
+
+func newSoapFaultError(fault types.AnyType) error {
+	f := &soap.Fault{}
+	f.Detail.Fault = fault
+	return soap.WrapSoapFault(f)
+}
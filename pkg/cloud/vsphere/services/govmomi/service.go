@@ -18,18 +18,31 @@ package govmomi
 
 import (
 	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
+	govmomitask "github.com/vmware/govmomi/task"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
+	corev1 "k8s.io/api/core/v1"
+	capierrors "sigs.k8s.io/cluster-api/errors"
 
 	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/extra"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/net"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/template"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
 )
 
 // VMService provdes API to interact with the VMs using govmomi
@@ -48,21 +61,57 @@ func (vms *VMService) ReconcileVM(ctx *context.MachineContext) (infrav1.VirtualM
 		State: infrav1.VirtualMachineStatePending,
 	}
 
+	// An existing VM is adopted rather than created.
+	if ctx.VSphereMachine.Spec.ExistingVMUUID != "" && ctx.VSphereMachine.Spec.MachineRef == "" {
+		return vm, adoptExistingVM(ctx)
+	}
+
 	// If there is no pending task or no machine ref then no VM exits, create one
 	if ctx.VSphereMachine.Status.TaskRef == "" && ctx.VSphereMachine.Spec.MachineRef == "" {
-		ref, err := findVMByInstanceUUID(ctx)
+		ref, err := findMachineVM(ctx)
 		if err != nil {
 			return vm, err
 		}
 
+		// A VM with the machine's instance UUID was created by a previous
+		// reconcile that did not record it, so adopt the VM instead of
+		// creating a duplicate.
 		if ref != "" {
-			return vm, errors.Errorf("vm with the same Instance UUID already exists %q", ctx.VSphereMachine.Name)
+			ctx.VSphereMachine.Spec.MachineRef = ref
+			ctx.Logger.V(2).Info("adopted existing vm", "moref-id", ref)
+			releaseCloneSlot(ctx)
+			return vm, nil
+		}
+
+		// Fail before cloning if the machine cannot be created as specified.
+		if err := validateMachine(ctx); err != nil {
+			return vm, err
+		}
+
+		// Queue the clone rather than blocking a worker if too many clones
+		// are already in flight on the machine's server.
+		if !acquireCloneSlot(ctx) {
+			record.Eventf(ctx.VSphereMachine, "CloneQueued", "queued vm clone as %d clones are in flight on %q", config.MaxConcurrentClones, ctx.Server())
+			return vm, &services.RequeueAfterError{
+				RequeueAfter: cloneQueueRequeue,
+				Reason:       fmt.Sprintf("too many clones in flight on %q", ctx.Server()),
+			}
 		}
 
 		// no VM exits, goahead and create a VM
 		if err := createVM(ctx, []byte(*ctx.Machine.Spec.Bootstrap.Data)); err != nil {
-			return vm, err
+			releaseCloneSlot(ctx)
+			if err := transientCloneError(ctx, err); err != nil {
+				return vm, err
+			}
+			return vm, permissionError(ctx, "create vm", err, capierrors.CreateMachine)
 		}
+		message := fmt.Sprintf("cloning VM from template %q", ctx.VSphereMachine.Spec.Template)
+		if ctx.VSphereMachine.Spec.ContentLibraryItem != "" {
+			message = fmt.Sprintf("deploying VM from content library item %q", ctx.VSphereMachine.Spec.ContentLibraryItem)
+		}
+		util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineCloneInProgress, corev1.ConditionTrue, "CloneStarted", message)
+		util.MarkCloneStarted(ctx.VSphereMachine)
 
 		return vm, nil
 	}
@@ -71,6 +120,17 @@ func (vms *VMService) ReconcileVM(ctx *context.MachineContext) (infrav1.VirtualM
 	// from this function's documented workflow (please see the function's
 	// GoDoc comments for more information)
 
+	// Clean up after a failed clone before checking for in-flight tasks as
+	// the latter forgets about failed tasks.
+	if ctx.VSphereMachine.Spec.MachineRef == "" {
+		if ok, err := reconcileFailedCreate(ctx); err != nil || !ok {
+			return vm, err
+		}
+		if ok, err := reconcileCloneTimeout(ctx); err != nil || !ok {
+			return vm, err
+		}
+	}
+
 	// Check for in-flight tasks
 	if inflight, err := hasInFlightTask(ctx); err != nil || inflight {
 		return vm, err
@@ -78,16 +138,31 @@ func (vms *VMService) ReconcileVM(ctx *context.MachineContext) (infrav1.VirtualM
 
 	// Update the MachineRef if not already present
 	if ctx.VSphereMachine.Spec.MachineRef == "" {
-		moRefID, err := findVMByInstanceUUID(ctx)
+		moRefID, err := findMachineVM(ctx)
 		if err != nil {
 			return vm, err
 		}
 		if moRefID != "" {
 			ctx.VSphereMachine.Spec.MachineRef = moRefID
 			ctx.Logger.V(6).Info("discovered moref id", "moref-id", ctx.VSphereMachine.Spec.MachineRef)
+			releaseCloneSlot(ctx)
+			resetCloneRetries(ctx)
+			recordVMCreated(ctx)
+
+			// Tagging is best-effort and does not block provisioning.
+			if ctx.VSphereCluster.Spec.EnableTagging {
+				if err := tagVM(ctx, getMoRef(ctx)); err != nil {
+					ctx.Logger.Error(err, "unable to tag vm")
+					record.Warnf(ctx.VSphereMachine, "TagFailed", "unable to tag vm: %v", err)
+				}
+			}
 		}
 	}
 
+	if err := reconcileMachineRef(ctx); err != nil {
+		return vm, err
+	}
+
 	// Verify if the VM exists
 	obj, err := getVMObject(ctx)
 	if err != nil {
@@ -95,6 +170,33 @@ func (vms *VMService) ReconcileVM(ctx *context.MachineContext) (infrav1.VirtualM
 		ctx.VSphereMachine.Spec.MachineRef = ""
 		return vm, err
 	}
+	// Machines whose instance UUID was not recorded when their VM was
+	// created, such as machines created by earlier versions, record the
+	// instance UUID of the VM they refer to.
+	if ctx.VSphereMachine.Status.InstanceUUID == "" && obj.Config != nil {
+		ctx.VSphereMachine.Status.InstanceUUID = obj.Config.InstanceUuid
+		ctx.Logger.V(4).Info("recorded instance UUID of vm", "instance-uuid", obj.Config.InstanceUuid)
+	}
+	util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineCloneInProgress, corev1.ConditionFalse, "CloneComplete", "")
+	util.MarkProvisioningPhaseCompleted(ctx.VSphereMachine, util.ProvisioningPhaseClone)
+
+	if err := checkVMConnectionState(ctx, obj); err != nil {
+		return vm, err
+	}
+
+	// The control plane anti-affinity rule is reconciled on every pass, so
+	// a rule that failed to be reconfigured, or that is missing VMs of
+	// control plane machines created since, is eventually corrected. A
+	// failure is not returned since the VM can run without the rule.
+	if util.IsControlPlaneMachine(ctx.Machine) {
+		ok, err := reconcileControlPlaneAntiAffinity(ctx, *getMoRef(ctx))
+		if err != nil {
+			ctx.Logger.Error(err, "unable to reconcile control plane anti-affinity rule")
+			record.Warnf(ctx.VSphereMachine, "AntiAffinityFailed", "unable to reconcile control plane anti-affinity rule: %v", err)
+		} else if !ok {
+			return vm, nil
+		}
+	}
 
 	if err := vms.reconcileNetworkStatus(ctx, &vm); err != nil {
 		return vm, nil
@@ -104,14 +206,60 @@ func (vms *VMService) ReconcileVM(ctx *context.MachineContext) (infrav1.VirtualM
 		return vm, err
 	}
 
+	if ok, err := vms.reconcileHardwareVersion(ctx); err != nil || !ok {
+		return vm, err
+	}
+
+	if ok, err := vms.reconcileDiskSize(ctx); err != nil || !ok {
+		return vm, err
+	}
+
+	if ok, err := vms.reconcileCPUAndMemory(ctx); err != nil || !ok {
+		return vm, err
+	}
+
+	if ok, err := vms.reconcileResourceAllocation(ctx); err != nil || !ok {
+		return vm, err
+	}
+
+	if ok, err := vms.reconcileBootstrapISO(ctx, vm); err != nil || !ok {
+		return vm, err
+	}
+
+	if err := vms.reconcileBootOrder(ctx); err != nil {
+		return vm, err
+	}
+
 	if ok, err := vms.reconcilePowerState(ctx); err != nil || !ok {
 		return vm, err
 	}
 
+	if ok, err := vms.reconcileRebootRequest(ctx); err != nil || !ok {
+		return vm, err
+	}
+
+	if ok, err := vms.reconcileGuestTools(ctx); err != nil || !ok {
+		return vm, err
+	}
+
 	if err := vms.reconcileUUIUDs(ctx, &vm, obj); err != nil {
 		return vm, err
 	}
 
+	if err := vms.reconcileResourceStatus(ctx); err != nil {
+		return vm, err
+	}
+
+	if err := vms.reconcileHostStatus(ctx); err != nil {
+		return vm, err
+	}
+
+	if err := vms.reconcileTemplateDrift(ctx); err != nil {
+		return vm, err
+	}
+
+	vms.reconcileImmutableSpec(ctx)
+
 	vm.State = infrav1.VirtualMachineStateReady
 	return vm, nil
 }
@@ -124,63 +272,375 @@ func (vms *VMService) DestroyVM(ctx *context.MachineContext) (infrav1.VirtualMac
 		State: infrav1.VirtualMachineStatePending,
 	}
 
-	if ctx.VSphereMachine.Spec.MachineRef == "" && ctx.VSphereMachine.Status.TaskRef == "" {
-		// vm already deleted
+	// Check for in-flight tasks. A clone that was started before the machine
+	// was deleted must complete before its VM can be destroyed.
+	if inflight, err := hasInFlightTask(ctx); err != nil || inflight {
+		return vm, err
+	}
+	releaseCloneSlot(ctx)
+	resetCloneRetries(ctx)
+
+	// A machine that never adopted its existing VM, such as because the VM
+	// was rejected, has no VM to destroy, and the VM with its
+	// ExistingVMUUID is left alone.
+	if !isExistingVMAdopted(ctx) {
+		ctx.Logger.V(2).Info("existing vm was not adopted, skipping destroy", "uuid", ctx.VSphereMachine.Spec.ExistingVMUUID)
 		vm.State = infrav1.VirtualMachineStateNotFound
 		return vm, nil
 	}
 
-	// check for in-flight tasks
-	if inflight, err := hasInFlightTask(ctx); err != nil || inflight {
+	// Check if the VM actually exists. The VM is found by its instance UUID,
+	// so a VM that was created without being recorded, such as by a clone
+	// that completed after the machine was deleted, is destroyed as well.
+	moRefID, err := findMachineVM(ctx)
+	if err != nil {
 		return vm, err
 	}
-
-	// check if VM actually exists
-	if ctx.VSphereMachine.Spec.MachineRef != "" {
-		moRefID, err := findVMByInstanceUUID(ctx)
-		if err != nil {
+	if moRefID == "" {
+		// No vm exists
+		// remove the MachineRef and set the vm state to notfound
+		if ok, err := deleteBootstrapISO(ctx); err != nil || !ok {
 			return vm, err
 		}
-		if moRefID == "" {
-			// No vm exists
-			// remove the MachineRef and set the vm state to notfound
-			ctx.VSphereMachine.Spec.MachineRef = ""
-			vm.State = infrav1.VirtualMachineStateNotFound
-			return vm, nil
+		ctx.VSphereMachine.Spec.MachineRef = ""
+		vm.State = infrav1.VirtualMachineStateNotFound
+		return vm, nil
+	}
+	// The VM that was found is the machine's VM, even if MachineRef refers
+	// to another VM.
+	if ctx.VSphereMachine.Spec.MachineRef != moRefID {
+		ctx.VSphereMachine.Spec.MachineRef = moRefID
+		ctx.Logger.V(2).Info("adopted existing vm for deletion", "moref-id", moRefID)
+	}
+
+	// A retained VM is left as it is and only forgotten.
+	if ctx.VSphereMachine.Spec.RetainVMOnDelete {
+		if ctx.VSphereCluster.Spec.EnableTagging {
+			if err := deleteMachineTag(ctx); err != nil {
+				ctx.Logger.Error(err, "unable to delete machine tag")
+			}
 		}
+		record.Eventf(ctx.VSphereMachine, "VMRetained", "retained vm %q of deleted machine", ctx.VSphereMachine.Name)
+		ctx.VSphereMachine.Spec.MachineRef = ""
+		vm.State = infrav1.VirtualMachineStateNotFound
+		return vm, nil
 	}
 
 	// VM actually exists
-	// Power off the VM if needed
+	// Power off the VM if needed, after giving its guest a chance to shut
+	// down.
 	powerState, err := vms.getPowerState(ctx)
 	if err != nil {
 		return vm, err
 	}
 	if powerState == infrav1.VirtualMachinePowerStatePoweredOn {
+		if ok, err := vms.reconcileGuestShutdown(ctx); err != nil || !ok {
+			return vm, err
+		}
 		task, err := vms.powerOffVM(ctx)
 		if err != nil {
-			return vm, err
+			return vm, permissionError(ctx, "power off vm", err, capierrors.DeleteMachine)
 		}
 		ctx.VSphereMachine.Status.TaskRef = task
 		// requeue for VM to be powered off
-		ctx.Logger.V(6).Info("reenqueue to wait for power off op")
+		ctx.Logger.V(6).Info("reenqueue to wait for power off op", "task", task)
 		return vm, nil
 	}
+	forgetGuestShutdown(ctx)
+
+	// Removing the machine's tag is best-effort and does not block the VM's
+	// deletion.
+	if ctx.VSphereCluster.Spec.EnableTagging {
+		if err := deleteMachineTag(ctx); err != nil {
+			ctx.Logger.Error(err, "unable to delete machine tag")
+		}
+	}
+
+	// vSphere cannot destroy a VM that is invalid or orphaned, so such a VM
+	// is removed from the inventory instead.
+	obj, err := getVMObject(ctx)
+	if err != nil {
+		return vm, err
+	}
+	if isVMUnrecoverable(obj) {
+		ctx.Logger.V(2).Info("unregistering unrecoverable vm", "connection-state", obj.Runtime.ConnectionState)
+		if err := object.NewVirtualMachine(ctx.Session.Client.Client, obj.Reference()).Unregister(ctx); err != nil {
+			return vm, permissionError(ctx, "unregister vm", errors.Wrapf(err, "failed to unregister vm %q", ctx), capierrors.DeleteMachine)
+		}
+		record.Eventf(ctx.VSphereMachine, "VMUnregistered", "unregistered %s vm %q, its files may remain on the datastore",
+			obj.Runtime.ConnectionState, ctx.VSphereMachine.Name)
+		ctx.VSphereMachine.Spec.MachineRef = ""
+		vm.State = infrav1.VirtualMachineStateNotFound
+		return vm, nil
+	}
+
+	if ok, err := detachKeptDisks(ctx); err != nil || !ok {
+		if err != nil {
+			err = permissionError(ctx, "detach disks", err, capierrors.DeleteMachine)
+		}
+		return vm, err
+	}
 
 	// At this point the VM is not powered on and can be destroyed. Store the
 	// destroy task's reference and return a requeue error.
 	ctx.Logger.V(6).Info("destroying vm")
 	task, err := vms.destroyVM(ctx)
 	if err != nil {
-		return vm, err
+		return vm, permissionError(ctx, "destroy vm", err, capierrors.DeleteMachine)
 	}
 	ctx.VSphereMachine.Status.TaskRef = task
 
 	// Requeue
-	ctx.Logger.V(6).Info("reenqueue to wait for destroy op")
+	ctx.Logger.V(6).Info("reenqueue to wait for destroy op", "task", task)
 	return vm, nil
 }
 
+// reconcileFailedCreate checks whether the task that created the machine's VM
+// failed. If it did, any VM left behind by the task is destroyed so it does
+// not become an orphan, and an error is returned so the VM is created again
+// once the destroy task completes. True is returned if the create task did
+// not fail. Only clone tasks, and the reconfigure tasks of VMs deployed from
+// a content library, are create tasks.
+func reconcileFailedCreate(ctx *context.MachineContext) (bool, error) {
+	if ctx.VSphereMachine.Status.TaskRef == "" {
+		return true, nil
+	}
+	task := getTask(ctx)
+	if task == nil || task.Info.State != types.TaskInfoStateError {
+		return true, nil
+	}
+	switch task.Info.DescriptionId {
+	case cloneTaskDescriptionID, reconfigureTaskDescriptionID:
+	case destroyTaskDescriptionID:
+		return false, reconcileFailedOrphanDestroy(ctx, task.Info)
+	default:
+		return true, nil
+	}
+
+	reason := "unknown error"
+	if task.Info.Error != nil {
+		reason = task.Info.Error.LocalizedMessage
+	}
+	if isHotAddEnabled(ctx) {
+		reason += " (CPU or memory hot-add is enabled for this machine, which some hosts reject for VMs with fixed reservations)"
+	}
+	observeTask(task.Info)
+	ctx.VSphereMachine.Status.TaskRef = ""
+	releaseCloneSlot(ctx)
+	util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineCloneInProgress, corev1.ConditionFalse, "CloneFailed", reason)
+	record.Warnf(ctx.VSphereMachine, "CloneFailed", "failed to create vm: %s", reason)
+
+	if err := destroyOrphanVM(ctx); err != nil {
+		return false, err
+	}
+
+	// The create task is not retried if it failed due to a missing
+	// privilege as it would fail the same way again.
+	if err := taskPermissionError(ctx, "create vm", &task.Info, capierrors.CreateMachine); err != nil {
+		return false, err
+	}
+	if err := taskNoDiskSpaceError(ctx, &task.Info); err != nil {
+		return false, err
+	}
+	// Faults that are expected to clear on their own, such as the template
+	// being busy, are retried with backoff rather than right away.
+	if task.Info.Error != nil {
+		taskErr := errors.Wrapf(govmomitask.Error{LocalizedMethodFault: task.Info.Error}, "failed to create vm for %q", ctx)
+		if err := transientCloneError(ctx, taskErr); err != nil {
+			return false, err
+		}
+	}
+	return false, errors.Errorf("failed to create vm for %q: %s", ctx, reason)
+}
+
+// reconcileFailedOrphanDestroy reports the failure of the task that destroyed
+// the VM left behind by a failed create task and destroys the VM again. The
+// failure is reported separately from the failed create, and does not count
+// as another attempt to clone the VM.
+func reconcileFailedOrphanDestroy(ctx *context.MachineContext, info types.TaskInfo) error {
+	reason := "unknown error"
+	if info.Error != nil {
+		reason = info.Error.LocalizedMessage
+	}
+	observeTask(info)
+	ctx.VSphereMachine.Status.TaskRef = ""
+	record.Warnf(ctx.VSphereMachine, "OrphanDestroyFailed", "failed to destroy vm left behind by failed create: %s", reason)
+
+	if err := destroyOrphanVM(ctx); err != nil {
+		return err
+	}
+	return errors.Errorf("failed to destroy vm left behind by failed create task for %q: %s", ctx, reason)
+}
+
+// destroyOrphanVM destroys the VM left behind by a failed create task, if
+// any, and records the destroy op in the machine's task reference.
+func destroyOrphanVM(ctx *context.MachineContext) error {
+	ref, err := findVMByInstanceUUID(ctx)
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		return nil
+	}
+	ctx.Logger.V(2).Info("destroying vm left behind by failed create task", "moref-id", ref)
+	orphan := object.NewVirtualMachine(ctx.Session.Client.Client, types.ManagedObjectReference{
+		Type:  "VirtualMachine",
+		Value: ref,
+	})
+	task, err := orphan.Destroy(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to destroy vm left behind by failed create task for %q", ctx)
+	}
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for destroy op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return nil
+}
+
+// reconcileCloneTimeout cancels the machine's clone task once it has run for
+// longer than the clone timeout, so a clone stalled by vSphere, ex. by a
+// storage outage, does not hold the machine and its clone slot forever. The
+// cancelled task fails, after which reconcileFailedCreate destroys the
+// partial VM and the clone is retried. Tasks that cannot be cancelled are
+// waited for.
+func reconcileCloneTimeout(ctx *context.MachineContext) (bool, error) {
+	if config.DefaultCloneTimeout <= 0 || ctx.VSphereMachine.Status.TaskRef == "" {
+		return true, nil
+	}
+	task := getTask(ctx)
+	if task == nil || !isCloneTimedOut(task.Info, config.DefaultCloneTimeout, time.Now()) {
+		return true, nil
+	}
+	if !task.Info.Cancelable {
+		ctx.Logger.V(2).Info("clone task timed out but cannot be cancelled", "task", task.Reference().Value, "timeout", config.DefaultCloneTimeout)
+		return true, nil
+	}
+
+	if err := object.NewTask(ctx.Session.Client.Client, task.Reference()).Cancel(ctx); err != nil {
+		return false, errors.Wrapf(err, "unable to cancel timed out clone task for %q", ctx)
+	}
+	record.Warnf(ctx.VSphereMachine, "CloneTimedOut", "cancelled clone of vm for machine %q after %s", ctx.Machine.Name, config.DefaultCloneTimeout)
+	return false, &services.RequeueAfterError{
+		RequeueAfter: config.DefaultRequeue,
+		Reason:       fmt.Sprintf("cancelled timed out clone task for %q", ctx),
+	}
+}
+
+// isCloneTimedOut returns a flag indicating whether the provided task is a
+// clone task that has been queued or running for longer than timeout.
+func isCloneTimedOut(info types.TaskInfo, timeout time.Duration, now time.Time) bool {
+	if info.DescriptionId != cloneTaskDescriptionID {
+		return false
+	}
+	if info.State != types.TaskInfoStateQueued && info.State != types.TaskInfoStateRunning {
+		return false
+	}
+	started := info.QueueTime
+	if info.StartTime != nil {
+		started = *info.StartTime
+	}
+	return now.Sub(started) > timeout
+}
+
+// adoptExistingVM records the existing VM with the machine's ExistingVMUUID
+// as the machine's VM. A *capierrors.MachineError is returned if the VM does
+// not exist or cannot be managed as the machine specifies.
+func adoptExistingVM(ctx *context.MachineContext) error {
+	if err := validateMachineSpec(ctx); err != nil {
+		return err
+	}
+
+	uuid := ctx.VSphereMachine.Spec.ExistingVMUUID
+	ref, err := findMachineVM(ctx)
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		return capierrors.InvalidMachineConfiguration("unable to adopt existing vm for %q: no vm has the UUID %q", ctx, uuid)
+	}
+
+	var obj mo.VirtualMachine
+	moRef := types.ManagedObjectReference{Type: "VirtualMachine", Value: ref}
+	if err := ctx.Session.RetrieveOne(ctx, moRef, []string{"config.template", "config.hardware.device"}, &obj); err != nil {
+		return errors.Wrapf(err, "unable to get existing vm %q for %q", uuid, ctx)
+	}
+	if obj.Config == nil {
+		return errors.Errorf("unable to get config of existing vm %q for %q", uuid, ctx)
+	}
+	if obj.Config.Template {
+		return capierrors.InvalidMachineConfiguration("unable to adopt existing vm for %q: %q is a template", ctx, uuid)
+	}
+	nics := object.VirtualDeviceList(obj.Config.Hardware.Device).SelectByType((*types.VirtualEthernetCard)(nil))
+	if expected := len(ctx.VSphereMachine.Spec.Network.Devices); len(nics) != expected {
+		return capierrors.InvalidMachineConfiguration("unable to adopt existing vm for %q: vm %q has %d network devices, expected %d",
+			ctx, uuid, len(nics), expected)
+	}
+
+	ctx.VSphereMachine.Spec.MachineRef = ref
+	ctx.Logger.V(2).Info("adopted existing vm", "uuid", uuid, "moref-id", ref)
+	record.Eventf(ctx.VSphereMachine, "VMAdopted", "adopted existing vm %q", uuid)
+	return nil
+}
+
+// recordVMCreated emits an event for the creation of the machine's VM that
+// includes how the VM was created.
+func recordVMCreated(ctx *context.MachineContext) {
+	if ctx.VSphereMachine.Spec.ContentLibraryItem != "" {
+		record.Eventf(ctx.VSphereMachine, "VMCreated", "created vm %q from content library item %q",
+			ctx.VSphereMachine.Name, ctx.VSphereMachine.Spec.ContentLibraryItem)
+		return
+	}
+	cloneMode := ctx.VSphereMachine.Spec.CloneMode
+	if cloneMode == "" {
+		cloneMode = infrav1.FullClone
+	}
+	record.Eventf(ctx.VSphereMachine, "VMCreated", "created vm %q from template %q with clone mode %s",
+		ctx.VSphereMachine.Name, ctx.VSphereMachine.Spec.Template, cloneMode)
+}
+
+// isExistingVMAdopted returns a flag indicating whether or not the machine
+// has adopted the existing VM with its ExistingVMUUID. Machines that do not
+// adopt an existing VM are always considered to have adopted it.
+func isExistingVMAdopted(ctx *context.MachineContext) bool {
+	return ctx.VSphereMachine.Spec.ExistingVMUUID == "" ||
+		ctx.VSphereMachine.Spec.MachineRef != "" ||
+		ctx.VSphereMachine.Status.InstanceUUID != ""
+}
+
+// checkVMConnectionState returns an error if vSphere reports the machine's
+// VM as unusable. A VM that is invalid or orphaned cannot be recovered, so a
+// *capierrors.MachineError is returned to fail the machine and allow it to
+// be replaced. A VM that is inaccessible, such as when its datastore is
+// unavailable, may recover, so a retryable error is returned.
+func checkVMConnectionState(ctx *context.MachineContext, obj mo.VirtualMachine) error {
+	state := obj.Runtime.ConnectionState
+	switch {
+	case isVMUnrecoverable(obj):
+		record.Warnf(ctx.VSphereMachine, "VMUnrecoverable", "vm %q is %s and cannot be recovered, the machine must be replaced",
+			ctx.VSphereMachine.Name, state)
+		return capierrors.UpdateMachine("vm %q is %s and cannot be recovered", ctx, state)
+	case state == types.VirtualMachineConnectionStateInaccessible:
+		record.Warnf(ctx.VSphereMachine, "VMInaccessible", "vm %q is inaccessible, its datastore may be unavailable",
+			ctx.VSphereMachine.Name)
+		return errors.Errorf("vm %q is inaccessible", ctx)
+	}
+	return nil
+}
+
+// isVMUnrecoverable returns a flag indicating whether or not vSphere reports
+// the VM as invalid or orphaned.
+func isVMUnrecoverable(obj mo.VirtualMachine) bool {
+	return obj.Runtime.ConnectionState == types.VirtualMachineConnectionStateInvalid ||
+		obj.Runtime.ConnectionState == types.VirtualMachineConnectionStateOrphaned
+}
+
+// isHotAddEnabled returns a flag indicating whether or not the machine
+// enables CPU or memory hot-add.
+func isHotAddEnabled(ctx *context.MachineContext) bool {
+	spec := ctx.VSphereMachine.Spec
+	return (spec.CPUHotAddEnabled != nil && *spec.CPUHotAddEnabled) ||
+		(spec.MemoryHotAddEnabled != nil && *spec.MemoryHotAddEnabled)
+}
+
 func (vms *VMService) reconcileNetworkStatus(ctx *context.MachineContext, vm *infrav1.VirtualMachine) error {
 	netStatus, err := vms.getNetworkStatus(ctx)
 	if err != nil {
@@ -197,7 +657,7 @@ func (vms *VMService) reconcileMetadata(ctx *context.MachineContext, vm infrav1.
 		return false, err
 	}
 
-	newMetadata, err := util.GetMachineMetadata(*ctx.VSphereMachine, vm.Network...)
+	newMetadata, err := util.GetMachineMetadata(*ctx.VSphereMachine, ctx.VSphereCluster.Spec.HostnameDomain, vm.Network...)
 	if err != nil {
 		return false, err
 	}
@@ -219,6 +679,124 @@ func (vms *VMService) reconcileMetadata(ctx *context.MachineContext, vm infrav1.
 	return false, nil
 }
 
+// reconcileHardwareVersion upgrades the VM's virtual hardware to the
+// machine's hardware version. The VM's hardware can only be upgraded while
+// it is powered off, so this is a no-op once the VM has been powered on.
+// False is returned if the VM is being upgraded, or if the snapshot taken
+// before the upgrade is being taken or removed.
+func (vms *VMService) reconcileHardwareVersion(ctx *context.MachineContext) (bool, error) {
+	version := ctx.VSphereMachine.Spec.HardwareVersion
+	if version == "" {
+		return true, nil
+	}
+
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, *getMoRef(ctx), []string{"config.version", "runtime.powerState"}, &obj); err != nil {
+		return false, errors.Wrapf(err, "unable to get hardware version for vm %q", ctx)
+	}
+	if obj.Config == nil {
+		return true, nil
+	}
+	vm, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		return false, err
+	}
+	if obj.Config.Version == version {
+		// The snapshot taken before an upgrade is removed once the upgrade
+		// is seen to have been applied.
+		if ctx.VSphereMachine.Spec.SnapshotBeforeUpdate {
+			return removeUpdateSnapshots(ctx, vm, hardwareUpgradeUpdate)
+		}
+		return true, nil
+	}
+	if obj.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+		return true, nil
+	}
+
+	current, err := util.ParseHardwareVersion(obj.Config.Version)
+	if err != nil {
+		return false, err
+	}
+	requested, err := util.ParseHardwareVersion(version)
+	if err != nil {
+		return false, capierrors.InvalidMachineConfiguration("%v", err)
+	}
+	if requested < current {
+		return false, capierrors.InvalidMachineConfiguration("unable to downgrade hardware version of vm %q from %q to %q", ctx, obj.Config.Version, version)
+	}
+
+	// A failed upgrade leaves the version unchanged, so it is retried, and
+	// its snapshot retained for recovery, until it succeeds.
+	if ctx.VSphereMachine.Spec.SnapshotBeforeUpdate {
+		if ok, err := takeUpdateSnapshot(ctx, vm, hardwareUpgradeUpdate); err != nil || !ok {
+			return false, err
+		}
+	}
+	ctx.Logger.V(4).Info("upgrading hardware version", "current-version", obj.Config.Version, "requested-version", version)
+	task, err := vm.UpgradeVM(ctx, version)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to trigger hardware upgrade op for vm %q", ctx)
+	}
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for hardware upgrade op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return false, nil
+}
+
+// reconcileDiskSize grows the VM's disk when DiskGiB exceeds the disk's
+// current size. A *capierrors.MachineError is returned if DiskGiB is less
+// than the disk's current size. False is returned if the disk is being
+// grown.
+func (vms *VMService) reconcileDiskSize(ctx *context.MachineContext) (bool, error) {
+	// The disks of linked clones cannot be resized.
+	if ctx.VSphereMachine.Spec.DiskGiB <= 0 || ctx.VSphereMachine.Spec.CloneMode == infrav1.LinkedClone {
+		return true, nil
+	}
+
+	vm, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		return false, err
+	}
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to get devices for vm %q", ctx)
+	}
+	// Only the disk cloned from the template is resized.
+	disks, _ := getMachineDisks(ctx, devices)
+	if len(disks) != 1 {
+		return false, errors.Errorf("invalid disk count for vm %q: %d", ctx, len(disks))
+	}
+	disk := disks[0]
+
+	currentKB := disk.CapacityInKB
+	requestedKB := int64(ctx.VSphereMachine.Spec.DiskGiB) * 1024 * 1024
+	if requestedKB == currentKB {
+		return true, nil
+	}
+	if requestedKB < currentKB {
+		return false, capierrors.InvalidMachineConfiguration("unable to shrink disk of vm %q from %dGiB to %dGiB",
+			ctx, currentKB/(1024*1024), ctx.VSphereMachine.Spec.DiskGiB)
+	}
+
+	ctx.Logger.V(4).Info("growing disk", "current-size-kb", currentKB, "requested-size-kb", requestedKB)
+	disk.CapacityInKB = requestedKB
+	task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+		DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+			&types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationEdit,
+				Device:    disk,
+			},
+		},
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to trigger disk resize op for vm %q", ctx)
+	}
+	record.Eventf(ctx.VSphereMachine, "DiskResizing", "resizing disk of vm %q from %dGiB to %dGiB",
+		ctx.VSphereMachine.Name, currentKB/(1024*1024), ctx.VSphereMachine.Spec.DiskGiB)
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for disk resize op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return false, nil
+}
+
 func (vms *VMService) reconcilePowerState(ctx *context.MachineContext) (bool, error) {
 	powerState, err := vms.getPowerState(ctx)
 	if err != nil {
@@ -226,16 +804,31 @@ func (vms *VMService) reconcilePowerState(ctx *context.MachineContext) (bool, er
 	}
 	switch powerState {
 	case infrav1.VirtualMachinePowerStatePoweredOff:
-		ctx.Logger.V(4).Info("powering on")
+		// A VM that is powered off after the machine is ready was stopped
+		// out-of-band.
+		if ctx.VSphereMachine.Status.Ready {
+			if !isPowerStateManaged(ctx) {
+				util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachinePoweringOn, corev1.ConditionFalse, "PoweredOff", "power state is not managed")
+				ctx.Logger.V(4).Info("vm is powered off and its power state is not managed")
+				return false, nil
+			}
+			ctx.Logger.Info("powering on vm that was powered off out-of-band")
+			record.Eventf(ctx.VSphereMachine, "PowerOnTriggered", "powering on vm %q that was powered off out-of-band", ctx.VSphereMachine.Name)
+		} else {
+			ctx.Logger.V(4).Info("powering on")
+		}
 		task, err := vms.powerOnVM(ctx)
 		if err != nil {
 			return false, errors.Wrapf(err, "failed to trigger power on op for vm %q", ctx)
 		}
 		// update the tak ref to track
 		ctx.VSphereMachine.Status.TaskRef = task
-		ctx.Logger.V(6).Info("reenqueue to wait for power on state")
+		util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachinePoweringOn, corev1.ConditionTrue, "PowerOnStarted", "")
+		ctx.Logger.V(6).Info("reenqueue to wait for power on state", "task", task)
 		return false, nil
 	case infrav1.VirtualMachinePowerStatePoweredOn:
+		util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachinePoweringOn, corev1.ConditionFalse, "PoweredOn", "")
+		util.MarkProvisioningPhaseCompleted(ctx.VSphereMachine, util.ProvisioningPhasePowerOn)
 		ctx.Logger.V(6).Info("powered on")
 	default:
 		return false, errors.Errorf("unexpected power state %q for vm %q", powerState, ctx)
@@ -244,10 +837,106 @@ func (vms *VMService) reconcilePowerState(ctx *context.MachineContext) (bool, er
 	return true, nil
 }
 
+// reconcileRebootRequest reboots the guest OS of, or resets, a powered on VM
+// when requested with the machine's reboot annotation. The annotation is
+// removed once the request is handled, and requests made within
+// rebootMinInterval of the last requested reboot are ignored. False is
+// returned while the VM is reset.
+func (vms *VMService) reconcileRebootRequest(ctx *context.MachineContext) (bool, error) {
+	annotations := ctx.VSphereMachine.Annotations
+	action, ok := annotations[constants.RebootAnnotationLabel]
+	if !ok {
+		return true, nil
+	}
+
+	if action != rebootSoft && action != rebootHard {
+		record.Warnf(ctx.VSphereMachine, "RebootIgnored", "ignored reboot of vm %q: invalid value %q, expected %q or %q",
+			ctx.VSphereMachine.Name, action, rebootSoft, rebootHard)
+		delete(annotations, constants.RebootAnnotationLabel)
+		return true, nil
+	}
+
+	if last, err := time.Parse(time.RFC3339, annotations[constants.LastRebootAnnotationLabel]); err == nil && time.Since(last) < rebootMinInterval {
+		record.Warnf(ctx.VSphereMachine, "RebootIgnored", "ignored reboot of vm %q: vm was rebooted less than %s ago at %s",
+			ctx.VSphereMachine.Name, rebootMinInterval, last.Format(time.RFC3339))
+		delete(annotations, constants.RebootAnnotationLabel)
+		return true, nil
+	}
+
+	vm, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if action == rebootSoft {
+		// The guest OS can only be rebooted while VMware Tools is running,
+		// which is not expected to change for a wedged VM, so the request is
+		// not retried.
+		if err := vm.RebootGuest(ctx); err != nil {
+			record.Warnf(ctx.VSphereMachine, "RebootFailed", "failed to reboot guest os of vm %q: %v", ctx.VSphereMachine.Name, err)
+			delete(annotations, constants.RebootAnnotationLabel)
+			return true, nil
+		}
+		record.Eventf(ctx.VSphereMachine, "GuestRebooted", "rebooted guest os of vm %q", ctx.VSphereMachine.Name)
+	} else {
+		task, err := vm.Reset(ctx)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to trigger reset op for vm %q", ctx)
+		}
+		ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+		record.Eventf(ctx.VSphereMachine, "VMReset", "reset vm %q", ctx.VSphereMachine.Name)
+	}
+
+	delete(annotations, constants.RebootAnnotationLabel)
+	annotations[constants.LastRebootAnnotationLabel] = time.Now().UTC().Format(time.RFC3339)
+	return action == rebootSoft, nil
+}
+
+// reconcileGuestTools returns false while a powered on VM that is not ready
+// waits for VMware Tools to run, since the VM's IP addresses are reported by
+// VMware Tools. True is returned once VMware Tools is running, the machine
+// skips the wait, or the guest tools timeout has elapsed since the VM was
+// powered on.
+func (vms *VMService) reconcileGuestTools(ctx *context.MachineContext) (bool, error) {
+	if ctx.VSphereMachine.Spec.SkipGuestToolsWait || ctx.VSphereMachine.Status.Ready {
+		return true, nil
+	}
+
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, *getMoRef(ctx), []string{"guest.toolsRunningStatus"}, &obj); err != nil {
+		return false, errors.Wrapf(err, "unable to get guest tools status of vm %q", ctx)
+	}
+	if obj.Guest != nil && obj.Guest.ToolsRunningStatus == string(types.VirtualMachineToolsRunningStatusGuestToolsRunning) {
+		util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineWaitingForGuestTools, corev1.ConditionFalse, "GuestToolsRunning", "")
+		return true, nil
+	}
+
+	if times := ctx.VSphereMachine.Status.ProvisioningTimes; times != nil && times.PoweredOn != nil &&
+		time.Since(times.PoweredOn.Time) >= config.DefaultGuestToolsTimeout {
+		// The warning is only emitted the first time the wait times out.
+		if cond := util.GetMachineCondition(ctx.VSphereMachine, infrav1.MachineWaitingForGuestTools); cond == nil || cond.Reason != "GuestToolsTimeout" {
+			record.Warnf(ctx.VSphereMachine, "GuestToolsTimeout", "timed out after %s waiting for VMware Tools to run in vm %q, its IP addresses may never be reported",
+				config.DefaultGuestToolsTimeout, ctx.VSphereMachine.Name)
+		}
+		util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineWaitingForGuestTools, corev1.ConditionFalse, "GuestToolsTimeout", "")
+		return true, nil
+	}
+
+	util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineWaitingForGuestTools, corev1.ConditionTrue, "WaitingForGuestTools", "")
+	ctx.Logger.V(6).Info("requeuing to wait on guest tools")
+	return false, nil
+}
+
+// isPowerStateManaged returns a flag indicating whether or not the machine's
+// VM is powered back on when it is powered off out-of-band.
+func isPowerStateManaged(ctx *context.MachineContext) bool {
+	return ctx.VSphereMachine.Spec.ManagePowerState == nil || *ctx.VSphereMachine.Spec.ManagePowerState
+}
+
 func (vms *VMService) reconcileUUIUDs(ctx *context.MachineContext, vm *infrav1.VirtualMachine, obj mo.VirtualMachine) error {
-	// Temporarily removing this. It is calling a panic (nil pointer reference).
-	// we dont use this anywhere so ti should be fine.
-	// vm.InstanceUUID = obj.Config.InstanceUuid
+	if obj.Config != nil {
+		vm.InstanceUUID = obj.Config.InstanceUuid
+	}
 
 	biosUUID, err := vms.getBiosUUID(ctx)
 	if err != nil {
@@ -257,6 +946,161 @@ func (vms *VMService) reconcileUUIUDs(ctx *context.MachineContext, vm *infrav1.V
 	return nil
 }
 
+// reconcileTemplateDrift compares the version of the machine's template with
+// the version the machine's VM was cloned from when the machine detects
+// template drift. A TemplateDrift event is emitted the first time a change
+// is seen.
+func (vms *VMService) reconcileTemplateDrift(ctx *context.MachineContext) error {
+	clonedVersion := ctx.VSphereMachine.Status.TemplateVersion
+	if !ctx.VSphereMachine.Spec.DetectTemplateDrift || ctx.VSphereMachine.Spec.Template == "" || clonedVersion == "" {
+		return nil
+	}
+
+	tpl, err := template.FindTemplate(ctx, ctx.VSphereMachine.Spec.Template)
+	if err != nil {
+		// A template that was removed cannot be compared, and machines that
+		// are still cloned from it fail on their own.
+		ctx.Logger.V(4).Info("unable to find template to detect drift", "template", ctx.VSphereMachine.Spec.Template, "reason", err.Error())
+		return nil
+	}
+	currentVersion, err := template.GetTemplateVersion(ctx, tpl)
+	if err != nil {
+		return err
+	}
+
+	if currentVersion == clonedVersion {
+		util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineTemplateDrifted, corev1.ConditionFalse, "TemplateUnchanged", "")
+		return nil
+	}
+	message := fmt.Sprintf("template %q changed from version %q to %q after vm %q was cloned from it",
+		ctx.VSphereMachine.Spec.Template, clonedVersion, currentVersion, ctx.VSphereMachine.Name)
+	if cond := util.GetMachineCondition(ctx.VSphereMachine, infrav1.MachineTemplateDrifted); cond == nil || cond.Message != message {
+		record.Warnf(ctx.VSphereMachine, "TemplateDrift", "%s, the machine may need to be replaced", message)
+	}
+	util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineTemplateDrifted, corev1.ConditionTrue, "TemplateChanged", message)
+	return nil
+}
+
+// reconcileImmutableSpec compares the fields of the machine's spec that are
+// only applied when its VM is created with the fields the VM was created
+// with. An ImmutableSpecChanged event is emitted the first time a change is
+// seen, as the machine must be replaced for the change to take effect.
+// Machines whose VM was created or adopted before the fields were recorded
+// record their current fields instead.
+func (vms *VMService) reconcileImmutableSpec(ctx *context.MachineContext) {
+	if ctx.VSphereMachine.Status.CreatedSpec == nil {
+		ctx.VSphereMachine.Status.CreatedSpec = getCreatedSpec(ctx.VSphereMachine.Spec)
+		if ctx.VSphereMachine.Status.FailureDomain == "" {
+			ctx.VSphereMachine.Status.FailureDomain = ctx.VSphereMachine.Spec.FailureDomain
+		}
+		return
+	}
+
+	changed := getChangedImmutableFields(ctx)
+	if len(changed) == 0 {
+		util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineImmutableSpecChanged, corev1.ConditionFalse, "ImmutableSpecUnchanged", "")
+		return
+	}
+	message := fmt.Sprintf("fields %s of machine %q changed after its vm was created", strings.Join(changed, ", "), ctx.VSphereMachine.Name)
+	if cond := util.GetMachineCondition(ctx.VSphereMachine, infrav1.MachineImmutableSpecChanged); cond == nil || cond.Message != message {
+		record.Warnf(ctx.VSphereMachine, "ImmutableSpecChanged", "%s and are not applied to the vm, the machine must be replaced to apply them", message)
+	}
+	util.SetMachineCondition(ctx.VSphereMachine, infrav1.MachineImmutableSpecChanged, corev1.ConditionTrue, "ImmutableSpecChanged", message)
+}
+
+// getChangedImmutableFields returns the names of the fields of the machine's
+// spec that differ from the fields its VM was created with.
+func getChangedImmutableFields(ctx *context.MachineContext) []string {
+	created := ctx.VSphereMachine.Status.CreatedSpec
+	current := getCreatedSpec(ctx.VSphereMachine.Spec)
+
+	var changed []string
+	for _, field := range []struct {
+		name             string
+		created, current interface{}
+	}{
+		{"template", created.Template, current.Template},
+		{"contentLibraryItem", created.ContentLibraryItem, current.ContentLibraryItem},
+		{"cloneMode", created.CloneMode, current.CloneMode},
+		{"datacenter", created.Datacenter, current.Datacenter},
+		{"computeCluster", created.ComputeCluster, current.ComputeCluster},
+		{"resourcePool", created.ResourcePool, current.ResourcePool},
+		{"host", created.Host, current.Host},
+		{"datastore", created.Datastore, current.Datastore},
+		{"datastores", created.Datastores, current.Datastores},
+		{"folder", created.Folder, current.Folder},
+		{"firmware", created.Firmware, current.Firmware},
+		{"secureBoot", created.SecureBoot, current.SecureBoot},
+		{"bootDelayMilliseconds", created.BootDelayMilliseconds, current.BootDelayMilliseconds},
+		{"latencySensitivity", created.LatencySensitivity, current.LatencySensitivity},
+		{"cpuAffinity", created.CPUAffinity, current.CPUAffinity},
+		{"bootstrapTransport", created.BootstrapTransport, current.BootstrapTransport},
+		{"failureDomain", ctx.VSphereMachine.Status.FailureDomain, ctx.VSphereMachine.Spec.FailureDomain},
+		{"network", created.NetworkNames, current.NetworkNames},
+	} {
+		if !reflect.DeepEqual(field.created, field.current) {
+			changed = append(changed, field.name)
+		}
+	}
+	return changed
+}
+
+// reconcileResourceStatus records the CPUs, memory, and disk space the VM is
+// configured with in the machine's status.
+func (vms *VMService) reconcileResourceStatus(ctx *context.MachineContext) error {
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, *getMoRef(ctx), []string{"config.hardware"}, &obj); err != nil {
+		return errors.Wrapf(err, "unable to get hardware of vm %q", ctx)
+	}
+	if obj.Config == nil {
+		return nil
+	}
+	ctx.VSphereMachine.Status.Resources = getVMResources(obj.Config.Hardware)
+	return nil
+}
+
+// reconcileHostStatus records the name of the host the VM is running on in
+// the machine's status, and reports when the VM has moved to another host.
+func (vms *VMService) reconcileHostStatus(ctx *context.MachineContext) error {
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, *getMoRef(ctx), []string{"runtime.host"}, &obj); err != nil {
+		return errors.Wrapf(err, "unable to get host of vm %q", ctx)
+	}
+	if obj.Runtime.Host == nil {
+		return nil
+	}
+	var host mo.HostSystem
+	if err := ctx.Session.RetrieveOne(ctx, *obj.Runtime.Host, []string{"name"}, &host); err != nil {
+		return errors.Wrapf(err, "unable to get name of host %q for vm %q", obj.Runtime.Host.Value, ctx)
+	}
+
+	previous := ctx.VSphereMachine.Status.Host
+	if previous == host.Name {
+		return nil
+	}
+	ctx.VSphereMachine.Status.Host = host.Name
+	if previous == "" {
+		ctx.Logger.V(4).Info("recorded host of vm", "host", host.Name)
+		return nil
+	}
+	ctx.Logger.V(2).Info("vm moved to another host", "previous-host", previous, "host", host.Name)
+	record.Eventf(ctx.VSphereMachine, "VMMigrated", "vm moved from host %q to host %q", previous, host.Name)
+	return nil
+}
+
+// getVMResources returns the resources of a VM with the provided hardware.
+func getVMResources(hw types.VirtualHardware) *infrav1.VSphereMachineResources {
+	var diskKB int64
+	for _, device := range object.VirtualDeviceList(hw.Device).SelectByType((*types.VirtualDisk)(nil)) {
+		diskKB += device.(*types.VirtualDisk).CapacityInKB
+	}
+	return &infrav1.VSphereMachineResources{
+		NumCPUs:   hw.NumCPU,
+		MemoryMiB: int64(hw.MemoryMB),
+		DiskGiB:   diskKB / (1024 * 1024),
+	}
+}
+
 func (vms *VMService) getPowerState(ctx *context.MachineContext) (infrav1.VirtualMachinePowerState, error) {
 
 	vm, err := getVMfromMachineRef(ctx)
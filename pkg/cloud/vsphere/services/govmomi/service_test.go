@@ -0,0 +1,1377 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/constants"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// testEvents records the events emitted by the tests.
+var testEvents = &eventRecorder{}
+
+func init() {
+	record.InitFromRecorder(testEvents)
+}
+
+// eventRecorder is an event recorder that keeps the reasons and messages of
+// the events it records.
+type eventRecorder struct {
+	sync.Mutex
+
+	events []string
+}
+
+func (r *eventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.events = append(r.events, reason+" "+message)
+}
+
+func (r *eventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *eventRecorder) PastEventf(object runtime.Object, timestamp metav1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func (r *eventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// has returns true if an event with the provided reason whose message
+// contains the provided text was recorded.
+func (r *eventRecorder) has(reason, text string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, event := range r.events {
+		if strings.HasPrefix(event, reason+" ") && strings.Contains(event, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// vcsim is a vCenter simulator for the tests of a VMService.
+type vcsim struct {
+	model  *simulator.Model
+	server *simulator.Server
+}
+
+// newVCSim starts a vCenter simulator whose hosts are all in clusters and
+// sets the credentials machine contexts use to connect to it. The simulator
+// must be stopped with destroy.
+func newVCSim(t *testing.T) *vcsim {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+	return newVCSimWithModel(t, model)
+}
+
+// newVCSimWithModel is like newVCSim, but starts the simulator with the
+// provided model.
+func newVCSimWithModel(t *testing.T, model *simulator.Model) *vcsim {
+	if err := model.Create(); err != nil {
+		model.Remove()
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	return &vcsim{model: model, server: s}
+}
+
+// destroy stops the simulator.
+func (s *vcsim) destroy() {
+	os.Unsetenv("VSPHERE_USERNAME")
+	os.Unsetenv("VSPHERE_PASSWORD")
+	s.server.Close()
+	s.model.Remove()
+}
+
+// newMachineContext returns the context of a machine in a cluster whose
+// server is the simulator. The machine and VSphereMachine are named
+// "test-machine" unless they are named otherwise, and a nil machine is
+// replaced with an empty one.
+func (s *vcsim) newMachineContext(t *testing.T, machine *clusterv1.Machine, vsphereMachine *infrav1.VSphereMachine) *context.MachineContext {
+	clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+		Cluster: &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+		},
+		VSphereCluster: &infrav1.VSphereCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+			Spec:       infrav1.VSphereClusterSpec{Server: s.server.URL.Host},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if machine == nil {
+		machine = &clusterv1.Machine{}
+	}
+	for _, meta := range []*metav1.ObjectMeta{&machine.ObjectMeta, &vsphereMachine.ObjectMeta} {
+		if meta.Name == "" {
+			meta.Name = "test-machine"
+		}
+		if meta.Namespace == "" {
+			meta.Namespace = "test-namespace"
+		}
+	}
+	machineContext, err := context.NewMachineContextFromClusterContext(clusterContext, machine, vsphereMachine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return machineContext
+}
+
+func TestReconcileVM_AdoptsExistingVM(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	// The existing VM has the machine's UID as its instance UUID, as if it
+	// was cloned by a previous reconcile that failed to record it.
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	machineContext := sim.newMachineContext(t, &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{UID: types.UID(vm.Config.InstanceUuid)},
+	}, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{Template: "unused"},
+	})
+
+	var vms VMService
+	if _, err := vms.ReconcileVM(machineContext); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if actual, expected := machineContext.VSphereMachine.Spec.MachineRef, vm.Reference().Value; actual != expected {
+		t.Fatalf("expected adopted machine ref %q, got %q", expected, actual)
+	}
+	if sim.model.Machine != sim.model.Count().Machine {
+		t.Fatal("expected no vm to be created")
+	}
+}
+
+func TestReconcilePowerState_PoweredOffOutOfBand(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	notManaged := false
+
+	testCases := []struct {
+		name             string
+		ready            bool
+		managePowerState *bool
+		expectedPowerOn  bool
+	}{
+		{
+			name:            "initial power on",
+			expectedPowerOn: true,
+		},
+		{
+			name:            "managed by default",
+			ready:           true,
+			expectedPowerOn: true,
+		},
+		{
+			name:             "not managed",
+			ready:            true,
+			managePowerState: &notManaged,
+		},
+		{
+			name:             "initial power on when not managed",
+			managePowerState: &notManaged,
+			expectedPowerOn:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vm.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOff
+			vm.Summary.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOff
+
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: infrav1.VSphereMachineSpec{
+					MachineRef:       vm.Reference().Value,
+					ManagePowerState: tc.managePowerState,
+				},
+				Status: infrav1.VSphereMachineStatus{Ready: tc.ready},
+			})
+
+			var vms VMService
+			ok, err := vms.reconcilePowerState(machineContext)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok {
+				t.Fatal("expected powered off vm to not be reconciled")
+			}
+			if poweringOn := machineContext.VSphereMachine.Status.TaskRef != ""; poweringOn != tc.expectedPowerOn {
+				t.Fatalf("expected power on %t, got %t", tc.expectedPowerOn, poweringOn)
+			}
+		})
+	}
+}
+
+func TestReconcileDiskSize(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	getDiskKB := func() int64 {
+		disks := object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*vimtypes.VirtualDisk)(nil))
+		return disks[0].(*vimtypes.VirtualDisk).CapacityInKB
+	}
+	// The simulator's disks are smaller than 1GiB, so the first case grows
+	// the disk to a whole number of GiB for the other cases.
+	const sizeGiB = 10
+
+	testCases := []struct {
+		name          string
+		diskGiB       int32
+		cloneMode     infrav1.CloneMode
+		expectedGiB   int32
+		expectedTask  bool
+		expectedError bool
+	}{
+		{
+			name:         "grow",
+			diskGiB:      sizeGiB,
+			expectedGiB:  sizeGiB,
+			expectedTask: true,
+		},
+		{
+			name:        "unset",
+			expectedGiB: sizeGiB,
+		},
+		{
+			name:        "unchanged",
+			diskGiB:     sizeGiB,
+			expectedGiB: sizeGiB,
+		},
+		{
+			name:        "linked clone",
+			diskGiB:     sizeGiB + 10,
+			cloneMode:   infrav1.LinkedClone,
+			expectedGiB: sizeGiB,
+		},
+		{
+			name:          "shrink",
+			diskGiB:       sizeGiB - 5,
+			expectedGiB:   sizeGiB,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: infrav1.VSphereMachineSpec{
+					MachineRef: vm.Reference().Value,
+					DiskGiB:    tc.diskGiB,
+					CloneMode:  tc.cloneMode,
+				},
+			})
+
+			var vms VMService
+			ok, err := vms.reconcileDiskSize(machineContext)
+			if tc.expectedError {
+				if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %T: %v", err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// The resize is waited for by the next reconcile.
+			if hasTask := machineContext.VSphereMachine.Status.TaskRef != ""; hasTask != tc.expectedTask {
+				t.Fatalf("expected task %t, got task ref %q", tc.expectedTask, machineContext.VSphereMachine.Status.TaskRef)
+			}
+			if tc.expectedTask {
+				if ok {
+					t.Fatal("expected resizing vm to not be reconciled")
+				}
+				if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+					t.Fatalf("expected resize task to be complete, got in flight %t, error %v", inflight, err)
+				}
+			}
+			if actual := int32(getDiskKB() / (1024 * 1024)); actual != tc.expectedGiB {
+				t.Fatalf("expected disk size %dGiB, got %dGiB", tc.expectedGiB, actual)
+			}
+		})
+	}
+}
+
+func TestReconcileHardwareVersion(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vm.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOff
+	vm.Summary.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOff
+	// The simulator upgrades VMs to vmx-13.
+	vm.Config.Version = "vmx-10"
+
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{
+			MachineRef:           vm.Reference().Value,
+			HardwareVersion:      "vmx-13",
+			SnapshotBeforeUpdate: true,
+		},
+	})
+	countSnapshots := func() int {
+		obj, err := getVMfromMachineRef(machineContext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots, err := getUpdateSnapshots(machineContext, obj, updateSnapshotPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(snapshots)
+	}
+
+	// Each task is started by a reconcile and waited for by the next one.
+	var vms VMService
+	reconcileTask := func() {
+		t.Helper()
+		ok, err := vms.reconcileHardwareVersion(machineContext)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok || machineContext.VSphereMachine.Status.TaskRef == "" {
+			t.Fatal("expected task to be recorded")
+		}
+		if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+			t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+		}
+	}
+
+	// The snapshot is taken before the upgrade is started.
+	reconcileTask()
+	if count := countSnapshots(); count != 1 {
+		t.Fatalf("expected 1 snapshot before upgrade, got %d", count)
+	}
+	if vm.Config.Version != "vmx-10" {
+		t.Fatalf("expected vm not to be upgraded before its snapshot, got %q", vm.Config.Version)
+	}
+	reconcileTask()
+	if vm.Config.Version != "vmx-13" {
+		t.Fatalf("expected hardware version %q, got %q", "vmx-13", vm.Config.Version)
+	}
+
+	// The upgraded VM's snapshot is removed.
+	reconcileTask()
+	if count := countSnapshots(); count != 0 {
+		t.Fatalf("expected no snapshots after upgrade, got %d", count)
+	}
+	ok, err := vms.reconcileHardwareVersion(machineContext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok || machineContext.VSphereMachine.Status.TaskRef != "" {
+		t.Fatal("expected upgraded vm to be reconciled")
+	}
+
+	// The hardware cannot be downgraded.
+	machineContext.VSphereMachine.Spec.HardwareVersion = "vmx-11"
+	_, err = vms.reconcileHardwareVersion(machineContext)
+	if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+		t.Fatalf("expected machine error, got %T: %v", err, err)
+	}
+}
+
+func TestDestroyVM_AfterCloneCompletes(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	// The machine was deleted while its VM was being cloned, so only the
+	// clone task is recorded.
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	machineContext := sim.newMachineContext(t, &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{UID: types.UID(vm.Config.InstanceUuid)},
+	}, &infrav1.VSphereMachine{})
+	task, err := object.NewVirtualMachine(machineContext.Session.Client.Client, vm.Reference()).PowerOff(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(machineContext); err != nil {
+		t.Fatal(err)
+	}
+	machineContext.VSphereMachine.Status.TaskRef = task.Reference().Value
+
+	var vms VMService
+	if _, err := vms.DestroyVM(machineContext); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual, expected := machineContext.VSphereMachine.Spec.MachineRef, vm.Reference().Value; actual != expected {
+		t.Fatalf("expected adopted machine ref %q, got %q", expected, actual)
+	}
+	if machineContext.VSphereMachine.Status.TaskRef == task.Reference().Value {
+		t.Fatal("expected vm to be destroyed")
+	}
+}
+
+func TestCheckVMConnectionState(t *testing.T) {
+	testCases := []struct {
+		state              vimtypes.VirtualMachineConnectionState
+		expectErr          bool
+		expectMachineError bool
+	}{
+		{state: vimtypes.VirtualMachineConnectionStateConnected},
+		{state: vimtypes.VirtualMachineConnectionStateDisconnected},
+		{state: vimtypes.VirtualMachineConnectionStateInaccessible, expectErr: true},
+		{state: vimtypes.VirtualMachineConnectionStateInvalid, expectErr: true, expectMachineError: true},
+		{state: vimtypes.VirtualMachineConnectionStateOrphaned, expectErr: true, expectMachineError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.state), func(t *testing.T) {
+			ctx := &context.MachineContext{
+				ClusterContext: &context.ClusterContext{
+					Cluster: &clusterv1.Cluster{
+						ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					},
+				},
+				Machine: &clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				VSphereMachine: &infrav1.VSphereMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+			}
+			var obj mo.VirtualMachine
+			obj.Runtime.ConnectionState = tc.state
+
+			err := checkVMConnectionState(ctx, obj)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+			if _, ok := err.(*capierrors.MachineError); ok != tc.expectMachineError {
+				t.Fatalf("expected machine error %v, got %v", tc.expectMachineError, err)
+			}
+		})
+	}
+}
+
+func TestDestroyVM_Unrecoverable(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	// The VM is invalid, so it cannot be destroyed.
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vm.Runtime.ConnectionState = vimtypes.VirtualMachineConnectionStateInvalid
+	machineContext := sim.newMachineContext(t, &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{UID: types.UID(vm.Config.InstanceUuid)},
+	}, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{MachineRef: vm.Reference().Value},
+	})
+
+	task, err := object.NewVirtualMachine(machineContext.Session.Client.Client, vm.Reference()).PowerOff(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(machineContext); err != nil {
+		t.Fatal(err)
+	}
+
+	var vms VMService
+	actual, err := vms.DestroyVM(machineContext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual.State != infrav1.VirtualMachineStateNotFound {
+		t.Fatalf("expected vm state %q, got %q", infrav1.VirtualMachineStateNotFound, actual.State)
+	}
+	if machineContext.VSphereMachine.Status.TaskRef != "" {
+		t.Fatalf("expected no task, got %q", machineContext.VSphereMachine.Status.TaskRef)
+	}
+	if simulator.Map.Get(vm.Reference()) != nil {
+		t.Fatal("expected vm to be unregistered")
+	}
+}
+
+func TestGetVMResources(t *testing.T) {
+	testCases := []struct {
+		name     string
+		hw       vimtypes.VirtualHardware
+		expected infrav1.VSphereMachineResources
+	}{
+		{
+			name:     "no disks",
+			hw:       vimtypes.VirtualHardware{NumCPU: 2, MemoryMB: 2048},
+			expected: infrav1.VSphereMachineResources{NumCPUs: 2, MemoryMiB: 2048},
+		},
+		{
+			name: "disks are summed",
+			hw: vimtypes.VirtualHardware{
+				NumCPU:   4,
+				MemoryMB: 8192,
+				Device: []vimtypes.BaseVirtualDevice{
+					&vimtypes.VirtualDisk{CapacityInKB: 20 * 1024 * 1024},
+					&vimtypes.VirtualE1000{},
+					&vimtypes.VirtualDisk{CapacityInKB: 100 * 1024 * 1024},
+				},
+			},
+			expected: infrav1.VSphereMachineResources{NumCPUs: 4, MemoryMiB: 8192, DiskGiB: 120},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := getVMResources(tc.hw)
+			if *actual != tc.expected {
+				t.Fatalf("expected %+v, got %+v", tc.expected, *actual)
+			}
+		})
+	}
+}
+
+func TestReconcileGuestTools(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	recently := metav1.Now()
+	longAgo := metav1.NewTime(recently.Add(-2 * config.DefaultGuestToolsTimeout))
+
+	testCases := []struct {
+		name           string
+		toolsStatus    vimtypes.VirtualMachineToolsRunningStatus
+		skip           bool
+		ready          bool
+		poweredOn      *metav1.Time
+		expectedOK     bool
+		expectedReason string
+	}{
+		{
+			name:           "tools running",
+			toolsStatus:    vimtypes.VirtualMachineToolsRunningStatusGuestToolsRunning,
+			poweredOn:      &recently,
+			expectedOK:     true,
+			expectedReason: "GuestToolsRunning",
+		},
+		{
+			name:           "tools not running",
+			toolsStatus:    vimtypes.VirtualMachineToolsRunningStatusGuestToolsNotRunning,
+			poweredOn:      &recently,
+			expectedReason: "WaitingForGuestTools",
+		},
+		{
+			name:           "tools not running after timeout",
+			toolsStatus:    vimtypes.VirtualMachineToolsRunningStatusGuestToolsNotRunning,
+			poweredOn:      &longAgo,
+			expectedOK:     true,
+			expectedReason: "GuestToolsTimeout",
+		},
+		{
+			name:        "wait skipped",
+			toolsStatus: vimtypes.VirtualMachineToolsRunningStatusGuestToolsNotRunning,
+			skip:        true,
+			poweredOn:   &recently,
+			expectedOK:  true,
+		},
+		{
+			name:        "machine ready",
+			toolsStatus: vimtypes.VirtualMachineToolsRunningStatusGuestToolsNotRunning,
+			ready:       true,
+			poweredOn:   &recently,
+			expectedOK:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vm.Guest.ToolsRunningStatus = string(tc.toolsStatus)
+
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: infrav1.VSphereMachineSpec{
+					MachineRef:         vm.Reference().Value,
+					SkipGuestToolsWait: tc.skip,
+				},
+				Status: infrav1.VSphereMachineStatus{
+					Ready:             tc.ready,
+					ProvisioningTimes: &infrav1.VSphereMachineProvisioningTimes{PoweredOn: tc.poweredOn},
+				},
+			})
+
+			var vms VMService
+			ok, err := vms.reconcileGuestTools(machineContext)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tc.expectedOK {
+				t.Fatalf("expected %t, got %t", tc.expectedOK, ok)
+			}
+			var reason string
+			if cond := util.GetMachineCondition(machineContext.VSphereMachine, infrav1.MachineWaitingForGuestTools); cond != nil {
+				reason = cond.Reason
+			}
+			if reason != tc.expectedReason {
+				t.Fatalf("expected condition reason %q, got %q", tc.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestReconcileRebootRequest(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	recently := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+	longAgo := time.Now().UTC().Add(-2 * rebootMinInterval).Format(time.RFC3339)
+
+	testCases := []struct {
+		name               string
+		annotations        map[string]string
+		expectedOK         bool
+		expectedTask       bool
+		expectedLastReboot bool
+	}{
+		{
+			name:       "no request",
+			expectedOK: true,
+		},
+		{
+			name:               "hard reset",
+			annotations:        map[string]string{constants.RebootAnnotationLabel: rebootHard},
+			expectedTask:       true,
+			expectedLastReboot: true,
+		},
+		{
+			name: "hard reset after previous reboot",
+			annotations: map[string]string{
+				constants.RebootAnnotationLabel:     rebootHard,
+				constants.LastRebootAnnotationLabel: longAgo,
+			},
+			expectedTask:       true,
+			expectedLastReboot: true,
+		},
+		{
+			name: "recently rebooted",
+			annotations: map[string]string{
+				constants.RebootAnnotationLabel:     rebootHard,
+				constants.LastRebootAnnotationLabel: recently,
+			},
+			expectedOK: true,
+		},
+		{
+			// The simulator does not implement rebooting a guest OS, so the
+			// request fails and is not retried.
+			name:        "soft reboot failure",
+			annotations: map[string]string{constants.RebootAnnotationLabel: rebootSoft},
+			expectedOK:  true,
+		},
+		{
+			name:        "invalid value",
+			annotations: map[string]string{constants.RebootAnnotationLabel: "true"},
+			expectedOK:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       infrav1.VSphereMachineSpec{MachineRef: vm.Reference().Value},
+			})
+			lastReboot := tc.annotations[constants.LastRebootAnnotationLabel]
+
+			var vms VMService
+			ok, err := vms.reconcileRebootRequest(machineContext)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tc.expectedOK {
+				t.Fatalf("expected %t, got %t", tc.expectedOK, ok)
+			}
+			annotations := machineContext.VSphereMachine.Annotations
+			if _, ok := annotations[constants.RebootAnnotationLabel]; ok {
+				t.Fatal("expected reboot annotation to be removed")
+			}
+			if hasTask := machineContext.VSphereMachine.Status.TaskRef != ""; hasTask != tc.expectedTask {
+				t.Fatalf("expected task %t, got task ref %q", tc.expectedTask, machineContext.VSphereMachine.Status.TaskRef)
+			}
+			if updated := annotations[constants.LastRebootAnnotationLabel] != lastReboot; updated != tc.expectedLastReboot {
+				t.Fatalf("expected last reboot to be updated %t, got %q", tc.expectedLastReboot, annotations[constants.LastRebootAnnotationLabel])
+			}
+		})
+	}
+}
+
+func TestReconcileTemplateDrift(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	tpl := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	tpl.Config.ChangeVersion = "2019-10-01T12:00:00Z"
+	currentVersion := tpl.Config.InstanceUuid + "@" + tpl.Config.ChangeVersion
+	oldVersion := tpl.Config.InstanceUuid + "@2019-09-01T12:00:00Z"
+
+	testCases := []struct {
+		name           string
+		template       string
+		detect         bool
+		clonedVersion  string
+		expectedReason string
+	}{
+		{
+			name:          "detection disabled",
+			template:      tpl.Name,
+			clonedVersion: oldVersion,
+		},
+		{
+			name:           "unchanged",
+			template:       tpl.Name,
+			detect:         true,
+			clonedVersion:  currentVersion,
+			expectedReason: "TemplateUnchanged",
+		},
+		{
+			name:           "changed",
+			template:       tpl.Name,
+			detect:         true,
+			clonedVersion:  oldVersion,
+			expectedReason: "TemplateChanged",
+		},
+		{
+			name:     "version not recorded",
+			template: tpl.Name,
+			detect:   true,
+		},
+		{
+			name:          "missing template",
+			template:      "missing-template",
+			detect:        true,
+			clonedVersion: oldVersion,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: infrav1.VSphereMachineSpec{
+					Template:            tc.template,
+					DetectTemplateDrift: tc.detect,
+				},
+				Status: infrav1.VSphereMachineStatus{TemplateVersion: tc.clonedVersion},
+			})
+
+			var vms VMService
+			if err := vms.reconcileTemplateDrift(machineContext); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var reason string
+			if cond := util.GetMachineCondition(machineContext.VSphereMachine, infrav1.MachineTemplateDrifted); cond != nil {
+				reason = cond.Reason
+			}
+			if reason != tc.expectedReason {
+				t.Fatalf("expected condition reason %q, got %q", tc.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestReconcileVM_ExistingVMUUID(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	oneNIC := []infrav1.NetworkDeviceSpec{{NetworkName: "VM Network", DHCP4: true}}
+
+	testCases := []struct {
+		name               string
+		uuid               string
+		devices            []infrav1.NetworkDeviceSpec
+		expectMachineError bool
+	}{
+		{
+			name:    "existing vm",
+			uuid:    vm.Config.Uuid,
+			devices: oneNIC,
+		},
+		{
+			name:               "missing vm",
+			uuid:               "00000000-0000-0000-0000-000000000000",
+			devices:            oneNIC,
+			expectMachineError: true,
+		},
+		{
+			name:               "network device count mismatch",
+			uuid:               vm.Config.Uuid,
+			devices:            append(oneNIC, oneNIC...),
+			expectMachineError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: infrav1.VSphereMachineSpec{
+					ExistingVMUUID: tc.uuid,
+					Network:        infrav1.NetworkSpec{Devices: tc.devices},
+				},
+			})
+
+			var vms VMService
+			_, err := vms.ReconcileVM(machineContext)
+			if tc.expectMachineError {
+				if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual, expected := machineContext.VSphereMachine.Spec.MachineRef, vm.Reference().Value; actual != expected {
+				t.Fatalf("expected adopted machine ref %q, got %q", expected, actual)
+			}
+			if sim.model.Machine != sim.model.Count().Machine {
+				t.Fatal("expected no vm to be created")
+			}
+		})
+	}
+}
+
+func TestDestroyVM_RetainVMOnDelete(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{
+			MachineRef:       vm.Reference().Value,
+			ExistingVMUUID:   vm.Config.Uuid,
+			RetainVMOnDelete: true,
+		},
+	})
+
+	var vms VMService
+	result, err := vms.DestroyVM(machineContext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.State != infrav1.VirtualMachineStateNotFound {
+		t.Fatalf("expected vm state %q, got %q", infrav1.VirtualMachineStateNotFound, result.State)
+	}
+	if machineContext.VSphereMachine.Status.TaskRef != "" {
+		t.Fatalf("expected no task, got %q", machineContext.VSphereMachine.Status.TaskRef)
+	}
+	if simulator.Map.Get(vm.Reference()) == nil {
+		t.Fatal("expected retained vm to exist")
+	}
+}
+
+func TestDestroyVM_ExistingVMNotAdopted(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	oneNIC := []infrav1.NetworkDeviceSpec{{NetworkName: "VM Network", DHCP4: true}}
+
+	testCases := []struct {
+		name     string
+		template bool
+		devices  []infrav1.NetworkDeviceSpec
+	}{
+		{
+			name:     "template",
+			template: true,
+			devices:  oneNIC,
+		},
+		{
+			name:    "network device count mismatch",
+			devices: append(oneNIC, oneNIC...),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vm.Config.Template = tc.template
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: infrav1.VSphereMachineSpec{
+					ExistingVMUUID: vm.Config.Uuid,
+					Network:        infrav1.NetworkSpec{Devices: tc.devices},
+				},
+			})
+
+			var vms VMService
+			if _, err := vms.ReconcileVM(machineContext); err == nil {
+				t.Fatal("expected adoption to be rejected")
+			}
+
+			result, err := vms.DestroyVM(machineContext)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.State != infrav1.VirtualMachineStateNotFound {
+				t.Fatalf("expected vm state %q, got %q", infrav1.VirtualMachineStateNotFound, result.State)
+			}
+			if machineContext.VSphereMachine.Status.TaskRef != "" {
+				t.Fatalf("expected no task, got %q", machineContext.VSphereMachine.Status.TaskRef)
+			}
+			if simulator.Map.Get(vm.Reference()) == nil {
+				t.Fatal("expected vm that was not adopted to exist")
+			}
+			if vm.Runtime.PowerState != vimtypes.VirtualMachinePowerStatePoweredOn {
+				t.Fatalf("expected vm that was not adopted to stay powered on, got %q", vm.Runtime.PowerState)
+			}
+		})
+	}
+}
+
+func TestReconcileImmutableSpec(t *testing.T) {
+	createdSpec := infrav1.VSphereMachineSpec{
+		Template:      "ubuntu",
+		FailureDomain: "az-1",
+		Network: infrav1.NetworkSpec{
+			Devices: []infrav1.NetworkDeviceSpec{{NetworkName: "VM Network"}},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		notRecorded     bool
+		modifySpec      func(*infrav1.VSphereMachineSpec)
+		expectedChanged bool
+		expectedMessage string
+	}{
+		{
+			name:        "not recorded",
+			modifySpec:  func(spec *infrav1.VSphereMachineSpec) { spec.Template = "centos" },
+			notRecorded: true,
+		},
+		{
+			name:       "unchanged",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) { spec.NumCPUs = 4 },
+		},
+		{
+			name: "changed",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.Template = "centos"
+				spec.FailureDomain = "az-2"
+				spec.Network.Devices[0].NetworkName = "DC0_DVPG0"
+			},
+			expectedChanged: true,
+			expectedMessage: `fields template, failureDomain, network of machine "test-machine" changed after its vm was created`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vsphereMachine := &infrav1.VSphereMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				Spec:       *createdSpec.DeepCopy(),
+			}
+			if !tc.notRecorded {
+				vsphereMachine.Status.CreatedSpec = getCreatedSpec(createdSpec)
+				vsphereMachine.Status.FailureDomain = createdSpec.FailureDomain
+			}
+			tc.modifySpec(&vsphereMachine.Spec)
+			ctx := &context.MachineContext{
+				ClusterContext: &context.ClusterContext{
+					Cluster: &clusterv1.Cluster{
+						ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					},
+				},
+				Machine: &clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				VSphereMachine: vsphereMachine,
+			}
+
+			vms := &VMService{}
+			vms.reconcileImmutableSpec(ctx)
+
+			cond := util.GetMachineCondition(vsphereMachine, infrav1.MachineImmutableSpecChanged)
+			if tc.notRecorded {
+				if cond != nil {
+					t.Fatalf("expected no condition, got %+v", cond)
+				}
+				if actual := vsphereMachine.Status.CreatedSpec; actual == nil || actual.Template != vsphereMachine.Spec.Template {
+					t.Fatalf("expected current spec to be recorded, got %+v", actual)
+				}
+				return
+			}
+			if cond == nil {
+				t.Fatal("expected condition")
+			}
+			if actual := cond.Status == corev1.ConditionTrue; actual != tc.expectedChanged {
+				t.Errorf("expected changed %t, got %t", tc.expectedChanged, actual)
+			}
+			if cond.Message != tc.expectedMessage {
+				t.Errorf("expected message %q, got %q", tc.expectedMessage, cond.Message)
+			}
+		})
+	}
+}
+
+func TestReconcileFailedCreate(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	// failClone returns a clone task that failed with the provided fault.
+	failClone := func(fault vimtypes.BaseMethodFault) string {
+		task := simulator.CreateTask(vm, "clone", func(*simulator.Task) (vimtypes.AnyType, vimtypes.BaseMethodFault) {
+			return nil, fault
+		})
+		return task.Run().Value
+	}
+
+	testCases := []struct {
+		name            string
+		fault           vimtypes.BaseMethodFault
+		expectedRequeue []bool
+	}{
+		{
+			name:            "permanent fault",
+			fault:           &vimtypes.InvalidArgument{},
+			expectedRequeue: []bool{false},
+		},
+		{
+			name:  "transient fault",
+			fault: &vimtypes.InvalidState{},
+			// The clone is retried with backoff until it has been attempted
+			// CloneRetryMaxAttempts times.
+			expectedRequeue: []bool{true, true, true, false},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{})
+			defer resetCloneRetries(machineContext)
+
+			var lastRequeue time.Duration
+			for attempt, expectedRequeue := range tc.expectedRequeue {
+				machineContext.VSphereMachine.Status.TaskRef = failClone(tc.fault)
+				ok, err := reconcileFailedCreate(machineContext)
+				if ok || err == nil {
+					t.Fatalf("attempt %d: expected failed clone error, got %t, %v", attempt+1, ok, err)
+				}
+				if machineContext.VSphereMachine.Status.TaskRef != "" {
+					t.Fatalf("attempt %d: expected failed clone task to be forgotten", attempt+1)
+				}
+				requeueErr, requeue := errors.Cause(err).(*services.RequeueAfterError)
+				if requeue != expectedRequeue {
+					t.Fatalf("attempt %d: expected requeue %t, got %v", attempt+1, expectedRequeue, err)
+				}
+				if requeue {
+					if requeueErr.RequeueAfter <= lastRequeue {
+						t.Fatalf("attempt %d: expected requeue after longer than %s, got %s", attempt+1, lastRequeue, requeueErr.RequeueAfter)
+					}
+					lastRequeue = requeueErr.RequeueAfter
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileFailedCreate_OtherTasks(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{})
+	defer resetCloneRetries(machineContext)
+	failTask := func(id string) string {
+		task := simulator.CreateTask(vm, id, func(*simulator.Task) (vimtypes.AnyType, vimtypes.BaseMethodFault) {
+			return nil, &vimtypes.InvalidState{}
+		})
+		return task.Run().Value
+	}
+
+	// A clone that failed with a transient fault is retried once.
+	machineContext.VSphereMachine.Status.TaskRef = failTask("clone")
+	if _, err := reconcileFailedCreate(machineContext); err == nil {
+		t.Fatal("expected failed clone error")
+	}
+
+	// A failed task that did not create the VM is left to be reported like
+	// any other failed task.
+	powerOnTask := failTask("powerOn")
+	machineContext.VSphereMachine.Status.TaskRef = powerOnTask
+	if ok, err := reconcileFailedCreate(machineContext); err != nil || !ok {
+		t.Fatalf("expected failed power on task to be ignored, got %t, %v", ok, err)
+	}
+	if machineContext.VSphereMachine.Status.TaskRef != powerOnTask {
+		t.Fatal("expected failed power on task to be remembered")
+	}
+
+	// A failed destroy of the VM left behind by the failed clone is reported
+	// on its own and is not another clone attempt.
+	machineContext.VSphereMachine.Status.TaskRef = failTask("destroy")
+	ok, err := reconcileFailedCreate(machineContext)
+	if ok || err == nil {
+		t.Fatalf("expected failed destroy error, got %t, %v", ok, err)
+	}
+	if _, requeue := errors.Cause(err).(*services.RequeueAfterError); requeue {
+		t.Fatalf("expected failed destroy not to be retried as a clone, got %v", err)
+	}
+	if machineContext.VSphereMachine.Status.TaskRef != "" {
+		t.Fatal("expected failed destroy task to be forgotten")
+	}
+	if !testEvents.has("OrphanDestroyFailed", "") {
+		t.Fatal("expected failed destroy to be reported")
+	}
+	if attempts := cloneRetries.attempts[cloneSlotKey(machineContext)]; attempts != 1 {
+		t.Fatalf("expected 1 clone attempt, got %d", attempts)
+	}
+}
+
+// busyTemplate is a simulated template whose clones fail right away because
+// the template is busy.
+type busyTemplate struct {
+	simulator.VirtualMachine
+}
+
+func (vm *busyTemplate) CloneVMTask(*simulator.Context, *vimtypes.CloneVM_Task) soap.HasFault {
+	return &methods.CloneVM_TaskBody{Fault_: simulator.Fault("", &vimtypes.InvalidState{})}
+}
+
+func TestReconcileVM_CloneRetriesExhausted(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	simulator.Map.Put(&busyTemplate{VirtualMachine: *vm})
+
+	bootstrapData := ""
+	machineContext := sim.newMachineContext(t, &clusterv1.Machine{
+		Spec: clusterv1.MachineSpec{
+			Bootstrap: clusterv1.Bootstrap{Data: &bootstrapData},
+		},
+	}, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{
+			Template:  vm.Name,
+			NumCPUs:   vm.Config.Hardware.NumCPU,
+			MemoryMiB: int64(vm.Config.Hardware.MemoryMB),
+		},
+	})
+	defer resetCloneRetries(machineContext)
+
+	// The clone is retried with backoff until it has been attempted
+	// CloneRetryMaxAttempts times, after which the error is no longer
+	// transient so it is reported instead of being retried forever.
+	var vms VMService
+	for attempt := 1; attempt <= constants.CloneRetryMaxAttempts; attempt++ {
+		_, err := vms.ReconcileVM(machineContext)
+		if err == nil {
+			t.Fatalf("attempt %d: expected clone error", attempt)
+		}
+		_, requeue := errors.Cause(err).(*services.RequeueAfterError)
+		if expected := attempt < constants.CloneRetryMaxAttempts; requeue != expected {
+			t.Fatalf("attempt %d: expected requeue %t, got %v", attempt, expected, err)
+		}
+		if !requeue && services.IsTransientError(err) {
+			t.Fatalf("attempt %d: expected error not to be transient once retries are exhausted, got %v", attempt, err)
+		}
+	}
+}
+
+func TestIsCloneTimedOut(t *testing.T) {
+	now := time.Now()
+	started := now.Add(-time.Hour)
+
+	testCases := []struct {
+		name     string
+		info     vimtypes.TaskInfo
+		expected bool
+	}{
+		{
+			name:     "running clone",
+			info:     vimtypes.TaskInfo{DescriptionId: cloneTaskDescriptionID, State: vimtypes.TaskInfoStateRunning, QueueTime: started, StartTime: &now},
+			expected: false,
+		},
+		{
+			name:     "timed out clone",
+			info:     vimtypes.TaskInfo{DescriptionId: cloneTaskDescriptionID, State: vimtypes.TaskInfoStateRunning, QueueTime: started, StartTime: &started},
+			expected: true,
+		},
+		{
+			name:     "timed out queued clone",
+			info:     vimtypes.TaskInfo{DescriptionId: cloneTaskDescriptionID, State: vimtypes.TaskInfoStateQueued, QueueTime: started},
+			expected: true,
+		},
+		{
+			name:     "failed clone",
+			info:     vimtypes.TaskInfo{DescriptionId: cloneTaskDescriptionID, State: vimtypes.TaskInfoStateError, QueueTime: started, StartTime: &started},
+			expected: false,
+		},
+		{
+			name:     "other task",
+			info:     vimtypes.TaskInfo{DescriptionId: "VirtualMachine.destroy", State: vimtypes.TaskInfoStateRunning, QueueTime: started, StartTime: &started},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isCloneTimedOut(tc.info, 30*time.Minute, now); actual != tc.expected {
+				t.Errorf("expected timed out %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestReconcileMachineRef(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vms := simulator.Map.All("VirtualMachine")
+	machineVM := vms[0].(*simulator.VirtualMachine)
+	otherVM := vms[1].(*simulator.VirtualMachine)
+
+	testCases := []struct {
+		name               string
+		machineRef         string
+		instanceUUID       string
+		expectedMachineRef string
+		expectedError      bool
+	}{
+		{
+			name:               "no recorded instance uuid",
+			machineRef:         otherVM.Reference().Value,
+			expectedMachineRef: otherVM.Reference().Value,
+		},
+		{
+			name:               "machine ref of vm",
+			machineRef:         machineVM.Reference().Value,
+			instanceUUID:       machineVM.Config.InstanceUuid,
+			expectedMachineRef: machineVM.Reference().Value,
+		},
+		{
+			name:               "machine ref of other vm",
+			machineRef:         otherVM.Reference().Value,
+			instanceUUID:       machineVM.Config.InstanceUuid,
+			expectedMachineRef: machineVM.Reference().Value,
+		},
+		{
+			name:               "missing machine ref",
+			machineRef:         "vm-missing",
+			instanceUUID:       machineVM.Config.InstanceUuid,
+			expectedMachineRef: machineVM.Reference().Value,
+		},
+		{
+			name:          "missing vm",
+			machineRef:    otherVM.Reference().Value,
+			instanceUUID:  "deadbeef-0000-0000-0000-000000000000",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec:   infrav1.VSphereMachineSpec{MachineRef: tc.machineRef},
+				Status: infrav1.VSphereMachineStatus{InstanceUUID: tc.instanceUUID},
+			})
+
+			err := reconcileMachineRef(machineContext)
+			if tc.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if ref := machineContext.VSphereMachine.Spec.MachineRef; ref != "" {
+					t.Fatalf("expected machine ref to be cleared, got %q", ref)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref := machineContext.VSphereMachine.Spec.MachineRef; ref != tc.expectedMachineRef {
+				t.Fatalf("expected machine ref %q, got %q", tc.expectedMachineRef, ref)
+			}
+		})
+	}
+}
+
+func TestReconcileHostStatus(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	var otherHost *simulator.HostSystem
+	for _, obj := range simulator.Map.All("HostSystem") {
+		if host := obj.(*simulator.HostSystem); host.Reference() != *vm.Runtime.Host {
+			otherHost = host
+			break
+		}
+	}
+	if otherHost == nil {
+		t.Fatal("expected another host")
+	}
+
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{MachineRef: vm.Reference().Value},
+	})
+
+	var vms VMService
+	if err := vms.reconcileHostStatus(machineContext); err != nil {
+		t.Fatal(err)
+	}
+	host := simulator.Map.Get(*vm.Runtime.Host).(*simulator.HostSystem)
+	if actual := machineContext.VSphereMachine.Status.Host; actual != host.Name {
+		t.Fatalf("expected host %q, got %q", host.Name, actual)
+	}
+
+	// The VM is migrated to another host.
+	ref := otherHost.Reference()
+	vm.Runtime.Host = &ref
+	if err := vms.reconcileHostStatus(machineContext); err != nil {
+		t.Fatal(err)
+	}
+	if actual := machineContext.VSphereMachine.Status.Host; actual != otherHost.Name {
+		t.Fatalf("expected host %q after migration, got %q", otherHost.Name, actual)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// guestShutdowns tracks the guest shutdowns this process issued to the VMs
+// of deleted or resized machines.
+var guestShutdowns = newShutdownTracker()
+
+// shutdownTracker records when the guest of each machine's VM was asked to
+// shut down. A guest shutdown is not a vSphere task, so it cannot be tracked
+// with the machine's task reference. A shutdown that is forgotten, ex. when
+// the controller restarts, is issued again.
+type shutdownTracker struct {
+	sync.Mutex
+
+	// started maps a machine to the time its guest shutdown was issued.
+	started map[string]time.Time
+
+	now func() time.Time
+}
+
+func newShutdownTracker() *shutdownTracker {
+	return &shutdownTracker{
+		started: map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+// start records that the machine's guest shutdown was issued, unless one is
+// already recorded.
+func (t *shutdownTracker) start(machine string) {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.started[machine]; !ok {
+		t.started[machine] = t.now()
+	}
+}
+
+// elapsed returns the time since the machine's guest shutdown was issued,
+// and false if no shutdown is recorded for the machine.
+func (t *shutdownTracker) elapsed(machine string) (time.Duration, bool) {
+	t.Lock()
+	defer t.Unlock()
+	started, ok := t.started[machine]
+	if !ok {
+		return 0, false
+	}
+	return t.now().Sub(started), true
+}
+
+// forget removes the machine's guest shutdown, if one is recorded.
+func (t *shutdownTracker) forget(machine string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.started, machine)
+}
+
+// reconcileGuestShutdown asks the guest of a powered on VM that is about to
+// be powered off, ex. because its machine is deleted or resized, to shut
+// down, and returns false while the guest is given the power off grace
+// period to do so. True is returned once the VM should be powered off, which
+// is right away if the grace period is disabled or VMware Tools is not
+// running. A warning is emitted if the VM is powered off without the guest
+// having shut down.
+func (vms *VMService) reconcileGuestShutdown(ctx *context.MachineContext) (bool, error) {
+	grace := config.DefaultPowerOffGracePeriod
+	if grace <= 0 {
+		return true, nil
+	}
+
+	key := guestShutdownKey(ctx)
+	if elapsed, ok := guestShutdowns.elapsed(key); ok {
+		if elapsed < grace {
+			ctx.Logger.V(6).Info("requeuing to wait on guest shutdown", "elapsed", elapsed, "grace-period", grace)
+			return false, nil
+		}
+		record.Warnf(ctx.VSphereMachine, "HardPowerOff", "guest of vm %q did not shut down within %s, powering off the vm", ctx.VSphereMachine.Name, grace)
+		return true, nil
+	}
+
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, *getMoRef(ctx), []string{"guest.toolsRunningStatus"}, &obj); err != nil {
+		return false, errors.Wrapf(err, "unable to get guest tools status of vm %q", ctx)
+	}
+	if obj.Guest == nil || obj.Guest.ToolsRunningStatus != string(types.VirtualMachineToolsRunningStatusGuestToolsRunning) {
+		record.Warnf(ctx.VSphereMachine, "HardPowerOff", "powering off vm %q without a guest shutdown as VMware Tools is not running", ctx.VSphereMachine.Name)
+		return true, nil
+	}
+
+	vm, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := vm.ShutdownGuest(ctx); err != nil {
+		record.Warnf(ctx.VSphereMachine, "HardPowerOff", "powering off vm %q as its guest could not be shut down: %v", ctx.VSphereMachine.Name, err)
+		return true, nil
+	}
+	guestShutdowns.start(key)
+	record.Eventf(ctx.VSphereMachine, "GuestShutdown", "shutting down guest of vm %q, the vm is powered off if the guest does not shut down within %s", ctx.VSphereMachine.Name, grace)
+	return false, nil
+}
+
+// forgetGuestShutdown removes the guest shutdown of the machine's VM once
+// the VM is no longer powered on.
+func forgetGuestShutdown(ctx *context.MachineContext) {
+	guestShutdowns.forget(guestShutdownKey(ctx))
+}
+
+func guestShutdownKey(ctx *context.MachineContext) string {
+	return ctx.VSphereMachine.Namespace + "/" + ctx.VSphereMachine.Name
+}
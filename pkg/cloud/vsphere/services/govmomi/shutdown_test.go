@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/config"
+)
+
+func TestReconcileGuestShutdown(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	defer func(grace time.Duration) { config.DefaultPowerOffGracePeriod = grace }(config.DefaultPowerOffGracePeriod)
+	config.DefaultPowerOffGracePeriod = time.Minute
+
+	now := time.Now()
+	defer func(tracker *shutdownTracker) { guestShutdowns = tracker }(guestShutdowns)
+	guestShutdowns = newShutdownTracker()
+	guestShutdowns.now = func() time.Time { return now }
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	testCases := []struct {
+		name          string
+		toolsStatus   vimtypes.VirtualMachineToolsRunningStatus
+		shutdownAgo   *time.Duration
+		expectedOK    bool
+		expectedState vimtypes.VirtualMachinePowerState
+	}{
+		{
+			name:          "tools not running",
+			toolsStatus:   vimtypes.VirtualMachineToolsRunningStatusGuestToolsNotRunning,
+			expectedOK:    true,
+			expectedState: vimtypes.VirtualMachinePowerStatePoweredOn,
+		},
+		{
+			name:          "guest shutdown",
+			toolsStatus:   vimtypes.VirtualMachineToolsRunningStatusGuestToolsRunning,
+			expectedState: vimtypes.VirtualMachinePowerStatePoweredOff,
+		},
+		{
+			name:          "within grace period",
+			toolsStatus:   vimtypes.VirtualMachineToolsRunningStatusGuestToolsRunning,
+			shutdownAgo:   durationPtr(30 * time.Second),
+			expectedState: vimtypes.VirtualMachinePowerStatePoweredOn,
+		},
+		{
+			name:          "grace period elapsed",
+			toolsStatus:   vimtypes.VirtualMachineToolsRunningStatusGuestToolsRunning,
+			shutdownAgo:   durationPtr(2 * time.Minute),
+			expectedOK:    true,
+			expectedState: vimtypes.VirtualMachinePowerStatePoweredOn,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vm.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOn
+			vm.Summary.Runtime.PowerState = vimtypes.VirtualMachinePowerStatePoweredOn
+			vm.Guest.ToolsRunningStatus = string(tc.toolsStatus)
+
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: infrav1.VSphereMachineSpec{MachineRef: vm.Reference().Value},
+			})
+
+			forgetGuestShutdown(machineContext)
+			if tc.shutdownAgo != nil {
+				guestShutdowns.started[guestShutdownKey(machineContext)] = now.Add(-*tc.shutdownAgo)
+			}
+
+			var vms VMService
+			ok, err := vms.reconcileGuestShutdown(machineContext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.expectedOK {
+				t.Fatalf("expected %t, got %t", tc.expectedOK, ok)
+			}
+			if vm.Runtime.PowerState != tc.expectedState {
+				t.Fatalf("expected power state %q, got %q", tc.expectedState, vm.Runtime.PowerState)
+			}
+		})
+	}
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
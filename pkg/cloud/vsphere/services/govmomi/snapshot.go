@@ -0,0 +1,167 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// takeUpdateSnapshot takes a snapshot of the provided VM before the named
+// update and returns true once the snapshot exists. Taking the snapshot, and
+// removing the oldest snapshot taken before an update to make room for it,
+// are tasks recorded in the machine's task reference, so false is returned
+// while either is started and later reconciles wait for it. A snapshot that
+// was retained after the update failed is reused when the update is retried.
+// The snapshot is removed with removeUpdateSnapshots once the update is seen
+// to have been applied.
+func takeUpdateSnapshot(ctx *context.MachineContext, vm *object.VirtualMachine, name string) (bool, error) {
+	snapshots, err := getUpdateSnapshots(ctx, vm, updateSnapshotPrefix)
+	if err != nil {
+		return false, err
+	}
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.Name, updateSnapshotPrefix+name+"-") {
+			return true, nil
+		}
+	}
+
+	// Make room for the new snapshot so snapshots retained after failed
+	// updates do not accumulate.
+	if len(snapshots) >= maxUpdateSnapshots {
+		ctx.Logger.V(4).Info("removing old update snapshot", "snapshot", snapshots[0].Name)
+		return false, removeSnapshot(ctx, vm, snapshots[0].Snapshot, snapshots[0].Name)
+	}
+
+	snapshotName := fmt.Sprintf("%s%s-%d", updateSnapshotPrefix, name, time.Now().UnixNano())
+	ctx.Logger.V(4).Info("creating snapshot before update", "update", name, "snapshot", snapshotName)
+	task, err := vm.CreateSnapshot(ctx, snapshotName, fmt.Sprintf("Taken by Cluster API before %s of machine %q", name, ctx.VSphereMachine.Name), false, false)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to trigger snapshot op for vm %q", ctx)
+	}
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("reenqueue to wait for snapshot op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return false, nil
+}
+
+// removeUpdateSnapshots removes the snapshots taken before the named update
+// of the provided VM and returns true once none remain. The snapshots are
+// removed one task at a time, so false is returned while a removal is
+// started and later reconciles wait for it.
+func removeUpdateSnapshots(ctx *context.MachineContext, vm *object.VirtualMachine, name string) (bool, error) {
+	snapshots, err := getUpdateSnapshots(ctx, vm, updateSnapshotPrefix+name+"-")
+	if err != nil {
+		return false, err
+	}
+	if len(snapshots) == 0 {
+		return true, nil
+	}
+	ctx.Logger.V(4).Info("removing snapshot after update", "update", name, "snapshot", snapshots[0].Name)
+	return false, removeSnapshot(ctx, vm, snapshots[0].Snapshot, snapshots[0].Name)
+}
+
+// getRetainedUpdateSnapshot returns the name of the latest snapshot taken
+// before the named update of the provided VM, which is retained for recovery
+// if the update failed, or an empty string if the VM has no such snapshot.
+func getRetainedUpdateSnapshot(ctx *context.MachineContext, vm *object.VirtualMachine, name string) (string, error) {
+	snapshots, err := getUpdateSnapshots(ctx, vm, updateSnapshotPrefix+name+"-")
+	if err != nil || len(snapshots) == 0 {
+		return "", err
+	}
+	return snapshots[len(snapshots)-1].Name, nil
+}
+
+// recordUpdateSnapshotRetained emits a warning that names the snapshot
+// retained for recovery after the named update of the machine's VM failed
+// for the provided reason. Nothing is emitted if the machine does not take
+// snapshots before updates or the VM has no snapshot for the update.
+func recordUpdateSnapshotRetained(ctx *context.MachineContext, name, reason string) {
+	if !ctx.VSphereMachine.Spec.SnapshotBeforeUpdate {
+		return
+	}
+	vm, err := getVMfromMachineRef(ctx)
+	if err != nil {
+		ctx.Logger.Error(err, "unable to get snapshot retained after failed update", "update", name)
+		return
+	}
+	snapshot, err := getRetainedUpdateSnapshot(ctx, vm, name)
+	if err != nil {
+		ctx.Logger.Error(err, "unable to get snapshot retained after failed update", "update", name)
+		return
+	}
+	if snapshot != "" {
+		record.Warnf(ctx.VSphereMachine, "UpdateFailed", "%s of vm %q failed, snapshot %q was retained for recovery: %s",
+			name, ctx.VSphereMachine.Name, snapshot, reason)
+	}
+}
+
+// getUpdateSnapshots returns the snapshots of the provided VM whose names
+// have the provided prefix, oldest first.
+func getUpdateSnapshots(ctx *context.MachineContext, vm *object.VirtualMachine, prefix string) ([]types.VirtualMachineSnapshotTree, error) {
+	var obj mo.VirtualMachine
+	if err := vm.Properties(ctx, vm.Reference(), []string{"snapshot"}, &obj); err != nil {
+		return nil, errors.Wrapf(err, "unable to get snapshots of vm %q", ctx)
+	}
+	if obj.Snapshot == nil {
+		return nil, nil
+	}
+
+	var snapshots []types.VirtualMachineSnapshotTree
+	var walk func([]types.VirtualMachineSnapshotTree)
+	walk = func(trees []types.VirtualMachineSnapshotTree) {
+		for _, tree := range trees {
+			if strings.HasPrefix(tree.Name, prefix) {
+				snapshots = append(snapshots, tree)
+			}
+			walk(tree.ChildSnapshotList)
+		}
+	}
+	walk(obj.Snapshot.RootSnapshotList)
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreateTime.Before(snapshots[j].CreateTime)
+	})
+	return snapshots, nil
+}
+
+// removeSnapshot starts the removal of the snapshot of the provided VM,
+// which consolidates the VM's disks, and records the removal task in the
+// machine's task reference.
+func removeSnapshot(ctx *context.MachineContext, vm *object.VirtualMachine, snapshot types.ManagedObjectReference, name string) error {
+	consolidate := true
+	res, err := methods.RemoveSnapshot_Task(ctx, vm.Client(), &types.RemoveSnapshot_Task{
+		This:        snapshot,
+		Consolidate: &consolidate,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to trigger removal of snapshot %q of vm %q", name, ctx)
+	}
+	ctx.VSphereMachine.Status.TaskRef = res.Returnval.Value
+	ctx.Logger.V(6).Info("reenqueue to wait for snapshot removal op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return nil
+}
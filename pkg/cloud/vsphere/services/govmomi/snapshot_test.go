@@ -0,0 +1,170 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	vimtypes "github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+)
+
+func TestUpdateSnapshots(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	simVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{
+			MachineRef:           simVM.Reference().Value,
+			SnapshotBeforeUpdate: true,
+		},
+	})
+	vm, err := getVMfromMachineRef(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countUpdateSnapshots := func() int {
+		var obj mo.VirtualMachine
+		if err := vm.Properties(machineContext, vm.Reference(), []string{"snapshot"}, &obj); err != nil {
+			t.Fatal(err)
+		}
+		if obj.Snapshot == nil {
+			return 0
+		}
+		count := 0
+		var walk func([]vimtypes.VirtualMachineSnapshotTree)
+		walk = func(trees []vimtypes.VirtualMachineSnapshotTree) {
+			for _, tree := range trees {
+				if strings.HasPrefix(tree.Name, updateSnapshotPrefix) {
+					count++
+				}
+				walk(tree.ChildSnapshotList)
+			}
+		}
+		walk(obj.Snapshot.RootSnapshotList)
+		return count
+	}
+
+	// expectTask asserts that a task was started and waits for it the way
+	// the next reconcile does.
+	expectTask := func(ok bool, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok || machineContext.VSphereMachine.Status.TaskRef == "" {
+			t.Fatal("expected task to be recorded")
+		}
+		if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+			t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+		}
+	}
+
+	// The snapshot is taken by a task, after which it exists.
+	expectTask(takeUpdateSnapshot(machineContext, vm, "test-update"))
+	if count := countUpdateSnapshots(); count != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", count)
+	}
+	if ok, err := takeUpdateSnapshot(machineContext, vm, "test-update"); err != nil || !ok {
+		t.Fatalf("expected snapshot to be taken, got %t, %v", ok, err)
+	}
+
+	// The snapshot is removed by a task, after which none remain.
+	expectTask(removeUpdateSnapshots(machineContext, vm, "test-update"))
+	if count := countUpdateSnapshots(); count != 0 {
+		t.Fatalf("expected no snapshots after removal, got %d", count)
+	}
+	if ok, err := removeUpdateSnapshots(machineContext, vm, "test-update"); err != nil || !ok {
+		t.Fatalf("expected snapshots to be removed, got %t, %v", ok, err)
+	}
+
+	// Only the most recent snapshots retained after failed updates are
+	// kept, so the oldest is removed to make room for a new snapshot.
+	for i := 0; i < maxUpdateSnapshots; i++ {
+		expectTask(takeUpdateSnapshot(machineContext, vm, fmt.Sprintf("failed-update-%d", i)))
+	}
+	if count := countUpdateSnapshots(); count != maxUpdateSnapshots {
+		t.Fatalf("expected %d snapshots, got %d", maxUpdateSnapshots, count)
+	}
+	expectTask(takeUpdateSnapshot(machineContext, vm, "test-update"))
+	if count := countUpdateSnapshots(); count != maxUpdateSnapshots-1 {
+		t.Fatalf("expected %d snapshots after pruning, got %d", maxUpdateSnapshots-1, count)
+	}
+	expectTask(takeUpdateSnapshot(machineContext, vm, "test-update"))
+	if count := countUpdateSnapshots(); count != maxUpdateSnapshots {
+		t.Fatalf("expected %d snapshots, got %d", maxUpdateSnapshots, count)
+	}
+	snapshots, err := getUpdateSnapshots(machineContext, vm, updateSnapshotPrefix+"failed-update-0-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 0 {
+		t.Fatalf("expected oldest snapshot to be removed, got %s", snapshots[0].Name)
+	}
+}
+
+func TestRecordUpdateSnapshotRetained(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	simVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+		Spec: infrav1.VSphereMachineSpec{
+			MachineRef:           simVM.Reference().Value,
+			SnapshotBeforeUpdate: true,
+		},
+	})
+	vm, err := getVMfromMachineRef(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := takeUpdateSnapshot(machineContext, vm, hardwareUpgradeUpdate); err != nil {
+		t.Fatal(err)
+	}
+	if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+		t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+	}
+	snapshot, err := getRetainedUpdateSnapshot(machineContext, vm, hardwareUpgradeUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot == "" {
+		t.Fatal("expected snapshot to be taken")
+	}
+
+	// A failed upgrade is reported with the snapshot retained for recovery.
+	task := simulator.CreateTask(simVM, "upgradeVirtualHardware", func(*simulator.Task) (vimtypes.AnyType, vimtypes.BaseMethodFault) {
+		return nil, &vimtypes.InvalidState{}
+	})
+	machineContext.VSphereMachine.Status.TaskRef = task.Run().Value
+	if inflight, err := hasInFlightTask(machineContext); err != nil || inflight {
+		t.Fatalf("expected task to be complete, got in flight %t, error %v", inflight, err)
+	}
+	if !testEvents.has("UpdateFailed", snapshot) {
+		t.Fatalf("expected UpdateFailed event naming snapshot %q", snapshot)
+	}
+}
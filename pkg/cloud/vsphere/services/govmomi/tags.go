@@ -0,0 +1,306 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/mo"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+const (
+	tagCategoryCluster = "capv-cluster"
+	tagCategoryMachine = "capv-machine"
+	tagCategoryRole    = "capv-role"
+
+	tagRoleControlPlane = "control-plane"
+	tagRoleWorker       = "worker"
+
+	tagCardinalitySingle = "SINGLE"
+	tagAssociableTypeVM  = "VirtualMachine"
+
+	// tagAlreadyExistsError is the vAPI error returned when a tag category
+	// or tag is created with a name that is already in use.
+	tagAlreadyExistsError = "com.vmware.vapi.std.errors.already_exists"
+)
+
+// tagIDs caches the IDs of the tag categories and tags this process found or
+// created on each vSphere server, so tagging a VM does not list every
+// category and tag on the server.
+var tagIDs = newTagCache()
+
+// tagCache maps the names of tag categories and tags to their IDs.
+type tagCache struct {
+	sync.Mutex
+
+	// categories maps a server and category name to the category's ID.
+	categories map[string]string
+
+	// tags maps a server, category ID, and tag name to the tag's ID.
+	tags map[string]string
+}
+
+func newTagCache() *tagCache {
+	return &tagCache{
+		categories: map[string]string{},
+		tags:       map[string]string{},
+	}
+}
+
+func (c *tagCache) category(server, name string) string {
+	c.Lock()
+	defer c.Unlock()
+	return c.categories[server+"/"+name]
+}
+
+func (c *tagCache) setCategory(server, name, id string) {
+	c.Lock()
+	defer c.Unlock()
+	c.categories[server+"/"+name] = id
+}
+
+func (c *tagCache) tag(server, categoryID, name string) string {
+	c.Lock()
+	defer c.Unlock()
+	return c.tags[server+"/"+categoryID+"/"+name]
+}
+
+func (c *tagCache) setTag(server, categoryID, name, id string) {
+	c.Lock()
+	defer c.Unlock()
+	c.tags[server+"/"+categoryID+"/"+name] = id
+}
+
+// forget removes the named category, and every tag cached for it, from the
+// server's cache.
+func (c *tagCache) forget(server, category string) {
+	c.Lock()
+	defer c.Unlock()
+	key := server + "/" + category
+	if id, ok := c.categories[key]; ok {
+		delete(c.categories, key)
+		prefix := server + "/" + id + "/"
+		for k := range c.tags {
+			if strings.HasPrefix(k, prefix) {
+				delete(c.tags, k)
+			}
+		}
+	}
+}
+
+// forgetTag removes the named tag in the provided category from the
+// server's cache.
+func (c *tagCache) forgetTag(server, categoryID, name string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.tags, server+"/"+categoryID+"/"+name)
+}
+
+// tagVM attaches the cluster, machine, and role tags to the machine's VM,
+// creating the tag categories and tags as needed.
+func tagVM(ctx *context.MachineContext, vm mo.Reference) error {
+	restClient, err := ctx.Session.NewRestClient(ctx, url.UserPassword(ctx.User(), ctx.Pass()))
+	if err != nil {
+		return errors.Wrapf(err, "unable to tag vm for %q", ctx)
+	}
+	defer func() {
+		_ = restClient.Logout(ctx)
+	}()
+	manager := tags.NewManager(restClient)
+
+	role := tagRoleWorker
+	if util.IsControlPlaneMachine(ctx.Machine) {
+		role = tagRoleControlPlane
+	}
+
+	for category, name := range map[string]string{
+		tagCategoryCluster: ctx.Cluster.Name,
+		tagCategoryMachine: ctx.Machine.Name,
+		tagCategoryRole:    role,
+	} {
+		tagID, err := getOrCreateTag(ctx, manager, category, name)
+		if err != nil {
+			return err
+		}
+		if err := manager.AttachTag(ctx, tagID, vm); err != nil {
+			// The cached IDs may refer to a category or tag that was
+			// deleted outside of this process, so they are looked up again
+			// on the next attempt.
+			tagIDs.forget(ctx.Server(), category)
+			return errors.Wrapf(err, "unable to attach tag %q in category %q to vm for %q", name, category, ctx)
+		}
+		ctx.Logger.V(6).Info("attached tag to vm", "category", category, "tag", name)
+	}
+
+	return nil
+}
+
+// deleteMachineTag deletes the machine's tag, which also detaches it from the
+// machine's VM. It is not an error if the tag does not exist.
+func deleteMachineTag(ctx *context.MachineContext) error {
+	restClient, err := ctx.Session.NewRestClient(ctx, url.UserPassword(ctx.User(), ctx.Pass()))
+	if err != nil {
+		return errors.Wrapf(err, "unable to delete tag for %q", ctx)
+	}
+	defer func() {
+		_ = restClient.Logout(ctx)
+	}()
+	manager := tags.NewManager(restClient)
+
+	categoryID, err := findTagCategory(ctx, manager, tagCategoryMachine)
+	if err != nil || categoryID == "" {
+		return err
+	}
+	tagIDs.forgetTag(ctx.Server(), categoryID, ctx.Machine.Name)
+	tag, err := findTag(ctx, manager, categoryID, ctx.Machine.Name)
+	if err != nil || tag == nil {
+		return err
+	}
+	if err := manager.DeleteTag(ctx, tag); err != nil {
+		return errors.Wrapf(err, "unable to delete tag %q in category %q for %q", tag.Name, tagCategoryMachine, ctx)
+	}
+	ctx.Logger.V(6).Info("deleted machine tag", "category", tagCategoryMachine, "tag", tag.Name)
+	return nil
+}
+
+// getOrCreateTag returns the ID of the named tag in the named category,
+// creating the category and tag if they do not exist. The IDs are cached, so
+// the category and tag are only looked up once per server.
+func getOrCreateTag(ctx *context.MachineContext, manager *tags.Manager, category, name string) (string, error) {
+	server := ctx.Server()
+
+	categoryID := tagIDs.category(server, category)
+	if categoryID == "" {
+		var err error
+		if categoryID, err = getOrCreateTagCategory(ctx, manager, category); err != nil {
+			return "", err
+		}
+		tagIDs.setCategory(server, category, categoryID)
+	}
+
+	if tagID := tagIDs.tag(server, categoryID, name); tagID != "" {
+		return tagID, nil
+	}
+	tagID, err := getOrCreateTagInCategory(ctx, manager, categoryID, category, name)
+	if err != nil {
+		return "", err
+	}
+	tagIDs.setTag(server, categoryID, name, tagID)
+	return tagID, nil
+}
+
+// getOrCreateTagInCategory returns the ID of the named tag in the provided
+// category, creating the tag if it does not exist.
+func getOrCreateTagInCategory(ctx *context.MachineContext, manager *tags.Manager, categoryID, category, name string) (string, error) {
+	tag, err := findTag(ctx, manager, categoryID, name)
+	if err != nil {
+		return "", err
+	}
+	if tag != nil {
+		return tag.ID, nil
+	}
+	tagID, err := manager.CreateTag(ctx, &tags.Tag{
+		Name:       name,
+		CategoryID: categoryID,
+	})
+	switch {
+	case err == nil:
+		return tagID, nil
+	case !isTagAlreadyExistsError(err):
+		return "", errors.Wrapf(err, "unable to create tag %q in category %q", name, category)
+	}
+	// The tag was created by another machine since it was looked up.
+	if tag, err = findTag(ctx, manager, categoryID, name); err != nil {
+		return "", err
+	}
+	if tag == nil {
+		return "", errors.Errorf("unable to find tag %q in category %q after it was created", name, category)
+	}
+	return tag.ID, nil
+}
+
+// getOrCreateTagCategory returns the ID of the named tag category, creating
+// the category if it does not exist.
+func getOrCreateTagCategory(ctx *context.MachineContext, manager *tags.Manager, name string) (string, error) {
+	categoryID, err := findTagCategory(ctx, manager, name)
+	if err != nil || categoryID != "" {
+		return categoryID, err
+	}
+	categoryID, err = manager.CreateCategory(ctx, &tags.Category{
+		Name:            name,
+		Description:     "Created by the Cluster API vSphere provider",
+		Cardinality:     tagCardinalitySingle,
+		AssociableTypes: []string{tagAssociableTypeVM},
+	})
+	switch {
+	case err == nil:
+		return categoryID, nil
+	case !isTagAlreadyExistsError(err):
+		return "", errors.Wrapf(err, "unable to create tag category %q", name)
+	}
+	// The category was created by another machine since it was looked up.
+	if categoryID, err = findTagCategory(ctx, manager, name); err != nil {
+		return "", err
+	}
+	if categoryID == "" {
+		return "", errors.Errorf("unable to find tag category %q after it was created", name)
+	}
+	return categoryID, nil
+}
+
+// isTagAlreadyExistsError returns a flag indicating whether the provided
+// error is the result of creating a tag category or tag that already exists.
+func isTagAlreadyExistsError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), tagAlreadyExistsError)
+}
+
+// findTagCategory returns the ID of the named tag category, or an empty
+// string if the category does not exist.
+func findTagCategory(ctx *context.MachineContext, manager *tags.Manager, name string) (string, error) {
+	categories, err := manager.GetCategories(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get tag categories")
+	}
+	for _, category := range categories {
+		if category.Name == name {
+			return category.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// findTag returns the named tag in the provided category, or nil if the tag
+// does not exist.
+func findTag(ctx *context.MachineContext, manager *tags.Manager, categoryID, name string) (*tags.Tag, error) {
+	categoryTags, err := manager.GetTagsForCategory(ctx, categoryID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get tags for category %q", categoryID)
+	}
+	for i := range categoryTags {
+		if categoryTags[i].Name == name {
+			return &categoryTags[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	vapisim "github.com/vmware/govmomi/vapi/simulator"
+	"github.com/vmware/govmomi/vapi/tags"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+)
+
+func TestTagVM(t *testing.T) {
+	sim := newVCSimWithModel(t, simulator.VPX())
+	defer sim.destroy()
+	sim.model.Service.Handle(vapisim.New(sim.server.URL, nil))
+
+	machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{})
+	machineContext.VSphereCluster.Spec.EnableTagging = true
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	restClient, err := machineContext.Session.NewRestClient(machineContext, url.UserPassword(machineContext.User(), machineContext.Pass()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := tags.NewManager(restClient)
+
+	attachedTagNames := func() []string {
+		attached, err := manager.GetAttachedTags(machineContext, vm.Reference())
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, tag := range attached {
+			names = append(names, tag.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Tagging twice must reuse the existing categories and tags.
+	for i := 0; i < 2; i++ {
+		if err := tagVM(machineContext, vm.Reference()); err != nil {
+			t.Fatalf("unexpected error tagging vm: %v", err)
+		}
+	}
+
+	expected := []string{"test-cluster", "test-machine", tagRoleWorker}
+	if actual := attachedTagNames(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected tags %v, got %v", expected, actual)
+	}
+
+	if err := deleteMachineTag(machineContext); err != nil {
+		t.Fatalf("unexpected error deleting machine tag: %v", err)
+	}
+
+	expected = []string{"test-cluster", tagRoleWorker}
+	if actual := attachedTagNames(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected tags %v, got %v", expected, actual)
+	}
+
+	// Deleting a tag that no longer exists is not an error.
+	if err := deleteMachineTag(machineContext); err != nil {
+		t.Fatalf("unexpected error deleting missing machine tag: %v", err)
+	}
+
+	// Cached IDs of a category deleted outside of this process fail the
+	// next attempt, after which the category is created again.
+	categories, err := manager.GetCategories(machineContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range categories {
+		if categories[i].Name == tagCategoryRole {
+			if err := manager.DeleteCategory(machineContext, &categories[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tagVM(machineContext, vm.Reference()); err == nil {
+		t.Fatal("expected error tagging vm with a deleted category")
+	}
+	if err := tagVM(machineContext, vm.Reference()); err != nil {
+		t.Fatalf("unexpected error tagging vm after deleting a category: %v", err)
+	}
+	expected = []string{"test-cluster", "test-machine", tagRoleWorker}
+	if actual := attachedTagNames(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected tags %v, got %v", expected, actual)
+	}
+
+	// Creating a category that already exists, such as one created by
+	// another machine at the same time, is recognized.
+	_, err = manager.CreateCategory(machineContext, &tags.Category{Name: tagCategoryCluster})
+	if !isTagAlreadyExistsError(err) {
+		t.Fatalf("expected already exists error, got %v", err)
+	}
+	categoryID, err := getOrCreateTagCategory(machineContext, manager, tagCategoryCluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached := tagIDs.category(machineContext.Server(), tagCategoryCluster); categoryID != cached {
+		t.Fatalf("expected category id %q, got %q", cached, categoryID)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
 
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
 )
@@ -69,6 +70,20 @@ func findTemplateByName(ctx tplContext, templateID string) (*object.VirtualMachi
 	return tpl, nil
 }
 
+// GetTemplateVersion returns the version of a template, its instance UUID and
+// the time at which its configuration last changed. The version changes when
+// the template is updated or replaced by another template.
+func GetTemplateVersion(ctx tplContext, tpl *object.VirtualMachine) (string, error) {
+	var obj mo.VirtualMachine
+	if err := tpl.Properties(ctx, tpl.Reference(), []string{"config.instanceUuid", "config.changeVersion"}, &obj); err != nil {
+		return "", errors.Wrapf(err, "unable to get version of template %q", tpl.Reference())
+	}
+	if obj.Config == nil {
+		return "", errors.Errorf("unable to get version of template %q: template has no config", tpl.Reference())
+	}
+	return obj.Config.InstanceUuid + "@" + obj.Config.ChangeVersion, nil
+}
+
 func isValidUUID(str string) bool {
 	_, err := uuid.Parse(str)
 	return err == nil
@@ -24,6 +24,7 @@ import (
 
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/net"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
 )
 
 func sanitizeIPAddrs(ctx *context.MachineContext, ipAddrs []string) []string {
@@ -54,6 +55,37 @@ func findVMByInstanceUUID(ctx *context.MachineContext) (string, error) {
 	return "", nil
 }
 
+// findMachineVM returns the managed object ID of the machine's VM. The VM is
+// the one with the instance UUID recorded in the machine's status, if any.
+// Otherwise it is the existing VM the machine adopts, if any, or the VM with
+// the machine's instance UUID. An empty string is returned if the VM does
+// not exist.
+func findMachineVM(ctx *context.MachineContext) (string, error) {
+	if instanceUUID := ctx.VSphereMachine.Status.InstanceUUID; instanceUUID != "" {
+		ctx.Logger.V(6).Info("finding vm by recorded instance UUID", "instance-uuid", instanceUUID)
+		ref, err := ctx.Session.FindByInstanceUUID(ctx, instanceUUID)
+		if err != nil || ref == nil {
+			return "", err
+		}
+		return ref.Reference().Value, nil
+	}
+
+	uuid := ctx.VSphereMachine.Spec.ExistingVMUUID
+	if uuid == "" {
+		return findVMByInstanceUUID(ctx)
+	}
+	ctx.Logger.V(6).Info("finding existing vm by UUID", "uuid", uuid)
+	ref, err := ctx.Session.FindByUUID(ctx, uuid)
+	if err != nil {
+		return "", err
+	}
+	if ref != nil {
+		ctx.Logger.V(6).Info("found existing vm by UUID", "uuid", uuid)
+		return ref.Reference().Value, nil
+	}
+	return "", nil
+}
+
 func getTask(ctx *context.MachineContext) *mo.Task {
 	var obj mo.Task
 	moRef := types.ManagedObjectReference{
@@ -75,22 +107,41 @@ func hasInFlightTask(ctx *context.MachineContext) (bool, error) {
 		// check if the status of task is in a favourable state
 		// If task is in completed or error state we can process further.
 		// if task is in other states requeue
-		ctx := context.NewMachineLoggerContext(ctx, task.Reference().Value)
+		logger := ctx.Logger.WithValues("task", task.Reference().Value)
 
-		ctx.Logger.V(4).Info("task found", "state", task.Info.State, "description-id", task.Info.DescriptionId)
+		logger.V(4).Info("task found", "state", task.Info.State, "description-id", task.Info.DescriptionId)
 		switch task.Info.State {
 		case types.TaskInfoStateQueued:
-			ctx.Logger.V(4).Info("task is still pending", "description-id", task.Info.DescriptionId)
+			logger.V(4).Info("task is still pending", "description-id", task.Info.DescriptionId)
 			return true, nil
 		case types.TaskInfoStateRunning:
-			ctx.Logger.V(4).Info("task is still running", "description-id", task.Info.DescriptionId)
+			logger.V(4).Info("task is still running", "description-id", task.Info.DescriptionId)
 			return true, nil
 		case types.TaskInfoStateSuccess:
-			ctx.Logger.V(4).Info("task is a success", "description-id", task.Info.DescriptionId)
+			logger.V(4).Info("task is a success", "description-id", task.Info.DescriptionId)
+			forgetDeletedBootstrapISO(ctx, task.Info)
+			observeTask(task.Info)
 			ctx.VSphereMachine.Status.TaskRef = ""
 			return false, nil
 		case types.TaskInfoStateError:
-			ctx.Logger.V(2).Info("task failed", "description-id", task.Info.DescriptionId)
+			if forgetDeletedBootstrapISO(ctx, task.Info) {
+				logger.V(4).Info("datastore file of task was already deleted", "description-id", task.Info.DescriptionId)
+				observeTask(task.Info)
+				ctx.VSphereMachine.Status.TaskRef = ""
+				return false, nil
+			}
+			reason := "unknown error"
+			if task.Info.Error != nil {
+				reason = task.Info.Error.LocalizedMessage
+			}
+			logger.V(2).Info("task failed", "description-id", task.Info.DescriptionId, "reason", reason)
+			// Tasks are not waited for, so the task's error is reported as
+			// an event rather than returned.
+			record.Warnf(ctx.VSphereMachine, "TaskFailed", "task %s of vm %q failed: %s", task.Info.DescriptionId, ctx.VSphereMachine.Name, reason)
+			if task.Info.DescriptionId == hardwareUpgradeTaskDescriptionID {
+				recordUpdateSnapshotRetained(ctx, hardwareUpgradeUpdate, reason)
+			}
+			observeTask(task.Info)
 			ctx.VSphereMachine.Status.TaskRef = ""
 			return false, nil
 		default:
@@ -127,6 +178,34 @@ func getVMObject(ctx *context.MachineContext) (mo.VirtualMachine, error) {
 		Value: ctx.VSphereMachine.Spec.MachineRef,
 	}
 	var obj mo.VirtualMachine
-	err := ctx.Session.RetrieveOne(ctx, moRef, []string{"name"}, &obj)
+	err := ctx.Session.RetrieveOne(ctx, moRef, []string{"name", "runtime.connectionState", "config.instanceUuid"}, &obj)
 	return obj, err
 }
+
+// reconcileMachineRef verifies the machine's MachineRef still refers to the
+// VM with the machine's recorded instance UUID. If it does not, ex. because
+// the VM was re-registered or the ID was reused by another VM, MachineRef is
+// updated to the VM with the instance UUID. An error is returned if no VM
+// has the instance UUID, so another VM is never acted on in its place.
+func reconcileMachineRef(ctx *context.MachineContext) error {
+	instanceUUID := ctx.VSphereMachine.Status.InstanceUUID
+	if instanceUUID == "" || ctx.VSphereMachine.Spec.MachineRef == "" {
+		return nil
+	}
+	if obj, err := getVMObject(ctx); err == nil && obj.Config != nil && obj.Config.InstanceUuid == instanceUUID {
+		return nil
+	}
+
+	ref, err := findMachineVM(ctx)
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		ctx.VSphereMachine.Spec.MachineRef = ""
+		return errors.Errorf("unable to find vm with instance UUID %q for %q", instanceUUID, ctx)
+	}
+	ctx.Logger.V(2).Info("updated moref id of vm", "instance-uuid", instanceUUID, "old-moref-id", ctx.VSphereMachine.Spec.MachineRef, "moref-id", ref)
+	record.Eventf(ctx.VSphereMachine, "MachineRefUpdated", "vm with instance UUID %q moved from %q to %q", instanceUUID, ctx.VSphereMachine.Spec.MachineRef, ref)
+	ctx.VSphereMachine.Spec.MachineRef = ref
+	return nil
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package govmomi
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/simulator"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+)
+
+func TestValidateMachine(t *testing.T) {
+	sim := newVCSim(t)
+	defer sim.destroy()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	// The simulator does not mark the datastores of its hosts as accessible.
+	for _, obj := range simulator.Map.All("Datastore") {
+		obj.(*simulator.Datastore).Summary.Accessible = true
+	}
+
+	newSpec := func() infrav1.VSphereMachineSpec {
+		return infrav1.VSphereMachineSpec{
+			Template: vm.Name,
+			Network: infrav1.NetworkSpec{
+				Devices: []infrav1.NetworkDeviceSpec{
+					{
+						NetworkName: "VM Network",
+						DHCP4:       true,
+					},
+				},
+			},
+			DiskGiB: 1,
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		modifySpec    func(*infrav1.VSphereMachineSpec)
+		expectedError bool
+	}{
+		{
+			name:       "valid",
+			modifySpec: func(*infrav1.VSphereMachineSpec) {},
+		},
+		{
+			name: "template and content library item",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.ContentLibraryItem = "library/item"
+			},
+			expectedError: true,
+		},
+		{
+			name: "missing template",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.Template = "missing-template"
+			},
+			expectedError: true,
+		},
+		{
+			name: "missing network",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.Network.Devices[0].NetworkName = "missing-network"
+			},
+			expectedError: true,
+		},
+		{
+			name: "missing resource pool",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.ResourcePool = "missing-pool"
+			},
+			expectedError: true,
+		},
+		{
+			name: "missing datastore",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.Datastore = "missing-datastore"
+			},
+			expectedError: true,
+		},
+		{
+			name: "datastores",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.Datastores = []string{"LocalDS_0"}
+			},
+		},
+		{
+			name: "missing datastore in datastores",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.Datastores = []string{"LocalDS_0", "missing-datastore"}
+			},
+			expectedError: true,
+		},
+		{
+			name: "insufficient datastore space",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.DiskGiB = 1024 * 1024
+			},
+			expectedError: true,
+		},
+		{
+			name: "static ip without gateway",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.Network.Devices[0].DHCP4 = false
+				spec.Network.Devices[0].IPAddrs = []string{"192.168.1.10/24"}
+			},
+			expectedError: true,
+		},
+		{
+			name: "additional disk with file name and size",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.AdditionalDisks = []infrav1.DiskSpec{{FileName: "[LocalDS_0] data.vmdk", SizeGiB: 10}}
+			},
+			expectedError: true,
+		},
+		{
+			name: "additional disk without file name or size",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.AdditionalDisks = []infrav1.DiskSpec{{KeepOnDelete: true}}
+			},
+			expectedError: true,
+		},
+		{
+			name: "duplicate additional disk",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.AdditionalDisks = []infrav1.DiskSpec{{FileName: "[LocalDS_0] data.vmdk"}, {FileName: "[LocalDS_0] data.vmdk"}}
+			},
+			expectedError: true,
+		},
+		{
+			name: "thick disks with linked clone",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.CloneMode = infrav1.LinkedClone
+				spec.DiskProvisioning = infrav1.ThickDiskProvisioning
+			},
+			expectedError: true,
+		},
+		{
+			name: "missing compute cluster",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.ComputeCluster = "missing-cluster"
+			},
+			expectedError: true,
+		},
+		{
+			name: "missing host",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.Host = "missing-host"
+			},
+			expectedError: true,
+		},
+		{
+			name: "undefined failure domain",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.FailureDomain = "missing-domain"
+			},
+			expectedError: true,
+		},
+		{
+			name: "ovf properties without content library item",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.OVFProperties = map[string]string{"license-key": "ABC-123"}
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			spec := newSpec()
+			tc.modifySpec(&spec)
+
+			machineContext := sim.newMachineContext(t, nil, &infrav1.VSphereMachine{
+				Spec: spec,
+			})
+
+			err := validateMachine(machineContext)
+			if !tc.expectedError {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+				t.Fatalf("expected machine error, got %T: %v", err, err)
+			}
+		})
+	}
+}
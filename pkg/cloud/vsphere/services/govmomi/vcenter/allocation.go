@@ -0,0 +1,53 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+)
+
+// GetResourceAllocation returns the CPU and memory allocation of the
+// machine's VM. Nil is returned for an allocation the spec does not set, so
+// the VM keeps its current allocation. The memory reservation is ignored for
+// VMs with high latency sensitivity, whose memory is fully reserved.
+func GetResourceAllocation(spec infrav1.VSphereMachineSpec) (*types.ResourceAllocationInfo, *types.ResourceAllocationInfo) {
+	memoryReservationMiB := spec.MemoryReservationMiB
+	if spec.LatencySensitivity == infrav1.HighLatencySensitivity {
+		memoryReservationMiB = 0
+	}
+	return newResourceAllocation(spec.CPUReservationMHz, spec.CPULimitMHz, spec.Shares),
+		newResourceAllocation(memoryReservationMiB, spec.MemoryLimitMiB, spec.Shares)
+}
+
+func newResourceAllocation(reservation, limit int64, shares infrav1.SharesLevel) *types.ResourceAllocationInfo {
+	if reservation == 0 && limit == 0 && shares == "" {
+		return nil
+	}
+	alloc := &types.ResourceAllocationInfo{}
+	if reservation != 0 {
+		alloc.Reservation = &reservation
+	}
+	if limit != 0 {
+		alloc.Limit = &limit
+	}
+	if shares != "" {
+		alloc.Shares = &types.SharesInfo{Level: types.SharesLevel(shares)}
+	}
+	return alloc
+}
@@ -17,17 +17,27 @@ limitations under the License.
 package vcenter
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
 
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/extra"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/template"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
 )
 
 const (
-	diskMoveType = string(types.VirtualMachineRelocateDiskMoveOptionsMoveAllDiskBackingsAndConsolidate)
+	fullCloneDiskMoveType   = string(types.VirtualMachineRelocateDiskMoveOptionsMoveAllDiskBackingsAndConsolidate)
+	linkedCloneDiskMoveType = string(types.VirtualMachineRelocateDiskMoveOptionsCreateNewChildDiskBacking)
+
+	morefTypeClusterComputeResource = "ClusterComputeResource"
 )
 
 // Clone kicks off a clone operation on vCenter to create a new virtual machine.
@@ -35,99 +45,499 @@ func Clone(ctx *context.MachineContext, bootstrapData []byte) error {
 	ctx = context.NewMachineLoggerContext(ctx, "vcenter")
 	ctx.Logger.V(6).Info("starting clone process")
 
-	var extraConfig extra.Config
-	extraConfig.SetCloudInitUserData(bootstrapData)
-
 	tpl, err := template.FindTemplate(ctx, ctx.VSphereMachine.Spec.Template)
 	if err != nil {
 		return err
 	}
 
-	folder, err := ctx.Session.Finder.FolderOrDefault(ctx, ctx.VSphereCluster.Spec.CloudProviderConfiguration.Workspace.Folder)
+	folder, err := getFolder(ctx)
 	if err != nil {
-		return errors.Wrapf(err, "unable to get folder for %q", ctx)
+		return err
 	}
 
-	datastore, err := ctx.Session.Finder.DatastoreOrDefault(ctx, ctx.VSphereCluster.Spec.CloudProviderConfiguration.Workspace.Datastore)
+	vmName, err := getVMName(ctx)
 	if err != nil {
-		return errors.Wrapf(err, "unable to get datastore for %q", ctx)
+		return err
 	}
 
-	pool, err := ctx.Session.Finder.ResourcePoolOrDefault(ctx, ctx.VSphereCluster.Spec.CloudProviderConfiguration.Workspace.ResourcePool)
-	if err != nil {
-		return errors.Wrapf(err, "unable to get resource pool for %q", ctx)
+	if ctx.VSphereMachine.Spec.Datastore != "" && ctx.VSphereMachine.Spec.DatastoreCluster != "" {
+		return errors.Errorf("invalid storage placement for %q: datastore %q and datastore cluster %q are mutually exclusive",
+			ctx, ctx.VSphereMachine.Spec.Datastore, ctx.VSphereMachine.Spec.DatastoreCluster)
 	}
 
-	devices, err := tpl.Device(ctx)
+	if ctx.VSphereMachine.Spec.StoragePolicy != "" && ctx.VSphereMachine.Spec.DatastoreCluster != "" {
+		return errors.Errorf("invalid storage placement for %q: storage policy %q and datastore cluster %q are mutually exclusive",
+			ctx, ctx.VSphereMachine.Spec.StoragePolicy, ctx.VSphereMachine.Spec.DatastoreCluster)
+	}
 
+	pool, err := getResourcePool(ctx)
 	if err != nil {
-		return errors.Wrapf(err, "error getting devices for %q", ctx)
+		return err
 	}
 
-	diskSpec, err := getDiskSpec(ctx, devices)
-	if err != nil {
-		return errors.Wrapf(err, "error getting disk spec for %q", ctx)
+	cloneMode := ctx.VSphereMachine.Spec.CloneMode
+	if cloneMode == "" {
+		cloneMode = infrav1.FullClone
 	}
 
-	networkSpecs, err := getNetworkSpecs(ctx, devices)
-	if err != nil {
-		return errors.Wrapf(err, "error getting network specs for %q", ctx)
+	var (
+		datastoreRef *types.ManagedObjectReference
+		storagePod   *object.StoragePod
+		profileID    string
+	)
+	switch {
+	case ctx.VSphereMachine.Spec.DatastoreCluster != "":
+		if storagePod, err = ctx.Session.Finder.DatastoreCluster(ctx, ctx.VSphereMachine.Spec.DatastoreCluster); err != nil {
+			return errors.Wrapf(err, "unable to get datastore cluster %q for %q", ctx.VSphereMachine.Spec.DatastoreCluster, ctx)
+		}
+	case ctx.VSphereMachine.Spec.StoragePolicy != "":
+		// The disks of a linked clone are backed by the template's snapshot,
+		// so only the policy's compatibility is checked.
+		var requiredBytes int64
+		if cloneMode == infrav1.FullClone {
+			if requiredBytes, err = getRequiredDiskBytes(ctx, tpl); err != nil {
+				return errors.Wrapf(err, "unable to get required disk space for %q", ctx)
+			}
+		}
+		if profileID, datastoreRef, err = getStoragePolicyPlacement(ctx, pool, requiredBytes); err != nil {
+			return err
+		}
+	default:
+		datastore, err := getDatastore(ctx)
+		if err != nil {
+			return err
+		}
+		datastoreRef = types.NewReference(datastore.Reference())
 	}
 
-	deviceSpecs := []types.BaseVirtualDeviceConfigSpec{diskSpec}
-	deviceSpecs = append(deviceSpecs, networkSpecs...)
+	snapshotRef, diskMoveType, err := getCloneDiskBacking(ctx, tpl, cloneMode)
+	if err != nil {
+		return err
+	}
 
-	numCPUs := ctx.VSphereMachine.Spec.NumCPUs
-	if numCPUs < 2 {
-		numCPUs = 2
+	devices, err := tpl.Device(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "error getting devices for %q", ctx)
 	}
-	numCoresPerSocket := ctx.VSphereMachine.Spec.NumCoresPerSocket
-	if numCoresPerSocket == 0 {
-		numCoresPerSocket = numCPUs
+
+	// The disks of a linked clone are backed by the template's snapshot and
+	// cannot be resized.
+	configSpec, err := newConfigSpec(ctx, bootstrapData, devices, cloneMode == infrav1.FullClone)
+	if err != nil {
+		return err
 	}
-	memMiB := ctx.VSphereMachine.Spec.MemoryMiB
-	if memMiB == 0 {
-		memMiB = 2048
+
+	// PCI passthrough devices are specific to a host, so the VM is placed on
+	// a host that has the requested devices.
+	var hostRef *types.ManagedObjectReference
+	if len(ctx.VSphereMachine.Spec.PCIDevices) > 0 {
+		var pciSpecs []types.BaseVirtualDeviceConfigSpec
+		if hostRef, pciSpecs, err = getPCIDeviceSpecsForPool(ctx, pool.Reference()); err != nil {
+			return err
+		}
+		configSpec.DeviceChange = append(configSpec.DeviceChange, pciSpecs...)
+	} else if hostRef, err = getHost(ctx, pool); err != nil {
+		return err
 	}
 
 	spec := types.VirtualMachineCloneSpec{
-		Config: &types.VirtualMachineConfigSpec{
-			Annotation: ctx.String(),
-			// Assign the clone's InstanceUUID the value of the Kubernetes Machine
-			// object's UID. This allows lookup of the cloned VM prior to knowing
-			// the VM's UUID.
-			InstanceUuid:      string(ctx.Machine.UID),
-			Flags:             newVMFlagInfo(),
-			DeviceChange:      deviceSpecs,
-			ExtraConfig:       extraConfig,
-			NumCPUs:           numCPUs,
-			NumCoresPerSocket: numCoresPerSocket,
-			MemoryMB:          memMiB,
-		},
+		Config: configSpec,
 		Location: types.VirtualMachineRelocateSpec{
-			Datastore:    types.NewReference(datastore.Reference()),
+			Datastore:    datastoreRef,
 			DiskMoveType: diskMoveType,
 			Folder:       types.NewReference(folder.Reference()),
+			Host:         hostRef,
 			Pool:         types.NewReference(pool.Reference()),
 		},
 		// This is implicit, but making it explicit as it is important to not
 		// power the VM on before its virtual hardware is created and the MAC
 		// address(es) used to build and inject the VM with cloud-init metadata
 		// are generated.
-		PowerOn: false,
+		PowerOn:  false,
+		Snapshot: snapshotRef,
+	}
+
+	if profileID != "" {
+		spec.Location.Profile = newStorageProfileSpecs(profileID)
+	}
+
+	if storagePod != nil {
+		if spec.Location.Datastore, err = recommendDatastore(ctx, vmName, tpl, folder, storagePod, spec); err != nil {
+			return err
+		}
+	}
+
+	// The disks of a linked clone are backed by the template's snapshot, so
+	// only the disks of a full clone are provisioned as requested.
+	var provisioning infrav1.DiskProvisioningType
+	if cloneMode == infrav1.FullClone {
+		provisioning = ctx.VSphereMachine.Spec.DiskProvisioning
+	}
+	if err := checkCloneDiskProvisioning(ctx, cloneMode, *spec.Location.Datastore); err != nil {
+		return err
+	}
+
+	if profileID != "" || provisioning != "" {
+		for _, disk := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+			locator := types.VirtualMachineRelocateSpecDiskLocator{
+				DiskId:    disk.GetVirtualDevice().Key,
+				Datastore: *spec.Location.Datastore,
+			}
+			if profileID != "" {
+				locator.Profile = newStorageProfileSpecs(profileID)
+			}
+			if provisioning != "" {
+				backing := &types.VirtualDiskFlatVer2BackingInfo{
+					DiskMode: string(types.VirtualDiskModePersistent),
+				}
+				setDiskProvisioning(backing, provisioning)
+				locator.DiskBackingInfo = backing
+			}
+			spec.Location.Disk = append(spec.Location.Disk, locator)
+		}
 	}
 
 	ctx.Logger.V(6).Info("cloning machine", "clone-spec", spec)
-	task, err := tpl.Clone(ctx, folder, ctx.Machine.Name, spec)
+	task, err := tpl.Clone(ctx, folder, vmName, spec)
 	if err != nil {
 		return errors.Wrapf(err, "error trigging clone op for machine %q", ctx)
 	}
 
 	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.VSphereMachine.Status.Datastore = getDatastoreName(ctx, *spec.Location.Datastore)
+	ctx.Logger.V(6).Info("started clone op", "task", ctx.VSphereMachine.Status.TaskRef)
+
+	// The template's version is only used to detect when the template drifts
+	// from the VM, so failing to get it does not fail the clone.
+	if ctx.VSphereMachine.Status.TemplateVersion, err = template.GetTemplateVersion(ctx, tpl); err != nil {
+		ctx.Logger.Error(err, "unable to record template version")
+	}
+
+	record.Eventf(ctx.VSphereMachine, "CloneStarted", "started clone of machine %q from template %q", ctx.Machine.Name, ctx.VSphereMachine.Spec.Template)
 
 	return nil
 }
 
+// getVMName returns the name of the machine's VM, rendered from the
+// cluster's VM name template.
+func getVMName(ctx *context.MachineContext) (string, error) {
+	name, err := util.GetMachineVMName(ctx.VSphereCluster.Spec.VMNameTemplate, ctx.Cluster.Name, ctx.Machine)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to get vm name for %q", ctx)
+	}
+	return name, nil
+}
+
+// getFolder returns the folder in which the machine's VM is created. The
+// machine's folder takes precedence over its failure domain's folder, then
+// the workspace's folder, and the datacenter's VM folder is used if none is
+// set.
+func getFolder(ctx *context.MachineContext) (*object.Folder, error) {
+	name := ctx.VSphereMachine.Spec.Folder
+	if fd := ctx.FailureDomain(); name == "" && fd != nil {
+		name = fd.Folder
+	}
+	if name == "" {
+		name = ctx.VSphereCluster.Spec.CloudProviderConfiguration.Workspace.Folder
+	}
+	folder, err := ctx.Session.Finder.FolderOrDefault(ctx, name)
+	if err != nil {
+		if name != "" {
+			return nil, errors.Wrapf(err, "unable to find folder %q for %q", name, ctx)
+		}
+		return nil, errors.Wrapf(err, "unable to get default folder for %q", ctx)
+	}
+	return folder, nil
+}
+
+// getResourcePool returns the resource pool in which the machine's VM is
+// created. The machine's resource pool takes precedence over the root
+// resource pool of the machine's compute cluster, then its failure domain's
+// resource pool or compute cluster, then the workspace's resource pool, and
+// the default resource pool is used if none is set.
+func getResourcePool(ctx *context.MachineContext) (*object.ResourcePool, error) {
+	name := ctx.VSphereMachine.Spec.ResourcePool
+	computeCluster := ctx.VSphereMachine.Spec.ComputeCluster
+	if fd := ctx.FailureDomain(); name == "" && computeCluster == "" && fd != nil {
+		name, computeCluster = fd.ResourcePool, fd.ComputeCluster
+	}
+	if name == "" && computeCluster != "" {
+		cluster, err := ctx.Session.Finder.ClusterComputeResource(ctx, computeCluster)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to find compute cluster %q for %q", computeCluster, ctx)
+		}
+		pool, err := cluster.ResourcePool(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get resource pool of compute cluster %q for %q", computeCluster, ctx)
+		}
+		return pool, nil
+	}
+	if name == "" {
+		name = ctx.VSphereCluster.Spec.CloudProviderConfiguration.Workspace.ResourcePool
+	}
+	pool, err := ctx.Session.Finder.ResourcePoolOrDefault(ctx, name)
+	if err != nil {
+		if name != "" {
+			return nil, errors.Wrapf(err, "unable to find resource pool %q for %q", name, ctx)
+		}
+		return nil, errors.Wrapf(err, "unable to get default resource pool for %q", ctx)
+	}
+	return pool, nil
+}
+
+// getHost returns the host on which the machine's VM is created in the
+// provided resource pool, or nil if vCenter picks the host. An error is
+// returned if the machine's host is not in the pool's compute resource, or
+// if no host is set and the pool belongs to a compute cluster without DRS.
+func getHost(ctx *context.MachineContext, pool *object.ResourcePool) (*types.ManagedObjectReference, error) {
+	var poolObj mo.ResourcePool
+	if err := ctx.Session.RetrieveOne(ctx, pool.Reference(), []string{"owner"}, &poolObj); err != nil {
+		return nil, errors.Wrapf(err, "unable to get owner of resource pool for %q", ctx)
+	}
+
+	if name := ctx.VSphereMachine.Spec.Host; name != "" {
+		host, err := ctx.Session.Finder.HostSystem(ctx, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to find host %q for %q", name, ctx)
+		}
+		var hostObj mo.HostSystem
+		if err := ctx.Session.RetrieveOne(ctx, host.Reference(), []string{"parent"}, &hostObj); err != nil {
+			return nil, errors.Wrapf(err, "unable to get compute resource of host %q for %q", name, ctx)
+		}
+		if hostObj.Parent == nil || *hostObj.Parent != poolObj.Owner {
+			return nil, capierrors.InvalidMachineConfiguration("invalid host for %q: host %q is not in the compute resource of resource pool %q", ctx, name, pool.InventoryPath)
+		}
+		ref := host.Reference()
+		return &ref, nil
+	}
+
+	if poolObj.Owner.Type != morefTypeClusterComputeResource {
+		return nil, nil
+	}
+	var clusterObj mo.ClusterComputeResource
+	if err := ctx.Session.RetrieveOne(ctx, poolObj.Owner, []string{"configurationEx"}, &clusterObj); err != nil {
+		return nil, errors.Wrapf(err, "unable to get configuration of compute cluster for %q", ctx)
+	}
+	if config, ok := clusterObj.ConfigurationEx.(*types.ClusterConfigInfoEx); ok && config.DrsConfig.Enabled != nil && *config.DrsConfig.Enabled {
+		return nil, nil
+	}
+	return nil, capierrors.InvalidMachineConfiguration("invalid host for %q: a host is required as DRS is disabled on the compute cluster of resource pool %q", ctx, pool.InventoryPath)
+}
+
+// getDatastore returns the datastore on which the machine's VM is created.
+// The machine's datastore, or the one selected from its datastores, takes
+// precedence over its failure domain's datastore, then the workspace's
+// datastore, and the default datastore is used if none is set.
+func getDatastore(ctx *context.MachineContext) (*object.Datastore, error) {
+	name := ctx.VSphereMachine.Spec.Datastore
+	if name == "" && len(ctx.VSphereMachine.Spec.Datastores) > 0 {
+		return selectDatastore(ctx)
+	}
+	if fd := ctx.FailureDomain(); name == "" && fd != nil {
+		name = fd.Datastore
+	}
+	if name == "" {
+		name = ctx.VSphereCluster.Spec.CloudProviderConfiguration.Workspace.Datastore
+	}
+	datastore, err := ctx.Session.Finder.DatastoreOrDefault(ctx, name)
+	if err != nil {
+		if name != "" {
+			return nil, errors.Wrapf(err, "unable to find datastore %q for %q", name, ctx)
+		}
+		return nil, errors.Wrapf(err, "unable to get default datastore for %q", ctx)
+	}
+	return datastore, nil
+}
+
+// newConfigSpec returns the configuration applied to a new machine's VM,
+// derived from the devices of the VM's source and the machine's spec. The
+// disk is only resized when resizeDisk is true.
+func newConfigSpec(
+	ctx *context.MachineContext,
+	bootstrapData []byte,
+	devices object.VirtualDeviceList,
+	resizeDisk bool) (*types.VirtualMachineConfigSpec, error) {
+
+	var extraConfig extra.Config
+	if ctx.VSphereMachine.Spec.BootstrapFormat == infrav1.IgnitionBootstrapFormat {
+		extraConfig.SetIgnitionUserData(bootstrapData)
+	} else {
+		extraConfig.SetCloudInitUserData(bootstrapData)
+	}
+
+	deviceSpecs := []types.BaseVirtualDeviceConfigSpec{}
+
+	if resizeDisk {
+		diskSpec, err := getDiskSpec(ctx, devices)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting disk spec for %q", ctx)
+		}
+		deviceSpecs = append(deviceSpecs, diskSpec)
+	}
+
+	networkSpecs, err := getNetworkSpecs(ctx, devices)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting network specs for %q", ctx)
+	}
+
+	deviceSpecs = append(deviceSpecs, networkSpecs...)
+
+	diskSpecs, err := getAdditionalDiskSpecs(ctx, devices)
+	if err != nil {
+		return nil, err
+	}
+	deviceSpecs = append(deviceSpecs, diskSpecs...)
+
+	numCPUs := ctx.VSphereMachine.Spec.NumCPUs
+	if numCPUs < minNumCPUs {
+		numCPUs = minNumCPUs
+	}
+	numCoresPerSocket := ctx.VSphereMachine.Spec.NumCoresPerSocket
+	if numCoresPerSocket == 0 {
+		numCoresPerSocket = numCPUs
+	}
+	memMiB := ctx.VSphereMachine.Spec.MemoryMiB
+	if memMiB == 0 {
+		memMiB = 2048
+	}
+
+	// PCI passthrough and high latency sensitivity require the VM's memory
+	// to be fully reserved.
+	var memoryReservationLockedToMax *bool
+	if len(ctx.VSphereMachine.Spec.PCIDevices) > 0 || ctx.VSphereMachine.Spec.LatencySensitivity == infrav1.HighLatencySensitivity {
+		locked := true
+		memoryReservationLockedToMax = &locked
+	}
+
+	cpuAllocation, memoryAllocation := GetResourceAllocation(ctx.VSphereMachine.Spec)
+
+	notes, err := util.GetMachineVMNotes(ctx.VSphereCluster.Spec.VMNotesTemplate, ctx.Cluster.Name, ctx.Machine, time.Now())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get vm notes for %q", ctx)
+	}
+
+	return &types.VirtualMachineConfigSpec{
+		Annotation: notes,
+		// Assign the clone's InstanceUUID the value of the Kubernetes Machine
+		// object's UID. This allows lookup of the cloned VM prior to knowing
+		// the VM's UUID.
+		InstanceUuid:      string(ctx.Machine.UID),
+		Flags:             newVMFlagInfo(),
+		DeviceChange:      deviceSpecs,
+		ExtraConfig:       extraConfig,
+		NumCPUs:           numCPUs,
+		NumCoresPerSocket: numCoresPerSocket,
+		MemoryMB:          memMiB,
+		// Nil values preserve the template's hot-add settings.
+		CpuHotAddEnabled:    ctx.VSphereMachine.Spec.CPUHotAddEnabled,
+		MemoryHotAddEnabled: ctx.VSphereMachine.Spec.MemoryHotAddEnabled,
+
+		MemoryReservationLockedToMax: memoryReservationLockedToMax,
+		CpuAllocation:                cpuAllocation,
+		MemoryAllocation:             memoryAllocation,
+
+		// An empty firmware preserves the template's firmware.
+		Firmware:    string(ctx.VSphereMachine.Spec.Firmware),
+		BootOptions: newBootOptions(ctx),
+
+		LatencySensitivity: newLatencySensitivity(ctx),
+		CpuAffinity:        newCPUAffinity(ctx),
+
+		Tools: newToolsConfigInfo(ctx),
+	}, nil
+}
+
+// newToolsConfigInfo returns the VMware Tools configuration of a new
+// machine's VM, or nil to preserve the configuration of the VM's source.
+func newToolsConfigInfo(ctx *context.MachineContext) *types.ToolsConfigInfo {
+	if ctx.VSphereMachine.Spec.SyncTimeWithHost == nil {
+		return nil
+	}
+	return &types.ToolsConfigInfo{
+		SyncTimeWithHost: ctx.VSphereMachine.Spec.SyncTimeWithHost,
+	}
+}
+
+// getCloneDiskBacking returns the template snapshot and the disk move type
+// with which the machine's VM is cloned in the provided clone mode. A full
+// clone copies the template's disks, while the disks of a linked clone are
+// backed by the template's current snapshot.
+func getCloneDiskBacking(
+	ctx *context.MachineContext,
+	tpl *object.VirtualMachine,
+	cloneMode infrav1.CloneMode) (*types.ManagedObjectReference, string, error) {
+
+	switch cloneMode {
+	case infrav1.FullClone:
+		return nil, fullCloneDiskMoveType, nil
+	case infrav1.LinkedClone:
+		snapshotRef, err := getCurrentSnapshotRef(ctx, tpl)
+		if err != nil {
+			return nil, "", err
+		}
+		return snapshotRef, linkedCloneDiskMoveType, nil
+	default:
+		return nil, "", errors.Errorf("invalid clone mode %q for %q", cloneMode, ctx)
+	}
+}
+
+// getCurrentSnapshotRef returns a reference to the current snapshot of the
+// provided template. An error is returned if the template has no snapshots.
+func getCurrentSnapshotRef(ctx *context.MachineContext, tpl *object.VirtualMachine) (*types.ManagedObjectReference, error) {
+	var obj mo.VirtualMachine
+	if err := tpl.Properties(ctx, tpl.Reference(), []string{"snapshot"}, &obj); err != nil {
+		return nil, errors.Wrapf(err, "unable to get snapshot info for template %q", ctx.VSphereMachine.Spec.Template)
+	}
+	if obj.Snapshot == nil || obj.Snapshot.CurrentSnapshot == nil {
+		return nil, errors.Errorf("unable to perform linked clone for %q: template %q has no snapshots", ctx, ctx.VSphereMachine.Spec.Template)
+	}
+	ctx.Logger.V(6).Info("found template snapshot for linked clone", "snapshot-ref", obj.Snapshot.CurrentSnapshot.Value)
+	return obj.Snapshot.CurrentSnapshot, nil
+}
+
+// recommendDatastore asks Storage DRS to recommend a datastore from the
+// provided datastore cluster on which to place the clone described by spec.
+func recommendDatastore(
+	ctx *context.MachineContext,
+	vmName string,
+	tpl *object.VirtualMachine,
+	folder *object.Folder,
+	pod *object.StoragePod,
+	spec types.VirtualMachineCloneSpec) (*types.ManagedObjectReference, error) {
+
+	podRef := pod.Reference()
+	folderRef := folder.Reference()
+	tplRef := tpl.Reference()
+
+	placementSpec := types.StoragePlacementSpec{
+		Type:      string(types.StoragePlacementSpecPlacementTypeClone),
+		CloneName: vmName,
+		CloneSpec: &spec,
+		Folder:    &folderRef,
+		Vm:        &tplRef,
+		PodSelectionSpec: types.StorageDrsPodSelectionSpec{
+			StoragePod: &podRef,
+		},
+	}
+
+	srm := object.NewStorageResourceManager(ctx.Session.Client.Client)
+	result, err := srm.RecommendDatastores(ctx, placementSpec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get storage drs recommendations for %q from datastore cluster %q", ctx, ctx.VSphereMachine.Spec.DatastoreCluster)
+	}
+
+	for _, recommendation := range result.Recommendations {
+		for _, action := range recommendation.Action {
+			if placement, ok := action.(*types.StoragePlacementAction); ok {
+				ctx.Logger.V(6).Info("using storage drs recommendation", "datastore-cluster", ctx.VSphereMachine.Spec.DatastoreCluster, "datastore-ref", placement.Destination.Value)
+				return &placement.Destination, nil
+			}
+		}
+	}
+
+	return nil, errors.Errorf("no storage drs recommendations for %q from datastore cluster %q", ctx, ctx.VSphereMachine.Spec.DatastoreCluster)
+}
+
 func newVMFlagInfo() *types.VirtualMachineFlagInfo {
 	diskUUIDEnabled := true
 	return &types.VirtualMachineFlagInfo{
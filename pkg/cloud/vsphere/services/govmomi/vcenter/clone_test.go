@@ -0,0 +1,455 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func TestGetFailureDomainPlacement(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+	model.Cluster = 2
+
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	pools := map[string]string{}
+	for _, obj := range simulator.Map.All("ClusterComputeResource") {
+		cluster := obj.(*simulator.ClusterComputeResource)
+		pools[cluster.Name] = cluster.ResourcePool.Value
+	}
+	datastore := simulator.Map.Any("Datastore").(*simulator.Datastore)
+
+	failureDomains := []infrav1.FailureDomain{
+		{
+			Name:           "az-1",
+			Datacenter:     "DC0",
+			ComputeCluster: "DC0_C1",
+			Datastore:      datastore.Name,
+		},
+		{
+			Name:           "az-missing",
+			ComputeCluster: "missing-cluster",
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		modifySpec    func(*infrav1.VSphereMachineSpec)
+		expectedPool  string
+		expectedError bool
+	}{
+		{
+			name:         "compute cluster of failure domain",
+			modifySpec:   func(spec *infrav1.VSphereMachineSpec) { spec.FailureDomain = "az-1" },
+			expectedPool: pools["DC0_C1"],
+		},
+		{
+			name: "machine resource pool overrides failure domain",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.FailureDomain = "az-1"
+				spec.ResourcePool = "/DC0/host/DC0_C0/Resources"
+			},
+			expectedPool: pools["DC0_C0"],
+		},
+		{
+			name: "machine compute cluster overrides failure domain",
+			modifySpec: func(spec *infrav1.VSphereMachineSpec) {
+				spec.FailureDomain = "az-1"
+				spec.ComputeCluster = "DC0_C0"
+			},
+			expectedPool: pools["DC0_C0"],
+		},
+		{
+			name:          "missing compute cluster",
+			modifySpec:    func(spec *infrav1.VSphereMachineSpec) { spec.FailureDomain = "az-missing" },
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+				Cluster: &clusterv1.Cluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+				},
+				VSphereCluster: &infrav1.VSphereCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					Spec: infrav1.VSphereClusterSpec{
+						Server:         s.URL.Host,
+						FailureDomains: failureDomains,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var spec infrav1.VSphereMachineSpec
+			tc.modifySpec(&spec)
+
+			machineContext, err := context.NewMachineContextFromClusterContext(
+				clusterContext,
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				&infrav1.VSphereMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+					Spec:       spec,
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pool, err := getResourcePool(machineContext)
+			if tc.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := pool.Reference().Value; actual != tc.expectedPool {
+				t.Errorf("expected resource pool %q, got %q", tc.expectedPool, actual)
+			}
+
+			ds, err := getDatastore(machineContext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ds.Reference() != datastore.Reference() {
+				t.Errorf("expected datastore %v, got %v", datastore.Reference(), ds.Reference())
+			}
+		})
+	}
+}
+
+func TestGetHost(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+	model.Cluster = 2
+
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	hosts := map[string]types.ManagedObjectReference{}
+	for _, obj := range simulator.Map.All("HostSystem") {
+		hosts[obj.Entity().Name] = obj.Reference()
+	}
+	// DRS is enabled on the simulator's compute clusters, so it is disabled
+	// on the second one.
+	disabled := false
+	for _, obj := range simulator.Map.All("ClusterComputeResource") {
+		if cluster := obj.(*simulator.ClusterComputeResource); cluster.Name == "DC0_C1" {
+			cluster.ConfigurationEx.(*types.ClusterConfigInfoEx).DrsConfig.Enabled = &disabled
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		computeCluster string
+		host           string
+		expectedHost   string
+		expectedError  bool
+	}{
+		{
+			name:           "drs picks host",
+			computeCluster: "DC0_C0",
+		},
+		{
+			name:           "host in compute cluster",
+			computeCluster: "DC0_C0",
+			host:           "DC0_C0_H0",
+			expectedHost:   "DC0_C0_H0",
+		},
+		{
+			name:           "host in other compute cluster",
+			computeCluster: "DC0_C0",
+			host:           "DC0_C1_H0",
+			expectedError:  true,
+		},
+		{
+			name:           "drs disabled without host",
+			computeCluster: "DC0_C1",
+			expectedError:  true,
+		},
+		{
+			name:           "drs disabled with host",
+			computeCluster: "DC0_C1",
+			host:           "DC0_C1_H1",
+			expectedHost:   "DC0_C1_H1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+				Cluster: &clusterv1.Cluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+				},
+				VSphereCluster: &infrav1.VSphereCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					Spec:       infrav1.VSphereClusterSpec{Server: s.URL.Host},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			machineContext, err := context.NewMachineContextFromClusterContext(
+				clusterContext,
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				&infrav1.VSphereMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+					Spec: infrav1.VSphereMachineSpec{
+						ComputeCluster: tc.computeCluster,
+						Host:           tc.host,
+					},
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pool, err := getResourcePool(machineContext)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hostRef, err := getHost(machineContext, pool)
+			if tc.expectedError {
+				if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tc.expectedHost == "" && hostRef != nil:
+				t.Errorf("expected no host, got %v", *hostRef)
+			case tc.expectedHost != "" && (hostRef == nil || *hostRef != hosts[tc.expectedHost]):
+				t.Errorf("expected host %v, got %v", hosts[tc.expectedHost], hostRef)
+			}
+		})
+	}
+}
+
+func TestNewToolsConfigInfo(t *testing.T) {
+	enabled, disabled := true, false
+
+	testCases := []struct {
+		name             string
+		syncTimeWithHost *bool
+		expected         *bool
+	}{
+		{name: "template setting"},
+		{name: "enabled", syncTimeWithHost: &enabled, expected: &enabled},
+		{name: "disabled", syncTimeWithHost: &disabled, expected: &disabled},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &context.MachineContext{
+				VSphereMachine: &infrav1.VSphereMachine{
+					Spec: infrav1.VSphereMachineSpec{SyncTimeWithHost: tc.syncTimeWithHost},
+				},
+			}
+			tools := newToolsConfigInfo(ctx)
+			if tc.expected == nil {
+				if tools != nil {
+					t.Fatalf("expected no tools config, got %+v", tools)
+				}
+				return
+			}
+			if tools == nil || tools.SyncTimeWithHost == nil || *tools.SyncTimeWithHost != *tc.expected {
+				t.Fatalf("expected time sync %t, got %+v", *tc.expected, tools)
+			}
+		})
+	}
+}
+
+func TestGetCloneDiskBacking(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	vms := simulator.Map.All("VirtualMachine")
+	if len(vms) < 2 {
+		t.Fatalf("expected at least 2 vms, got %d", len(vms))
+	}
+	snapshotTemplate, noSnapshotTemplate := vms[0].Entity().Name, vms[1].Entity().Name
+
+	testCases := []struct {
+		name                 string
+		template             string
+		cloneMode            infrav1.CloneMode
+		expectedDiskMoveType string
+		expectedSnapshot     bool
+		expectedError        bool
+	}{
+		{
+			name:                 "full clone",
+			template:             snapshotTemplate,
+			cloneMode:            infrav1.FullClone,
+			expectedDiskMoveType: fullCloneDiskMoveType,
+		},
+		{
+			name:                 "linked clone",
+			template:             snapshotTemplate,
+			cloneMode:            infrav1.LinkedClone,
+			expectedDiskMoveType: linkedCloneDiskMoveType,
+			expectedSnapshot:     true,
+		},
+		{
+			name:          "linked clone without snapshot",
+			template:      noSnapshotTemplate,
+			cloneMode:     infrav1.LinkedClone,
+			expectedError: true,
+		},
+		{
+			name:          "invalid clone mode",
+			template:      snapshotTemplate,
+			cloneMode:     "invalid",
+			expectedError: true,
+		},
+	}
+
+	var snapshotRef types.ManagedObjectReference
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+				Cluster: &clusterv1.Cluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+				},
+				VSphereCluster: &infrav1.VSphereCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					Spec:       infrav1.VSphereClusterSpec{Server: s.URL.Host},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			machineContext, err := context.NewMachineContextFromClusterContext(
+				clusterContext,
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				&infrav1.VSphereMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+					Spec: infrav1.VSphereMachineSpec{
+						Template:  tc.template,
+						CloneMode: tc.cloneMode,
+					},
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tpl, err := machineContext.Session.Finder.VirtualMachine(machineContext, tc.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.template == snapshotTemplate && snapshotRef.Value == "" {
+				task, err := tpl.CreateSnapshot(machineContext, "test-snapshot", "", false, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := task.Wait(machineContext); err != nil {
+					t.Fatal(err)
+				}
+				var obj mo.VirtualMachine
+				if err := tpl.Properties(machineContext, tpl.Reference(), []string{"snapshot"}, &obj); err != nil {
+					t.Fatal(err)
+				}
+				snapshotRef = *obj.Snapshot.CurrentSnapshot
+			}
+
+			actualSnapshot, diskMoveType, err := getCloneDiskBacking(machineContext, tpl, tc.cloneMode)
+			if tc.expectedError {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diskMoveType != tc.expectedDiskMoveType {
+				t.Errorf("expected disk move type %q, got %q", tc.expectedDiskMoveType, diskMoveType)
+			}
+			switch {
+			case !tc.expectedSnapshot && actualSnapshot != nil:
+				t.Errorf("expected no snapshot, got %v", *actualSnapshot)
+			case tc.expectedSnapshot && (actualSnapshot == nil || *actualSnapshot != snapshotRef):
+				t.Errorf("expected snapshot %v, got %v", snapshotRef, actualSnapshot)
+			}
+		})
+	}
+}
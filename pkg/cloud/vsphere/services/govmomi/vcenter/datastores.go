@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
+)
+
+const (
+	// datastoreUnavailableRequeue is how long to wait before selecting a
+	// datastore again when none of the machine's datastores can be used,
+	// ex. because they are all in maintenance mode.
+	datastoreUnavailableRequeue = time.Minute
+
+	// datastorePlacementTimeout is how long a VM placed on a datastore is
+	// assumed to be cloning to it, which is long enough for the clone to
+	// create the VM's disks and reduce the datastore's free space.
+	datastorePlacementTimeout = 10 * time.Minute
+)
+
+// placements tracks the datastores this process placed VMs on recently, as
+// the free space of a datastore does not include the VMs being cloned to it.
+var placements = newPlacementTracker()
+
+// placementTracker tracks the machines whose VMs were recently placed on
+// each datastore.
+type placementTracker struct {
+	sync.Mutex
+
+	// placed maps a datastore to the machines placed on it and the time
+	// each machine was placed.
+	placed map[string]map[string]time.Time
+
+	now func() time.Time
+}
+
+func newPlacementTracker() *placementTracker {
+	return &placementTracker{
+		placed: map[string]map[string]time.Time{},
+		now:    time.Now,
+	}
+}
+
+// inFlight returns the number of machines other than the provided one that
+// were placed on each datastore within the placement timeout.
+func (p *placementTracker) inFlight(machine string, datastores []string) []int {
+	p.Lock()
+	defer p.Unlock()
+
+	now := p.now()
+	counts := make([]int, len(datastores))
+	for i, datastore := range datastores {
+		for m, placed := range p.placed[datastore] {
+			if m != machine && now.Sub(placed) < datastorePlacementTimeout {
+				counts[i]++
+			}
+		}
+	}
+	return counts
+}
+
+// place records that the machine's VM was placed on the datastore, and
+// forgets the machine's earlier placements and any that timed out.
+func (p *placementTracker) place(machine, datastore string) {
+	p.Lock()
+	defer p.Unlock()
+
+	now := p.now()
+	for ds, placed := range p.placed {
+		delete(placed, machine)
+		for m, at := range placed {
+			if now.Sub(at) >= datastorePlacementTimeout {
+				delete(placed, m)
+			}
+		}
+		if len(placed) == 0 {
+			delete(p.placed, ds)
+		}
+	}
+	if _, ok := p.placed[datastore]; !ok {
+		p.placed[datastore] = map[string]time.Time{}
+	}
+	p.placed[datastore][machine] = now
+}
+
+// selectDatastore returns the datastore, from the machine's datastores, on
+// which the machine's VM is created. The free space of the datastores is
+// queried each time a VM is created, less the disks of the VMs this process
+// placed on them recently, so new VMs are placed on the datastore with the
+// most free space and the datastores fill evenly. A
+// *services.RequeueAfterError is returned if none of the datastores can be
+// used right now.
+func selectDatastore(ctx *context.MachineContext) (*object.Datastore, error) {
+	names := ctx.VSphereMachine.Spec.Datastores
+	datastores := make([]*object.Datastore, len(names))
+	refs := make([]string, len(names))
+	summaries := make([]types.DatastoreSummary, len(names))
+	for i, name := range names {
+		datastore, err := ctx.Session.Finder.Datastore(ctx, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to find datastore %q for %q", name, ctx)
+		}
+		var obj mo.Datastore
+		if err := datastore.Properties(ctx, datastore.Reference(), []string{"summary"}, &obj); err != nil {
+			return nil, errors.Wrapf(err, "unable to get free space of datastore %q for %q", name, ctx)
+		}
+		datastores[i], refs[i], summaries[i] = datastore, ctx.Server()+"/"+datastore.Reference().Value, obj.Summary
+	}
+
+	machine := ctx.VSphereMachine.Namespace + "/" + ctx.VSphereMachine.Name
+	inFlight := placements.inFlight(machine, refs)
+	i := pickDatastore(summaries, inFlight, int64(ctx.VSphereMachine.Spec.DiskGiB)*bytesPerGiB)
+	if i < 0 {
+		return nil, &services.RequeueAfterError{
+			RequeueAfter: datastoreUnavailableRequeue,
+			Reason:       fmt.Sprintf("none of the datastores of %q are accessible and out of maintenance mode", ctx),
+		}
+	}
+	placements.place(machine, refs[i])
+	ctx.Logger.V(6).Info("selected datastore", "datastore", names[i], "free-gib", summaries[i].FreeSpace/bytesPerGiB, "in-flight", inFlight[i])
+	return datastores[i], nil
+}
+
+// pickDatastore returns the index of the accessible datastore, not in
+// maintenance mode, with the most free space once diskSize is reserved for
+// each of its in-flight VMs. Ties are broken in favor of the datastore with
+// the fewest in-flight VMs, then the earlier datastore, so VMs of an unknown
+// size are still spread across the datastores. -1 is returned if no
+// datastore can be used.
+func pickDatastore(summaries []types.DatastoreSummary, inFlight []int, diskSize int64) int {
+	picked := -1
+	var pickedFree int64
+	for i, summary := range summaries {
+		if !summary.Accessible {
+			continue
+		}
+		if summary.MaintenanceMode != "" && summary.MaintenanceMode != string(types.DatastoreSummaryMaintenanceModeStateNormal) {
+			continue
+		}
+		free := summary.FreeSpace - int64(inFlight[i])*diskSize
+		if picked < 0 || free > pickedFree || (free == pickedFree && inFlight[i] < inFlight[picked]) {
+			picked, pickedFree = i, free
+		}
+	}
+	return picked
+}
+
+// getDatastoreName returns the name of the referenced datastore, or the
+// reference's value if the name cannot be retrieved.
+func getDatastoreName(ctx *context.MachineContext, ref types.ManagedObjectReference) string {
+	name, err := object.NewDatastore(ctx.Session.Client.Client, ref).ObjectName(ctx)
+	if err != nil {
+		ctx.Logger.Error(err, "unable to get datastore name", "datastore-ref", ref.Value)
+		return ref.Value
+	}
+	return name
+}
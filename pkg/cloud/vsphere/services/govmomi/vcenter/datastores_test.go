@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestPickDatastore(t *testing.T) {
+	const gib = bytesPerGiB
+
+	testCases := []struct {
+		name      string
+		summaries []types.DatastoreSummary
+		inFlight  []int
+		diskSize  int64
+		expected  int
+	}{
+		{
+			name:     "no datastores",
+			expected: -1,
+		},
+		{
+			name: "most free space",
+			summaries: []types.DatastoreSummary{
+				{Accessible: true, FreeSpace: 10 * gib},
+				{Accessible: true, FreeSpace: 30 * gib},
+				{Accessible: true, FreeSpace: 20 * gib},
+			},
+			expected: 1,
+		},
+		{
+			name: "tie prefers earlier datastore",
+			summaries: []types.DatastoreSummary{
+				{Accessible: true, FreeSpace: 10 * gib},
+				{Accessible: true, FreeSpace: 20 * gib},
+				{Accessible: true, FreeSpace: 20 * gib},
+			},
+			expected: 1,
+		},
+		{
+			name: "in-flight vms reserve their disks",
+			summaries: []types.DatastoreSummary{
+				{Accessible: true, FreeSpace: 30 * gib},
+				{Accessible: true, FreeSpace: 20 * gib},
+			},
+			inFlight: []int{2, 0},
+			diskSize: 10 * gib,
+			expected: 1,
+		},
+		{
+			name: "tie prefers fewest in-flight vms",
+			summaries: []types.DatastoreSummary{
+				{Accessible: true, FreeSpace: 20 * gib},
+				{Accessible: true, FreeSpace: 20 * gib},
+			},
+			inFlight: []int{1, 0},
+			expected: 1,
+		},
+		{
+			name: "inaccessible datastore",
+			summaries: []types.DatastoreSummary{
+				{Accessible: true, FreeSpace: 10 * gib},
+				{Accessible: false, FreeSpace: 30 * gib},
+			},
+			expected: 0,
+		},
+		{
+			name: "datastore in maintenance mode",
+			summaries: []types.DatastoreSummary{
+				{Accessible: true, FreeSpace: 30 * gib, MaintenanceMode: string(types.DatastoreSummaryMaintenanceModeStateEnteringMaintenance)},
+				{Accessible: true, FreeSpace: 10 * gib, MaintenanceMode: string(types.DatastoreSummaryMaintenanceModeStateNormal)},
+			},
+			expected: 1,
+		},
+		{
+			name: "no usable datastores",
+			summaries: []types.DatastoreSummary{
+				{Accessible: false, FreeSpace: 10 * gib},
+				{Accessible: true, FreeSpace: 10 * gib, MaintenanceMode: string(types.DatastoreSummaryMaintenanceModeStateInMaintenance)},
+			},
+			expected: -1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			inFlight := tc.inFlight
+			if inFlight == nil {
+				inFlight = make([]int, len(tc.summaries))
+			}
+			if actual := pickDatastore(tc.summaries, inFlight, tc.diskSize); actual != tc.expected {
+				t.Fatalf("expected datastore %d, got %d", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPlacementTracker(t *testing.T) {
+	now := time.Now()
+	tracker := newPlacementTracker()
+	tracker.now = func() time.Time { return now }
+
+	datastores := []string{"ds-1", "ds-2"}
+	tracker.place("machine-1", "ds-1")
+	tracker.place("machine-2", "ds-1")
+	if actual := tracker.inFlight("machine-3", datastores); !reflect.DeepEqual(actual, []int{2, 0}) {
+		t.Fatalf("expected in-flight vms [2 0], got %v", actual)
+	}
+
+	// A machine is not counted against itself, and placing it again moves
+	// it to the new datastore.
+	if actual := tracker.inFlight("machine-1", datastores); !reflect.DeepEqual(actual, []int{1, 0}) {
+		t.Fatalf("expected in-flight vms [1 0], got %v", actual)
+	}
+	tracker.place("machine-1", "ds-2")
+	if actual := tracker.inFlight("machine-3", datastores); !reflect.DeepEqual(actual, []int{1, 1}) {
+		t.Fatalf("expected in-flight vms [1 1], got %v", actual)
+	}
+
+	// Placements are forgotten once they time out.
+	now = now.Add(datastorePlacementTimeout)
+	if actual := tracker.inFlight("machine-3", datastores); !reflect.DeepEqual(actual, []int{0, 0}) {
+		t.Fatalf("expected no in-flight vms, got %v", actual)
+	}
+}
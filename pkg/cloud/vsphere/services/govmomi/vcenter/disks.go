@@ -0,0 +1,220 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// maxUnitNumber is the highest unit number of a device on a controller.
+const maxUnitNumber = 15
+
+// getAdditionalDiskSpecs returns the device specs that attach the machine's
+// additional disks to a VM with the provided devices. The disks are added to
+// the controller of the VM's first disk, after all of the controller's
+// existing devices. An error is returned if an existing disk is attached to
+// another VM that is powered on.
+func getAdditionalDiskSpecs(
+	ctx *context.MachineContext,
+	devices object.VirtualDeviceList) ([]types.BaseVirtualDeviceConfigSpec, error) {
+
+	if len(ctx.VSphereMachine.Spec.AdditionalDisks) == 0 {
+		return nil, nil
+	}
+
+	disks := devices.SelectByType((*types.VirtualDisk)(nil))
+	if len(disks) == 0 {
+		return nil, errors.Errorf("unable to attach additional disks for %q: template has no disks", ctx)
+	}
+	controllerKey := disks[0].GetVirtualDevice().ControllerKey
+	controller, ok := devices.FindByKey(controllerKey).(types.BaseVirtualController)
+	if !ok {
+		return nil, errors.Errorf("unable to attach additional disks for %q: unable to find controller of template's disk", ctx)
+	}
+	// The unit number of a SCSI controller is reserved for the controller.
+	_, isSCSI := controller.(types.BaseVirtualSCSIController)
+
+	var unitNumber int32
+	for _, device := range devices {
+		if d := device.GetVirtualDevice(); d.ControllerKey == controllerKey && d.UnitNumber != nil && *d.UnitNumber >= unitNumber {
+			unitNumber = *d.UnitNumber + 1
+		}
+	}
+
+	// Assign temporary device keys that do not collide with the keys used
+	// for new network devices.
+	key := int32(-200)
+	deviceSpecs := []types.BaseVirtualDeviceConfigSpec{}
+	for _, diskSpec := range ctx.VSphereMachine.Spec.AdditionalDisks {
+		if isSCSI && unitNumber == 7 {
+			unitNumber++
+		}
+		if unitNumber > maxUnitNumber {
+			return nil, errors.Errorf("unable to attach additional disks for %q: no free unit numbers on controller of template's disk", ctx)
+		}
+
+		disk := &types.VirtualDisk{
+			VirtualDevice: types.VirtualDevice{
+				Key:           key,
+				ControllerKey: controllerKey,
+				UnitNumber:    types.NewInt32(unitNumber),
+				Backing: &types.VirtualDiskFlatVer2BackingInfo{
+					VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{
+						FileName: diskSpec.FileName,
+					},
+					DiskMode: string(types.VirtualDiskModePersistent),
+				},
+			},
+		}
+		spec := &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device:    disk,
+		}
+		if diskSpec.FileName != "" {
+			if err := checkDiskNotInUse(ctx, diskSpec.FileName); err != nil {
+				return nil, err
+			}
+			ctx.Logger.V(6).Info("attaching existing disk", "file-name", diskSpec.FileName, "unit-number", unitNumber)
+		} else {
+			disk.CapacityInKB = int64(diskSpec.SizeGiB) * 1024 * 1024
+			setDiskProvisioning(disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo), ctx.VSphereMachine.Spec.DiskProvisioning)
+			spec.FileOperation = types.VirtualDeviceConfigSpecFileOperationCreate
+			ctx.Logger.V(6).Info("creating new disk", "size-gib", diskSpec.SizeGiB, "unit-number", unitNumber)
+		}
+		deviceSpecs = append(deviceSpecs, spec)
+
+		key--
+		unitNumber++
+	}
+	return deviceSpecs, nil
+}
+
+// thickProvisioningDatastoreTypes are the types of datastores that support
+// the thick disk provisioning types.
+var thickProvisioningDatastoreTypes = map[string]bool{
+	string(types.HostFileSystemVolumeFileSystemTypeVMFS): true,
+	string(types.HostFileSystemVolumeFileSystemTypeVsan): true,
+	string(types.HostFileSystemVolumeFileSystemTypeVVOL): true,
+}
+
+// setDiskProvisioning sets the provisioning type of a disk backing. Disks
+// are thin provisioned if no type is provided.
+func setDiskProvisioning(backing *types.VirtualDiskFlatVer2BackingInfo, provisioning infrav1.DiskProvisioningType) {
+	switch provisioning {
+	case infrav1.ThickDiskProvisioning:
+		backing.ThinProvisioned = types.NewBool(false)
+		backing.EagerlyScrub = types.NewBool(false)
+	case infrav1.EagerZeroedThickDiskProvisioning:
+		backing.ThinProvisioned = types.NewBool(false)
+		backing.EagerlyScrub = types.NewBool(true)
+	default:
+		backing.ThinProvisioned = types.NewBool(true)
+	}
+}
+
+// checkCloneDiskProvisioning checks the machine's disk provisioning type
+// against the datastore of a clone. The disks of a linked clone are backed by
+// the template's snapshot, so the type only applies to a linked clone's new
+// additional disks, and is reported as ignored if there are none.
+func checkCloneDiskProvisioning(ctx *context.MachineContext, cloneMode infrav1.CloneMode, datastoreRef types.ManagedObjectReference) error {
+	if cloneMode == infrav1.LinkedClone && !hasNewAdditionalDisks(ctx) {
+		if provisioning := ctx.VSphereMachine.Spec.DiskProvisioning; provisioning != "" {
+			record.Warnf(ctx.VSphereMachine, "DiskProvisioningIgnored",
+				"disk provisioning type %q of machine %q is ignored as the disks of a linked clone are backed by the template's snapshot",
+				provisioning, ctx.Machine.Name)
+		}
+		return nil
+	}
+	return checkDiskProvisioning(ctx, datastoreRef)
+}
+
+// hasNewAdditionalDisks returns true if any of the machine's additional disks
+// is created rather than attached from an existing file.
+func hasNewAdditionalDisks(ctx *context.MachineContext) bool {
+	for _, diskSpec := range ctx.VSphereMachine.Spec.AdditionalDisks {
+		if diskSpec.FileName == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDiskProvisioning returns a *capierrors.MachineError if the machine's
+// disk provisioning type is not supported by the datastore on which the
+// machine's disks are created.
+func checkDiskProvisioning(ctx *context.MachineContext, datastoreRef types.ManagedObjectReference) error {
+	provisioning := ctx.VSphereMachine.Spec.DiskProvisioning
+	switch provisioning {
+	case "", infrav1.ThinDiskProvisioning:
+		return nil
+	case infrav1.ThickDiskProvisioning, infrav1.EagerZeroedThickDiskProvisioning:
+	default:
+		return capierrors.InvalidMachineConfiguration("invalid disk provisioning type %q for %q", provisioning, ctx)
+	}
+
+	var obj mo.Datastore
+	if err := object.NewDatastore(ctx.Session.Client.Client, datastoreRef).Properties(ctx, datastoreRef, []string{"summary"}, &obj); err != nil {
+		return errors.Wrapf(err, "unable to get type of datastore %q for %q", datastoreRef, ctx)
+	}
+	if !thickProvisioningDatastoreTypes[obj.Summary.Type] {
+		return capierrors.InvalidMachineConfiguration("disk provisioning type %q is not supported by datastore %q of type %q for %q",
+			provisioning, obj.Summary.Name, obj.Summary.Type, ctx)
+	}
+	return nil
+}
+
+// checkDiskNotInUse returns an error if the disk with the provided datastore
+// path is attached to a VM that is powered on, other than the machine's VM.
+func checkDiskNotInUse(ctx *context.MachineContext, fileName string) error {
+	manager := view.NewManager(ctx.Session.Client.Client)
+	containerView, err := manager.CreateContainerView(ctx, ctx.Session.Client.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create view to find vms using disk %q for %q", fileName, ctx)
+	}
+	defer func() {
+		_ = containerView.Destroy(ctx)
+	}()
+
+	var vms []mo.VirtualMachine
+	if err := containerView.Retrieve(ctx, []string{"VirtualMachine"}, []string{"name", "config.instanceUuid", "config.hardware.device", "runtime.powerState"}, &vms); err != nil {
+		return errors.Wrapf(err, "unable to find vms using disk %q for %q", fileName, ctx)
+	}
+	for _, vm := range vms {
+		if vm.Config == nil || vm.Config.InstanceUuid == string(ctx.Machine.UID) {
+			continue
+		}
+		if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+			continue
+		}
+		for _, device := range object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil)) {
+			backing, ok := device.GetVirtualDevice().Backing.(types.BaseVirtualDeviceFileBackingInfo)
+			if ok && backing.GetVirtualDeviceFileBackingInfo().FileName == fileName {
+				return errors.Errorf("unable to attach disk %q for %q: disk is attached to powered on vm %q", fileName, ctx, vm.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func TestGetAdditionalDiskSpecs(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+	model.Machine = 3
+
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	vms := simulator.Map.All("VirtualMachine")
+	if len(vms) < 3 {
+		t.Fatalf("expected at least 3 vms, got %d", len(vms))
+	}
+	getDiskFileName := func(ref types.ManagedObjectReference) string {
+		vm := simulator.Map.Get(ref).(*simulator.VirtualMachine)
+		disk := object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))[0]
+		return disk.GetVirtualDevice().Backing.(types.BaseVirtualDeviceFileBackingInfo).GetVirtualDeviceFileBackingInfo().FileName
+	}
+	tpl := simulator.Map.Get(vms[0].Reference()).(*simulator.VirtualMachine)
+	poweredOnDisk := getDiskFileName(vms[1].Reference())
+	poweredOffDisk := getDiskFileName(vms[2].Reference())
+
+	clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+		Cluster: &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+		},
+		VSphereCluster: &infrav1.VSphereCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+			Spec:       infrav1.VSphereClusterSpec{Server: s.URL.Host},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newMachineContext := func(disks ...infrav1.DiskSpec) *context.MachineContext {
+		machineContext, err := context.NewMachineContextFromClusterContext(
+			clusterContext,
+			&clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+			},
+			&infrav1.VSphereMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				Spec:       infrav1.VSphereMachineSpec{AdditionalDisks: disks},
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return machineContext
+	}
+
+	ctx := newMachineContext()
+	task, err := object.NewVirtualMachine(ctx.Session.Client.Client, vms[2].Reference()).PowerOff(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The simulator's VMs do not remap the temporary controller keys of
+	// their disks, so attach the template's disk to its SCSI controller as
+	// vCenter would.
+	devices := object.VirtualDeviceList(tpl.Config.Hardware.Device)
+	tplDisk := devices.SelectByType((*types.VirtualDisk)(nil))[0].GetVirtualDevice()
+	tplDisk.ControllerKey = devices.SelectByType((*types.ParaVirtualSCSIController)(nil))[0].GetVirtualDevice().Key
+
+	// No devices are added without additional disks.
+	if specs, err := getAdditionalDiskSpecs(ctx, devices); err != nil || len(specs) != 0 {
+		t.Fatalf("expected no specs, got %v: %v", specs, err)
+	}
+
+	// A new disk is created and an existing disk is attached after the
+	// template's disk.
+	ctx = newMachineContext(infrav1.DiskSpec{SizeGiB: 10}, infrav1.DiskSpec{FileName: poweredOffDisk, KeepOnDelete: true})
+	specs, err := getAdditionalDiskSpecs(ctx, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("expected 2 specs, got %d", len(specs))
+	}
+	for i, expectedFileOperation := range []types.VirtualDeviceConfigSpecFileOperation{types.VirtualDeviceConfigSpecFileOperationCreate, ""} {
+		spec := specs[i].GetVirtualDeviceConfigSpec()
+		if spec.FileOperation != expectedFileOperation {
+			t.Errorf("expected disk %d file operation %q, got %q", i, expectedFileOperation, spec.FileOperation)
+		}
+		disk := spec.Device.GetVirtualDevice()
+		if disk.ControllerKey != tplDisk.ControllerKey {
+			t.Errorf("expected disk %d controller %d, got %d", i, tplDisk.ControllerKey, disk.ControllerKey)
+		}
+		if *disk.UnitNumber <= *tplDisk.UnitNumber {
+			t.Errorf("expected disk %d unit number after %d, got %d", i, *tplDisk.UnitNumber, *disk.UnitNumber)
+		}
+	}
+	if actual := specs[0].GetVirtualDeviceConfigSpec().Device.(*types.VirtualDisk).CapacityInKB; actual != 10*1024*1024 {
+		t.Errorf("expected new disk capacity of 10GiB, got %dKB", actual)
+	}
+
+	// A disk attached to a powered on VM cannot be attached.
+	ctx = newMachineContext(infrav1.DiskSpec{FileName: poweredOnDisk})
+	if _, err := getAdditionalDiskSpecs(ctx, devices); err == nil {
+		t.Fatal("expected error for disk attached to powered on vm, got nil")
+	}
+}
+
+func TestSetDiskProvisioning(t *testing.T) {
+	testCases := []struct {
+		provisioning         infrav1.DiskProvisioningType
+		expectedThin         bool
+		expectedEagerlyScrub bool
+	}{
+		{provisioning: "", expectedThin: true},
+		{provisioning: infrav1.ThinDiskProvisioning, expectedThin: true},
+		{provisioning: infrav1.ThickDiskProvisioning},
+		{provisioning: infrav1.EagerZeroedThickDiskProvisioning, expectedEagerlyScrub: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.provisioning), func(t *testing.T) {
+			backing := &types.VirtualDiskFlatVer2BackingInfo{}
+			setDiskProvisioning(backing, tc.provisioning)
+			if thin := backing.ThinProvisioned != nil && *backing.ThinProvisioned; thin != tc.expectedThin {
+				t.Errorf("expected thin provisioned %t, got %t", tc.expectedThin, thin)
+			}
+			if scrub := backing.EagerlyScrub != nil && *backing.EagerlyScrub; scrub != tc.expectedEagerlyScrub {
+				t.Errorf("expected eagerly scrub %t, got %t", tc.expectedEagerlyScrub, scrub)
+			}
+		})
+	}
+}
+
+func TestCheckDiskProvisioning(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	ds := simulator.Map.Any("Datastore").(*simulator.Datastore)
+
+	clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+		Cluster: &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+		},
+		VSphereCluster: &infrav1.VSphereCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+			Spec:       infrav1.VSphereClusterSpec{Server: s.URL.Host},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name            string
+		provisioning    infrav1.DiskProvisioningType
+		cloneMode       infrav1.CloneMode
+		additionalDisks []infrav1.DiskSpec
+		datastoreType   types.HostFileSystemVolumeFileSystemType
+		expectedError   bool
+	}{
+		{name: "unset", datastoreType: types.HostFileSystemVolumeFileSystemTypeNFS},
+		{name: "thin on nfs", provisioning: infrav1.ThinDiskProvisioning, datastoreType: types.HostFileSystemVolumeFileSystemTypeNFS},
+		{name: "thick on vmfs", provisioning: infrav1.ThickDiskProvisioning, datastoreType: types.HostFileSystemVolumeFileSystemTypeVMFS},
+		{name: "eager zeroed thick on vsan", provisioning: infrav1.EagerZeroedThickDiskProvisioning, datastoreType: types.HostFileSystemVolumeFileSystemTypeVsan},
+		{name: "eager zeroed thick on nfs", provisioning: infrav1.EagerZeroedThickDiskProvisioning, datastoreType: types.HostFileSystemVolumeFileSystemTypeNFS, expectedError: true},
+		{name: "invalid type", provisioning: "sparse", datastoreType: types.HostFileSystemVolumeFileSystemTypeVMFS, expectedError: true},
+		{name: "thick on nfs with linked clone", provisioning: infrav1.ThickDiskProvisioning, cloneMode: infrav1.LinkedClone, datastoreType: types.HostFileSystemVolumeFileSystemTypeNFS},
+		{
+			name:            "thick on nfs with linked clone and existing additional disk",
+			provisioning:    infrav1.ThickDiskProvisioning,
+			cloneMode:       infrav1.LinkedClone,
+			additionalDisks: []infrav1.DiskSpec{{FileName: "[LocalDS_0] data/disk.vmdk"}},
+			datastoreType:   types.HostFileSystemVolumeFileSystemTypeNFS,
+		},
+		{
+			name:            "thick on nfs with linked clone and new additional disk",
+			provisioning:    infrav1.ThickDiskProvisioning,
+			cloneMode:       infrav1.LinkedClone,
+			additionalDisks: []infrav1.DiskSpec{{SizeGiB: 10}},
+			datastoreType:   types.HostFileSystemVolumeFileSystemTypeNFS,
+			expectedError:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ds.Summary.Type = string(tc.datastoreType)
+
+			ctx, err := context.NewMachineContextFromClusterContext(
+				clusterContext,
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				&infrav1.VSphereMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+					Spec: infrav1.VSphereMachineSpec{
+						DiskProvisioning: tc.provisioning,
+						AdditionalDisks:  tc.additionalDisks,
+					},
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cloneMode := tc.cloneMode
+			if cloneMode == "" {
+				cloneMode = infrav1.FullClone
+			}
+			err = checkCloneDiskProvisioning(ctx, cloneMode, ds.Reference())
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("expected error %t, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
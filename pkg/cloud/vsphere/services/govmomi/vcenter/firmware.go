@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+// minSecureBootHardwareVersion is the oldest virtual hardware version that
+// supports UEFI secure boot.
+const minSecureBootHardwareVersion = 13
+
+// newBootOptions returns the boot options of a new machine's VM, or nil to
+// preserve the boot options of the VM's source. The boot order is applied
+// once the VM exists, as it refers to the keys of the VM's devices.
+func newBootOptions(ctx *context.MachineContext) *types.VirtualMachineBootOptions {
+	spec := ctx.VSphereMachine.Spec
+	if !spec.SecureBoot && spec.BootDelayMilliseconds == 0 {
+		return nil
+	}
+	boot := &types.VirtualMachineBootOptions{
+		BootDelay: spec.BootDelayMilliseconds,
+	}
+	if spec.SecureBoot {
+		secureBoot := true
+		boot.EfiSecureBootEnabled = &secureBoot
+	}
+	return boot
+}
+
+// checkFirmware returns a *capierrors.MachineError if the machine's
+// firmware or secure boot are not supported by the template's hardware
+// version or guest OS. The guest OS is only checked if the compute resource
+// of the resource pool describes it.
+func checkFirmware(ctx *context.MachineContext, tpl *object.VirtualMachine, pool *object.ResourcePool) error {
+	spec := ctx.VSphereMachine.Spec
+	if spec.Firmware == "" && !spec.SecureBoot {
+		return nil
+	}
+
+	var tplObj mo.VirtualMachine
+	if err := tpl.Properties(ctx, tpl.Reference(), []string{"config.version", "config.guestId"}, &tplObj); err != nil {
+		return errors.Wrapf(err, "unable to get hardware version and guest OS of template %q", spec.Template)
+	}
+	if tplObj.Config == nil {
+		return nil
+	}
+
+	if spec.SecureBoot {
+		version, err := util.ParseHardwareVersion(tplObj.Config.Version)
+		if err != nil {
+			return err
+		}
+		if version < minSecureBootHardwareVersion {
+			return capierrors.InvalidMachineConfiguration("invalid firmware for %q: secure boot requires hardware version vmx-%d or later, template %q has hardware version %q",
+				ctx, minSecureBootHardwareVersion, spec.Template, tplObj.Config.Version)
+		}
+	}
+
+	guest, err := getGuestOsDescriptor(ctx, pool, tplObj.Config.Version, tplObj.Config.GuestId)
+	if err != nil || guest == nil {
+		return err
+	}
+	if spec.Firmware != "" && len(guest.SupportedFirmware) > 0 && !containsString(guest.SupportedFirmware, string(spec.Firmware)) {
+		return capierrors.InvalidMachineConfiguration("invalid firmware for %q: guest OS %q of template %q does not support the %q firmware",
+			ctx, tplObj.Config.GuestId, spec.Template, spec.Firmware)
+	}
+	if spec.SecureBoot && guest.SupportsSecureBoot != nil && !*guest.SupportsSecureBoot {
+		return capierrors.InvalidMachineConfiguration("invalid firmware for %q: guest OS %q of template %q does not support secure boot",
+			ctx, tplObj.Config.GuestId, spec.Template)
+	}
+	return nil
+}
+
+// getGuestOsDescriptor returns the description of the guest OS for VMs with
+// the provided hardware version in the resource pool's compute resource, or
+// nil if the compute resource does not describe the guest OS.
+func getGuestOsDescriptor(ctx *context.MachineContext, pool *object.ResourcePool, version, guestID string) (*types.GuestOsDescriptor, error) {
+	var poolObj mo.ResourcePool
+	if err := ctx.Session.RetrieveOne(ctx, pool.Reference(), []string{"owner"}, &poolObj); err != nil {
+		return nil, errors.Wrapf(err, "unable to get owner of resource pool for %q", ctx)
+	}
+	var computeObj mo.ComputeResource
+	if err := ctx.Session.RetrieveOne(ctx, poolObj.Owner, []string{"environmentBrowser"}, &computeObj); err != nil {
+		return nil, errors.Wrapf(err, "unable to get environment browser for %q", ctx)
+	}
+	if computeObj.EnvironmentBrowser == nil {
+		return nil, nil
+	}
+
+	res, err := methods.QueryConfigOptionEx(ctx, ctx.Session.Client.Client, &types.QueryConfigOptionEx{
+		This: *computeObj.EnvironmentBrowser,
+		Spec: &types.EnvironmentBrowserConfigOptionQuerySpec{
+			Key:     version,
+			GuestId: []string{guestID},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get guest OS options for %q", ctx)
+	}
+	if res.Returnval == nil {
+		return nil, nil
+	}
+	for i := range res.Returnval.GuestOSDescriptor {
+		if guest := &res.Returnval.GuestOSDescriptor[i]; guest.Id == guestID {
+			return guest, nil
+		}
+	}
+	return nil, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func TestCheckFirmware(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	simVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+
+	testCases := []struct {
+		name            string
+		firmware        infrav1.FirmwareType
+		secureBoot      bool
+		hardwareVersion string
+		expectedError   bool
+	}{
+		{name: "template firmware", hardwareVersion: "vmx-11"},
+		{name: "efi", firmware: infrav1.EFIFirmware, hardwareVersion: "vmx-11"},
+		{name: "secure boot", firmware: infrav1.EFIFirmware, secureBoot: true, hardwareVersion: "vmx-13"},
+		{name: "secure boot with old hardware", firmware: infrav1.EFIFirmware, secureBoot: true, hardwareVersion: "vmx-11", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			simVM.Config.Version = tc.hardwareVersion
+
+			clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+				Cluster: &clusterv1.Cluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+				},
+				VSphereCluster: &infrav1.VSphereCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					Spec:       infrav1.VSphereClusterSpec{Server: s.URL.Host},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			machineContext, err := context.NewMachineContextFromClusterContext(
+				clusterContext,
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				&infrav1.VSphereMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+					Spec: infrav1.VSphereMachineSpec{
+						Template:   simVM.Name,
+						Firmware:   tc.firmware,
+						SecureBoot: tc.secureBoot,
+					},
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pool, err := getResourcePool(machineContext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tpl := object.NewVirtualMachine(machineContext.Session.Client.Client, simVM.Reference())
+
+			err = checkFirmware(machineContext, tpl, pool)
+			if tc.expectedError {
+				if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if boot := newBootOptions(machineContext); tc.secureBoot != (boot != nil && boot.EfiSecureBootEnabled != nil && *boot.EfiSecureBootEnabled) {
+				t.Fatalf("expected secure boot %t, got %+v", tc.secureBoot, boot)
+			}
+		})
+	}
+}
+
+func TestNewBootOptions(t *testing.T) {
+	testCases := []struct {
+		name               string
+		secureBoot         bool
+		bootDelay          int64
+		expectNil          bool
+		expectedSecureBoot bool
+	}{
+		{name: "template settings", expectNil: true},
+		{name: "secure boot", secureBoot: true, expectedSecureBoot: true},
+		{name: "boot delay", bootDelay: 5000},
+		{name: "secure boot and boot delay", secureBoot: true, bootDelay: 5000, expectedSecureBoot: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &context.MachineContext{
+				VSphereMachine: &infrav1.VSphereMachine{
+					Spec: infrav1.VSphereMachineSpec{SecureBoot: tc.secureBoot, BootDelayMilliseconds: tc.bootDelay},
+				},
+			}
+			boot := newBootOptions(ctx)
+			if tc.expectNil {
+				if boot != nil {
+					t.Fatalf("expected no boot options, got %+v", boot)
+				}
+				return
+			}
+			if boot == nil {
+				t.Fatal("expected boot options, got nil")
+			}
+			if secureBoot := boot.EfiSecureBootEnabled != nil && *boot.EfiSecureBootEnabled; secureBoot != tc.expectedSecureBoot {
+				t.Fatalf("expected secure boot %t, got %t", tc.expectedSecureBoot, secureBoot)
+			}
+			if boot.BootDelay != tc.bootDelay {
+				t.Fatalf("expected boot delay %d, got %d", tc.bootDelay, boot.BootDelay)
+			}
+		})
+	}
+}
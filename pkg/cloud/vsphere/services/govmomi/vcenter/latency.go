@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+// minNumCPUs is the number of CPUs of a new machine's VM if its spec does
+// not set one, or sets fewer.
+const minNumCPUs = 2
+
+// newLatencySensitivity returns the latency sensitivity of a new machine's
+// VM, or nil to preserve the latency sensitivity of the VM's source.
+func newLatencySensitivity(ctx *context.MachineContext) *types.LatencySensitivity {
+	if ctx.VSphereMachine.Spec.LatencySensitivity == "" {
+		return nil
+	}
+	return &types.LatencySensitivity{
+		Level: types.LatencySensitivitySensitivityLevel(ctx.VSphereMachine.Spec.LatencySensitivity),
+	}
+}
+
+// newCPUAffinity returns the CPU affinity of a new machine's VM, or nil to
+// preserve the CPU affinity of the VM's source.
+func newCPUAffinity(ctx *context.MachineContext) *types.VirtualMachineAffinityInfo {
+	if len(ctx.VSphereMachine.Spec.CPUAffinity) == 0 {
+		return nil
+	}
+	return &types.VirtualMachineAffinityInfo{
+		AffinitySet: ctx.VSphereMachine.Spec.CPUAffinity,
+	}
+}
+
+// checkCPUAffinity returns a *capierrors.MachineError if the machine's CPU
+// affinity does not fit the logical processors of the provided host, which
+// is nil if vCenter picks the host.
+func checkCPUAffinity(ctx *context.MachineContext, host *types.ManagedObjectReference) error {
+	spec := ctx.VSphereMachine.Spec
+	if len(spec.CPUAffinity) == 0 {
+		return nil
+	}
+	if host == nil {
+		return capierrors.InvalidMachineConfiguration("invalid cpu affinity for %q: cpu affinity requires a host", ctx)
+	}
+
+	numCPUs := spec.NumCPUs
+	if numCPUs < minNumCPUs {
+		numCPUs = minNumCPUs
+	}
+	if int32(len(spec.CPUAffinity)) < numCPUs {
+		return capierrors.InvalidMachineConfiguration("invalid cpu affinity for %q: %d processors are fewer than the vm's %d cpus",
+			ctx, len(spec.CPUAffinity), numCPUs)
+	}
+
+	var hostObj mo.HostSystem
+	if err := ctx.Session.RetrieveOne(ctx, *host, []string{"hardware.cpuInfo"}, &hostObj); err != nil {
+		return errors.Wrapf(err, "unable to get processors of host %q for %q", spec.Host, ctx)
+	}
+	if hostObj.Hardware == nil {
+		return nil
+	}
+	numThreads := int32(hostObj.Hardware.CpuInfo.NumCpuThreads)
+	for _, cpu := range spec.CPUAffinity {
+		if cpu >= numThreads {
+			return capierrors.InvalidMachineConfiguration("invalid cpu affinity for %q: processor %d does not exist on host %q, which has %d logical processors",
+				ctx, cpu, spec.Host, numThreads)
+		}
+	}
+	return nil
+}
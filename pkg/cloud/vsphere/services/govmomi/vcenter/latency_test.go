@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func TestCheckCPUAffinity(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	// The simulator's hosts have two logical processors.
+	simHost := simulator.Map.Any("HostSystem").(*simulator.HostSystem)
+
+	testCases := []struct {
+		name          string
+		cpuAffinity   []int32
+		withoutHost   bool
+		expectedError bool
+	}{
+		{name: "no affinity"},
+		{name: "affinity", cpuAffinity: []int32{0, 1}},
+		{name: "affinity without host", cpuAffinity: []int32{0, 1}, withoutHost: true, expectedError: true},
+		{name: "fewer processors than cpus", cpuAffinity: []int32{1}, expectedError: true},
+		{name: "missing processor", cpuAffinity: []int32{0, 2}, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+				Cluster: &clusterv1.Cluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+				},
+				VSphereCluster: &infrav1.VSphereCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					Spec:       infrav1.VSphereClusterSpec{Server: s.URL.Host},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			machineContext, err := context.NewMachineContextFromClusterContext(
+				clusterContext,
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				&infrav1.VSphereMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+					Spec: infrav1.VSphereMachineSpec{
+						Host:        simHost.Name,
+						CPUAffinity: tc.cpuAffinity,
+					},
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var host *types.ManagedObjectReference
+			if !tc.withoutHost {
+				ref := simHost.Reference()
+				host = &ref
+			}
+
+			err = checkCPUAffinity(machineContext, host)
+			if tc.expectedError {
+				if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if affinity := newCPUAffinity(machineContext); len(tc.cpuAffinity) != 0 && (affinity == nil || len(affinity.AffinitySet) != len(tc.cpuAffinity)) {
+				t.Fatalf("expected cpu affinity %v, got %+v", tc.cpuAffinity, affinity)
+			}
+		})
+	}
+}
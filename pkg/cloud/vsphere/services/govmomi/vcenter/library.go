@@ -0,0 +1,453 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// The vAPI paths are relative to the rest client's URL, which ends with
+// "/com/vmware".
+const (
+	libraryFindPath     = "/content/library"
+	libraryItemFindPath = "/content/library/item"
+	libraryDeployPath   = "/vcenter/ovf/library-item"
+
+	libraryPropertyParamsClass = "com.vmware.vcenter.ovf.property_params"
+	libraryPropertyParamsType  = "PropertyParams"
+
+	// libraryDeployNotesPrefix prefixes the notes of a VM deployed from a
+	// content library item, which are followed by the machine's UID. The
+	// deployment API cannot set the VM's instance UUID, so the notes identify
+	// the VM's machine until the VM is reconfigured with its instance UUID.
+	libraryDeployNotesPrefix = "cluster-api-provider-vsphere: deployed for machine "
+)
+
+type libraryFindSpec struct {
+	Name string `json:"name,omitempty"`
+}
+
+type libraryItemFindSpec struct {
+	LibraryID string `json:"library_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+type libraryDeploymentTarget struct {
+	ResourcePoolID string `json:"resource_pool_id,omitempty"`
+	HostID         string `json:"host_id,omitempty"`
+	FolderID       string `json:"folder_id,omitempty"`
+}
+
+type libraryDeploymentSpec struct {
+	Name                string                    `json:"name,omitempty"`
+	Annotation          string                    `json:"annotation,omitempty"`
+	DefaultDatastoreID  string                    `json:"default_datastore_id,omitempty"`
+	AcceptAllEULA       bool                      `json:"accept_all_EULA,omitempty"`
+	StorageProfileID    string                    `json:"storage_profile_id,omitempty"`
+	StorageProvisioning string                    `json:"storage_provisioning,omitempty"`
+	AdditionalParams    []libraryAdditionalParams `json:"additional_parameters,omitempty"`
+}
+
+// libraryAdditionalParams are the additional parameters of an OVF
+// deployment. Only the OVF property parameters are used.
+type libraryAdditionalParams struct {
+	Class      string               `json:"@class"`
+	Type       string               `json:"type"`
+	Properties []libraryOVFProperty `json:"properties,omitempty"`
+}
+
+// libraryOVFProperty is an OVF property of a content library item. The
+// property's value is its default when it is returned by a filter request.
+type libraryOVFProperty struct {
+	ID         string `json:"id"`
+	Value      string `json:"value,omitempty"`
+	UIOptional bool   `json:"ui_optional,omitempty"`
+}
+
+type libraryFilterResult struct {
+	AdditionalParams []libraryAdditionalParams `json:"additional_params,omitempty"`
+}
+
+type libraryDeploy struct {
+	Target         libraryDeploymentTarget `json:"target"`
+	DeploymentSpec libraryDeploymentSpec   `json:"deployment_spec"`
+}
+
+type libraryDeploymentResult struct {
+	Succeeded  bool `json:"succeeded"`
+	ResourceID *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	} `json:"resource_id,omitempty"`
+	Error *struct {
+		Errors []struct {
+			Message struct {
+				DefaultMessage string `json:"default_message"`
+			} `json:"message"`
+		} `json:"errors,omitempty"`
+	} `json:"error,omitempty"`
+}
+
+// DeployFromLibrary deploys a new virtual machine from an OVF content
+// library item and starts applying the machine's configuration to the
+// deployed virtual machine. The reconfigure op is recorded in the machine's
+// task reference. A VM that was deployed for the machine but not
+// reconfigured, ex. because the controller restarted, is reconfigured
+// instead of deploying another.
+func DeployFromLibrary(ctx *context.MachineContext, bootstrapData []byte) error {
+	ctx = context.NewMachineLoggerContext(ctx, "vcenter")
+	ctx.Logger.V(6).Info("starting content library deploy process")
+
+	itemPath := ctx.VSphereMachine.Spec.ContentLibraryItem
+	libraryName, itemName, err := parseLibraryItemPath(itemPath)
+	if err != nil {
+		return err
+	}
+
+	if ctx.VSphereMachine.Spec.DatastoreCluster != "" {
+		return errors.Errorf("unable to deploy %q: datastore clusters are not supported for content library items", ctx)
+	}
+
+	folder, err := getFolder(ctx)
+	if err != nil {
+		return err
+	}
+
+	vmName, err := getVMName(ctx)
+	if err != nil {
+		return err
+	}
+
+	deployed, err := findDeployedVM(ctx, folder, vmName)
+	if err != nil {
+		return err
+	}
+	if deployed != nil {
+		ctx.Logger.V(2).Info("resuming deploy of content library item", "library-item", itemPath, "moref-id", deployed.Reference().Value)
+		if len(deployed.Datastore) > 0 {
+			ctx.VSphereMachine.Status.Datastore = getDatastoreName(ctx, deployed.Datastore[0])
+		}
+		vm := object.NewVirtualMachine(ctx.Session.Client.Client, deployed.Reference())
+		return reconfigureDeployedVM(ctx, vm, bootstrapData)
+	}
+
+	pool, err := getResourcePool(ctx)
+	if err != nil {
+		return err
+	}
+
+	hostRef, err := getHost(ctx, pool)
+	if err != nil {
+		return err
+	}
+
+	// The disk size of a content library item is not known before it is
+	// deployed, so only the policy's compatibility is checked.
+	var (
+		datastoreRef types.ManagedObjectReference
+		profileID    string
+	)
+	if ctx.VSphereMachine.Spec.StoragePolicy != "" {
+		var ref *types.ManagedObjectReference
+		if profileID, ref, err = getStoragePolicyPlacement(ctx, pool, 0); err != nil {
+			return err
+		}
+		datastoreRef = *ref
+	} else {
+		datastore, err := getDatastore(ctx)
+		if err != nil {
+			return err
+		}
+		datastoreRef = datastore.Reference()
+	}
+	if err := checkDiskProvisioning(ctx, datastoreRef); err != nil {
+		return err
+	}
+
+	restClient, err := ctx.Session.NewRestClient(ctx, url.UserPassword(ctx.User(), ctx.Pass()))
+	if err != nil {
+		return errors.Wrapf(err, "unable to deploy %q", ctx)
+	}
+	defer func() {
+		_ = restClient.Logout(ctx)
+	}()
+
+	itemID, err := findLibraryItem(ctx, restClient, libraryName, itemName)
+	if err != nil {
+		return err
+	}
+
+	target := libraryDeploymentTarget{
+		ResourcePoolID: pool.Reference().Value,
+		FolderID:       folder.Reference().Value,
+	}
+	if hostRef != nil {
+		target.HostID = hostRef.Value
+	}
+	deploy := libraryDeploy{
+		Target: target,
+		DeploymentSpec: libraryDeploymentSpec{
+			Name:               vmName,
+			Annotation:         libraryDeployNotesPrefix + string(ctx.Machine.UID),
+			DefaultDatastoreID: datastoreRef.Value,
+			AcceptAllEULA:      true,
+			StorageProfileID:   profileID,
+			// The provisioning types are named as they are by the
+			// deployment API, which defaults to the item's type if unset.
+			StorageProvisioning: string(ctx.VSphereMachine.Spec.DiskProvisioning),
+		},
+	}
+
+	// Check the item's OVF properties before deploying so missing properties
+	// are reported by name.
+	properties, err := getLibraryItemOVFProperties(ctx, restClient, itemID, target)
+	if err != nil {
+		return errors.Wrapf(err, "error getting ovf properties of content library item %q for %q", itemPath, ctx)
+	}
+	params, err := newOVFPropertyParams(ctx, properties)
+	if err != nil {
+		return err
+	}
+	if len(params.Properties) > 0 {
+		deploy.DeploymentSpec.AdditionalParams = []libraryAdditionalParams{*params}
+	}
+
+	ctx.Logger.V(6).Info("deploying content library item", "library-item", itemPath, "library-item-id", itemID)
+	var result libraryDeploymentResult
+	deployURL := restClient.URL()
+	deployURL.Path += libraryDeployPath + "/id:" + itemID
+	deployURL.RawQuery = url.Values{"~action": []string{"deploy"}}.Encode()
+	if err := doRequest(ctx, restClient, deployURL, deploy, &result); err != nil {
+		return errors.Wrapf(err, "error deploying content library item %q for %q", itemPath, ctx)
+	}
+	if !result.Succeeded || result.ResourceID == nil {
+		var messages []string
+		if result.Error != nil {
+			for _, e := range result.Error.Errors {
+				messages = append(messages, e.Message.DefaultMessage)
+			}
+		}
+		return errors.Errorf("error deploying content library item %q for %q: %s", itemPath, ctx, strings.Join(messages, "; "))
+	}
+
+	vm := object.NewVirtualMachine(ctx.Session.Client.Client, types.ManagedObjectReference{
+		Type:  result.ResourceID.Type,
+		Value: result.ResourceID.ID,
+	})
+
+	ctx.VSphereMachine.Status.Datastore = getDatastoreName(ctx, datastoreRef)
+	record.Eventf(ctx.VSphereMachine, "DeployStarted", "deployed machine %q from content library item %q", ctx.Machine.Name, itemPath)
+
+	// A VM that fails to reconfigure cannot be found by its instance UUID,
+	// so it is found by its notes and reconfigured again by a later create.
+	return reconfigureDeployedVM(ctx, vm, bootstrapData)
+}
+
+// findDeployedVM returns the VM with the provided name in the folder if it
+// was deployed for the machine and has yet to be reconfigured, or nil.
+func findDeployedVM(ctx *context.MachineContext, folder *object.Folder, vmName string) (*mo.VirtualMachine, error) {
+	ref, err := object.NewSearchIndex(ctx.Session.Client.Client).FindChild(ctx, folder, vmName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error finding deployed vm for %q", ctx)
+	}
+	if ref == nil || ref.Reference().Type != "VirtualMachine" {
+		return nil, nil
+	}
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, ref.Reference(), []string{"config.annotation", "datastore"}, &obj); err != nil {
+		return nil, errors.Wrapf(err, "error getting notes of vm %q for %q", vmName, ctx)
+	}
+	if obj.Config == nil || obj.Config.Annotation != libraryDeployNotesPrefix+string(ctx.Machine.UID) {
+		return nil, nil
+	}
+	return &obj, nil
+}
+
+// reconfigureDeployedVM starts applying the machine's configuration to a VM
+// deployed from a content library item and records the reconfigure op in
+// the machine's task reference.
+func reconfigureDeployedVM(ctx *context.MachineContext, vm *object.VirtualMachine, bootstrapData []byte) error {
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "error getting devices for %q", ctx)
+	}
+
+	configSpec, err := newConfigSpec(ctx, bootstrapData, devices, true)
+	if err != nil {
+		return err
+	}
+
+	if len(ctx.VSphereMachine.Spec.PCIDevices) > 0 {
+		pciSpecs, err := getPCIDeviceSpecsForVM(ctx, vm.Reference())
+		if err != nil {
+			return err
+		}
+		configSpec.DeviceChange = append(configSpec.DeviceChange, pciSpecs...)
+	}
+
+	ctx.Logger.V(6).Info("reconfiguring deployed machine", "config-spec", configSpec)
+	task, err := vm.Reconfigure(ctx, *configSpec)
+	if err != nil {
+		return errors.Wrapf(err, "error trigging reconfigure op for machine %q", ctx)
+	}
+	ctx.VSphereMachine.Status.TaskRef = task.Reference().Value
+	ctx.Logger.V(6).Info("started reconfigure op", "task", ctx.VSphereMachine.Status.TaskRef)
+	return nil
+}
+
+// getLibraryItemOVFProperties returns the OVF properties of the content
+// library item with the provided ID when it is deployed to the target.
+func getLibraryItemOVFProperties(
+	ctx *context.MachineContext,
+	c *rest.Client,
+	itemID string,
+	target libraryDeploymentTarget) ([]libraryOVFProperty, error) {
+
+	var result libraryFilterResult
+	filterURL := c.URL()
+	filterURL.Path += libraryDeployPath + "/id:" + itemID
+	filterURL.RawQuery = url.Values{"~action": []string{"filter"}}.Encode()
+	body := struct {
+		Target libraryDeploymentTarget `json:"target"`
+	}{target}
+	if err := doRequest(ctx, c, filterURL, body, &result); err != nil {
+		return nil, err
+	}
+	var properties []libraryOVFProperty
+	for _, params := range result.AdditionalParams {
+		if params.Class == libraryPropertyParamsClass {
+			properties = append(properties, params.Properties...)
+		}
+	}
+	return properties, nil
+}
+
+// newOVFPropertyParams returns the deployment parameters that set the
+// machine's OVF properties. A *capierrors.MachineError is returned if the
+// machine sets properties the content library item does not define, or the
+// item requires properties without defaults that the machine does not set.
+func newOVFPropertyParams(ctx *context.MachineContext, properties []libraryOVFProperty) (*libraryAdditionalParams, error) {
+	values := ctx.VSphereMachine.Spec.OVFProperties
+	defined := map[string]bool{}
+	params := &libraryAdditionalParams{
+		Class: libraryPropertyParamsClass,
+		Type:  libraryPropertyParamsType,
+	}
+	var missing []string
+	for _, property := range properties {
+		defined[property.ID] = true
+		value, ok := values[property.ID]
+		if !ok {
+			if property.Value == "" && !property.UIOptional {
+				missing = append(missing, property.ID)
+			}
+			continue
+		}
+		params.Properties = append(params.Properties, libraryOVFProperty{ID: property.ID, Value: value})
+	}
+
+	var unknown []string
+	for id := range values {
+		if !defined[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	sort.Strings(unknown)
+
+	switch {
+	case len(unknown) > 0:
+		return nil, capierrors.InvalidMachineConfiguration("invalid ovf properties for %q: content library item %q does not define %s",
+			ctx, ctx.VSphereMachine.Spec.ContentLibraryItem, strings.Join(unknown, ", "))
+	case len(missing) > 0:
+		return nil, capierrors.InvalidMachineConfiguration("missing ovf properties for %q: content library item %q requires %s",
+			ctx, ctx.VSphereMachine.Spec.ContentLibraryItem, strings.Join(missing, ", "))
+	}
+	return params, nil
+}
+
+// parseLibraryItemPath splits a content library item path of the form
+// "library/item" into its library and item names.
+func parseLibraryItemPath(itemPath string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(itemPath, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.Errorf("invalid content library item %q, expected the form library/item", itemPath)
+	}
+	return parts[0], parts[1], nil
+}
+
+// findLibraryItem returns the ID of the named item in the named content
+// library.
+func findLibraryItem(ctx *context.MachineContext, c *rest.Client, libraryName, itemName string) (string, error) {
+	var libraryIDs []string
+	libraryURL := c.URL()
+	libraryURL.Path += libraryFindPath
+	libraryURL.RawQuery = url.Values{"~action": []string{"find"}}.Encode()
+	body := struct {
+		Spec libraryFindSpec `json:"spec"`
+	}{libraryFindSpec{Name: libraryName}}
+	if err := doRequest(ctx, c, libraryURL, body, &libraryIDs); err != nil {
+		return "", errors.Wrapf(err, "error finding content library %q", libraryName)
+	}
+	if len(libraryIDs) != 1 {
+		return "", errors.Errorf("expected one content library named %q, found %d", libraryName, len(libraryIDs))
+	}
+
+	var itemIDs []string
+	itemURL := c.URL()
+	itemURL.Path += libraryItemFindPath
+	itemURL.RawQuery = url.Values{"~action": []string{"find"}}.Encode()
+	itemBody := struct {
+		Spec libraryItemFindSpec `json:"spec"`
+	}{libraryItemFindSpec{LibraryID: libraryIDs[0], Name: itemName}}
+	if err := doRequest(ctx, c, itemURL, itemBody, &itemIDs); err != nil {
+		return "", errors.Wrapf(err, "error finding content library item %q in library %q", itemName, libraryName)
+	}
+	if len(itemIDs) != 1 {
+		return "", errors.Errorf("expected one content library item named %q in library %q, found %d", itemName, libraryName, len(itemIDs))
+	}
+
+	return itemIDs[0], nil
+}
+
+// doRequest POSTs the JSON encoded body to the provided vAPI URL and decodes
+// the response's value into resBody.
+func doRequest(ctx *context.MachineContext, c *rest.Client, u *url.URL, body, resBody interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "error encoding vAPI request")
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "error creating vAPI request")
+	}
+	return c.Do(ctx, req, resBody)
+}
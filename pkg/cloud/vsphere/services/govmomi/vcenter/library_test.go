@@ -0,0 +1,345 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	vapisim "github.com/vmware/govmomi/vapi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func TestNewOVFPropertyParams(t *testing.T) {
+	properties := []libraryOVFProperty{
+		{ID: "license-key"},
+		{ID: "hostname", UIOptional: true},
+		{ID: "log-level", Value: "info"},
+	}
+	testCases := []struct {
+		name               string
+		values             map[string]string
+		expected           []libraryOVFProperty
+		expectMachineError bool
+	}{
+		{
+			name:     "required property",
+			values:   map[string]string{"license-key": "ABC-123"},
+			expected: []libraryOVFProperty{{ID: "license-key", Value: "ABC-123"}},
+		},
+		{
+			name: "all properties",
+			values: map[string]string{
+				"license-key": "ABC-123",
+				"hostname":    "node-1",
+				"log-level":   "debug",
+			},
+			expected: []libraryOVFProperty{
+				{ID: "license-key", Value: "ABC-123"},
+				{ID: "hostname", Value: "node-1"},
+				{ID: "log-level", Value: "debug"},
+			},
+		},
+		{
+			name:               "missing required property",
+			values:             map[string]string{"log-level": "debug"},
+			expectMachineError: true,
+		},
+		{
+			name:               "unknown property",
+			values:             map[string]string{"license-key": "ABC-123", "unknown": "value"},
+			expectMachineError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &context.MachineContext{
+				ClusterContext: &context.ClusterContext{
+					Cluster: &clusterv1.Cluster{
+						ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					},
+				},
+				Machine: &clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				VSphereMachine: &infrav1.VSphereMachine{
+					Spec: infrav1.VSphereMachineSpec{
+						ContentLibraryItem: "library/appliance",
+						OVFProperties:      tc.values,
+					},
+				},
+			}
+			params, err := newOVFPropertyParams(ctx, properties)
+			if tc.expectMachineError {
+				if _, ok := err.(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %v", err)
+				}
+				t.Log(err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if params.Class != libraryPropertyParamsClass || params.Type != libraryPropertyParamsType {
+				t.Fatalf("unexpected params class %q and type %q", params.Class, params.Type)
+			}
+			if !reflect.DeepEqual(params.Properties, tc.expected) {
+				t.Fatalf("expected properties %+v, got %+v", tc.expected, params.Properties)
+			}
+		})
+	}
+}
+
+// fakeLibrary serves the content library and OVF deployment endpoints of
+// the vAPI, which the vAPI simulator does not implement. An item is
+// deployed by cloning the source VM.
+type fakeLibrary struct {
+	t       *testing.T
+	source  *object.VirtualMachine
+	deploys int
+}
+
+func (l *fakeLibrary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var result interface{}
+	switch path := strings.TrimPrefix(r.URL.Path, "/rest/com/vmware"); {
+	case path == libraryFindPath:
+		var body struct {
+			Spec libraryFindSpec `json:"spec"`
+		}
+		l.decode(r, &body)
+		result = []string{}
+		if body.Spec.Name == "library" {
+			result = []string{"library-id"}
+		}
+	case path == libraryItemFindPath:
+		var body struct {
+			Spec libraryItemFindSpec `json:"spec"`
+		}
+		l.decode(r, &body)
+		result = []string{}
+		if body.Spec.LibraryID == "library-id" && body.Spec.Name == "appliance" {
+			result = []string{"item-id"}
+		}
+	case path == libraryDeployPath+"/id:item-id" && r.URL.Query().Get("~action") == "filter":
+		result = libraryFilterResult{}
+	case path == libraryDeployPath+"/id:item-id" && r.URL.Query().Get("~action") == "deploy":
+		var body libraryDeploy
+		l.decode(r, &body)
+		result = l.deploy(r, body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(struct {
+		Value interface{} `json:"value"`
+	}{result}); err != nil {
+		l.t.Error(err)
+	}
+}
+
+func (l *fakeLibrary) decode(r *http.Request, body interface{}) {
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		l.t.Error(err)
+	}
+}
+
+func (l *fakeLibrary) deploy(r *http.Request, body libraryDeploy) libraryDeploymentResult {
+	l.deploys++
+	c := l.source.Client()
+	folder := object.NewFolder(c, types.ManagedObjectReference{Type: "Folder", Value: body.Target.FolderID})
+	pool := types.ManagedObjectReference{Type: "ResourcePool", Value: body.Target.ResourcePoolID}
+	task, err := l.source.Clone(r.Context(), folder, body.DeploymentSpec.Name, types.VirtualMachineCloneSpec{
+		Location: types.VirtualMachineRelocateSpec{Pool: &pool},
+	})
+	if err != nil {
+		l.t.Error(err)
+		return libraryDeploymentResult{}
+	}
+	info, err := task.WaitForResult(r.Context(), nil)
+	if err != nil {
+		l.t.Error(err)
+		return libraryDeploymentResult{}
+	}
+	ref := info.Result.(types.ManagedObjectReference)
+	task, err = object.NewVirtualMachine(c, ref).Reconfigure(r.Context(), types.VirtualMachineConfigSpec{
+		Annotation: body.DeploymentSpec.Annotation,
+	})
+	if err != nil {
+		l.t.Error(err)
+		return libraryDeploymentResult{}
+	}
+	if err := task.Wait(r.Context()); err != nil {
+		l.t.Error(err)
+		return libraryDeploymentResult{}
+	}
+
+	result := libraryDeploymentResult{Succeeded: true}
+	result.ResourceID = &struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}{Type: ref.Type, ID: ref.Value}
+	return result
+}
+
+func TestDeployFromLibrary(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	library := &fakeLibrary{t: t}
+	model.Service.Handle(vapisim.New(s.URL, nil))
+	model.Service.Handle("/rest/com/vmware/content/", library)
+	model.Service.Handle("/rest/com/vmware/vcenter/ovf/", library)
+
+	newMachineContext := func(name, item string) *context.MachineContext {
+		clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+			Cluster: &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+			},
+			VSphereCluster: &infrav1.VSphereCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+				Spec:       infrav1.VSphereClusterSpec{Server: s.URL.Host},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		machineContext, err := context.NewMachineContextFromClusterContext(
+			clusterContext,
+			&clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-namespace", UID: apitypes.UID(name + "-uid")},
+			},
+			&infrav1.VSphereMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-namespace"},
+				Spec:       infrav1.VSphereMachineSpec{ContentLibraryItem: item},
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return machineContext
+	}
+
+	simVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	library.source = object.NewVirtualMachine(newMachineContext("source", "").Session.Client.Client, simVM.Reference())
+
+	// waitForReconfigure waits for the machine's reconfigure op and returns
+	// the deployed VM.
+	waitForReconfigure := func(ctx *context.MachineContext) *mo.VirtualMachine {
+		t.Helper()
+		if ctx.VSphereMachine.Status.TaskRef == "" {
+			t.Fatal("expected reconfigure op to be recorded")
+		}
+		task := object.NewTask(ctx.Session.Client.Client, types.ManagedObjectReference{Type: "Task", Value: ctx.VSphereMachine.Status.TaskRef})
+		if err := task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		ref, err := ctx.Session.FindByInstanceUUID(ctx, string(ctx.Machine.UID))
+		if err != nil || ref == nil {
+			t.Fatalf("expected deployed vm with instance uuid %q, got %v", ctx.Machine.UID, err)
+		}
+		var obj mo.VirtualMachine
+		if err := ctx.Session.RetrieveOne(ctx, ref.Reference(), []string{"name", "config.annotation"}, &obj); err != nil {
+			t.Fatal(err)
+		}
+		return &obj
+	}
+
+	t.Run("deploy", func(t *testing.T) {
+		ctx := newMachineContext("deployed", "library/appliance")
+		if err := DeployFromLibrary(ctx, []byte("bootstrap")); err != nil {
+			t.Fatal(err)
+		}
+		if library.deploys != 1 {
+			t.Fatalf("expected 1 deploy, got %d", library.deploys)
+		}
+		obj := waitForReconfigure(ctx)
+		if strings.HasPrefix(obj.Config.Annotation, libraryDeployNotesPrefix) {
+			t.Fatalf("expected deploy notes to be replaced, got %q", obj.Config.Annotation)
+		}
+	})
+
+	t.Run("item not found", func(t *testing.T) {
+		library.deploys = 0
+		ctx := newMachineContext("missing-item", "library/missing")
+		if err := DeployFromLibrary(ctx, []byte("bootstrap")); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+		if library.deploys != 0 {
+			t.Fatalf("expected no deploys, got %d", library.deploys)
+		}
+		if ctx.VSphereMachine.Status.TaskRef != "" {
+			t.Fatalf("expected no task, got %q", ctx.VSphereMachine.Status.TaskRef)
+		}
+	})
+
+	t.Run("resume partial deploy", func(t *testing.T) {
+		// The VM was deployed by a previous reconcile that did not get to
+		// reconfigure it, so it is reconfigured instead of deployed again.
+		library.deploys = 0
+		ctx := newMachineContext("resumed", "library/appliance")
+		library.deploy(&http.Request{}, libraryDeploy{
+			Target: libraryDeploymentTarget{
+				FolderID:       simVM.Parent.Value,
+				ResourcePoolID: simVM.ResourcePool.Value,
+			},
+			DeploymentSpec: libraryDeploymentSpec{
+				Name:       "resumed",
+				Annotation: libraryDeployNotesPrefix + string(ctx.Machine.UID),
+			},
+		})
+		library.deploys = 0
+
+		if err := DeployFromLibrary(ctx, []byte("bootstrap")); err != nil {
+			t.Fatal(err)
+		}
+		if library.deploys != 0 {
+			t.Fatalf("expected no deploys, got %d", library.deploys)
+		}
+		if obj := waitForReconfigure(ctx); obj.Name != "resumed" {
+			t.Fatalf("expected resumed vm to be reconfigured, got %q", obj.Name)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+// getPCIDeviceSpecsForPool finds a host in the provided resource pool's
+// compute resource that has all of the machine's PCI devices available for
+// passthrough. The host and the device specs that add the devices to the
+// machine's VM are returned.
+func getPCIDeviceSpecsForPool(
+	ctx *context.MachineContext,
+	pool types.ManagedObjectReference) (*types.ManagedObjectReference, []types.BaseVirtualDeviceConfigSpec, error) {
+
+	var poolObj mo.ResourcePool
+	if err := ctx.Session.RetrieveOne(ctx, pool, []string{"owner"}, &poolObj); err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to get owner of resource pool for %q", ctx)
+	}
+	var computeObj mo.ComputeResource
+	if err := ctx.Session.RetrieveOne(ctx, poolObj.Owner, []string{"environmentBrowser", "host"}, &computeObj); err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to get hosts of compute resource for %q", ctx)
+	}
+	if computeObj.EnvironmentBrowser == nil {
+		return nil, nil, errors.Errorf("unable to query pci devices for %q: compute resource has no environment browser", ctx)
+	}
+	return getPCIDeviceSpecs(ctx, *computeObj.EnvironmentBrowser, computeObj.Host)
+}
+
+// getPCIDeviceSpecsForVM returns the device specs that add the machine's PCI
+// devices to the provided VM from the host on which the VM is registered.
+func getPCIDeviceSpecsForVM(
+	ctx *context.MachineContext,
+	vm types.ManagedObjectReference) ([]types.BaseVirtualDeviceConfigSpec, error) {
+
+	var obj mo.VirtualMachine
+	if err := ctx.Session.RetrieveOne(ctx, vm, []string{"environmentBrowser", "runtime.host"}, &obj); err != nil {
+		return nil, errors.Wrapf(err, "unable to get host of vm for %q", ctx)
+	}
+	if obj.Runtime.Host == nil {
+		return nil, errors.Errorf("unable to query pci devices for %q: vm is not registered to a host", ctx)
+	}
+	_, deviceSpecs, err := getPCIDeviceSpecs(ctx, obj.EnvironmentBrowser, []types.ManagedObjectReference{*obj.Runtime.Host})
+	return deviceSpecs, err
+}
+
+// getPCIDeviceSpecs returns the first of the provided hosts that has all of
+// the machine's PCI devices available for passthrough, and the device specs
+// that add the devices to the machine's VM.
+func getPCIDeviceSpecs(
+	ctx *context.MachineContext,
+	environmentBrowser types.ManagedObjectReference,
+	hosts []types.ManagedObjectReference) (*types.ManagedObjectReference, []types.BaseVirtualDeviceConfigSpec, error) {
+
+	var missing []infrav1.PCIDeviceSpec
+	for i := range hosts {
+		host := hosts[i]
+		res, err := methods.QueryConfigTarget(ctx, ctx.Session.Client.Client, &types.QueryConfigTarget{
+			This: environmentBrowser,
+			Host: &host,
+		})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to query pci devices of host %q for %q", host.Value, ctx)
+		}
+		if res.Returnval == nil {
+			continue
+		}
+		var deviceSpecs []types.BaseVirtualDeviceConfigSpec
+		deviceSpecs, missing = newPCIDeviceSpecs(ctx.VSphereMachine.Spec.PCIDevices, res.Returnval.PciPassthrough)
+		if len(missing) == 0 {
+			ctx.Logger.V(6).Info("found host with pci devices", "host", host.Value, "pci-devices", ctx.VSphereMachine.Spec.PCIDevices)
+			return &host, deviceSpecs, nil
+		}
+	}
+	if len(missing) == 0 {
+		missing = ctx.VSphereMachine.Spec.PCIDevices
+	}
+
+	ids := make([]string, len(missing))
+	for i, device := range missing {
+		ids[i] = pciDeviceID(device)
+	}
+	return nil, nil, errors.Errorf("no host available for %q with pci passthrough device(s) %s", ctx, strings.Join(ids, ", "))
+}
+
+// newPCIDeviceSpecs returns the device specs that add the requested PCI
+// devices from the provided passthrough devices, and the requested devices
+// that are not available.
+func newPCIDeviceSpecs(
+	requested []infrav1.PCIDeviceSpec,
+	available []types.BaseVirtualMachinePciPassthroughInfo) ([]types.BaseVirtualDeviceConfigSpec, []infrav1.PCIDeviceSpec) {
+
+	var (
+		deviceSpecs []types.BaseVirtualDeviceConfigSpec
+		missing     []infrav1.PCIDeviceSpec
+		used        = map[string]bool{}
+		key         = int32(-200)
+	)
+
+	for _, device := range requested {
+		var backing *types.VirtualPCIPassthroughDeviceBackingInfo
+		for _, baseInfo := range available {
+			info := baseInfo.GetVirtualMachinePciPassthroughInfo()
+			pci := info.PciDevice
+			if used[pci.Id] || int32(uint16(pci.VendorId)) != device.VendorID || int32(uint16(pci.DeviceId)) != device.DeviceID {
+				continue
+			}
+			used[pci.Id] = true
+			backing = &types.VirtualPCIPassthroughDeviceBackingInfo{
+				Id:       pci.Id,
+				DeviceId: fmt.Sprintf("%x", uint16(pci.DeviceId)),
+				SystemId: info.SystemId,
+				VendorId: pci.VendorId,
+			}
+			break
+		}
+		if backing == nil {
+			missing = append(missing, device)
+			continue
+		}
+
+		deviceSpecs = append(deviceSpecs, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device: &types.VirtualPCIPassthrough{
+				VirtualDevice: types.VirtualDevice{
+					Key:     key,
+					Backing: backing,
+				},
+			},
+		})
+		key--
+	}
+
+	return deviceSpecs, missing
+}
+
+func pciDeviceID(device infrav1.PCIDeviceSpec) string {
+	return fmt.Sprintf("%04x:%04x", device.VendorID, device.DeviceID)
+}
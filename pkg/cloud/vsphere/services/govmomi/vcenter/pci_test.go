@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+)
+
+func Test_newPCIDeviceSpecs(t *testing.T) {
+	// The vSphere API represents PCI IDs as int16 values, so IDs above
+	// 0x7fff, such as Intel's vendor ID 0x8086, are negative.
+	newInfo := func(id string, vendorID, deviceID uint16) types.BaseVirtualMachinePciPassthroughInfo {
+		return &types.VirtualMachinePciPassthroughInfo{
+			PciDevice: types.HostPciDevice{Id: id, VendorId: int16(vendorID), DeviceId: int16(deviceID)},
+			SystemId:  "system-id",
+		}
+	}
+	available := []types.BaseVirtualMachinePciPassthroughInfo{
+		newInfo("0000:3b:00.0", 0x10de, 0x1db4),
+		newInfo("0000:d8:00.0", 0x10de, 0x1db4),
+		newInfo("0000:af:00.0", 0x8086, 0x1572),
+	}
+
+	testCases := []struct {
+		name            string
+		requested       []infrav1.PCIDeviceSpec
+		expectedIDs     []string
+		expectedMissing int
+	}{
+		{
+			name:        "one gpu",
+			requested:   []infrav1.PCIDeviceSpec{{VendorID: 0x10de, DeviceID: 0x1db4}},
+			expectedIDs: []string{"0000:3b:00.0"},
+		},
+		{
+			name: "two gpus use distinct devices",
+			requested: []infrav1.PCIDeviceSpec{
+				{VendorID: 0x10de, DeviceID: 0x1db4},
+				{VendorID: 0x10de, DeviceID: 0x1db4},
+			},
+			expectedIDs: []string{"0000:3b:00.0", "0000:d8:00.0"},
+		},
+		{
+			name: "more gpus than available",
+			requested: []infrav1.PCIDeviceSpec{
+				{VendorID: 0x10de, DeviceID: 0x1db4},
+				{VendorID: 0x10de, DeviceID: 0x1db4},
+				{VendorID: 0x10de, DeviceID: 0x1db4},
+			},
+			expectedIDs:     []string{"0000:3b:00.0", "0000:d8:00.0"},
+			expectedMissing: 1,
+		},
+		{
+			name:        "vendor id above int16 range",
+			requested:   []infrav1.PCIDeviceSpec{{VendorID: 0x8086, DeviceID: 0x1572}},
+			expectedIDs: []string{"0000:af:00.0"},
+		},
+		{
+			name:            "unknown device",
+			requested:       []infrav1.PCIDeviceSpec{{VendorID: 0x10de, DeviceID: 0x1eb8}},
+			expectedMissing: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deviceSpecs, missing := newPCIDeviceSpecs(tc.requested, available)
+			if len(missing) != tc.expectedMissing {
+				t.Fatalf("expected %d missing devices, got %d", tc.expectedMissing, len(missing))
+			}
+			if len(deviceSpecs) != len(tc.expectedIDs) {
+				t.Fatalf("expected %d device specs, got %d", len(tc.expectedIDs), len(deviceSpecs))
+			}
+			keys := map[int32]bool{}
+			for i, spec := range deviceSpecs {
+				device := spec.GetVirtualDeviceConfigSpec().Device.GetVirtualDevice()
+				backing := device.Backing.(*types.VirtualPCIPassthroughDeviceBackingInfo)
+				if backing.Id != tc.expectedIDs[i] {
+					t.Fatalf("expected device %q, got %q", tc.expectedIDs[i], backing.Id)
+				}
+				if expected := fmt.Sprintf("%x", tc.requested[i].DeviceID); backing.DeviceId != expected {
+					t.Fatalf("expected hex device ID %q, got %q", expected, backing.DeviceId)
+				}
+				if keys[device.Key] {
+					t.Fatalf("duplicate device key %d", device.Key)
+				}
+				keys[device.Key] = true
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/pbm"
+	pbmtypes "github.com/vmware/govmomi/pbm/types"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+// getStoragePolicyPlacement resolves the machine's storage policy and returns
+// the policy's profile ID and the datastore in which the machine's VM is
+// created. The datastore is the machine's datastore if one is specified, or
+// else the datastore of the machine's datastores, or of the resource pool's
+// compute resource, that is compatible with the policy and has the most free
+// space. A
+// *capierrors.MachineError is returned if the policy does not exist or no
+// compatible datastore has requiredBytes of free space.
+func getStoragePolicyPlacement(
+	ctx *context.MachineContext,
+	pool *object.ResourcePool,
+	requiredBytes int64) (string, *types.ManagedObjectReference, error) {
+
+	policy := ctx.VSphereMachine.Spec.StoragePolicy
+
+	pbmClient, err := pbm.NewClient(ctx, ctx.Session.Client.Client)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "unable to create storage policy client for %q", ctx)
+	}
+	profileID, err := pbmClient.ProfileIDByName(ctx, policy)
+	if err != nil {
+		return "", nil, capierrors.InvalidMachineConfiguration("unable to find storage policy %q for %q: %v", policy, ctx, err)
+	}
+
+	candidates, err := getStoragePolicyCandidates(ctx, pool)
+	if err != nil {
+		return "", nil, err
+	}
+	hubs := make([]pbmtypes.PbmPlacementHub, len(candidates))
+	for i, ref := range candidates {
+		hubs[i] = pbmtypes.PbmPlacementHub{HubType: ref.Type, HubId: ref.Value}
+	}
+	result, err := pbmClient.CheckRequirements(ctx, hubs, nil, []pbmtypes.BasePbmPlacementRequirement{
+		&pbmtypes.PbmPlacementCapabilityProfileRequirement{
+			ProfileId: pbmtypes.PbmProfileId{UniqueId: profileID},
+		},
+	})
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "unable to check datastore compatibility with storage policy %q for %q", policy, ctx)
+	}
+	compatible := result.CompatibleDatastores()
+	if len(compatible) == 0 {
+		return "", nil, capierrors.InvalidMachineConfiguration("no datastore compatible with storage policy %q for %q", policy, ctx)
+	}
+
+	var (
+		datastoreRef *types.ManagedObjectReference
+		maxFreeBytes int64
+	)
+	for _, hub := range compatible {
+		ref := types.ManagedObjectReference{Type: hub.HubType, Value: hub.HubId}
+		var obj mo.Datastore
+		if err := ctx.Session.RetrieveOne(ctx, ref, []string{"summary"}, &obj); err != nil {
+			return "", nil, errors.Wrapf(err, "unable to get free space of datastore %q for %q", ref.Value, ctx)
+		}
+		if datastoreRef == nil || obj.Summary.FreeSpace > maxFreeBytes {
+			datastoreRef = types.NewReference(ref)
+			maxFreeBytes = obj.Summary.FreeSpace
+		}
+	}
+	if maxFreeBytes < requiredBytes {
+		return "", nil, capierrors.InvalidMachineConfiguration(
+			"no datastore compatible with storage policy %q has enough free space for %q: required=%dGiB free=%dGiB",
+			policy, ctx, requiredBytes/bytesPerGiB, maxFreeBytes/bytesPerGiB)
+	}
+
+	ctx.Logger.V(6).Info("selected datastore for storage policy", "storage-policy", policy, "datastore", datastoreRef.Value)
+	return profileID, datastoreRef, nil
+}
+
+// getStoragePolicyCandidates returns the datastores that may be selected for
+// the machine's storage policy.
+func getStoragePolicyCandidates(ctx *context.MachineContext, pool *object.ResourcePool) ([]types.ManagedObjectReference, error) {
+	if ctx.VSphereMachine.Spec.Datastore != "" {
+		datastore, err := getDatastore(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []types.ManagedObjectReference{datastore.Reference()}, nil
+	}
+	if names := ctx.VSphereMachine.Spec.Datastores; len(names) > 0 {
+		candidates := make([]types.ManagedObjectReference, len(names))
+		for i, name := range names {
+			datastore, err := ctx.Session.Finder.Datastore(ctx, name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to find datastore %q for %q", name, ctx)
+			}
+			candidates[i] = datastore.Reference()
+		}
+		return candidates, nil
+	}
+
+	var poolObj mo.ResourcePool
+	if err := ctx.Session.RetrieveOne(ctx, pool.Reference(), []string{"owner"}, &poolObj); err != nil {
+		return nil, errors.Wrapf(err, "unable to get owner of resource pool for %q", ctx)
+	}
+	var computeObj mo.ComputeResource
+	if err := ctx.Session.RetrieveOne(ctx, poolObj.Owner, []string{"datastore"}, &computeObj); err != nil {
+		return nil, errors.Wrapf(err, "unable to get datastores of compute resource for %q", ctx)
+	}
+	return computeObj.Datastore, nil
+}
+
+// newStorageProfileSpecs returns the profile specs that apply the storage
+// policy with the provided profile ID.
+func newStorageProfileSpecs(profileID string) []types.BaseVirtualMachineProfileSpec {
+	return []types.BaseVirtualMachineProfileSpec{
+		&types.VirtualMachineDefinedProfileSpec{ProfileId: profileID},
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	pbmsimulator "github.com/vmware/govmomi/pbm/simulator"
+	"github.com/vmware/govmomi/simulator"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+func TestGetStoragePolicyPlacement(t *testing.T) {
+	model := simulator.VPX()
+	model.Host = 0 // ClusterHost only
+
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	model.Service.RegisterSDK(pbmsimulator.New())
+
+	s := model.Service.NewServer()
+	defer s.Close()
+	pass, _ := s.URL.User.Password()
+	os.Setenv("VSPHERE_USERNAME", s.URL.User.Username())
+	os.Setenv("VSPHERE_PASSWORD", pass)
+	defer os.Unsetenv("VSPHERE_USERNAME")
+	defer os.Unsetenv("VSPHERE_PASSWORD")
+
+	datastore := simulator.Map.Any("Datastore").(*simulator.Datastore)
+
+	testCases := []struct {
+		name          string
+		storagePolicy string
+		datastore     string
+		requiredBytes int64
+		expectedError bool
+	}{
+		{
+			name:          "compatible datastore",
+			storagePolicy: "vSAN Default Storage Policy",
+		},
+		{
+			name:          "compatible machine datastore",
+			storagePolicy: "vSAN Default Storage Policy",
+			datastore:     datastore.Name,
+		},
+		{
+			name:          "missing storage policy",
+			storagePolicy: "missing-policy",
+			expectedError: true,
+		},
+		{
+			name:          "insufficient free space",
+			storagePolicy: "vSAN Default Storage Policy",
+			requiredBytes: datastore.Summary.Capacity + bytesPerGiB,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterContext, err := context.NewClusterContext(&context.ClusterContextParams{
+				Cluster: &clusterv1.Cluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+				},
+				VSphereCluster: &infrav1.VSphereCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "test-namespace"},
+					Spec:       infrav1.VSphereClusterSpec{Server: s.URL.Host},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			machineContext, err := context.NewMachineContextFromClusterContext(
+				clusterContext,
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+				},
+				&infrav1.VSphereMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-machine", Namespace: "test-namespace"},
+					Spec: infrav1.VSphereMachineSpec{
+						Datastore:     tc.datastore,
+						StoragePolicy: tc.storagePolicy,
+					},
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pool, err := getResourcePool(machineContext)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			profileID, datastoreRef, err := getStoragePolicyPlacement(machineContext, pool, tc.requiredBytes)
+			if tc.expectedError {
+				if _, ok := errors.Cause(err).(*capierrors.MachineError); !ok {
+					t.Fatalf("expected machine error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if profileID == "" {
+				t.Error("expected profile id")
+			}
+			if datastoreRef == nil {
+				t.Fatal("expected datastore")
+			}
+			if tc.datastore != "" && *datastoreRef != datastore.Reference() {
+				t.Errorf("expected datastore %v, got %v", datastore.Reference(), *datastoreRef)
+			}
+		})
+	}
+}
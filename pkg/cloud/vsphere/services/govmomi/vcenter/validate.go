@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcenter
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services/govmomi/template"
+)
+
+const bytesPerGiB = 1024 * 1024 * 1024
+
+// Validate verifies the vSphere objects referenced by the machine exist and
+// that there is enough free space to create the machine's VM. A
+// *capierrors.MachineError is returned if the machine cannot be created
+// as specified.
+func Validate(ctx *context.MachineContext) error {
+	ctx = context.NewMachineLoggerContext(ctx, "vcenter")
+	ctx.Logger.V(6).Info("validating machine")
+
+	pool, err := getResourcePool(ctx)
+	if err != nil {
+		return validationError(err)
+	}
+
+	if len(ctx.VSphereMachine.Spec.PCIDevices) == 0 {
+		host, err := getHost(ctx, pool)
+		if err != nil {
+			return validationError(err)
+		}
+		if err := checkCPUAffinity(ctx, host); err != nil {
+			return err
+		}
+	}
+
+	if _, err := getFolder(ctx); err != nil {
+		return validationError(err)
+	}
+
+	for _, device := range ctx.VSphereMachine.Spec.Network.Devices {
+		if _, err := ctx.Session.Finder.Network(ctx, device.NetworkName); err != nil {
+			return validationError(errors.Wrapf(err, "unable to find network %q for %q", device.NetworkName, ctx))
+		}
+	}
+
+	// Content library items are validated when they are deployed as their
+	// disk size is not known beforehand.
+	if ctx.VSphereMachine.Spec.Template == "" {
+		return nil
+	}
+
+	tpl, err := template.FindTemplate(ctx, ctx.VSphereMachine.Spec.Template)
+	if err != nil {
+		return validationError(errors.Wrapf(err, "unable to find template %q for %q", ctx.VSphereMachine.Spec.Template, ctx))
+	}
+
+	if err := checkFirmware(ctx, tpl, pool); err != nil {
+		return validationError(err)
+	}
+
+	// The disks of a linked clone are backed by the template's snapshot, so
+	// there is no meaningful amount of space to check for.
+	if ctx.VSphereMachine.Spec.CloneMode == infrav1.LinkedClone {
+		return nil
+	}
+
+	requiredBytes, err := getRequiredDiskBytes(ctx, tpl)
+	if err != nil {
+		return err
+	}
+
+	if ctx.VSphereMachine.Spec.StoragePolicy != "" {
+		_, _, err := getStoragePolicyPlacement(ctx, pool, requiredBytes)
+		return validationError(err)
+	}
+
+	name, freeBytes, err := getFreeSpace(ctx)
+	if err != nil {
+		return err
+	}
+
+	if freeBytes < requiredBytes {
+		return capierrors.InvalidMachineConfiguration("insufficient free space on %q for %q: required=%dGiB free=%dGiB",
+			name, ctx, requiredBytes/bytesPerGiB, freeBytes/bytesPerGiB)
+	}
+
+	return nil
+}
+
+// getRequiredDiskBytes returns the size of the machine's disk, which defaults
+// to the size of the template's disk.
+func getRequiredDiskBytes(ctx *context.MachineContext, tpl *object.VirtualMachine) (int64, error) {
+	if ctx.VSphereMachine.Spec.DiskGiB > 0 {
+		return int64(ctx.VSphereMachine.Spec.DiskGiB) * bytesPerGiB, nil
+	}
+	devices, err := tpl.Device(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var requiredBytes int64
+	for _, disk := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		requiredBytes += disk.(*types.VirtualDisk).CapacityInKB * 1024
+	}
+	return requiredBytes, nil
+}
+
+// getFreeSpace returns the name and the free space, in bytes, of the datastore
+// or datastore cluster in which the machine's VM is created.
+func getFreeSpace(ctx *context.MachineContext) (string, int64, error) {
+	if name := ctx.VSphereMachine.Spec.DatastoreCluster; name != "" {
+		pod, err := ctx.Session.Finder.DatastoreCluster(ctx, name)
+		if err != nil {
+			return "", 0, validationError(errors.Wrapf(err, "unable to find datastore cluster %q for %q", name, ctx))
+		}
+		var obj mo.StoragePod
+		if err := pod.Properties(ctx, pod.Reference(), []string{"summary"}, &obj); err != nil {
+			return "", 0, err
+		}
+		if obj.Summary == nil {
+			return name, 0, nil
+		}
+		return name, obj.Summary.FreeSpace, nil
+	}
+
+	datastore, err := getDatastore(ctx)
+	if err != nil {
+		return "", 0, validationError(err)
+	}
+	var obj mo.Datastore
+	if err := datastore.Properties(ctx, datastore.Reference(), []string{"summary"}, &obj); err != nil {
+		return "", 0, err
+	}
+	return datastore.Name(), obj.Summary.FreeSpace, nil
+}
+
+// GetDiskSpace returns the name and free space, in GiB, of the named
+// datastore, or of the datastore or datastore cluster in which the machine's
+// VM is created if no name is provided. The space the VM's disks require is
+// returned as well, or zero if it is not known before the VM is created, as
+// for linked clones and content library items.
+func GetDiskSpace(ctx *context.MachineContext, datastoreName string) (string, int64, int64, error) {
+	var (
+		name      string
+		freeBytes int64
+		err       error
+	)
+	if datastoreName != "" {
+		datastore, err := ctx.Session.Finder.Datastore(ctx, datastoreName)
+		if err != nil {
+			return "", 0, 0, errors.Wrapf(err, "unable to find datastore %q for %q", datastoreName, ctx)
+		}
+		var obj mo.Datastore
+		if err := datastore.Properties(ctx, datastore.Reference(), []string{"summary"}, &obj); err != nil {
+			return "", 0, 0, errors.Wrapf(err, "unable to get free space of datastore %q for %q", datastoreName, ctx)
+		}
+		name, freeBytes = datastore.Name(), obj.Summary.FreeSpace
+	} else if name, freeBytes, err = getFreeSpace(ctx); err != nil {
+		return "", 0, 0, err
+	}
+
+	var requiredBytes int64
+	if ctx.VSphereMachine.Spec.Template != "" && ctx.VSphereMachine.Spec.CloneMode != infrav1.LinkedClone {
+		tpl, err := template.FindTemplate(ctx, ctx.VSphereMachine.Spec.Template)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		if requiredBytes, err = getRequiredDiskBytes(ctx, tpl); err != nil {
+			return "", 0, 0, err
+		}
+	}
+	return name, freeBytes / bytesPerGiB, requiredBytes / bytesPerGiB, nil
+}
+
+// validationError returns a *capierrors.MachineError for errors caused by
+// missing vSphere objects. Any other error, such as a connection error, is
+// returned as-is so the operation is retried.
+func validationError(err error) error {
+	if services.IsNotFoundError(err) {
+		return capierrors.InvalidMachineConfiguration("%v", err)
+	}
+	return err
+}
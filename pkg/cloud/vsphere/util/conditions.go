@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+)
+
+// GetMachineCondition returns the condition of the given type from a
+// VSphereMachine resource's status, or nil if no such condition exists.
+func GetMachineCondition(
+	machine *infrav1.VSphereMachine,
+	conditionType infrav1.VSphereMachineProviderConditionType) *infrav1.VSphereMachineProviderCondition {
+
+	for i := range machine.Status.Conditions {
+		if machine.Status.Conditions[i].Type == conditionType {
+			return &machine.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetMachineCondition adds or updates the condition of the given type in a
+// VSphereMachine resource's status. The condition's last transition time is
+// only updated when the condition's status changes.
+func SetMachineCondition(
+	machine *infrav1.VSphereMachine,
+	conditionType infrav1.VSphereMachineProviderConditionType,
+	status corev1.ConditionStatus,
+	reason, message string) {
+
+	condition := GetMachineCondition(machine, conditionType)
+	if condition == nil {
+		machine.Status.Conditions = append(machine.Status.Conditions, infrav1.VSphereMachineProviderCondition{
+			Type: conditionType,
+		})
+		condition = &machine.Status.Conditions[len(machine.Status.Conditions)-1]
+	}
+	if condition.Status != status {
+		condition.Status = status
+		condition.LastTransitionTime = metav1.Now()
+	}
+	condition.Reason = reason
+	condition.Message = message
+}
+
+// IsMachineConditionTrue returns a flag indicating whether or not the
+// condition of the given type is true in a VSphereMachine resource's status.
+func IsMachineConditionTrue(
+	machine *infrav1.VSphereMachine,
+	conditionType infrav1.VSphereMachineProviderConditionType) bool {
+
+	condition := GetMachineCondition(machine, conditionType)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util_test
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+func Test_SetMachineCondition(t *testing.T) {
+	machine := &v1alpha2.VSphereMachine{}
+
+	util.SetMachineCondition(machine, v1alpha2.MachineCloneInProgress, corev1.ConditionTrue, "CloneStarted", "")
+	if !util.IsMachineConditionTrue(machine, v1alpha2.MachineCloneInProgress) {
+		t.Fatal("expected condition to be true")
+	}
+
+	// Updating the reason without changing the status must not update the
+	// transition time.
+	transitionTime := metav1.NewTime(time.Now().Add(-time.Minute))
+	machine.Status.Conditions[0].LastTransitionTime = transitionTime
+	util.SetMachineCondition(machine, v1alpha2.MachineCloneInProgress, corev1.ConditionTrue, "StillCloning", "")
+	condition := util.GetMachineCondition(machine, v1alpha2.MachineCloneInProgress)
+	if !condition.LastTransitionTime.Equal(&transitionTime) {
+		t.Fatal("expected transition time to be unchanged")
+	}
+	if condition.Reason != "StillCloning" {
+		t.Fatalf("expected reason to be updated, got %q", condition.Reason)
+	}
+
+	util.SetMachineCondition(machine, v1alpha2.MachineCloneInProgress, corev1.ConditionFalse, "CloneComplete", "")
+	if condition := util.GetMachineCondition(machine, v1alpha2.MachineCloneInProgress); condition.LastTransitionTime.Equal(&transitionTime) {
+		t.Fatal("expected transition time to be updated")
+	}
+
+	util.SetMachineCondition(machine, v1alpha2.MachinePoweringOn, corev1.ConditionTrue, "PowerOnStarted", "")
+	if len(machine.Status.Conditions) != 2 {
+		t.Fatalf("expected 2 conditions, got %d", len(machine.Status.Conditions))
+	}
+}
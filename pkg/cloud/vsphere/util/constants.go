@@ -16,8 +16,14 @@ limitations under the License.
 
 package util
 
+const networkConfigMetadataFormat = `
+instance-id: "{{ .InstanceID }}"
+local-hostname: "{{ .Hostname }}"
+network: {{ .Network }}
+`
+
 const metadataFormat = `
-instance-id: "{{ .Hostname }}"
+instance-id: "{{ .InstanceID }}"
 local-hostname: "{{ .Hostname }}"
 network:
   version: 2
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// componentLabel is the label kubeadm sets on the static pods of the
+	// control plane components.
+	componentLabel = "component"
+
+	apiServerComponent = "kube-apiserver"
+	etcdComponent      = "etcd"
+)
+
+// GetControlPlaneHealth returns an empty string if a control plane member's
+// node is ready and the member's kube-apiserver is ready and, if the member
+// runs a stacked etcd member, so is etcd. Otherwise the reason the member
+// is not healthy is returned. The components are checked using the
+// readiness of their static pods, which is determined by the probes of the
+// components' health endpoints.
+func GetControlPlaneHealth(client corev1client.CoreV1Interface, nodeName string) (string, error) {
+	node, err := client.Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("node %q does not exist", nodeName), nil
+		}
+		return "", errors.Wrapf(err, "failed to get node %q", nodeName)
+	}
+	if !isNodeReady(node) {
+		return fmt.Sprintf("node %q is not ready", nodeName), nil
+	}
+
+	// Only the member's own control plane pods are listed, rather than all of
+	// the cluster's system pods.
+	pods, err := client.Pods(metav1.NamespaceSystem).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s in (%s,%s)", componentLabel, apiServerComponent, etcdComponent),
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to list control plane pods on node %q", nodeName)
+	}
+	components := map[string]*corev1.Pod{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		components[pod.Labels[componentLabel]] = pod
+	}
+
+	apiServer, ok := components[apiServerComponent]
+	if !ok {
+		return fmt.Sprintf("kube-apiserver is not running on node %q", nodeName), nil
+	}
+	if !isPodReady(apiServer) {
+		return fmt.Sprintf("kube-apiserver on node %q is not ready", nodeName), nil
+	}
+	// An etcd pod only exists if etcd is stacked on the control plane nodes.
+	if etcd, ok := components[etcdComponent]; ok && !isPodReady(etcd) {
+		return fmt.Sprintf("etcd on node %q is not ready", nodeName), nil
+	}
+	return "", nil
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+func Test_GetControlPlaneHealth(t *testing.T) {
+	newNode := func(ready corev1.ConditionStatus) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			},
+		}
+	}
+	newPod := func(component, nodeName string, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: metav1.NamespaceSystem,
+				Name:      component + "-" + nodeName,
+				Labels:    map[string]string{"component": component},
+			},
+			Spec: corev1.PodSpec{NodeName: nodeName},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		objects []runtime.Object
+		healthy bool
+	}{
+		{
+			name:    "healthy",
+			objects: []runtime.Object{newNode(corev1.ConditionTrue), newPod("kube-apiserver", "node1", corev1.ConditionTrue), newPod("etcd", "node1", corev1.ConditionTrue)},
+			healthy: true,
+		},
+		{
+			name:    "healthy with external etcd",
+			objects: []runtime.Object{newNode(corev1.ConditionTrue), newPod("kube-apiserver", "node1", corev1.ConditionTrue)},
+			healthy: true,
+		},
+		{
+			name: "missing node",
+		},
+		{
+			name:    "node not ready",
+			objects: []runtime.Object{newNode(corev1.ConditionFalse), newPod("kube-apiserver", "node1", corev1.ConditionTrue)},
+		},
+		{
+			name:    "kube-apiserver on other node",
+			objects: []runtime.Object{newNode(corev1.ConditionTrue), newPod("kube-apiserver", "node2", corev1.ConditionTrue)},
+		},
+		{
+			name:    "other component not ready",
+			objects: []runtime.Object{newNode(corev1.ConditionTrue), newPod("kube-apiserver", "node1", corev1.ConditionTrue), newPod("kube-scheduler", "node1", corev1.ConditionFalse)},
+			healthy: true,
+		},
+		{
+			name:    "kube-apiserver not ready",
+			objects: []runtime.Object{newNode(corev1.ConditionTrue), newPod("kube-apiserver", "node1", corev1.ConditionFalse)},
+		},
+		{
+			name:    "etcd not ready",
+			objects: []runtime.Object{newNode(corev1.ConditionTrue), newPod("kube-apiserver", "node1", corev1.ConditionTrue), newPod("etcd", "node1", corev1.ConditionUnknown)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tc.objects...)
+			// The fake clientset ignores field selectors, so the pods are
+			// listed by the selectors the API server would apply.
+			client.PrependReactor("list", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				restrictions := action.(clienttesting.ListAction).GetListRestrictions()
+				list := &corev1.PodList{}
+				for _, obj := range tc.objects {
+					pod, ok := obj.(*corev1.Pod)
+					if ok && pod.Namespace == action.GetNamespace() &&
+						restrictions.Labels.Matches(labels.Set(pod.Labels)) &&
+						restrictions.Fields.Matches(fields.Set{"spec.nodeName": pod.Spec.NodeName}) {
+						list.Items = append(list.Items, *pod)
+					}
+				}
+				return true, list, nil
+			})
+			reason, err := util.GetControlPlaneHealth(client.CoreV1(), "node1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if healthy := reason == ""; healthy != tc.healthy {
+				t.Fatalf("expected healthy %t, got reason %q", tc.healthy, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// mirrorPodAnnotation is the annotation the kubelet sets on the API
+// representation of a static pod.
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// CordonNode marks a node as unschedulable.
+func CordonNode(client corev1client.NodesGetter, nodeName string) error {
+	node, err := client.Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if node.Spec.Unschedulable {
+		return nil
+	}
+	node.Spec.Unschedulable = true
+	if _, err := client.Nodes().Update(node); err != nil {
+		return errors.Wrapf(err, "failed to cordon node %q", nodeName)
+	}
+	return nil
+}
+
+// EvictNodePods requests the eviction of all pods on a node that are not
+// managed by the node itself or by a DaemonSet. Evictions that would violate
+// a PodDisruptionBudget are left for a subsequent call. The number of pods
+// that have yet to leave the node is returned.
+func EvictNodePods(client corev1client.PodsGetter, nodeName string) (int, error) {
+	pods, err := client.Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to list pods on node %q", nodeName)
+	}
+
+	remaining := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !isEvictablePod(pod) {
+			continue
+		}
+		remaining++
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		err := client.Pods(pod.Namespace).Evict(&policyv1beta1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: pod.Namespace,
+				Name:      pod.Name,
+			},
+		})
+		switch {
+		case err == nil:
+		case apierrors.IsNotFound(err):
+			remaining--
+		case apierrors.IsTooManyRequests(err):
+			// The eviction is blocked by a PodDisruptionBudget.
+		default:
+			return remaining, errors.Wrapf(err, "failed to evict pod %s/%s", pod.Namespace, pod.Name)
+		}
+	}
+
+	return remaining, nil
+}
+
+func isEvictablePod(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return false
+	}
+	if ref := metav1.GetControllerOf(pod); ref != nil && ref.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+func Test_CordonNode(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+	})
+	if err := util.CordonNode(client.CoreV1(), "node1"); err != nil {
+		t.Fatal(err)
+	}
+	node, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !node.Spec.Unschedulable {
+		t.Fatal("expected node to be unschedulable")
+	}
+	if err := util.CordonNode(client.CoreV1(), "node2"); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func Test_EvictNodePods(t *testing.T) {
+	newPod := func(name string, mutate func(*corev1.Pod)) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		if mutate != nil {
+			mutate(pod)
+		}
+		return pod
+	}
+	isController := true
+
+	client := fake.NewSimpleClientset(
+		newPod("evictable", nil),
+		newPod("blocked-by-pdb", nil),
+		newPod("succeeded", func(pod *corev1.Pod) {
+			pod.Status.Phase = corev1.PodSucceeded
+		}),
+		newPod("mirror", func(pod *corev1.Pod) {
+			pod.Annotations = map[string]string{"kubernetes.io/config.mirror": "hash"}
+		}),
+		newPod("daemonset", func(pod *corev1.Pod) {
+			pod.OwnerReferences = []metav1.OwnerReference{
+				{Kind: "DaemonSet", Name: "ds", Controller: &isController},
+			}
+		}),
+	)
+
+	var evicted []string
+	client.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(clienttesting.CreateAction).GetObject().(*policyv1beta1.Eviction)
+		if eviction.Name == "blocked-by-pdb" {
+			return true, nil, apierrors.NewTooManyRequests("disruption budget", 10)
+		}
+		evicted = append(evicted, eviction.Name)
+		return true, nil, nil
+	})
+
+	remaining, err := util.EvictNodePods(client.CoreV1(), "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 2 {
+		t.Fatalf("expected 2 remaining pods, got %d", remaining)
+	}
+	if len(evicted) != 1 || evicted[0] != "evictable" {
+		t.Fatalf("expected only the evictable pod to be evicted, got %v", evicted)
+	}
+}
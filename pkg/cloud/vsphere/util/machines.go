@@ -19,18 +19,25 @@ package util
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"net"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 	vim25types "github.com/vmware/govmomi/vim25/types"
 	corev1 "k8s.io/api/core/v1"
 	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
 	clusterutilv1 "sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/version"
 )
 
 // GetMachinesInCluster gets a cluster's Machine resources.
@@ -138,22 +145,278 @@ func GetMachinePreferredIPAddress(machine *infrav1.VSphereMachine) (string, erro
 	return "", ErrNoMachineIPAddr
 }
 
+// ValidateMachineNetwork returns an error if the network configuration of a
+// VSphereMachine resource cannot be used to clone a VM, such as a device with
+// a static IP address but no gateway for that address family.
+func ValidateMachineNetwork(machine *infrav1.VSphereMachine) error {
+	networkConfig := machine.Spec.Network.NetworkConfig
+	if networkConfig != "" {
+		if _, err := parseNetworkConfig(networkConfig); err != nil {
+			return errors.Wrapf(err, "invalid network config of machine %s/%s", machine.Namespace, machine.Name)
+		}
+	}
+	for _, addr := range machine.Spec.Network.DNSServers {
+		if net.ParseIP(addr) == nil {
+			return errors.Errorf(
+				"invalid DNS server address %q of machine %s/%s",
+				addr, machine.Namespace, machine.Name)
+		}
+	}
+	for i, device := range machine.Spec.Network.Devices {
+		if device.MACAddr != "" {
+			if err := validateMACAddress(device.MACAddr); err != nil {
+				return errors.Wrapf(err,
+					"invalid MAC address for network device %d (%s) of machine %s/%s",
+					i, device.NetworkName, machine.Namespace, machine.Name)
+			}
+		}
+		// The guest is configured entirely by the network config, so the
+		// devices' IP settings are not validated.
+		if networkConfig != "" {
+			continue
+		}
+		var hasIPv4, hasIPv6 bool
+		for _, addr := range device.IPAddrs {
+			ip, _, err := net.ParseCIDR(addr)
+			if err != nil {
+				ip = net.ParseIP(addr)
+			}
+			if ip == nil {
+				return errors.Errorf(
+					"invalid IP address %q for network device %d (%s) of machine %s/%s",
+					addr, i, device.NetworkName, machine.Namespace, machine.Name)
+			}
+			if ip.To4() != nil {
+				hasIPv4 = true
+			} else {
+				hasIPv6 = true
+			}
+		}
+		if hasIPv4 && !device.DHCP4 && device.Gateway4 == "" {
+			return errors.Errorf(
+				"gateway4 is required for static IPv4 addresses on network device %d (%s) of machine %s/%s",
+				i, device.NetworkName, machine.Namespace, machine.Name)
+		}
+		if hasIPv6 && !device.DHCP6 && device.Gateway6 == "" {
+			return errors.Errorf(
+				"gateway6 is required for static IPv6 addresses on network device %d (%s) of machine %s/%s",
+				i, device.NetworkName, machine.Namespace, machine.Name)
+		}
+		if len(device.IPAddrs) > 0 && !device.DHCP4 && !device.DHCP6 &&
+			len(device.Nameservers) == 0 && len(machine.Spec.Network.DNSServers) == 0 {
+			return errors.Errorf(
+				"a nameserver is required for static IP addresses on network device %d (%s) of machine %s/%s",
+				i, device.NetworkName, machine.Namespace, machine.Name)
+		}
+	}
+	return nil
+}
+
+// vmwareOUI is the organizationally unique identifier of the MAC addresses
+// vSphere assigns and allows to be assigned manually.
+var vmwareOUI = []byte{0x00, 0x50, 0x56}
+
+// maxManualMACFourthOctet is the largest fourth octet of a MAC address with
+// the VMware OUI that vSphere allows to be assigned manually.
+const maxManualMACFourthOctet = 0x3F
+
+// validateMACAddress returns an error unless mac is a unicast MAC address
+// that vSphere allows to be assigned manually, which are those with the
+// VMware OUI in the range 00:50:56:00:00:00 to 00:50:56:3F:FF:FF, or locally
+// administered addresses.
+func validateMACAddress(mac string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	if len(hw) != 6 {
+		return errors.Errorf("%q is not a 48-bit MAC address", mac)
+	}
+	if hw[0]&0x01 != 0 {
+		return errors.Errorf("%q is a multicast address", mac)
+	}
+	if bytes.Equal(hw[:3], vmwareOUI) {
+		if hw[3] > maxManualMACFourthOctet {
+			return errors.Errorf("%q is outside of the range 00:50:56:00:00:00 to 00:50:56:3F:FF:FF vSphere allows to be assigned manually", mac)
+		}
+		return nil
+	}
+	if hw[0]&0x02 == 0 {
+		return errors.Errorf("%q is neither a VMware address nor a locally administered address", mac)
+	}
+	return nil
+}
+
+const hardwareVersionPrefix = "vmx-"
+
+// ParseHardwareVersion returns the numeric value of a virtual hardware
+// version of the form "vmx-15".
+func ParseHardwareVersion(version string) (int, error) {
+	if !strings.HasPrefix(version, hardwareVersionPrefix) {
+		return 0, errors.Errorf("invalid hardware version %q, expected the form %s<version>", version, hardwareVersionPrefix)
+	}
+	value, err := strconv.Atoi(strings.TrimPrefix(version, hardwareVersionPrefix))
+	if err != nil || value <= 0 {
+		return 0, errors.Errorf("invalid hardware version %q, expected the form %s<version>", version, hardwareVersionPrefix)
+	}
+	return value, nil
+}
+
 // IsControlPlaneMachine returns a flag indicating whether or not a machine has
 // the control plane role.
 func IsControlPlaneMachine(machine *clusterv1.Machine) bool {
 	return clusterutilv1.IsControlPlaneMachine(machine)
 }
 
+const (
+	// maxVMNameLength is the maximum length of a vSphere VM name.
+	maxVMNameLength = 80
+
+	// invalidVMNameChars are the characters vSphere escapes in VM names.
+	invalidVMNameChars = `%/\`
+
+	// defaultVMNotesTemplate is the template of the notes of the VMs of a
+	// cluster that does not set a VM notes template.
+	defaultVMNotesTemplate = "Managed by cluster-api-provider-vsphere {{.Version}} for machine {{.Namespace}}/{{.Machine}} of cluster {{.Cluster}}, created at {{.Created}}"
+
+	// maxVMNotesLength is the maximum length of the notes of a VM, which
+	// keeps the notes readable in the summary of the VM in the vSphere UI.
+	maxVMNotesLength = 1024
+)
+
+// GetMachineVMName returns the name of a machine's VM rendered from the
+// provided VM name template. The machine's name is returned if the template
+// is empty. An error is returned if the template cannot be rendered or the
+// rendered name is not a valid vSphere VM name.
+func GetMachineVMName(nameTemplate, clusterName string, machine *clusterv1.Machine) (string, error) {
+	if nameTemplate == "" {
+		return machine.Name, nil
+	}
+
+	tpl, err := template.New("vm-name").Parse(nameTemplate)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid vm name template %q", nameTemplate)
+	}
+	buf := &bytes.Buffer{}
+	if err := tpl.Execute(buf, struct {
+		Cluster string
+		Machine string
+		Role    string
+	}{
+		Cluster: clusterName,
+		Machine: machine.Name,
+		Role:    machineRole(machine),
+	}); err != nil {
+		return "", errors.Wrapf(err, "error rendering vm name template %q for machine %s/%s", nameTemplate, machine.Namespace, machine.Name)
+	}
+
+	name := buf.String()
+	switch {
+	case name == "":
+		return "", errors.Errorf("vm name template %q rendered an empty name for machine %s/%s", nameTemplate, machine.Namespace, machine.Name)
+	case len(name) > maxVMNameLength:
+		return "", errors.Errorf("vm name %q for machine %s/%s is longer than %d characters", name, machine.Namespace, machine.Name, maxVMNameLength)
+	case strings.ContainsAny(name, invalidVMNameChars):
+		return "", errors.Errorf("vm name %q for machine %s/%s contains one of the invalid characters %q", name, machine.Namespace, machine.Name, invalidVMNameChars)
+	}
+	return name, nil
+}
+
+// GetMachineVMNotes returns the notes of a machine's VM rendered from the
+// provided VM notes template, or from the default template if it is empty.
+// An error is returned if the template cannot be rendered or the rendered
+// notes are longer than maxVMNotesLength.
+func GetMachineVMNotes(notesTemplate, clusterName string, machine *clusterv1.Machine, created time.Time) (string, error) {
+	if notesTemplate == "" {
+		notesTemplate = defaultVMNotesTemplate
+	}
+
+	tpl, err := template.New("vm-notes").Parse(notesTemplate)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid vm notes template %q", notesTemplate)
+	}
+	buf := &bytes.Buffer{}
+	if err := tpl.Execute(buf, struct {
+		Cluster   string
+		Namespace string
+		Machine   string
+		Role      string
+		Created   string
+		Version   string
+	}{
+		Cluster:   clusterName,
+		Namespace: machine.Namespace,
+		Machine:   machine.Name,
+		Role:      machineRole(machine),
+		Created:   created.UTC().Format(time.RFC3339),
+		Version:   version.Version,
+	}); err != nil {
+		return "", errors.Wrapf(err, "error rendering vm notes template %q for machine %s/%s", notesTemplate, machine.Namespace, machine.Name)
+	}
+
+	notes := buf.String()
+	if len(notes) > maxVMNotesLength {
+		return "", errors.Errorf("vm notes for machine %s/%s are longer than %d characters", machine.Namespace, machine.Name, maxVMNotesLength)
+	}
+	return notes, nil
+}
+
+// machineRole returns the role of a machine used in the templates of its
+// VM's name and notes, either "control-plane" or "worker".
+func machineRole(machine *clusterv1.Machine) string {
+	if IsControlPlaneMachine(machine) {
+		return "control-plane"
+	}
+	return "worker"
+}
+
+// GetMachineHostname returns the hostname of a machine's guest, which is the
+// machine's name, qualified with the provided domain if it is not empty. An
+// error is returned if the machine's name is not a valid DNS label or the
+// qualified name is not a valid DNS subdomain.
+func GetMachineHostname(machine infrav1.VSphereMachine, domain string) (string, error) {
+	if errs := validation.IsDNS1123Label(machine.Name); len(errs) > 0 {
+		return "", errors.Errorf("invalid hostname %q for machine %s/%s: %s", machine.Name, machine.Namespace, machine.Name, strings.Join(errs, ", "))
+	}
+	hostname := machineHostname(machine.Name, domain)
+	if errs := validation.IsDNS1123Subdomain(hostname); len(errs) > 0 {
+		return "", errors.Errorf("invalid hostname %q for machine %s/%s: %s", hostname, machine.Namespace, machine.Name, strings.Join(errs, ", "))
+	}
+	return hostname, nil
+}
+
+func machineHostname(name, domain string) string {
+	if domain == "" {
+		return name
+	}
+	return name + "." + strings.TrimSuffix(domain, ".")
+}
+
 // GetMachineMetadata returns the cloud-init metadata as a base-64 encoded
-// string for a given VSphereMachine.
-func GetMachineMetadata(machine infrav1.VSphereMachine, networkStatus ...infrav1.NetworkStatus) ([]byte, error) {
-	// Create a copy of the devices and add their MAC addresses from a network status.
+// string for a given VSphereMachine. The guest's hostname is the machine's
+// name qualified with the provided domain, if any.
+func GetMachineMetadata(machine infrav1.VSphereMachine, domain string, networkStatus ...infrav1.NetworkStatus) ([]byte, error) {
+	if machine.Spec.Network.NetworkConfig != "" {
+		return getMachineMetadataWithNetworkConfig(machine, domain)
+	}
+
+	// Create a copy of the devices and add their MAC addresses from a network
+	// status. The network status is ordered the same as the devices, but may
+	// be shorter if not all of the VM's NICs have been reported yet.
+	// Netplan has no global DNS settings, so the machine's DNS servers and
+	// search domains are added to each device without its own.
 	devices := make([]infrav1.NetworkDeviceSpec, len(machine.Spec.Network.Devices))
 	for i := range machine.Spec.Network.Devices {
 		machine.Spec.Network.Devices[i].DeepCopyInto(&devices[i])
-		if len(networkStatus) > 0 {
+		if i < len(networkStatus) {
 			devices[i].MACAddr = networkStatus[i].MACAddr
 		}
+		if len(devices[i].Nameservers) == 0 {
+			devices[i].Nameservers = machine.Spec.Network.DNSServers
+		}
+		if len(devices[i].SearchDomains) == 0 {
+			devices[i].SearchDomains = machine.Spec.Network.SearchDomains
+		}
 	}
 
 	buf := &bytes.Buffer{}
@@ -164,13 +427,15 @@ func GetMachineMetadata(machine infrav1.VSphereMachine, networkStatus ...infrav1
 			},
 		}).Parse(metadataFormat))
 	if err := tpl.Execute(buf, struct {
-		Hostname string
-		Devices  []infrav1.NetworkDeviceSpec
-		Routes   []infrav1.NetworkRouteSpec
+		InstanceID string
+		Hostname   string
+		Devices    []infrav1.NetworkDeviceSpec
+		Routes     []infrav1.NetworkRouteSpec
 	}{
-		Hostname: machine.Name,
-		Devices:  devices,
-		Routes:   machine.Spec.Network.Routes,
+		InstanceID: machine.Name,
+		Hostname:   machineHostname(machine.Name, domain),
+		Devices:    devices,
+		Routes:     machine.Spec.Network.Routes,
 	}); err != nil {
 		return nil, errors.Wrapf(
 			err,
@@ -179,3 +444,84 @@ func GetMachineMetadata(machine infrav1.VSphereMachine, networkStatus ...infrav1
 	}
 	return buf.Bytes(), nil
 }
+
+// GetMachineNetworkConfig returns the cloud-init network configuration in a
+// machine's metadata as JSON, which is also valid YAML. It is provided to
+// datasources that read the network configuration separately from the
+// metadata, such as NoCloud.
+func GetMachineNetworkConfig(metadata []byte) ([]byte, error) {
+	data, err := yaml.ToJSON(metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse cloud init metadata")
+	}
+	var parsed struct {
+		Network json.RawMessage `json:"network"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, errors.Wrap(err, "unable to parse cloud init metadata")
+	}
+	if len(parsed.Network) == 0 {
+		return nil, errors.New("cloud init metadata has no network configuration")
+	}
+	return parsed.Network, nil
+}
+
+// getMachineMetadataWithNetworkConfig returns the cloud-init metadata of a
+// machine whose network is configured by the machine's network config. The
+// network config is embedded as JSON, which is also valid YAML.
+func getMachineMetadataWithNetworkConfig(machine infrav1.VSphereMachine, domain string) ([]byte, error) {
+	network, err := parseNetworkConfig(machine.Spec.Network.NetworkConfig)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error getting cloud init metadata for machine %s/%s/%s",
+			machine.Namespace, machine.ClusterName, machine.Name)
+	}
+	data, err := json.Marshal(network)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error getting cloud init metadata for machine %s/%s/%s",
+			machine.Namespace, machine.ClusterName, machine.Name)
+	}
+
+	buf := &bytes.Buffer{}
+	tpl := template.Must(template.New("t").Parse(networkConfigMetadataFormat))
+	if err := tpl.Execute(buf, struct {
+		InstanceID string
+		Hostname   string
+		Network    string
+	}{
+		InstanceID: machine.Name,
+		Hostname:   machineHostname(machine.Name, domain),
+		Network:    string(data),
+	}); err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error getting cloud init metadata for machine %s/%s/%s",
+			machine.Namespace, machine.ClusterName, machine.Name)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseNetworkConfig returns the parsed cloud-init network configuration
+// version 2 document. An error is returned if the document is not valid YAML
+// or is not a version 2 network configuration.
+func parseNetworkConfig(config string) (map[string]interface{}, error) {
+	data, err := yaml.ToJSON([]byte(config))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse network config")
+	}
+	var network map[string]interface{}
+	if err := json.Unmarshal(data, &network); err != nil {
+		return nil, errors.Wrap(err, "unable to parse network config")
+	}
+	// A document may nest the configuration under a top-level network key.
+	if nested, ok := network["network"].(map[string]interface{}); ok && len(network) == 1 {
+		network = nested
+	}
+	if version, ok := network["version"].(float64); !ok || version != 2 {
+		return nil, errors.Errorf("network config version must be 2, got %v", network["version"])
+	}
+	return network, nil
+}
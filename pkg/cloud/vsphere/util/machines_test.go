@@ -17,9 +17,15 @@ limitations under the License.
 package util_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
 
 	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
 	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
@@ -214,11 +220,41 @@ func Test_GetMachinePreferredIPAddress(t *testing.T) {
 	}
 }
 
+const bondNetworkConfig = `version: 2
+ethernets:
+  id0:
+    match:
+      macaddress: "00:50:56:00:00:01"
+  id1:
+    match:
+      macaddress: "00:50:56:00:00:02"
+bonds:
+  bond0:
+    interfaces: [id0, id1]
+    addresses: [192.168.4.21/24]
+    gateway4: 192.168.4.1
+`
+
 func Test_GetMachineMetadata(t *testing.T) {
 	testCases := []struct {
-		name    string
-		machine *v1alpha2.VSphereMachine
+		name             string
+		machine          *v1alpha2.VSphereMachine
+		networkStatus    []v1alpha2.NetworkStatus
+		domain           string
+		expectedHostname string
 	}{
+		{
+			name: "hostname-domain",
+			machine: &v1alpha2.VSphereMachine{
+				Spec: v1alpha2.VSphereMachineSpec{
+					Network: v1alpha2.NetworkSpec{
+						Devices: []v1alpha2.NetworkDeviceSpec{{NetworkName: "network1", DHCP4: true}},
+					},
+				},
+			},
+			domain:           "example.com",
+			expectedHostname: "hostname-domain.example.com",
+		},
 		{
 			name: "dhcp4",
 			machine: &v1alpha2.VSphereMachine{
@@ -366,19 +402,499 @@ func Test_GetMachineMetadata(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "2nets-global-dns",
+			machine: &v1alpha2.VSphereMachine{
+				Spec: v1alpha2.VSphereMachineSpec{
+					Network: v1alpha2.NetworkSpec{
+						Devices: []v1alpha2.NetworkDeviceSpec{
+							{
+								NetworkName: "network1",
+								MACAddr:     "00:00:00:00:00",
+								IPAddrs:     []string{"192.168.4.21"},
+								Gateway4:    "192.168.4.1",
+							},
+							{
+								NetworkName:   "network12",
+								MACAddr:       "00:00:00:00:01",
+								DHCP6:         true,
+								Nameservers:   []string{"1.1.1.1"},
+								SearchDomains: []string{"vmware6.ci"},
+							},
+						},
+						DNSServers:    []string{"192.168.4.2"},
+						SearchDomains: []string{"vmware.ci"},
+					},
+				},
+			},
+		},
+		{
+			name: "network-config",
+			machine: &v1alpha2.VSphereMachine{
+				Spec: v1alpha2.VSphereMachineSpec{
+					Network: v1alpha2.NetworkSpec{
+						Devices: []v1alpha2.NetworkDeviceSpec{
+							{NetworkName: "network1"},
+							{NetworkName: "network1"},
+						},
+						NetworkConfig: bondNetworkConfig,
+					},
+				},
+			},
+		},
+		{
+			name: "2nets-same-network",
+			machine: &v1alpha2.VSphereMachine{
+				Spec: v1alpha2.VSphereMachineSpec{
+					Network: v1alpha2.NetworkSpec{
+						Devices: []v1alpha2.NetworkDeviceSpec{
+							{
+								NetworkName: "network1",
+								DHCP4:       true,
+							},
+							{
+								NetworkName: "network1",
+								IPAddrs:     []string{"192.168.4.21"},
+								Gateway4:    "192.168.4.1",
+							},
+						},
+					},
+				},
+			},
+			networkStatus: []v1alpha2.NetworkStatus{
+				{
+					MACAddr:     "00:00:00:00:00",
+					NetworkName: "network1",
+				},
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			tc.machine.Name = tc.name
-			actVal, err := util.GetMachineMetadata(*tc.machine)
+			actVal, err := util.GetMachineMetadata(*tc.machine, tc.domain, tc.networkStatus...)
 			if err != nil {
 				t.Fatal(err)
 			}
+			if tc.expectedHostname != "" {
+				for _, expected := range []string{
+					fmt.Sprintf("instance-id: %q", tc.name),
+					fmt.Sprintf("local-hostname: %q", tc.expectedHostname),
+				} {
+					if !strings.Contains(string(actVal), expected) {
+						t.Errorf("expected metadata to contain %s, got %s", expected, actVal)
+					}
+				}
+			}
 			t.Log(string(actVal))
 		})
 	}
 }
 
+func Test_GetMachineNetworkConfig(t *testing.T) {
+	machine := v1alpha2.VSphereMachine{
+		Spec: v1alpha2.VSphereMachineSpec{
+			Network: v1alpha2.NetworkSpec{
+				Devices: []v1alpha2.NetworkDeviceSpec{{NetworkName: "network1", DHCP4: true}},
+			},
+		},
+	}
+	machine.Name = "test-machine"
+	metadata, err := util.GetMachineMetadata(machine, "", v1alpha2.NetworkStatus{MACAddr: "00:50:56:00:00:01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actVal, err := util.GetMachineNetworkConfig(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var network struct {
+		Version   int `json:"version"`
+		Ethernets map[string]struct {
+			Match struct {
+				MACAddress string `json:"macaddress"`
+			} `json:"match"`
+			DHCP4 bool `json:"dhcp4"`
+		} `json:"ethernets"`
+	}
+	if err := json.Unmarshal(actVal, &network); err != nil {
+		t.Fatalf("expected network config to be JSON: %v", err)
+	}
+	if network.Version != 2 || network.Ethernets["id0"].Match.MACAddress != "00:50:56:00:00:01" || !network.Ethernets["id0"].DHCP4 {
+		t.Fatalf("unexpected network config %s", actVal)
+	}
+
+	if _, err := util.GetMachineNetworkConfig([]byte("instance-id: test")); err == nil {
+		t.Fatal("expected error for metadata without network config, got nil")
+	}
+}
+
+func Test_GetMachineHostname(t *testing.T) {
+	testCases := []struct {
+		name        string
+		machineName string
+		domain      string
+		expected    string
+		expectErr   bool
+	}{
+		{name: "machine name", machineName: "worker-0", expected: "worker-0"},
+		{name: "fqdn", machineName: "worker-0", domain: "example.com", expected: "worker-0.example.com"},
+		{name: "fqdn with trailing dot", machineName: "worker-0", domain: "example.com.", expected: "worker-0.example.com"},
+		{name: "name with dots", machineName: "worker.0", expectErr: true},
+		{name: "name too long", machineName: strings.Repeat("a", 64), expectErr: true},
+		{name: "invalid domain", machineName: "worker-0", domain: "example_com", expectErr: true},
+		{name: "fqdn too long", machineName: "worker-0", domain: strings.Repeat("a.", 123) + "com", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := v1alpha2.VSphereMachine{ObjectMeta: metav1.ObjectMeta{Name: tc.machineName, Namespace: "test-namespace"}}
+			actual, err := util.GetMachineHostname(machine, tc.domain)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected hostname %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_ValidateMachineNetwork(t *testing.T) {
+	testCases := []struct {
+		name          string
+		devices       []v1alpha2.NetworkDeviceSpec
+		dnsServers    []string
+		networkConfig string
+		expectErr     bool
+	}{
+		{
+			name: "network-config",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1"},
+				{NetworkName: "network1"},
+			},
+			networkConfig: bondNetworkConfig,
+		},
+		{
+			name: "network-config-nested",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1"},
+			},
+			networkConfig: "network:\n  version: 2\n  ethernets: {}\n",
+		},
+		{
+			name: "network-config-invalid-yaml",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1"},
+			},
+			networkConfig: "version: 2\nethernets: [",
+			expectErr:     true,
+		},
+		{
+			name: "network-config-version-1",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1"},
+			},
+			networkConfig: "version: 1\nconfig: []\n",
+			expectErr:     true,
+		},
+		{
+			name: "dhcp",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", DHCP4: true, DHCP6: true},
+			},
+		},
+		{
+			name: "static4-with-gateway",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", IPAddrs: []string{"192.168.4.21/24"}, Gateway4: "192.168.4.1", Nameservers: []string{"192.168.4.2"}},
+			},
+		},
+		{
+			name: "static4-with-gateway+dns-servers",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", IPAddrs: []string{"192.168.4.21/24"}, Gateway4: "192.168.4.1"},
+			},
+			dnsServers: []string{"192.168.4.2"},
+		},
+		{
+			name: "static4-without-nameservers",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", IPAddrs: []string{"192.168.4.21/24"}, Gateway4: "192.168.4.1"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "invalid-dns-server",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", DHCP4: true},
+			},
+			dnsServers: []string{"dns.local"},
+			expectErr:  true,
+		},
+		{
+			name: "vmware-mac",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", DHCP4: true, MACAddr: "00:50:56:3f:ff:ff"},
+			},
+		},
+		{
+			name: "locally-administered-mac",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", DHCP4: true, MACAddr: "02:00:00:12:34:56"},
+			},
+		},
+		{
+			name: "vmware-mac-out-of-range",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", DHCP4: true, MACAddr: "00:50:56:40:00:00"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "universal-mac",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", DHCP4: true, MACAddr: "00:0c:29:12:34:56"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "multicast-mac",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", DHCP4: true, MACAddr: "03:00:00:12:34:56"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "invalid-mac",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", DHCP4: true, MACAddr: "00:50:56:00:00"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "static4-without-gateway",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", IPAddrs: []string{"192.168.4.21/24"}},
+			},
+			expectErr: true,
+		},
+		{
+			name: "static4-with-gateway+static6-without-gateway",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", IPAddrs: []string{"192.168.4.21", "fdf3:35b5:9dad:6e09::0001"}, Gateway4: "192.168.4.1"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "static6-without-gateway+dhcp6",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", IPAddrs: []string{"fdf3:35b5:9dad:6e09::0001/64"}, DHCP6: true},
+			},
+		},
+		{
+			name: "invalid-ip-addr",
+			devices: []v1alpha2.NetworkDeviceSpec{
+				{NetworkName: "network1", IPAddrs: []string{"192.168.4"}, Gateway4: "192.168.4.1"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &v1alpha2.VSphereMachine{
+				Spec: v1alpha2.VSphereMachineSpec{
+					Network: v1alpha2.NetworkSpec{
+						Devices:       tc.devices,
+						DNSServers:    tc.dnsServers,
+						NetworkConfig: tc.networkConfig,
+					},
+				},
+			}
+			if err := util.ValidateMachineNetwork(machine); err != nil {
+				t.Log(err)
+				if !tc.expectErr {
+					t.Fatal(err)
+				}
+			} else if tc.expectErr {
+				t.Fatal("expected error did not occur")
+			}
+		})
+	}
+}
+
+func Test_ParseHardwareVersion(t *testing.T) {
+	testCases := []struct {
+		version   string
+		expected  int
+		expectErr bool
+	}{
+		{version: "vmx-15", expected: 15},
+		{version: "vmx-8", expected: 8},
+		{version: "15", expectErr: true},
+		{version: "vmx-", expectErr: true},
+		{version: "vmx-0", expectErr: true},
+		{version: "vmx-15a", expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			actual, err := util.ParseHardwareVersion(tc.version)
+			if err != nil {
+				t.Log(err)
+				if !tc.expectErr {
+					t.Fatal(err)
+				}
+			} else if tc.expectErr {
+				t.Fatal("expected error did not occur")
+			}
+			if actual != tc.expected {
+				t.Fatalf("expected %d, got %d", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_GetMachineVMName(t *testing.T) {
+	worker := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Namespace: "default"},
+	}
+	controlPlane := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "control-plane-1",
+			Namespace: "default",
+			Labels:    map[string]string{clusterv1.MachineControlPlaneLabelName: "true"},
+		},
+	}
+	testCases := []struct {
+		name      string
+		template  string
+		machine   *clusterv1.Machine
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "default",
+			machine:  worker,
+			expected: "worker-1",
+		},
+		{
+			name:     "worker",
+			template: "prod-{{.Cluster}}-{{.Role}}-{{.Machine}}",
+			machine:  worker,
+			expected: "prod-my-cluster-worker-worker-1",
+		},
+		{
+			name:     "control plane",
+			template: "{{.Cluster}}-{{.Role}}-{{.Machine}}",
+			machine:  controlPlane,
+			expected: "my-cluster-control-plane-control-plane-1",
+		},
+		{
+			name:      "invalid template",
+			template:  "{{.Cluster",
+			machine:   worker,
+			expectErr: true,
+		},
+		{
+			name:      "unknown placeholder",
+			template:  "{{.Namespace}}-{{.Machine}}",
+			machine:   worker,
+			expectErr: true,
+		},
+		{
+			name:      "empty name",
+			template:  "{{if false}}{{.Machine}}{{end}}",
+			machine:   worker,
+			expectErr: true,
+		},
+		{
+			name:      "too long",
+			template:  "{{.Machine}}-{{.Cluster}}-0123456789012345678901234567890123456789012345678901234567890123456789",
+			machine:   worker,
+			expectErr: true,
+		},
+		{
+			name:      "invalid characters",
+			template:  "{{.Cluster}}/{{.Machine}}",
+			machine:   worker,
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := util.GetMachineVMName(tc.template, "my-cluster", tc.machine)
+			if err != nil {
+				t.Log(err)
+				if !tc.expectErr {
+					t.Fatal(err)
+				}
+			} else if tc.expectErr {
+				t.Fatal("expected error did not occur")
+			}
+			if actual != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_GetMachineVMNotes(t *testing.T) {
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "control-plane-1",
+			Namespace: "default",
+			Labels:    map[string]string{clusterv1.MachineControlPlaneLabelName: "true"},
+		},
+	}
+	created := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name      string
+		template  string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "default",
+			expected: "Managed by cluster-api-provider-vsphere dev for machine default/control-plane-1 of cluster my-cluster, created at 2019-10-01T12:00:00Z",
+		},
+		{
+			name:     "custom",
+			template: "{{.Role}} {{.Machine}} of {{.Cluster}} ({{.Version}})",
+			expected: "control-plane control-plane-1 of my-cluster (dev)",
+		},
+		{
+			name:      "invalid template",
+			template:  "{{.Machine",
+			expectErr: true,
+		},
+		{
+			name:      "unknown field",
+			template:  "{{.Owner}}",
+			expectErr: true,
+		},
+		{
+			name:      "too long",
+			template:  strings.Repeat("x", 1025),
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := util.GetMachineVMNotes(tc.template, "my-cluster", machine, created)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if actual != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
 func mtu(i int64) *int64 {
 	if i == 0 {
 		return nil
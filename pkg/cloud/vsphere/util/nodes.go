@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// SetNodeProviderID sets the provider ID of the first of the named nodes
+// that exists, so the node can be matched to its machine before the cloud
+// provider initializes it. The node's name is returned, or an empty string
+// if none of the nodes exist yet. A node whose provider ID is already set,
+// such as by the cloud provider, is left unchanged, and an error is returned
+// along with the node's name if the provider ID differs.
+func SetNodeProviderID(client corev1client.CoreV1Interface, nodeNames []string, providerID string) (string, error) {
+	for _, name := range nodeNames {
+		node, err := client.Nodes().Get(name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return "", errors.Wrapf(err, "failed to get node %q", name)
+		}
+		if node.Spec.ProviderID != "" {
+			if !strings.EqualFold(node.Spec.ProviderID, providerID) {
+				return name, errors.Errorf("node %q has provider ID %q instead of %q", name, node.Spec.ProviderID, providerID)
+			}
+			return name, nil
+		}
+		patch := []byte(fmt.Sprintf(`{"spec":{"providerID":%q}}`, providerID))
+		if _, err := client.Nodes().Patch(name, types.MergePatchType, patch); err != nil {
+			return "", errors.Wrapf(err, "failed to set provider ID of node %q", name)
+		}
+		return name, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+func Test_SetNodeProviderID(t *testing.T) {
+	const providerID = "vsphere://42000000-0000-0000-0000-000000000000"
+
+	newNode := func(name, providerID string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.NodeSpec{ProviderID: providerID},
+		}
+	}
+
+	testCases := []struct {
+		name         string
+		objects      []runtime.Object
+		expectedNode string
+		expectErr    bool
+	}{
+		{
+			name: "node not registered",
+		},
+		{
+			name:         "node without provider id",
+			objects:      []runtime.Object{newNode("machine1", "")},
+			expectedNode: "machine1",
+		},
+		{
+			name:         "node with qualified hostname",
+			objects:      []runtime.Object{newNode("machine1.example.com", "")},
+			expectedNode: "machine1.example.com",
+		},
+		{
+			name:         "provider id set by cloud provider",
+			objects:      []runtime.Object{newNode("machine1", "vsphere://42000000-0000-0000-0000-000000000000")},
+			expectedNode: "machine1",
+		},
+		{
+			name:         "different provider id",
+			objects:      []runtime.Object{newNode("machine1", "vsphere://42000000-0000-0000-0000-000000000001")},
+			expectedNode: "machine1",
+			expectErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tc.objects...).CoreV1()
+			nodeName, err := util.SetNodeProviderID(client, []string{"machine1.example.com", "machine1"}, providerID)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if nodeName != tc.expectedNode {
+				t.Fatalf("expected node %q, got %q", tc.expectedNode, nodeName)
+			}
+			if nodeName == "" || tc.expectErr {
+				return
+			}
+			node, err := client.Nodes().Get(nodeName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if node.Spec.ProviderID != providerID {
+				t.Fatalf("expected provider id %q, got %q", providerID, node.Spec.ProviderID)
+			}
+		})
+	}
+}
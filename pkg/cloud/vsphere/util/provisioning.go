@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/record"
+)
+
+// ProvisioningPhase is a phase of provisioning a VSphereMachine.
+type ProvisioningPhase string
+
+const (
+	// ProvisioningPhaseClone is the time from starting to clone the
+	// machine's VM until the VM is created.
+	ProvisioningPhaseClone ProvisioningPhase = "Clone"
+
+	// ProvisioningPhasePowerOn is the time from creating the machine's VM
+	// until it is powered on.
+	ProvisioningPhasePowerOn ProvisioningPhase = "PowerOn"
+
+	// ProvisioningPhaseIPAddress is the time from powering on the machine's
+	// VM until it reports an IP address.
+	ProvisioningPhaseIPAddress ProvisioningPhase = "IPAddress"
+
+	// ProvisioningPhaseNodeJoin is the time from the machine's VM reporting
+	// an IP address until the machine's node joins the cluster.
+	ProvisioningPhaseNodeJoin ProvisioningPhase = "NodeJoin"
+)
+
+// MarkCloneStarted records the time at which the clone of a VSphereMachine's
+// VM started, unless a previous clone has already been recorded.
+func MarkCloneStarted(machine *infrav1.VSphereMachine) {
+	times := getProvisioningTimes(machine)
+	if times.CloneStarted == nil {
+		now := metav1.Now()
+		times.CloneStarted = &now
+	}
+}
+
+// MarkProvisioningPhaseCompleted records the time at which a provisioning
+// phase of a VSphereMachine completed. Phases are only recorded the first
+// time they complete. When a phase's completion is recorded and the time at
+// which the phase started is known, an event with the phase's duration is
+// emitted and the duration is returned.
+func MarkProvisioningPhaseCompleted(machine *infrav1.VSphereMachine, phase ProvisioningPhase) (time.Duration, bool) {
+	times := getProvisioningTimes(machine)
+
+	var started, completed **metav1.Time
+	switch phase {
+	case ProvisioningPhaseClone:
+		started, completed = &times.CloneStarted, &times.CloneCompleted
+	case ProvisioningPhasePowerOn:
+		started, completed = &times.CloneCompleted, &times.PoweredOn
+	case ProvisioningPhaseIPAddress:
+		started, completed = &times.PoweredOn, &times.IPAddressAssigned
+	case ProvisioningPhaseNodeJoin:
+		started, completed = &times.IPAddressAssigned, &times.NodeJoined
+	default:
+		return 0, false
+	}
+
+	if *completed != nil {
+		return 0, false
+	}
+	now := metav1.Now()
+	*completed = &now
+	if *started == nil {
+		return 0, false
+	}
+
+	duration := now.Sub((*started).Time)
+	record.Eventf(machine, "ProvisioningPhaseCompleted", "%s phase completed in %s", phase, duration.Round(time.Second))
+	return duration, true
+}
+
+func getProvisioningTimes(machine *infrav1.VSphereMachine) *infrav1.VSphereMachineProvisioningTimes {
+	if machine.Status.ProvisioningTimes == nil {
+		machine.Status.ProvisioningTimes = &infrav1.VSphereMachineProvisioningTimes{}
+	}
+	return machine.Status.ProvisioningTimes
+}
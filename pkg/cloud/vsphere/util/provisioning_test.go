@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util_test
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+func Test_MarkProvisioningPhaseCompleted(t *testing.T) {
+	machine := &v1alpha2.VSphereMachine{}
+
+	util.MarkCloneStarted(machine)
+	cloneStarted := metav1.NewTime(time.Now().Add(-time.Minute))
+	machine.Status.ProvisioningTimes.CloneStarted = &cloneStarted
+
+	// A repeated clone must not reset the start time.
+	util.MarkCloneStarted(machine)
+	if !machine.Status.ProvisioningTimes.CloneStarted.Equal(&cloneStarted) {
+		t.Fatal("expected clone start time to be unchanged")
+	}
+
+	duration, ok := util.MarkProvisioningPhaseCompleted(machine, util.ProvisioningPhaseClone)
+	if !ok {
+		t.Fatal("expected clone duration")
+	}
+	if duration < time.Minute {
+		t.Fatalf("expected clone duration of at least 1m, got %s", duration)
+	}
+	cloneCompleted := machine.Status.ProvisioningTimes.CloneCompleted
+	if cloneCompleted == nil {
+		t.Fatal("expected clone completion time")
+	}
+
+	// Completing a phase again must not update its time.
+	if _, ok := util.MarkProvisioningPhaseCompleted(machine, util.ProvisioningPhaseClone); ok {
+		t.Fatal("expected no duration for a repeated phase")
+	}
+	if machine.Status.ProvisioningTimes.CloneCompleted != cloneCompleted {
+		t.Fatal("expected clone completion time to be unchanged")
+	}
+
+	// A phase whose start is unknown is recorded without a duration.
+	if _, ok := util.MarkProvisioningPhaseCompleted(machine, util.ProvisioningPhaseIPAddress); ok {
+		t.Fatal("expected no duration for a phase without a start time")
+	}
+	if machine.Status.ProvisioningTimes.IPAddressAssigned == nil {
+		t.Fatal("expected ip address time")
+	}
+	if machine.Status.ProvisioningTimes.PoweredOn != nil {
+		t.Fatal("expected no power on time")
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/object"
+)
+
+// WaitForTask waits for a vSphere task to complete. The task's managed
+// object reference is logged with the task's outcome so the log lines may be
+// correlated with the task in vCenter. The wait is aborted when the context
+// is cancelled, in which case the task may still complete.
+func WaitForTask(ctx context.Context, logger logr.Logger, task *object.Task) error {
+	logger = logger.WithValues("task", task.Reference().Value)
+	logger.V(6).Info("waiting for task")
+	err := task.Wait(ctx)
+	// The wait may return without an error when it is cancelled, so the
+	// task's outcome is unknown whenever the context is done.
+	if ctx.Err() != nil {
+		logger.V(4).Info("stopped waiting for task", "reason", ctx.Err().Error())
+		return errors.Wrapf(ctx.Err(), "stopped waiting for task %s", task.Reference().Value)
+	}
+	if err != nil {
+		logger.Error(err, "task failed")
+		return err
+	}
+	logger.V(6).Info("task succeeded")
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains the Prometheus metrics for VM operations. The
+// metrics are registered with the controller-runtime metrics registry and
+// served from the manager's metrics endpoint.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/services"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/util"
+)
+
+const (
+	// OperationReconcile is the label value for VM reconcile operations.
+	OperationReconcile = "reconcile"
+
+	// OperationDestroy is the label value for VM destroy operations.
+	OperationDestroy = "destroy"
+
+	roleControlPlane = "control-plane"
+	roleWorker       = "worker"
+
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+var (
+	vmOperations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "capv_vm_operations_total",
+			Help: "The number of VM operations by operation, machine role, and result.",
+		},
+		[]string{"operation", "role", "result"},
+	)
+
+	vmOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "capv_vm_operation_duration_seconds",
+			Help:    "The duration of VM operations by operation and machine role.",
+			Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		},
+		[]string{"operation", "role"},
+	)
+
+	vmOperationsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "capv_vm_operations_in_flight",
+			Help: "The number of VM operations in progress by operation.",
+		},
+		[]string{"operation"},
+	)
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(vmOperations, vmOperationDuration, vmOperationsInFlight)
+}
+
+// InstrumentVMService returns a VirtualMachineService that records metrics
+// for the operations of the provided service.
+func InstrumentVMService(vmService services.VirtualMachineService) services.VirtualMachineService {
+	return &instrumentedVMService{vmService: vmService}
+}
+
+type instrumentedVMService struct {
+	vmService services.VirtualMachineService
+}
+
+func (s *instrumentedVMService) ReconcileVM(ctx *context.MachineContext) (infrav1.VirtualMachine, error) {
+	return observe(ctx, OperationReconcile, s.vmService.ReconcileVM)
+}
+
+func (s *instrumentedVMService) DestroyVM(ctx *context.MachineContext) (infrav1.VirtualMachine, error) {
+	return observe(ctx, OperationDestroy, s.vmService.DestroyVM)
+}
+
+func observe(
+	ctx *context.MachineContext,
+	operation string,
+	fn func(*context.MachineContext) (infrav1.VirtualMachine, error)) (infrav1.VirtualMachine, error) {
+
+	role := roleWorker
+	if util.IsControlPlaneMachine(ctx.Machine) {
+		role = roleControlPlane
+	}
+
+	inFlight := vmOperationsInFlight.WithLabelValues(operation)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	vm, err := fn(ctx)
+	vmOperationDuration.WithLabelValues(operation, role).Observe(time.Since(start).Seconds())
+
+	result := resultSuccess
+	if err != nil {
+		result = resultError
+	}
+	vmOperations.WithLabelValues(operation, role, result).Inc()
+
+	return vm, err
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-vsphere/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-vsphere/pkg/cloud/vsphere/context"
+)
+
+type fakeVMService struct {
+	err error
+
+	// inFlight is the value of the in-flight gauge observed during the
+	// operation.
+	inFlight float64
+}
+
+func (s *fakeVMService) ReconcileVM(ctx *context.MachineContext) (infrav1.VirtualMachine, error) {
+	s.inFlight = testutil.ToFloat64(vmOperationsInFlight.WithLabelValues(OperationReconcile))
+	return infrav1.VirtualMachine{}, s.err
+}
+
+func (s *fakeVMService) DestroyVM(ctx *context.MachineContext) (infrav1.VirtualMachine, error) {
+	s.inFlight = testutil.ToFloat64(vmOperationsInFlight.WithLabelValues(OperationDestroy))
+	return infrav1.VirtualMachine{}, s.err
+}
+
+func TestInstrumentVMService(t *testing.T) {
+	testCases := []struct {
+		name           string
+		labels         map[string]string
+		err            error
+		expectedRole   string
+		expectedResult string
+	}{
+		{
+			name:           "worker success",
+			expectedRole:   roleWorker,
+			expectedResult: resultSuccess,
+		},
+		{
+			name:           "control plane error",
+			labels:         map[string]string{clusterv1.MachineControlPlaneLabelName: "true"},
+			err:            errors.New("failed"),
+			expectedRole:   roleControlPlane,
+			expectedResult: resultError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vmOperations.Reset()
+			vmOperationsInFlight.Reset()
+
+			ctx := &context.MachineContext{
+				Machine: &clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Labels: tc.labels},
+				},
+			}
+			fake := &fakeVMService{err: tc.err}
+			vmService := InstrumentVMService(fake)
+
+			if _, err := vmService.ReconcileVM(ctx); err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if fake.inFlight != 1 {
+				t.Fatalf("expected 1 in-flight operation, got %v", fake.inFlight)
+			}
+			if actual := testutil.ToFloat64(vmOperationsInFlight.WithLabelValues(OperationReconcile)); actual != 0 {
+				t.Fatalf("expected 0 in-flight operations after reconcile, got %v", actual)
+			}
+			if actual := testutil.ToFloat64(vmOperations.WithLabelValues(OperationReconcile, tc.expectedRole, tc.expectedResult)); actual != 1 {
+				t.Fatalf("expected 1 reconcile operation, got %v", actual)
+			}
+
+			if _, err := vmService.DestroyVM(ctx); err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if actual := testutil.ToFloat64(vmOperations.WithLabelValues(OperationDestroy, tc.expectedRole, tc.expectedResult)); actual != 1 {
+				t.Fatalf("expected 1 destroy operation, got %v", actual)
+			}
+		})
+	}
+}